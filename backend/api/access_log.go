@@ -0,0 +1,93 @@
+// access_log.go
+// Access log level policy. The logging middleware logs each request at a level chosen by its
+// status class, so server errors stand out and can be filtered by level. Handlers can add
+// domain context (user, model, address) to a request's access log line with AddLogField.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logFieldsKey is the gin context key holding the fields handlers added to the access log line.
+const logFieldsKey = "log_fields"
+
+// AccessLogLevels maps a status class (2 for 2xx, 5 for 5xx, ...) to its access log level.
+type AccessLogLevels map[int]zapcore.Level
+
+// DefaultAccessLogLevels logs successes and redirects at info, client errors at warn and server errors at error.
+func DefaultAccessLogLevels() AccessLogLevels {
+	return AccessLogLevels{
+		1: zapcore.InfoLevel,
+		2: zapcore.InfoLevel,
+		3: zapcore.InfoLevel,
+		4: zapcore.WarnLevel,
+		5: zapcore.ErrorLevel,
+	}
+}
+
+// LoadAccessLogLevels applies overrides from ACCESS_LOG_LEVELS (e.g. "2xx=debug,4xx=info") to the defaults.
+// Only debug, info, warn and error are accepted.
+func LoadAccessLogLevels() AccessLogLevels {
+	levels := DefaultAccessLogLevels()
+	value := os.Getenv("ACCESS_LOG_LEVELS")
+	if value == "" {
+		return levels
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			logger.Warn("Invalid ACCESS_LOG_LEVELS entry, expected class=level", zap.String("entry", entry))
+			continue
+		}
+		class, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(parts[0])), "xx"))
+		if err != nil || class < 1 || class > 5 {
+			logger.Warn("Invalid status class in ACCESS_LOG_LEVELS", zap.String("entry", entry))
+			continue
+		}
+		// dpanic, panic and fatal would crash the server on a matching request
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(parts[1]))); err != nil || level < zapcore.DebugLevel || level > zapcore.ErrorLevel {
+			logger.Warn("Invalid level in ACCESS_LOG_LEVELS, expected debug, info, warn or error", zap.String("entry", entry))
+			continue
+		}
+		levels[class] = level
+	}
+	return levels
+}
+
+// Level returns the access log level for a response status code.
+func (l AccessLogLevels) Level(status int) zapcore.Level {
+	if level, ok := l[status/100]; ok {
+		return level
+	}
+	return zapcore.InfoLevel
+}
+
+// AddLogField adds key=value to the request's access log line; adding a key again replaces its
+// value. It must be called from the goroutine handling the request.
+func AddLogField(c *gin.Context, key string, value interface{}) {
+	fields := LogFields(c)
+	for i, field := range fields {
+		if field.Key == key {
+			fields[i] = zap.Any(key, value)
+			return
+		}
+	}
+	c.Set(logFieldsKey, append(fields, zap.Any(key, value)))
+}
+
+// LogFields returns the fields added to the request's access log line with AddLogField.
+func LogFields(c *gin.Context) []zap.Field {
+	value, ok := c.Get(logFieldsKey)
+	if !ok {
+		return nil
+	}
+	return value.([]zap.Field)
+}
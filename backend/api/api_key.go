@@ -0,0 +1,123 @@
+// api_key.go
+// Shared API key authentication for internal service-to-service calls. Requests
+// must carry one of the configured keys in the X-API-Key header unless their path
+// is on the exempt allowlist. This is a lighter-weight gate than JWT auth.
+// Keys can also be issued per tenant, in which case the matched key determines
+// the request's tenant (see tenant.go).
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// APIKeyHeader carries the caller's API key.
+const APIKeyHeader = "X-API-Key"
+
+// DefaultAPIKeyExemptPaths lists the public endpoints served without an API key.
+var DefaultAPIKeyExemptPaths = []string{"/api/health", "/metrics"}
+
+// APIKeyConfig holds the accepted API keys and the paths exempt from the check.
+type APIKeyConfig struct {
+	Keys        []string          // Accepted keys; several may be valid at once during rotation
+	TenantKeys  map[string]string // Accepted keys issued to a tenant, mapped to the tenant ID
+	ExemptPaths []string          // Request paths served without a key
+}
+
+// Enabled reports whether any API keys are configured.
+func (c APIKeyConfig) Enabled() bool {
+	return len(c.Keys) > 0 || len(c.TenantKeys) > 0
+}
+
+// LoadAPIKeyConfig loads API keys from API_KEYS, tenant keys from API_KEY_TENANTS
+// ("tenant=key" pairs) and the allowlist from API_KEY_EXEMPT_PATHS (all comma-separated).
+// Without any keys the check is disabled. Tenant entries with an invalid tenant ID are skipped.
+func LoadAPIKeyConfig() APIKeyConfig {
+	config := APIKeyConfig{
+		Keys:        splitList(os.Getenv("API_KEYS")),
+		ExemptPaths: DefaultAPIKeyExemptPaths,
+	}
+	for i, entry := range splitList(os.Getenv("API_KEY_TENANTS")) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[1] == "" || !validTenantID(parts[0]) {
+			// The entry holds a key, so only its position is logged
+			logger.Warn("Invalid entry in API_KEY_TENANTS, ignoring", zap.Int("entry", i+1))
+			continue
+		}
+		if config.TenantKeys == nil {
+			config.TenantKeys = make(map[string]string)
+		}
+		config.TenantKeys[parts[1]] = parts[0]
+	}
+	if pathsEnv := os.Getenv("API_KEY_EXEMPT_PATHS"); pathsEnv != "" {
+		config.ExemptPaths = splitList(pathsEnv)
+	}
+	return config
+}
+
+// matchAPIKey returns the index of the key in keys matching key, or -1. Keys are hashed to a
+// fixed length and every key is compared, so timing reveals neither key lengths nor which key matched.
+func matchAPIKey(keys [][sha256.Size]byte, key string) int {
+	sum := sha256.Sum256([]byte(key))
+	match := -1
+	for i := range keys {
+		equal := subtle.ConstantTimeCompare(keys[i][:], sum[:])
+		match = subtle.ConstantTimeSelect(equal, i, match)
+	}
+	return match
+}
+
+// APIKeyMiddleware rejects requests to non-exempt paths with 401 unless they carry a valid
+// API key. CORS preflights are let through since browsers send them without credentials.
+func APIKeyMiddleware(config APIKeyConfig) gin.HandlerFunc {
+	// Tenants are parallel to keys; shared keys have no tenant
+	keys := make([][sha256.Size]byte, 0, len(config.Keys)+len(config.TenantKeys))
+	tenants := make([]string, 0, cap(keys))
+	for _, key := range config.Keys {
+		keys = append(keys, sha256.Sum256([]byte(key)))
+		tenants = append(tenants, "")
+	}
+	for key, tenant := range config.TenantKeys {
+		keys = append(keys, sha256.Sum256([]byte(key)))
+		tenants = append(tenants, tenant)
+	}
+	exempt := make(map[string]bool, len(config.ExemptPaths))
+	for _, path := range config.ExemptPaths {
+		exempt[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if exempt[c.Request.URL.Path] || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader(APIKeyHeader)
+		match := -1
+		if key != "" {
+			match = matchAPIKey(keys, key)
+		}
+		if match < 0 {
+			logger.Warn("Rejected request without a valid API key",
+				zap.String("path", c.Request.URL.Path),
+				zap.Bool("key_present", key != ""),
+				zap.String("client_ip", c.ClientIP()),
+			)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, apitypes.ErrorResponse{Error: "missing or invalid API key"})
+			return
+		}
+		if tenants[match] != "" {
+			c.Set(tenantIDContextKey, tenants[match])
+		}
+		c.Next()
+	}
+}
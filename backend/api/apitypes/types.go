@@ -0,0 +1,37 @@
+// types.go
+// Request and response structures shared by the API server and its Go client.
+
+package apitypes
+
+import "encoding/json"
+
+// RequestIDHeader carries the caller-supplied request ID used to correlate logs across services.
+const RequestIDHeader = "X-Request-ID"
+
+// RoutingKeyHeader carries an optional caller key (e.g. user ID) for sticky model variant routing.
+const RoutingKeyHeader = "X-Routing-Key"
+
+// ModelVariantHeader reports which model variant served an inference request.
+const ModelVariantHeader = "X-Model-Variant"
+
+// ModelHeader names the model of a binary inference request, whose body can't carry it.
+const ModelHeader = "X-Model"
+
+// HealthResponse is returned by GET /api/health.
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Version string `json:"version"`
+}
+
+// ErrorResponse is the error envelope returned by every endpoint on failure.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// InferenceRequest is the body of POST /api/inference; it is forwarded to the model server as-is.
+type InferenceRequest struct {
+	Model  string                 `json:"model,omitempty"`
+	Input  json.RawMessage        `json:"input"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
@@ -0,0 +1,165 @@
+// baggage.go
+// Baggage propagation. Services pass request-scoped baggage such as a tenant ID
+// or feature-flag overrides in X-Baggage-<key> headers. BaggageMiddleware
+// accepts only the keys (and, where listed, values) allowed by BAGGAGE_ALLOWED
+// and stores them in the gin context; unknown or malformed baggage is dropped,
+// or rejected with 400 when BAGGAGE_STRICT is set. Accepted baggage is sent on
+// to the inference backend and scopes cache keys, so responses produced under
+// different baggage are never served to each other.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// baggageHeaderPrefix starts the name of every baggage header; the rest is the baggage key.
+const baggageHeaderPrefix = "X-Baggage-"
+
+// baggageContextKey stores the request's accepted baggage in the gin context.
+const baggageContextKey = "baggage"
+
+// maxBaggageValueLength bounds a baggage value.
+const maxBaggageValueLength = 256
+
+// BaggageConfig controls which baggage requests may carry.
+type BaggageConfig struct {
+	Allowed map[string][]string // Accepted keys (lowercase) and their allowed values; an empty list accepts any well-formed value
+	Strict  bool                // Reject requests with unknown or malformed baggage instead of dropping it
+}
+
+// LoadBaggageConfig reads BAGGAGE_ALLOWED, a JSON object mapping baggage keys to their allowed
+// values, e.g. {"tenant-id":[],"flags":["beta","canary"]}, and BAGGAGE_STRICT. Invalid
+// BAGGAGE_ALLOWED accepts no baggage; it is logged and otherwise ignored, unless BAGGAGE_STRICT
+// is set, where it is returned as an error along with that config.
+func LoadBaggageConfig() (BaggageConfig, error) {
+	config := BaggageConfig{Strict: getEnvBool("BAGGAGE_STRICT", false)}
+	allowedEnv := os.Getenv("BAGGAGE_ALLOWED")
+	if allowedEnv == "" {
+		return config, nil
+	}
+	var allowed map[string][]string
+	if err := json.Unmarshal([]byte(allowedEnv), &allowed); err != nil {
+		if config.Strict {
+			return config, fmt.Errorf("invalid BAGGAGE_ALLOWED: %w", err)
+		}
+		logger.Warn("Invalid BAGGAGE_ALLOWED, no baggage is accepted", zap.Error(err))
+		return config, nil
+	}
+	config.Allowed = make(map[string][]string, len(allowed))
+	for key, values := range allowed {
+		config.Allowed[strings.ToLower(key)] = values
+	}
+	return config, nil
+}
+
+// validBaggageValue reports whether value is non-empty, bounded and made only of characters
+// safe to forward in a header and to log.
+func validBaggageValue(value string) bool {
+	if value == "" || len(value) > maxBaggageValueLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("-_.:=,/+", ch) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBaggage validates one baggage header's values against config.
+func (config BaggageConfig) checkBaggage(key string, values []string) error {
+	allowed, ok := config.Allowed[key]
+	if !ok {
+		return fmt.Errorf("unknown baggage key %q", key)
+	}
+	if len(values) != 1 || !validBaggageValue(values[0]) {
+		return fmt.Errorf("malformed value for baggage key %q", key)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, value := range allowed {
+		if value == values[0] {
+			return nil
+		}
+	}
+	return fmt.Errorf("value not allowed for baggage key %q", key)
+}
+
+// BaggageMiddleware parses the request's X-Baggage-* headers, storing the accepted baggage for
+// Baggage. Unknown or malformed baggage is dropped, or answered with 400 in strict mode.
+func BaggageMiddleware(config BaggageConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var baggage map[string]string
+		for name, values := range c.Request.Header {
+			if !strings.HasPrefix(name, baggageHeaderPrefix) {
+				continue
+			}
+			key := strings.ToLower(strings.TrimPrefix(name, baggageHeaderPrefix))
+			if err := config.checkBaggage(key, values); err != nil {
+				if config.Strict {
+					c.AbortWithStatusJSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: err.Error()})
+					return
+				}
+				logger.Debug("Dropping request baggage", zap.Error(err))
+				continue
+			}
+			if baggage == nil {
+				baggage = make(map[string]string)
+			}
+			baggage[key] = values[0]
+		}
+		if baggage != nil {
+			c.Set(baggageContextKey, baggage)
+		}
+		c.Next()
+	}
+}
+
+// Baggage returns the request's accepted baggage, keyed by lowercase key, or nil if it has none.
+func Baggage(c *gin.Context) map[string]string {
+	if value, ok := c.Get(baggageContextKey); ok {
+		if baggage, ok := value.(map[string]string); ok {
+			return baggage
+		}
+	}
+	return nil
+}
+
+// setBaggageHeaders adds baggage to the headers of a downstream request.
+func setBaggageHeaders(header http.Header, baggage map[string]string) {
+	for key, value := range baggage {
+		header.Set(baggageHeaderPrefix+key, value)
+	}
+}
+
+// baggageScopedKey scopes a cache key to baggage, leaving it unchanged without baggage.
+func baggageScopedKey(key string, baggage map[string]string) string {
+	if len(baggage) == 0 {
+		return key
+	}
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, k := range keys {
+		hash.Write([]byte(k + "=" + baggage[k] + "\n"))
+	}
+	return key + ":baggage:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,119 @@
+// cache_admin.go
+// Admin endpoints for inspecting the cache. GET /api/cache/keys lists the keys
+// recorded in a namespace's manifest so ops can see what is cached before
+// invalidating it. Memcached can't enumerate keys, so the list is best-effort:
+// it may include keys that have since expired and miss keys the manifest failed
+// to record (see config.MemcachedConfig.ListKeys).
+//
+// GET /api/cache/warm/status reports whether a warm-up is running and the
+// results of the last one, so ops can confirm warm-ups ran without reading logs.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+	"your_project/config"   // Replace with your actual package path for the cache clients
+)
+
+// CacheKeysResponse is the response of GET /api/cache/keys.
+type CacheKeysResponse struct {
+	Namespace string   `json:"namespace"`
+	Keys      []string `json:"keys"`
+	Count     int      `json:"count"`
+}
+
+// keyLister is implemented by caches that can list a namespace's recorded keys
+// (satisfied by config.MemcachedConfig).
+type keyLister interface {
+	ListKeys(namespace string) ([]string, error)
+}
+
+// CacheKeysHandler serves GET /api/cache/keys?namespace=... from store's manifests.
+func CacheKeysHandler(store ResponseCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace := c.Query("namespace")
+		if namespace == "" {
+			c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "namespace query parameter is required"})
+			return
+		}
+		lister, ok := store.(keyLister)
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, apitypes.ErrorResponse{Error: "cache key listing requires a Memcached backend"})
+			return
+		}
+
+		keys, err := lister.ListKeys(namespace)
+		if errors.Is(err, config.ErrManifestDisabled) {
+			c.JSON(http.StatusNotFound, apitypes.ErrorResponse{Error: "no manifest is kept for namespace " + namespace})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to list cache keys", zap.String("namespace", namespace), zap.Error(err))
+			c.JSON(http.StatusBadGateway, apitypes.ErrorResponse{Error: "failed to read cache manifest"})
+			return
+		}
+		c.JSON(http.StatusOK, CacheKeysResponse{Namespace: namespace, Keys: keys, Count: len(keys)})
+	}
+}
+
+// CacheWarmStatusResponse is the response of GET /api/cache/warm/status.
+type CacheWarmStatusResponse struct {
+	InProgress bool          `json:"in_progress"`
+	LastWarm   *CacheWarmRun `json:"last_warm"` // null until a warm-up has finished
+}
+
+// CacheWarmRun describes a finished warm-up.
+type CacheWarmRun struct {
+	Mode            string    `json:"mode"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Attempted       int       `json:"attempted"`
+	Succeeded       int       `json:"succeeded"`
+	Skipped         int       `json:"skipped"`
+	Failed          int       `json:"failed"`
+	Error           string    `json:"error,omitempty"` // Why the warm-up stopped early
+}
+
+// warmStatusReporter is implemented by caches that track their warm-ups
+// (satisfied by config.MemcachedConfig).
+type warmStatusReporter interface {
+	WarmStatus() config.WarmStatus
+}
+
+// CacheWarmStatusHandler serves GET /api/cache/warm/status from store's warm-up state.
+func CacheWarmStatusHandler(store ResponseCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reporter, ok := store.(warmStatusReporter)
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, apitypes.ErrorResponse{Error: "cache warm-up status requires a Memcached backend"})
+			return
+		}
+
+		status := reporter.WarmStatus()
+		response := CacheWarmStatusResponse{InProgress: status.InProgress}
+		if last := status.Last; last != nil {
+			response.LastWarm = &CacheWarmRun{
+				Mode:            last.Mode.String(),
+				StartedAt:       last.StartedAt,
+				FinishedAt:      last.FinishedAt,
+				DurationSeconds: last.FinishedAt.Sub(last.StartedAt).Seconds(),
+				Attempted:       last.Attempted,
+				Succeeded:       last.Stats.Stored,
+				Skipped:         last.Stats.Skipped,
+				Failed:          last.Stats.Failed,
+			}
+			if last.Err != nil {
+				response.LastWarm.Error = last.Err.Error()
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,64 @@
+// cache_control.go
+// Cache-Control headers for edge caches (CDNs). Route groups attach
+// CacheControlMiddleware with the policy that suits them; this is separate from
+// the internal Memcached response cache. Requests carrying credentials always
+// get "private, no-store" so personalized responses never land in a shared cache.
+
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// noStoreCacheControl is sent for authenticated requests regardless of the route's policy.
+const noStoreCacheControl = "private, no-store"
+
+// CacheControlConfig holds the Cache-Control policies of the route groups.
+type CacheControlConfig struct {
+	Public string // CDN-frontable GET endpoints
+	Health string // Health checks, which must reach the server every time
+}
+
+// DefaultCacheControlConfig provides default Cache-Control policies.
+func DefaultCacheControlConfig() CacheControlConfig {
+	return CacheControlConfig{
+		Public: "public, max-age=10, s-maxage=30",
+		Health: "no-cache",
+	}
+}
+
+// LoadCacheControlConfig loads Cache-Control policies from CACHE_CONTROL_PUBLIC and
+// CACHE_CONTROL_HEALTH or defaults.
+func LoadCacheControlConfig() CacheControlConfig {
+	config := DefaultCacheControlConfig()
+	if value := os.Getenv("CACHE_CONTROL_PUBLIC"); value != "" {
+		config.Public = value
+	}
+	if value := os.Getenv("CACHE_CONTROL_HEALTH"); value != "" {
+		config.Health = value
+	}
+	return config
+}
+
+// authenticatedRequest reports whether r carries credentials, making its response personal.
+func authenticatedRequest(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(APIKeyHeader) != "" || r.Header.Get("Cookie") != ""
+}
+
+// CacheControlMiddleware sets Cache-Control to value on GET and HEAD responses, or to
+// "private, no-store" for authenticated requests. Handlers may still override it.
+func CacheControlMiddleware(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			if authenticatedRequest(c.Request) {
+				c.Header("Cache-Control", noStoreCacheControl)
+			} else if value != "" {
+				c.Header("Cache-Control", value)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,140 @@
+// client.go
+// Typed Go client for the API server, for internal services that call it.
+// It sets the request ID header, decodes the error envelope into APIError,
+// and returns typed results.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// Client calls the API server.
+type Client struct {
+	BaseURL    string // Server root, e.g. "http://api:8080"
+	HTTPClient *http.Client
+}
+
+// New creates a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// APIError is returned when the server responds with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s (request ID %s)", e.StatusCode, e.Message, e.RequestID)
+}
+
+// InferenceResponse is the result of an inference call.
+type InferenceResponse struct {
+	Result      json.RawMessage // Model server output
+	CacheStatus string          // Value of the X-Cache header (HIT, MISS, ...)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose calls carry the given request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// requestID returns the request ID from ctx, generating one if none was set.
+func requestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// do sends a request and returns the raw response body, converting error responses to *APIError.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}) ([]byte, http.Header, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	id := requestID(ctx)
+	req.Header.Set(apitypes.RequestIDHeader, id)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode), RequestID: id}
+		var envelope apitypes.ErrorResponse
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error != "" {
+			apiErr.Message = envelope.Error
+		}
+		return nil, nil, apiErr
+	}
+	return data, resp.Header, nil
+}
+
+// Health returns the server health status.
+func (c *Client) Health(ctx context.Context) (*apitypes.HealthResponse, error) {
+	data, _, err := c.do(ctx, http.MethodGet, "/api/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	var health apitypes.HealthResponse
+	if err := json.Unmarshal(data, &health); err != nil {
+		return nil, fmt.Errorf("failed to decode health response: %v", err)
+	}
+	return &health, nil
+}
+
+// Infer runs an inference request.
+func (c *Client) Infer(ctx context.Context, req apitypes.InferenceRequest) (*InferenceResponse, error) {
+	data, header, err := c.do(ctx, http.MethodPost, "/api/inference", req)
+	if err != nil {
+		return nil, err
+	}
+	return &InferenceResponse{
+		Result:      json.RawMessage(data),
+		CacheStatus: header.Get("X-Cache"),
+	}, nil
+}
@@ -0,0 +1,93 @@
+// coalesce.go
+// Request coalescing: concurrent calls sharing a key wait for a single
+// execution and all receive its result.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errCoalescedPanic is returned to callers waiting on a call that panicked; the caller that ran
+// it panics as usual.
+var errCoalescedPanic = errors.New("coalesced call panicked")
+
+// coalescedCall is an in-progress or completed call shared by concurrent callers.
+type coalescedCall struct {
+	done  chan struct{} // Closed once value and err are set
+	value interface{}
+	err   error
+	dups  int // Callers waiting for the result instead of running the call
+}
+
+// callGroup deduplicates concurrent calls by key.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// Do runs fn once for all concurrent callers with the same key and reports whether the
+// result was shared with (produced by) another caller. A waiting caller gives up with
+// ctx.Err() when ctx is done, leaving the call running for the others. If fn panics, the
+// caller running it panics and the waiting callers get an error wrapping errCoalescedPanic.
+func (g *callGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*coalescedCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err, true
+		case <-ctx.Done():
+			g.mu.Lock()
+			call.dups--
+			g.mu.Unlock()
+			return nil, ctx.Err(), true
+		}
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			// Release the waiters before the panic (or runtime.Goexit) carries on up the stack
+			recovered := recover()
+			call.err = fmt.Errorf("%w: %v", errCoalescedPanic, recovered)
+			g.finish(key, call)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}
+	}()
+	call.value, call.err = fn()
+	returned = true
+	g.finish(key, call)
+	return call.value, call.err, false
+}
+
+// finish publishes call's result to its waiters and forgets it, so later callers run fn again.
+func (g *callGroup) finish(key string, call *coalescedCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// waiting returns the number of callers waiting for in-progress calls.
+func (g *callGroup) waiting() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	total := 0
+	for _, call := range g.calls {
+		total += call.dups
+	}
+	return total
+}
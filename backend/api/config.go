@@ -0,0 +1,213 @@
+// config.go
+// Server configuration resolved from environment variables with sensible defaults.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// ServerConfig holds the HTTP server settings.
+type ServerConfig struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // Time allowed for in-flight requests to drain on shutdown
+	CacheRequired   bool          // Serve cache-dependent routes only once Memcached connects; exit if it doesn't within StartupTimeout
+	StartupTimeout  time.Duration // Time allowed for dependencies (e.g. Memcached) to become reachable at startup
+}
+
+// DefaultServerConfig provides default values for the HTTP server.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:            ":8080",
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		StartupTimeout:  30 * time.Second,
+	}
+}
+
+// LoadServerConfig loads server configuration from environment variables or defaults.
+func LoadServerConfig() ServerConfig {
+	config := DefaultServerConfig()
+	if addr := os.Getenv("SERVER_ADDR"); addr != "" {
+		config.Addr = addr
+	}
+	config.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", config.ShutdownTimeout)
+	config.CacheRequired = getEnvBool("CACHE_REQUIRED", config.CacheRequired)
+	config.StartupTimeout = getEnvDuration("STARTUP_TIMEOUT", config.StartupTimeout)
+	return config
+}
+
+// DefaultCORSAllowMethods lists the HTTP methods allowed by CORS unless overridden.
+var DefaultCORSAllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
+// LoadCORSAllowMethods returns the CORS allowed methods from CORS_ALLOW_METHODS (comma-separated) or defaults.
+func LoadCORSAllowMethods() []string {
+	methodsEnv := os.Getenv("CORS_ALLOW_METHODS")
+	if methodsEnv == "" {
+		return DefaultCORSAllowMethods
+	}
+	var methods []string
+	for _, method := range strings.Split(methodsEnv, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// LoadCORSPreflightBypass reports whether CORS preflight requests are answered before the
+// logging and metrics middleware (CORS_PREFLIGHT_BYPASS, default true), keeping them out of
+// request logs and metrics.
+func LoadCORSPreflightBypass() bool {
+	return getEnvBool("CORS_PREFLIGHT_BYPASS", true)
+}
+
+// getEnvBool parses a boolean (e.g. "true", "0") from an environment variable, falling back on absence or error.
+func getEnvBool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn("Invalid boolean in environment, using default",
+			zap.String("name", name),
+			zap.String("value", value),
+			zap.Bool("default", fallback),
+		)
+		return fallback
+	}
+	return parsed
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvInt parses a non-negative integer from an environment variable, falling back on absence or error.
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		logger.Warn("Invalid integer in environment, using default",
+			zap.String("name", name),
+			zap.String("value", value),
+			zap.Int("default", fallback),
+		)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvDuration parses a duration (e.g. "30s") from an environment variable, falling back on absence or error.
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		logger.Warn("Invalid duration in environment, using default",
+			zap.String("name", name),
+			zap.String("value", value),
+			zap.Duration("default", fallback),
+		)
+		return fallback
+	}
+	return duration
+}
+
+// redactedValue replaces secret values in logged configuration.
+const redactedValue = "[REDACTED]"
+
+// secretEnvVars lists environment variables whose values must never be logged.
+var secretEnvVars = []string{"JWT_SECRET", "REDIS_PASSWORD", "DB_PASSWORD", "API_KEYS", "API_KEY_TENANTS", "MEMCACHED_ENCRYPTION_KEYS"}
+
+// redactServerAddr strips credentials (user:pass@) from a server address.
+func redactServerAddr(addr string) string {
+	if at := strings.LastIndex(addr, "@"); at >= 0 {
+		return redactedValue + addr[at:]
+	}
+	return addr
+}
+
+// configSummary describes the effective configuration, with secrets redacted, for the startup log.
+func configSummary(server ServerConfig, responseCache ResponseCacheConfig, memcached *config.MemcachedConfig) map[string]interface{} {
+	summary := map[string]interface{}{
+		"addr":                server.Addr,
+		"read_timeout":        server.ReadTimeout.String(),
+		"write_timeout":       server.WriteTimeout.String(),
+		"idle_timeout":        server.IdleTimeout.String(),
+		"shutdown_timeout":    server.ShutdownTimeout.String(),
+		"cache_required":      server.CacheRequired,
+		"startup_timeout":     server.StartupTimeout.String(),
+		"cors_allow_methods":  LoadCORSAllowMethods(),
+		"method_overrides":    LoadMethodOverrideMethods(),
+		"middleware":          routerMiddleware(LoadCORSPreflightBypass(), LoadAPIKeyConfig().Enabled()),
+		"metrics_path":        "/metrics",
+		"metrics_cache_ttl":   LoadMetricsCacheTTL().String(),
+		"metrics_max_labels":  LoadMetricsMaxLabelValues(),
+		"response_cache_ttl":  responseCache.TTL.String(),
+		"response_stale_ttl":  responseCache.StaleTTL.String(),
+		"cache_status_header": responseCache.StatusHeader,
+		"cache_backend":       "none",
+	}
+
+	if memcached != nil {
+		servers := make([]string, len(memcached.Servers))
+		for i, server := range memcached.Servers {
+			servers[i] = redactServerAddr(server)
+		}
+		summary["cache_backend"] = "memcached"
+		summary["cache_servers"] = servers
+		summary["cache_timeout"] = memcached.Timeout.String()
+		summary["cache_default_expiry"] = memcached.DefaultExpiry.String()
+		summary["cache_fallback_enabled"] = memcached.FallbackEnabled
+		summary["cache_key_prefix"] = memcached.KeyPrefix
+		summary["cache_origin_concurrency"] = memcached.OriginConcurrency
+	}
+
+	// Report only whether secrets are configured, never their values
+	secrets := make(map[string]string)
+	for _, name := range secretEnvVars {
+		if os.Getenv(name) != "" {
+			secrets[name] = redactedValue
+		} else {
+			secrets[name] = "unset"
+		}
+	}
+	summary["secrets"] = secrets
+
+	return summary
+}
+
+// logConfigSummary logs the effective configuration as a single structured line.
+func logConfigSummary(summary map[string]interface{}) {
+	fields := make([]zap.Field, 0, len(summary))
+	for key, value := range summary {
+		fields = append(fields, zap.Any(key, value))
+	}
+	logger.Info("Effective configuration", fields...)
+}
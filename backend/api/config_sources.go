@@ -0,0 +1,224 @@
+// config_sources.go
+// Configuration file support and setting provenance. CONFIG_FILE names a file
+// of NAME=value lines using the same names as the environment variables;
+// values in the environment override the file. File values are applied to the
+// process environment at startup, so every loader reads them unchanged.
+//
+// Where each setting came from (default, file or env) is logged at debug level
+// and served, secrets redacted, by GET /api/config/sources.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Sources a setting's value can come from.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// configSettings lists the settings read by the server and its cache client, so settings left
+// at their defaults are reported too.
+var configSettings = []string{
+	"ACCESS_LOG_LEVELS", "API_KEYS", "API_KEY_EXEMPT_PATHS", "API_KEY_TENANTS", "APP_ENV", "BAGGAGE_ALLOWED", "BAGGAGE_STRICT",
+	"CACHE_CONTROL_HEALTH", "CACHE_CONTROL_PUBLIC", "CACHE_PROBE_KEY", "CACHE_PROBE_MIN_INTERVAL",
+	"CACHE_REQUIRED", "CACHE_STATUS_HEADER", "CORS_ALLOW_METHODS", "CORS_PREFLIGHT_BYPASS", "ENV", "GIN_MODE",
+	"INFERENCE_BACKEND_URL", "INFERENCE_BUDGETS", "INFERENCE_CONTENT_TYPES", "INFERENCE_BUDGET_DEFAULT", "INFERENCE_BUDGET_WINDOW", "INFERENCE_CACHE_TTL", "INFERENCE_DISALLOW_UNKNOWN_FIELDS",
+	"INFERENCE_FIELD_MAPPINGS", "INFERENCE_MAX_BINARY_BYTES", "INFERENCE_MAX_CONCURRENT", "INFERENCE_MAX_JSON_DEPTH",
+	"INFERENCE_MAX_JSON_TOKENS", "INFERENCE_MAX_RESPONSE_BYTES", "INFERENCE_MODEL_ROUTES",
+	"INFERENCE_MODEL_TIMEOUTS", "INFERENCE_QUEUE_DEPTH", "INFERENCE_QUEUE_WAIT", "INFERENCE_RESPONSE_SCHEMAS",
+	"INFERENCE_RESPONSE_STALL_TIMEOUT", "INFERENCE_RETRY_AFTER_BASE", "INFERENCE_RETRY_AFTER_JITTER", "INFERENCE_STATS_WINDOW", "INFERENCE_STREAM_CACHE_MAX_BYTES",
+	"INFERENCE_STREAM_REPLAY_PACED", "INFERENCE_TIMEOUT", "INFERENCE_WORKERS",
+	"LOG_FORMAT", "LOG_FORMAT_ALLOW_CONSOLE", "LOG_OUTPUTS",
+	"MEMCACHED_BLOCKCHAIN_ENCODING", "MEMCACHED_CHECKSUMS", "MEMCACHED_COMPRESS_MIN_BYTES", "MEMCACHED_ENCRYPTED_NAMESPACES",
+	"MEMCACHED_ENCRYPTION_KEY_VERSION", "MEMCACHED_ERROR_LOG_WINDOW_SECONDS",
+	"MEMCACHED_FALLBACK_ENABLED", "MEMCACHED_FALLBACK_MAX_ITEMS", "MEMCACHED_KEY_PREFIX",
+	"MEMCACHED_LOG_SAMPLE_RATE", "MEMCACHED_MAX_SERVERS", "MEMCACHED_MULTIGET_CHUNK_SIZE",
+	"MEMCACHED_ORIGIN_CONCURRENCY", "MEMCACHED_ORIGIN_WAIT_SECONDS", "MEMCACHED_PING_ATTEMPTS",
+	"MEMCACHED_SECONDARY_SERVERS", "MEMCACHED_SERVERS", "MEMCACHED_TTL_POLICY_URL",
+	"METHOD_OVERRIDE_METHODS", "METRICS_CACHE_TTL", "METRICS_MAX_LABEL_VALUES",
+	"REQUEST_CACHE_OP_BUDGET", "RESPONSE_CACHE_EXCLUDE_PATHS", "RESPONSE_CACHE_STALE_SECONDS", "RESPONSE_CACHE_STATUSES",
+	"RESPONSE_CACHE_TTL_SECONDS", "SELFTEST", "SERVER_ADDR", "SHUTDOWN_TIMEOUT",
+	"SLO_ERROR_RATE_TARGET", "SLO_P99_LATENCY_TARGET", "SLO_WINDOW", "STARTUP_TIMEOUT",
+	"WATCHDOG_INTERVAL", "WATCHDOG_THRESHOLD",
+}
+
+// configSources records where settings came from; nil until main has loaded the config file.
+var configSources *ConfigSources
+
+// ConfigSetting is a setting's final value and where it came from.
+type ConfigSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"` // Empty when the setting is at its default
+	Source string `json:"source"`
+}
+
+// ConfigSources records which settings were set by the config file or the environment.
+type ConfigSources struct {
+	File    string            // Config file path, empty if none was used
+	sources map[string]string // Setting name to SourceFile or SourceEnv
+}
+
+// LoadConfigFile applies the settings in CONFIG_FILE that are not set in the environment and
+// records the source of every setting.
+func LoadConfigFile() (*ConfigSources, error) {
+	return loadConfigFile(os.Getenv("CONFIG_FILE"))
+}
+
+// loadConfigFile applies the settings in the file at path (none if empty) for LoadConfigFile.
+func loadConfigFile(path string) (*ConfigSources, error) {
+	sources := &ConfigSources{File: path, sources: make(map[string]string)}
+	for _, name := range append(configSettings, secretEnvVars...) {
+		if _, ok := os.LookupEnv(name); ok {
+			sources.sources[name] = SourceEnv
+		}
+	}
+	if path == "" {
+		return sources, nil
+	}
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range values {
+		if _, ok := os.LookupEnv(name); ok {
+			sources.sources[name] = SourceEnv
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, name, err)
+		}
+		sources.sources[name] = SourceFile
+	}
+	return sources, nil
+}
+
+// readConfigFile parses NAME=value lines, skipping blank lines and # comments. Values may be
+// wrapped in double quotes.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("config file %s:%d: expected NAME=value", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Source returns where the setting name came from.
+func (s *ConfigSources) Source(name string) string {
+	if s == nil {
+		if _, ok := os.LookupEnv(name); ok {
+			return SourceEnv
+		}
+		return SourceDefault
+	}
+	if source, ok := s.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Settings returns every known setting and every setting from the file, sorted by name, with
+// secret values redacted.
+func (s *ConfigSources) Settings() []ConfigSetting {
+	names := make(map[string]bool)
+	for _, name := range append(configSettings, secretEnvVars...) {
+		names[name] = true
+	}
+	if s != nil {
+		for name := range s.sources {
+			names[name] = true
+		}
+	}
+
+	settings := make([]ConfigSetting, 0, len(names))
+	for name := range names {
+		setting := ConfigSetting{Name: name, Source: s.Source(name)}
+		if setting.Source != SourceDefault {
+			setting.Value = redactSetting(name, os.Getenv(name))
+		}
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings
+}
+
+// redactSetting hides secret values and the credentials in server addresses.
+func redactSetting(name, value string) string {
+	for _, secret := range secretEnvVars {
+		if name == secret {
+			return redactedValue
+		}
+	}
+	if strings.HasSuffix(name, "_SERVERS") {
+		servers := splitList(value)
+		for i, server := range servers {
+			servers[i] = redactServerAddr(server)
+		}
+		return strings.Join(servers, ",")
+	}
+	return value
+}
+
+// Log records each setting's final value and source at debug level.
+func (s *ConfigSources) Log() {
+	for _, setting := range s.Settings() {
+		logger.Debug("Config setting resolved",
+			zap.String("name", setting.Name),
+			zap.String("value", setting.Value),
+			zap.String("source", setting.Source),
+		)
+	}
+}
+
+// ConfigSourcesResponse is the response of GET /api/config/sources.
+type ConfigSourcesResponse struct {
+	File     string          `json:"file,omitempty"`
+	Settings []ConfigSetting `json:"settings"`
+}
+
+// ConfigSourcesHandler serves GET /api/config/sources. With nil sources (no config file was
+// loaded) settings are attributed to the environment or their defaults.
+func ConfigSourcesHandler(sources *ConfigSources) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var file string
+		if sources != nil {
+			file = sources.File
+		}
+		c.JSON(http.StatusOK, ConfigSourcesResponse{File: file, Settings: sources.Settings()})
+	}
+}
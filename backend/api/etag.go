@@ -0,0 +1,73 @@
+// etag.go
+// ETag generation and conditional GET handling. Successful GET responses get a
+// strong ETag derived from the body, and requests whose If-None-Match matches
+// it receive 304 Not Modified without the body.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// computeETag returns a strong ETag for a response body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag (weak comparison).
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ETagMiddleware sets an ETag on GET 200 responses and answers matching conditional requests with 304.
+// An ETag already set downstream (e.g. stored with a cached response) is reused rather than recomputed.
+func ETagMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+		if recorder.detached() {
+			// Upgraded and streamed responses have already gone out as written
+			return
+		}
+
+		status := recorder.Status()
+		if status == http.StatusOK {
+			etag := c.Writer.Header().Get("ETag")
+			if etag == "" {
+				etag = computeETag(recorder.body.Bytes())
+				c.Header("ETag", etag)
+			}
+			if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+				c.Writer.Header().Del("Content-Length")
+				c.Writer.WriteHeader(http.StatusNotModified)
+				c.Writer.WriteHeaderNow()
+				return
+			}
+		}
+
+		c.Writer.WriteHeader(status)
+		c.Writer.Write(recorder.body.Bytes())
+	}
+}
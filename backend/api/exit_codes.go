@@ -0,0 +1,65 @@
+// exit_codes.go
+// Process exit codes and shutdown reasons. Orchestration tooling keys on the
+// exit code to decide whether a restart can help: 0 means a clean, requested
+// shutdown; the other codes distinguish bad configuration (restarting won't
+// help) from an unavailable dependency or a failed listener (it may).
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes returned by the server process. Config and dependency codes follow sysexits.h.
+const (
+	ExitOK         = 0  // Clean shutdown after a signal
+	ExitFailure    = 1  // Unexpected failure while running or shutting down
+	ExitStartup    = 2  // The server could not start listening
+	ExitDependency = 69 // A required dependency was unavailable (EX_UNAVAILABLE)
+	ExitConfig     = 78 // Invalid configuration (EX_CONFIG)
+)
+
+// Shutdown reasons carried by the final log line.
+const (
+	ReasonSignal             = "signal"
+	ReasonConfig             = "config_invalid"
+	ReasonDependency         = "dependency_unavailable"
+	ReasonListen             = "listen_failed"
+	ReasonShutdownIncomplete = "shutdown_incomplete"
+	ReasonSelfTest           = "selftest_failed"
+	ReasonUnknown            = "unknown_error"
+)
+
+// ExitError is an error that ends the process with a specific exit code and reason.
+type ExitError struct {
+	Code   int
+	Reason string
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitError wraps err so the process exits with code, logging reason.
+func exitError(code int, reason string, err error) error {
+	return &ExitError{Code: code, Reason: reason, Err: err}
+}
+
+// ExitCode maps the error returned by Run to a process exit code and shutdown reason.
+// A nil error is a clean shutdown; errors not produced by exitError are reported as ExitFailure.
+func ExitCode(err error) (int, string) {
+	if err == nil {
+		return ExitOK, ReasonSignal
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code, exitErr.Reason
+	}
+	return ExitFailure, ReasonUnknown
+}
@@ -0,0 +1,470 @@
+// inference.go
+// Proxy for the AI model inference backend. Inference requests are forwarded to
+// the configured model server, and valid JSON results are cached by request body.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+	"your_project/config"   // Replace with your actual package path for the cache clients
+)
+
+// maxLoggedBodySample caps how much of an invalid upstream body is logged.
+const maxLoggedBodySample = 512
+
+// Inference metrics for correlating latency with payload size and cache usage.
+var (
+	inferenceRequestSizeBytes = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "inference_request_size_bytes",
+			Help:    "Size of inference request bodies in bytes.",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+	)
+	inferenceDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "inference_duration_seconds",
+			Help:    "Duration of inference requests in seconds, partitioned by whether the result was cached.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"cached"},
+	)
+)
+
+// errInvalidUpstreamResponse is returned when the inference backend replies with something other than JSON.
+var errInvalidUpstreamResponse = errors.New("inference backend returned a non-JSON response")
+
+// InferenceConfig holds the settings for the inference backend.
+type InferenceConfig struct {
+	BackendURL    string                   // Model server endpoint receiving inference requests
+	Timeout       time.Duration            // Time allowed for a single upstream call
+	ModelTimeouts map[string]time.Duration // Per-model overrides of Timeout
+	CacheTTL      time.Duration            // Time an inference result is cached
+	MaxConcurrent int                      // Maximum inference requests handled at once; zero means unlimited
+	Workers       int                      // Maximum concurrent upstream calls; zero means unlimited
+	QueueDepth    int                      // Maximum calls waiting for a worker
+	QueueWait     time.Duration            // Maximum time a call waits for a worker
+
+	ContentTypes   map[string][]string // Per-model accepted request media types; models not listed accept JSON only
+	MaxBinaryBytes int64               // Maximum size of a binary request body; zero means unlimited
+
+	MaxJSONDepth          int  // Maximum nesting depth of a request body; zero means unlimited
+	MaxJSONTokens         int  // Maximum JSON tokens in a request body; zero means unlimited
+	DisallowUnknownFields bool // Reject request fields InferenceRequest does not define
+
+	MaxResponseBytes     int64         // Maximum size of a backend response body; zero means unlimited
+	ResponseStallTimeout time.Duration // Maximum time without body data from the backend; zero means unlimited
+
+	StreamCacheMaxBytes int  // Largest batch stream recorded for replay; zero disables stream caching
+	StreamReplayPaced   bool // Replay cached streams with their original timing instead of immediately
+
+	RetryAfterBase   time.Duration // Retry-After sent when a backend call fails; zero sends none
+	RetryAfterJitter time.Duration // Maximum random delay added to RetryAfterBase
+}
+
+// DefaultInferenceConfig provides default values for the inference backend.
+func DefaultInferenceConfig() InferenceConfig {
+	return InferenceConfig{
+		BackendURL:    "http://localhost:9000/v1/infer",
+		Timeout:       30 * time.Second,
+		CacheTTL:      5 * time.Minute,
+		MaxConcurrent: 256,
+		QueueDepth:    100,
+		QueueWait:     2 * time.Second,
+		MaxJSONDepth:  32,
+		MaxJSONTokens: 10000,
+
+		MaxBinaryBytes: 10 << 20,
+
+		MaxResponseBytes:     10 << 20,
+		ResponseStallTimeout: 10 * time.Second,
+
+		StreamCacheMaxBytes: 1 << 20,
+
+		RetryAfterJitter: 5 * time.Second,
+	}
+}
+
+// LoadInferenceConfig loads inference configuration from environment variables or defaults.
+func LoadInferenceConfig() InferenceConfig {
+	config := DefaultInferenceConfig()
+	if url := os.Getenv("INFERENCE_BACKEND_URL"); url != "" {
+		config.BackendURL = url
+	}
+	config.Timeout = getEnvDuration("INFERENCE_TIMEOUT", config.Timeout)
+	if timeoutsEnv := os.Getenv("INFERENCE_MODEL_TIMEOUTS"); timeoutsEnv != "" {
+		config.ModelTimeouts = parseModelTimeouts(timeoutsEnv)
+	}
+	config.CacheTTL = getEnvDuration("INFERENCE_CACHE_TTL", config.CacheTTL)
+	config.MaxConcurrent = getEnvInt("INFERENCE_MAX_CONCURRENT", config.MaxConcurrent)
+	config.Workers = getEnvInt("INFERENCE_WORKERS", config.Workers)
+	config.QueueDepth = getEnvInt("INFERENCE_QUEUE_DEPTH", config.QueueDepth)
+	config.QueueWait = getEnvDuration("INFERENCE_QUEUE_WAIT", config.QueueWait)
+	config.ContentTypes = LoadModelContentTypes()
+	config.MaxBinaryBytes = int64(getEnvInt("INFERENCE_MAX_BINARY_BYTES", int(config.MaxBinaryBytes)))
+	config.MaxJSONDepth = getEnvInt("INFERENCE_MAX_JSON_DEPTH", config.MaxJSONDepth)
+	config.MaxJSONTokens = getEnvInt("INFERENCE_MAX_JSON_TOKENS", config.MaxJSONTokens)
+	config.DisallowUnknownFields = getEnvBool("INFERENCE_DISALLOW_UNKNOWN_FIELDS", config.DisallowUnknownFields)
+	config.MaxResponseBytes = int64(getEnvInt("INFERENCE_MAX_RESPONSE_BYTES", int(config.MaxResponseBytes)))
+	config.ResponseStallTimeout = getEnvDuration("INFERENCE_RESPONSE_STALL_TIMEOUT", config.ResponseStallTimeout)
+	config.StreamCacheMaxBytes = getEnvInt("INFERENCE_STREAM_CACHE_MAX_BYTES", config.StreamCacheMaxBytes)
+	config.StreamReplayPaced = getEnvBool("INFERENCE_STREAM_REPLAY_PACED", config.StreamReplayPaced)
+	config.RetryAfterBase = getEnvDuration("INFERENCE_RETRY_AFTER_BASE", config.RetryAfterBase)
+	config.RetryAfterJitter = getEnvDuration("INFERENCE_RETRY_AFTER_JITTER", config.RetryAfterJitter)
+	return config
+}
+
+// parseModelTimeouts parses per-model timeouts of the form "classifier=200ms,generator=30s",
+// skipping invalid entries with a warning.
+func parseModelTimeouts(value string) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			if timeout, err := time.ParseDuration(strings.TrimSpace(parts[1])); err == nil && timeout > 0 {
+				timeouts[strings.TrimSpace(parts[0])] = timeout
+				continue
+			}
+		}
+		logger.Warn("Invalid entry in INFERENCE_MODEL_TIMEOUTS, ignoring", zap.String("entry", entry))
+	}
+	return timeouts
+}
+
+// InferenceService forwards inference requests to the model server.
+type InferenceService struct {
+	Config  InferenceConfig
+	Client  *http.Client
+	Cacher  *ResponseCacher
+	Router  *ModelRouter            // Optional weighted routing of model names to variants
+	Schemas *ResponseSchemaRegistry // Optional per-model validation of backend responses
+	Hooks   *InferenceHookRegistry  // Optional per-model request and response transformations
+	Budget  *InferenceBudget        // Optional per-caller cost budgets
+
+	inflight    callGroup       // Coalesces concurrent identical requests into one backend call
+	queue       *inferenceQueue // Limits concurrent backend calls; nil when unlimited
+	concurrency chan struct{}   // Caps requests handled at once; nil when unlimited
+}
+
+// NewInferenceService creates an inference service caching results through cacher.
+func NewInferenceService(cacher *ResponseCacher, config InferenceConfig) *InferenceService {
+	return &InferenceService{
+		Config:      config,
+		Client:      &http.Client{}, // Deadlines are set per call from the model's timeout
+		Cacher:      cacher,
+		queue:       newInferenceQueue(config.Workers, config.QueueDepth, config.QueueWait),
+		concurrency: newInferenceSemaphore(config.MaxConcurrent),
+	}
+}
+
+// inferenceTarget is the model server chosen for a request.
+type inferenceTarget struct {
+	Model       string
+	Variant     string // Empty unless the model is routed to a variant
+	BackendURL  string
+	ContentType string            // Media type of a binary request body; empty for JSON
+	Baggage     map[string]string // Request baggage sent on to the backend; it also scopes the cache key
+}
+
+// modelTimeout returns the upstream timeout for model, defaulting to the global timeout.
+func (s *InferenceService) modelTimeout(model string) time.Duration {
+	if timeout, ok := s.Config.ModelTimeouts[model]; ok {
+		return timeout
+	}
+	return s.Config.Timeout
+}
+
+// detachWithDeadline returns a context that is not cancelled with parent but keeps its deadline,
+// so a shared backend call outlives any one caller yet still honors request timeouts.
+func detachWithDeadline(parent context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := parent.Deadline(); ok {
+		return context.WithDeadline(context.Background(), deadline)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// resolveTarget picks the backend for an inference request body, routing by its model name.
+func (s *InferenceService) resolveTarget(body []byte, routingKey string) inferenceTarget {
+	var request apitypes.InferenceRequest
+	json.Unmarshal(body, &request)
+	return s.resolveModel(request.Model, routingKey)
+}
+
+// resolveModel picks the backend for an inference request for model.
+func (s *InferenceService) resolveModel(model string, routingKey string) inferenceTarget {
+	target := inferenceTarget{Model: model, BackendURL: s.Config.BackendURL}
+	if variant, ok := s.Router.Route(model, routingKey); ok {
+		target.Variant = variant.Name
+		target.BackendURL = variant.BackendURL
+		inferenceRoutedTotal.WithLabelValues(model, variant.Name).Inc()
+	}
+	return target
+}
+
+// normalizeInferenceBody re-encodes a JSON body canonically (sorted keys, no insignificant
+// whitespace) so equivalent requests share cache entries and coalesced backend calls. Numbers
+// keep their literal text, so values that differ only beyond float64 precision stay distinct.
+func normalizeInferenceBody(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return body
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return body
+	}
+	normalized, err := json.Marshal(decoded)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+// inferenceCacheKey derives the cache and coalescing key for an inference request body sent to target.
+// Binary bodies don't name their model, so their key covers the model and media type too.
+func inferenceCacheKey(target inferenceTarget, body []byte) string {
+	var sum [sha256.Size]byte
+	if target.ContentType != "" {
+		sum = sha256.Sum256(append([]byte(target.Model+"\x00"+target.ContentType+"\x00"), body...))
+	} else {
+		sum = sha256.Sum256(normalizeInferenceBody(body))
+	}
+	if target.Variant != "" {
+		return baggageScopedKey("api:inference:"+target.Variant+":"+hex.EncodeToString(sum[:]), target.Baggage)
+	}
+	return baggageScopedKey("api:inference:"+hex.EncodeToString(sum[:]), target.Baggage)
+}
+
+// bodySample returns at most maxLoggedBodySample bytes of body for logging.
+func bodySample(body []byte) string {
+	if len(body) > maxLoggedBodySample {
+		return string(body[:maxLoggedBodySample]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// isJSONContentType reports whether a Content-Type header denotes JSON.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// call sends an inference request to the model server and returns its JSON result, first
+// waiting for a free worker when the queue is enabled. The call is bounded by the model's
+// timeout or ctx's deadline, whichever is stricter.
+func (s *InferenceService) call(ctx context.Context, target inferenceTarget, body []byte) (json.RawMessage, error) {
+	result, _, err := s.fetch(ctx, target, body)
+	return result, err
+}
+
+// fetch is call that also returns the model server's response headers. The model's hooks
+// transform the request body before it is sent (unless it is binary) and the validated response
+// before it is returned.
+func (s *InferenceService) fetch(ctx context.Context, target inferenceTarget, body []byte) (json.RawMessage, http.Header, error) {
+	contentType := jsonMediaType
+	if target.ContentType != "" {
+		contentType = target.ContentType
+	} else {
+		var err error
+		if body, err = s.Hooks.Preprocess(target.Model, body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	release, err := s.queue.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, s.modelTimeout(target.Model))
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, target.BackendURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	setBaggageHeaders(req.Header, target.Baggage)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readUpstreamBody(resp, s.Config.MaxResponseBytes, s.Config.ResponseStallTimeout, cancel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("inference backend returned status %d", resp.StatusCode)
+	}
+
+	respContentType := resp.Header.Get("Content-Type")
+	if !isJSONContentType(respContentType) || !json.Valid(respBody) {
+		logger.Error("Inference backend returned a non-JSON response",
+			zap.Int("status_code", resp.StatusCode),
+			zap.String("content_type", respContentType),
+			zap.String("body_sample", bodySample(respBody)),
+		)
+		return nil, nil, errInvalidUpstreamResponse
+	}
+	if err := s.Schemas.Validate(target.Model, respBody); err != nil {
+		logger.Error("Inference backend response failed validation",
+			zap.String("model", target.Model),
+			zap.String("variant", target.Variant),
+			zap.Error(err),
+			zap.String("body_sample", bodySample(respBody)),
+		)
+		return nil, nil, errInvalidUpstreamResponse
+	}
+	result, err := s.Hooks.Postprocess(target.Model, respBody)
+	if err != nil {
+		logger.Error("Inference backend response failed postprocessing",
+			zap.String("model", target.Model),
+			zap.String("variant", target.Variant),
+			zap.Error(err),
+			zap.String("body_sample", bodySample(respBody)),
+		)
+		return nil, nil, errInvalidUpstreamResponse
+	}
+	return result, resp.Header, nil
+}
+
+// inferenceErrorMessage maps an upstream failure to the message returned to clients.
+func inferenceErrorMessage(err error) string {
+	if err == errInvalidUpstreamResponse {
+		return "inference backend returned an invalid response"
+	}
+	if err == errInferenceQueueFull || err == errInferenceQueueTimeout {
+		return "inference backend is at capacity, try again later"
+	}
+	if err == errUpstreamTooSlow {
+		return "inference backend stalled while sending its response"
+	}
+	if err == errUpstreamTooLarge {
+		return "inference backend response too large"
+	}
+	if errors.Is(err, errPreprocessFailed) {
+		return err.Error()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "inference backend timed out"
+	}
+	return "inference backend unavailable"
+}
+
+// inferenceErrorStatus maps an upstream failure to the HTTP status returned to clients.
+func inferenceErrorStatus(err error) int {
+	if err == errInferenceQueueFull || err == errInferenceQueueTimeout {
+		return http.StatusServiceUnavailable
+	}
+	if err == errUpstreamTooSlow {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, errPreprocessFailed) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// Handler serves POST /api/inference.
+func (s *InferenceService) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, ok := s.admit(c)
+		if !ok {
+			return
+		}
+		defer release()
+
+		start := time.Now()
+		target, body, ok := s.readRequest(c)
+		if !ok {
+			return
+		}
+		inferenceRequestSizeBytes.Observe(float64(len(body)))
+		target.Baggage = Baggage(c)
+
+		if target.Model != "" {
+			AddLogField(c, "model", target.Model)
+		}
+		if target.Variant != "" {
+			c.Header(apitypes.ModelVariantHeader, target.Variant)
+			AddLogField(c, "model_variant", target.Variant)
+		}
+
+		// Turn away callers over budget before any backend work
+		cost := s.Budget.Estimate(target.Model, body)
+		if !s.Budget.Check(c, cost) {
+			return
+		}
+
+		// Identical concurrent requests share one backend call. It runs detached from any
+		// single client's cancellation (keeping the request deadline) so one caller going
+		// away doesn't fail the others. The backend's Cache-Control max-age, when it sends
+		// one, can shorten the configured cache TTL.
+		key := tenantScopedKey(c, inferenceCacheKey(target, body))
+		var result json.RawMessage
+		err := s.Cacher.CacheAside(c, key, s.Config.CacheTTL, &result, func() (interface{}, error) {
+			defer StartTiming(c, "inference")()
+			value, err, _ := s.inflight.Do(c.Request.Context(), key, func() (interface{}, error) {
+				// Only the caller running the backend call is charged; those sharing it are not
+				s.Budget.Charge(c, cost)
+				ctx, cancel := detachWithDeadline(c.Request.Context())
+				defer cancel()
+				result, header, err := s.fetch(ctx, target, body)
+				if err != nil {
+					return nil, err
+				}
+				return config.LoadedValue{Value: result, TTL: config.TTLFromCacheControl(header.Get("Cache-Control"))}, nil
+			})
+			return value, err
+		})
+		if err != nil {
+			if err != errInvalidUpstreamResponse && !errors.Is(err, errPreprocessFailed) {
+				logger.Error("Inference request failed", zap.Error(err))
+			}
+			status := inferenceErrorStatus(err)
+			s.setRetryAfter(c, status)
+			c.JSON(status, apitypes.ErrorResponse{Error: inferenceErrorMessage(err)})
+			return
+		}
+		cached := c.Writer.Header().Get(s.Cacher.Config.StatusHeader) == CacheHit
+		inferenceDurationSeconds.WithLabelValues(strconv.FormatBool(cached)).Observe(time.Since(start).Seconds())
+		inferenceRequestsTotal.WithLabelValues(s.modelLabel(target.Model)).Inc()
+		c.Data(http.StatusOK, "application/json; charset=utf-8", result)
+	}
+}
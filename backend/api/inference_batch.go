@@ -0,0 +1,180 @@
+// inference_batch.go
+// Batch inference endpoint. Items are forwarded to the model server concurrently;
+// results are returned as a single JSON array or, when the client asks for
+// application/x-ndjson, streamed one line per item as each completes (and
+// recorded for replay, see inference_stream_cache.go).
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// Batch inference limits.
+const (
+	maxBatchSize        = 100
+	maxBatchConcurrency = 4
+)
+
+// ndjsonContentType is the media type for newline-delimited JSON streams.
+const ndjsonContentType = "application/x-ndjson"
+
+// BatchInferenceResult is the outcome of one item in a batch; exactly one of Result or Error is set.
+type BatchInferenceResult struct {
+	Index   int             `json:"index"`
+	Variant string          `json:"variant,omitempty"` // Model variant that served the item, when routed
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// wantsNDJSON reports whether the client asked for a streamed ndjson response.
+func wantsNDJSON(c *gin.Context) bool {
+	if c.Query("stream") == "ndjson" {
+		return true
+	}
+	return strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// runBatch processes items concurrently, sending each result on the returned channel as it completes.
+func (s *InferenceService) runBatch(c *gin.Context, items []json.RawMessage) <-chan BatchInferenceResult {
+	results := make(chan BatchInferenceResult, len(items))
+	sem := make(chan struct{}, maxBatchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		go func(index int, body json.RawMessage) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			target := s.resolveTarget(body, c.GetHeader(apitypes.RoutingKeyHeader))
+			target.Baggage = Baggage(c)
+			if !s.acceptsContentType(target.Model, jsonMediaType) {
+				results <- BatchInferenceResult{Index: index, Variant: target.Variant, Error: fmt.Sprintf("model %q does not accept %s requests", target.Model, jsonMediaType)}
+				return
+			}
+			result, err := s.call(c.Request.Context(), target, body)
+			if err != nil {
+				if err != errInvalidUpstreamResponse && !errors.Is(err, errPreprocessFailed) {
+					logger.Error("Batch inference item failed", zap.Int("index", index), zap.Error(err))
+				}
+				results <- BatchInferenceResult{Index: index, Variant: target.Variant, Error: inferenceErrorMessage(err)}
+				return
+			}
+			results <- BatchInferenceResult{Index: index, Variant: target.Variant, Result: result}
+		}(i, item)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// BatchHandler serves POST /api/inference/batch with a JSON array of inference requests.
+func (s *InferenceService) BatchHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, ok := s.admit(c)
+		if !ok {
+			return
+		}
+		defer release()
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "failed to read request body"})
+			return
+		}
+		if err := s.checkBatchBody(body); err == errJSONTooDeep || err == errJSONTooManyTokens {
+			c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: err.Error()})
+			return
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "request body must be a JSON array"})
+			return
+		}
+		if len(items) == 0 || len(items) > maxBatchSize {
+			c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "batch must contain between 1 and 100 items"})
+			return
+		}
+		for _, item := range items {
+			if err := s.checkUnknownFields(item); err != nil {
+				c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: requestBodyErrorMessage(err)})
+				return
+			}
+		}
+
+		streaming := wantsNDJSON(c)
+		var streamKey string
+		if streaming && s.streamCachingEnabled() {
+			streamKey = streamCacheKey(c, items)
+			if s.replayStream(c, streamKey) {
+				return
+			}
+		}
+
+		// Items are charged together, so a batch is admitted whole or not at all
+		cost := s.Budget.EstimateBatch(items)
+		if !s.Budget.Check(c, cost) {
+			return
+		}
+		s.Budget.Charge(c, cost)
+
+		results := s.runBatch(c, items)
+
+		if !streaming {
+			ordered := make([]BatchInferenceResult, len(items))
+			for result := range results {
+				ordered[result.Index] = result
+			}
+			c.JSON(http.StatusOK, ordered)
+			return
+		}
+
+		// Stream each result as its own line, flushing so clients see it immediately
+		var recorder *streamRecorder
+		if streamKey != "" {
+			recorder = newStreamRecorder(s.Config.StreamCacheMaxBytes)
+			s.Cacher.setStatus(c, CacheMiss, time.Time{})
+		}
+		c.Header("Content-Type", ndjsonContentType)
+		c.Status(http.StatusOK)
+		for result := range results {
+			line, err := json.Marshal(result)
+			if err == nil {
+				_, err = c.Writer.Write(append(line, '\n'))
+			}
+			if err != nil {
+				// Client went away; drain remaining results so workers can finish
+				for range results {
+				}
+				return
+			}
+			c.Writer.Flush()
+			if recorder != nil {
+				if result.Error != "" {
+					recorder.drop()
+				}
+				recorder.record(line)
+			}
+		}
+		if recorder != nil {
+			s.storeStream(c, streamKey, recorder)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// inference_budget.go
+// Per-caller cost budgets for inference. Before the backend is called, the
+// request's cost is estimated by its model's registered estimator, or from the
+// size of its input, and a caller whose consumption over the rolling window
+// would exceed its budget is turned away: 429 with Retry-After while the budget
+// refills, or 402 when the request alone costs more than the whole budget.
+//
+// Consumption is kept in the response cache (Memcached), so every instance
+// charges the same budget. It is counted in slots of a sixth of the window and
+// charged with an atomic increment, but a request is checked before it is
+// charged, so concurrent requests of one caller can each pass the check and
+// overrun the budget by up to their combined cost. Only backend calls are
+// charged, to the caller that makes them; cached results, replayed batch
+// streams and requests sharing an identical in-flight call are free.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// budgetSlots is the number of slots a budget window is counted in.
+const budgetSlots = 6
+
+// defaultBudgetWindow is the rolling window budgets apply to unless INFERENCE_BUDGET_WINDOW is set.
+const defaultBudgetWindow = time.Hour
+
+// CostEstimator estimates the cost of an inference request body, in budget units.
+type CostEstimator func(body json.RawMessage) int
+
+// EstimateInputSize is the default cost estimator: one unit per four bytes of the request's
+// input, a common approximation of its token count.
+func EstimateInputSize(body json.RawMessage) int {
+	var request apitypes.InferenceRequest
+	size := len(body)
+	if json.Unmarshal(body, &request) == nil && request.Input != nil {
+		size = len(request.Input)
+	}
+	return int(math.Ceil(float64(size) / 4))
+}
+
+// InferenceBudget limits the estimated inference cost each caller consumes per Window.
+type InferenceBudget struct {
+	Default int            // Budget of callers without their own; zero means unlimited
+	Callers map[string]int // Budgets of individual callers, keyed by tenant ID
+	Window  time.Duration  // Rolling window budgets apply to
+	Store   ResponseCache  // Where consumption is tracked; nil only rejects requests over the whole budget
+
+	estimators map[string]CostEstimator
+	now        func() time.Time
+}
+
+// LoadInferenceBudget loads budgets from INFERENCE_BUDGET_DEFAULT, INFERENCE_BUDGETS
+// ("tenant-a=50000,tenant-b=2000") and INFERENCE_BUDGET_WINDOW, tracking consumption in store.
+func LoadInferenceBudget(store ResponseCache) *InferenceBudget {
+	budget := NewInferenceBudget(getEnvInt("INFERENCE_BUDGET_DEFAULT", 0), getEnvDuration("INFERENCE_BUDGET_WINDOW", defaultBudgetWindow), store)
+	for _, entry := range splitList(os.Getenv("INFERENCE_BUDGETS")) {
+		caller, value, ok := strings.Cut(entry, "=")
+		units, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || units < 0 {
+			logger.Warn("Invalid entry in INFERENCE_BUDGETS, ignoring", zap.String("entry", entry))
+			continue
+		}
+		budget.Callers[strings.TrimSpace(caller)] = units
+	}
+	return budget
+}
+
+// NewInferenceBudget creates a budget of defaultUnits per window for every caller.
+func NewInferenceBudget(defaultUnits int, window time.Duration, store ResponseCache) *InferenceBudget {
+	return &InferenceBudget{
+		Default:    defaultUnits,
+		Callers:    make(map[string]int),
+		Window:     window,
+		Store:      store,
+		estimators: make(map[string]CostEstimator),
+		now:        time.Now,
+	}
+}
+
+// RegisterEstimator sets the cost estimator for model, replacing EstimateInputSize.
+func (b *InferenceBudget) RegisterEstimator(model string, estimator CostEstimator) {
+	b.estimators[model] = estimator
+}
+
+// Estimate returns the estimated cost of body for model. A nil budget charges nothing.
+func (b *InferenceBudget) Estimate(model string, body json.RawMessage) int {
+	if b == nil {
+		return 0
+	}
+	if estimator, ok := b.estimators[model]; ok {
+		return estimator(body)
+	}
+	return EstimateInputSize(body)
+}
+
+// EstimateBatch returns the summed estimated cost of a batch's items, each by its own model.
+func (b *InferenceBudget) EstimateBatch(items []json.RawMessage) int {
+	if b == nil {
+		return 0
+	}
+	total := 0
+	for _, item := range items {
+		var request apitypes.InferenceRequest
+		json.Unmarshal(item, &request)
+		total += b.Estimate(request.Model, item)
+	}
+	return total
+}
+
+// budgetCaller identifies whose budget a request is charged to: its tenant, or its client IP.
+func budgetCaller(c *gin.Context) string {
+	if tenant := TenantID(c); tenant != "" {
+		return "tenant:" + tenant
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// limit returns the budget of the request's caller, zero meaning unlimited.
+func (b *InferenceBudget) limit(c *gin.Context) int {
+	if tenant := TenantID(c); tenant != "" {
+		if units, ok := b.Callers[tenant]; ok {
+			return units
+		}
+	}
+	return b.Default
+}
+
+// slotLength is the duration of one counting slot.
+func (b *InferenceBudget) slotLength() time.Duration {
+	return b.Window / budgetSlots
+}
+
+// slotKey is the cache key holding caller's consumption during slot.
+func slotKey(caller string, slot int64) string {
+	return fmt.Sprintf("api:inference:budget:%s:%d", caller, slot)
+}
+
+// consumed returns caller's consumption over the window and the time until the oldest slot
+// with consumption leaves the window, freeing part of the budget.
+func (b *InferenceBudget) consumed(caller string) (int, time.Duration) {
+	slotLength := int64(b.slotLength())
+	now := b.now().UnixNano()
+	current := now / slotLength
+	total, oldest := 0, int64(0)
+	for slot := current - budgetSlots + 1; slot <= current; slot++ {
+		var units int
+		found, err := b.Store.GetCache(slotKey(caller, slot), &units)
+		if err != nil {
+			logger.Warn("Failed to read inference budget, not counting it", zap.String("caller", caller), zap.Error(err))
+			continue
+		}
+		if found && units > 0 && oldest == 0 {
+			oldest = slot
+		}
+		total += units
+	}
+	if oldest == 0 {
+		oldest = current
+	}
+	return total, time.Duration((oldest+budgetSlots)*slotLength - now)
+}
+
+// Check reports whether a request costing cost fits the caller's budget, answering the
+// request with 402 or 429 when it doesn't. A nil budget admits every request.
+func (b *InferenceBudget) Check(c *gin.Context, cost int) bool {
+	if b == nil {
+		return true
+	}
+	limit := b.limit(c)
+	if limit <= 0 {
+		return true
+	}
+	if cost > limit {
+		c.JSON(http.StatusPaymentRequired, apitypes.ErrorResponse{
+			Error: fmt.Sprintf("inference request estimated at %d units exceeds the budget of %d units per %s", cost, limit, b.Window),
+		})
+		return false
+	}
+	if b.Store == nil || b.slotLength() <= 0 {
+		return true
+	}
+
+	used, retryAfter := b.consumed(budgetCaller(c))
+	if used+cost > limit {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, apitypes.ErrorResponse{
+			Error: fmt.Sprintf("inference budget exhausted: %d of %d units used in the last %s, request estimated at %d", used, limit, b.Window, cost),
+		})
+		return false
+	}
+	return true
+}
+
+// counterCache is implemented by stores with an atomic counter increment (satisfied by
+// config.MemcachedConfig).
+type counterCache interface {
+	IncrementCache(key string, delta uint64, expiration time.Duration) (uint64, error)
+}
+
+// Charge adds cost to the caller's consumption in the current slot, atomically when the store
+// supports it and read-modify-write otherwise.
+func (b *InferenceBudget) Charge(c *gin.Context, cost int) {
+	if b == nil || b.Store == nil || cost <= 0 || b.limit(c) <= 0 || b.slotLength() <= 0 {
+		return
+	}
+	caller := budgetCaller(c)
+	key := slotKey(caller, b.now().UnixNano()/int64(b.slotLength()))
+	if counter, ok := b.Store.(counterCache); ok {
+		if _, err := counter.IncrementCache(key, uint64(cost), b.Window+b.slotLength()); err != nil {
+			logger.Warn("Failed to charge inference budget", zap.String("caller", caller), zap.Error(err))
+		}
+		return
+	}
+	var units int
+	if _, err := b.Store.GetCache(key, &units); err != nil {
+		logger.Warn("Failed to read inference budget before charging", zap.String("caller", caller), zap.Error(err))
+	}
+	if err := b.Store.SetCache(key, units+cost, b.Window+b.slotLength()); err != nil {
+		logger.Warn("Failed to charge inference budget", zap.String("caller", caller), zap.Error(err))
+	}
+}
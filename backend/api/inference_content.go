@@ -0,0 +1,149 @@
+// inference_content.go
+// Per-model request content types. Models accept JSON inference requests
+// unless configured otherwise; multimodal models can instead (or also) accept
+// binary bodies such as images or audio, which are forwarded to the backend
+// as-is, without JSON parsing or request hooks, under a size cap. A binary
+// request names its model in the X-Model header. A request whose content type
+// its model doesn't accept is rejected with 415.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// jsonMediaType is the media type of JSON inference requests.
+const jsonMediaType = "application/json"
+
+// LoadModelContentTypes reads INFERENCE_CONTENT_TYPES, a JSON object mapping model names to the
+// request media types they accept, e.g. {"vision":["image/png","image/jpeg"],"asr":["audio/*"]}.
+// Models not listed accept JSON only; a listed model accepts JSON only if its list includes it.
+func LoadModelContentTypes() map[string][]string {
+	typesEnv := os.Getenv("INFERENCE_CONTENT_TYPES")
+	if typesEnv == "" {
+		return nil
+	}
+	var contentTypes map[string][]string
+	if err := json.Unmarshal([]byte(typesEnv), &contentTypes); err != nil {
+		logger.Warn("Invalid INFERENCE_CONTENT_TYPES, models accept JSON only", zap.Error(err))
+		return nil
+	}
+	return contentTypes
+}
+
+// acceptsContentType reports whether model accepts requests of mediaType. Allowed types may
+// end in "/*" to accept a whole family, e.g. "image/*".
+func (s *InferenceService) acceptsContentType(model, mediaType string) bool {
+	allowed, ok := s.Config.ContentTypes[model]
+	if !ok {
+		return mediaType == jsonMediaType
+	}
+	for _, allowedType := range allowed {
+		if allowedType == mediaType {
+			return true
+		}
+		if family := strings.TrimSuffix(allowedType, "*"); family != allowedType && strings.HasPrefix(mediaType, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestMediaType returns the media type of the request body. Requests without a Content-Type,
+// or with a JSON one, are JSON requests.
+func requestMediaType(c *gin.Context) (string, error) {
+	contentType := c.GetHeader("Content-Type")
+	if contentType == "" {
+		return jsonMediaType, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+	if isJSONContentType(contentType) {
+		return jsonMediaType, nil
+	}
+	return mediaType, nil
+}
+
+// rejectContentType answers a request whose model doesn't accept its content type.
+func rejectContentType(c *gin.Context, model, mediaType string) {
+	c.JSON(http.StatusUnsupportedMediaType, apitypes.ErrorResponse{
+		Error: fmt.Sprintf("model %q does not accept %s requests", model, mediaType),
+	})
+}
+
+// readRequest reads an inference request and resolves its target, answering the request and
+// returning false if it is invalid or its model doesn't accept its content type.
+func (s *InferenceService) readRequest(c *gin.Context) (inferenceTarget, []byte, bool) {
+	mediaType, err := requestMediaType(c)
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, apitypes.ErrorResponse{Error: "invalid Content-Type header"})
+		return inferenceTarget{}, nil, false
+	}
+	if mediaType != jsonMediaType {
+		return s.readBinaryRequest(c, mediaType)
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err == nil {
+		err = s.checkRequestBody(body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: requestBodyErrorMessage(err)})
+		return inferenceTarget{}, nil, false
+	}
+	target := s.resolveTarget(body, c.GetHeader(apitypes.RoutingKeyHeader))
+	if !s.acceptsContentType(target.Model, jsonMediaType) {
+		rejectContentType(c, target.Model, jsonMediaType)
+		return inferenceTarget{}, nil, false
+	}
+	return target, body, true
+}
+
+// readBinaryRequest reads a binary request body of mediaType for the model named in X-Model,
+// up to MaxBinaryBytes.
+func (s *InferenceService) readBinaryRequest(c *gin.Context, mediaType string) (inferenceTarget, []byte, bool) {
+	model := c.GetHeader(apitypes.ModelHeader)
+	if model == "" {
+		c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "binary inference requests must name their model in the " + apitypes.ModelHeader + " header"})
+		return inferenceTarget{}, nil, false
+	}
+	if !s.acceptsContentType(model, mediaType) {
+		rejectContentType(c, model, mediaType)
+		return inferenceTarget{}, nil, false
+	}
+
+	reader := c.Request.Body
+	if s.Config.MaxBinaryBytes > 0 {
+		reader = http.MaxBytesReader(c.Writer, reader, s.Config.MaxBinaryBytes)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, apitypes.ErrorResponse{
+				Error: fmt.Sprintf("request body exceeds %d bytes", s.Config.MaxBinaryBytes),
+			})
+			return inferenceTarget{}, nil, false
+		}
+		c.JSON(http.StatusBadRequest, apitypes.ErrorResponse{Error: "failed to read request body"})
+		return inferenceTarget{}, nil, false
+	}
+
+	target := s.resolveModel(model, c.GetHeader(apitypes.RoutingKeyHeader))
+	target.ContentType = mediaType
+	return target, body, true
+}
@@ -0,0 +1,124 @@
+// inference_decode.go
+// Decode guard for inference request bodies. A payload well under the body-size
+// limit can still be expensive to parse (thousands of nested arrays, millions of
+// tiny tokens), so bodies are scanned token by token and rejected with 400 as
+// soon as they exceed the configured nesting depth or token count, before any
+// full decode. Unknown top-level fields can optionally be rejected too.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// Errors returned by guardJSON; both are reported to the client as 400.
+var (
+	errJSONTooDeep       = errors.New("request body is nested too deeply")
+	errJSONTooManyTokens = errors.New("request body has too many JSON tokens")
+)
+
+// guardJSON checks that body is a single valid JSON value nested at most maxDepth levels
+// and made of at most maxTokens tokens. Non-positive limits are not enforced.
+func guardJSON(body []byte, maxDepth int, maxTokens int) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	depth, tokens := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		tokens++
+		if maxTokens > 0 && tokens > maxTokens {
+			return errJSONTooManyTokens
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if maxDepth > 0 && depth > maxDepth {
+				return errJSONTooDeep
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 && decoder.More() {
+			return errors.New("request body must contain a single JSON value")
+		}
+	}
+	if tokens == 0 {
+		return errors.New("request body is empty")
+	}
+	if depth != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// checkRequestBody applies the decode guard to a single inference request body and, when
+// DisallowUnknownFields is set, rejects fields InferenceRequest does not define.
+func (s *InferenceService) checkRequestBody(body []byte) error {
+	if err := guardJSON(body, s.Config.MaxJSONDepth, s.Config.MaxJSONTokens); err != nil {
+		return err
+	}
+	return s.checkUnknownFields(body)
+}
+
+// checkUnknownFields rejects fields InferenceRequest does not define when DisallowUnknownFields is set.
+func (s *InferenceService) checkUnknownFields(body []byte) error {
+	if !s.Config.DisallowUnknownFields {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	var request apitypes.InferenceRequest
+	if err := decoder.Decode(&request); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			return &unknownFieldError{Field: strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)}
+		}
+		return err
+	}
+	return nil
+}
+
+// unknownFieldError reports a request field InferenceRequest does not define.
+type unknownFieldError struct {
+	Field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q in request body", e.Field)
+}
+
+// checkBatchBody applies the decode guard to a batch body, allowing one extra level for the
+// enclosing array and the token budget of a full batch.
+func (s *InferenceService) checkBatchBody(body []byte) error {
+	maxDepth, maxTokens := s.Config.MaxJSONDepth, s.Config.MaxJSONTokens
+	if maxDepth > 0 {
+		maxDepth++
+	}
+	if maxTokens > 0 {
+		maxTokens = maxTokens*maxBatchSize + 2
+	}
+	return guardJSON(body, maxDepth, maxTokens)
+}
+
+// requestBodyErrorMessage maps a body read or decode guard failure to the message returned to clients.
+func requestBodyErrorMessage(err error) string {
+	var unknownField *unknownFieldError
+	switch {
+	case err == errJSONTooDeep, err == errJSONTooManyTokens:
+		return err.Error()
+	case errors.As(err, &unknownField):
+		return unknownField.Error()
+	}
+	return "request body must be valid JSON"
+}
@@ -0,0 +1,119 @@
+// inference_hooks.go
+// Per-model request and response transformations. A model whose backend expects
+// a different payload shape (renamed fields, tokenized or normalized input)
+// registers a preprocessing hook that turns the validated request into that
+// payload, and optionally a postprocessing hook mapping the backend's response
+// back to the API's shape, keeping model quirks out of the core handler.
+// Renaming request fields can also be configured with INFERENCE_FIELD_MAPPINGS.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// errPreprocessFailed is wrapped around errors from preprocessing hooks; the request can't be
+// turned into the model's payload, so it is rejected with 400.
+var errPreprocessFailed = errors.New("inference request could not be prepared for the model")
+
+// PreprocessHook turns a validated inference request body into the payload the model's backend expects.
+type PreprocessHook func(body json.RawMessage) (json.RawMessage, error)
+
+// PostprocessHook turns a model backend's validated JSON response into the result returned to clients.
+type PostprocessHook func(result json.RawMessage) (json.RawMessage, error)
+
+// InferenceHookRegistry holds the preprocessing and postprocessing hooks of models.
+type InferenceHookRegistry struct {
+	preprocess  map[string]PreprocessHook
+	postprocess map[string]PostprocessHook
+}
+
+// NewInferenceHookRegistry creates a registry renaming request fields of each model in mappings.
+func NewInferenceHookRegistry(mappings map[string]map[string]string) *InferenceHookRegistry {
+	registry := &InferenceHookRegistry{
+		preprocess:  make(map[string]PreprocessHook),
+		postprocess: make(map[string]PostprocessHook),
+	}
+	for model, mapping := range mappings {
+		registry.RegisterPreprocess(model, RenameFields(mapping))
+	}
+	return registry
+}
+
+// RegisterPreprocess sets the preprocessing hook for model, replacing any field mapping loaded from configuration.
+func (r *InferenceHookRegistry) RegisterPreprocess(model string, hook PreprocessHook) {
+	r.preprocess[model] = hook
+}
+
+// RegisterPostprocess sets the postprocessing hook for model.
+func (r *InferenceHookRegistry) RegisterPostprocess(model string, hook PostprocessHook) {
+	r.postprocess[model] = hook
+}
+
+// Preprocess runs model's preprocessing hook on body; models without one send body unchanged.
+// Hook errors are wrapped with errPreprocessFailed.
+func (r *InferenceHookRegistry) Preprocess(model string, body json.RawMessage) (json.RawMessage, error) {
+	if r == nil {
+		return body, nil
+	}
+	hook, ok := r.preprocess[model]
+	if !ok {
+		return body, nil
+	}
+	payload, err := hook(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPreprocessFailed, err)
+	}
+	return payload, nil
+}
+
+// Postprocess runs model's postprocessing hook on result; models without one return result unchanged.
+func (r *InferenceHookRegistry) Postprocess(model string, result json.RawMessage) (json.RawMessage, error) {
+	if r == nil {
+		return result, nil
+	}
+	hook, ok := r.postprocess[model]
+	if !ok {
+		return result, nil
+	}
+	return hook(result)
+}
+
+// RenameFields returns a preprocessing hook renaming the top-level request fields in mapping
+// (request field to backend field), e.g. {"input":"inputs"}.
+func RenameFields(mapping map[string]string) PreprocessHook {
+	return func(body json.RawMessage) (json.RawMessage, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("request is not a JSON object")
+		}
+		renamed := make(map[string]json.RawMessage, len(fields))
+		for name, value := range fields {
+			if to, ok := mapping[name]; ok {
+				name = to
+			}
+			renamed[name] = value
+		}
+		return json.Marshal(renamed)
+	}
+}
+
+// LoadInferenceFieldMappings reads INFERENCE_FIELD_MAPPINGS, a JSON object mapping model names to
+// field renames, e.g. {"legacy-sentiment":{"input":"text"}}.
+func LoadInferenceFieldMappings() map[string]map[string]string {
+	mappingsEnv := os.Getenv("INFERENCE_FIELD_MAPPINGS")
+	if mappingsEnv == "" {
+		return nil
+	}
+	var mappings map[string]map[string]string
+	if err := json.Unmarshal([]byte(mappingsEnv), &mappings); err != nil {
+		logger.Warn("Invalid INFERENCE_FIELD_MAPPINGS, field mapping disabled", zap.Error(err))
+		return nil
+	}
+	return mappings
+}
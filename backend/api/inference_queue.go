@@ -0,0 +1,104 @@
+// inference_queue.go
+// Bounded FIFO queue in front of inference backend calls. At most Workers calls
+// run at once; further calls wait in line for a free worker, up to QueueDepth
+// waiting calls and QueueWait per call, and are rejected with 503 beyond that.
+// This smooths bursts without overwhelming the model server.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Inference queue metrics.
+var (
+	inferenceQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "inference_queue_depth",
+			Help: "Number of inference calls waiting for a free worker.",
+		},
+	)
+	inferenceQueueWaitSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "inference_queue_wait_seconds",
+			Help:    "Time inference calls waited for a free worker in seconds, including calls that gave up.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+	)
+)
+
+// Errors returned when a call can't get a worker; both are served as 503.
+var (
+	errInferenceQueueFull    = errors.New("inference queue is full")
+	errInferenceQueueTimeout = errors.New("timed out waiting in the inference queue")
+)
+
+// inferenceQueue limits concurrent backend calls. Goroutines blocked sending on a channel are
+// woken in arrival order, so waiting calls are served first come, first served.
+type inferenceQueue struct {
+	workers  chan struct{}
+	maxDepth int64
+	maxWait  time.Duration
+	waiting  int64 // Calls waiting for a worker; accessed atomically
+}
+
+// newInferenceQueue returns a queue for workers concurrent calls, or nil (no limit) if workers is not positive.
+func newInferenceQueue(workers, maxDepth int, maxWait time.Duration) *inferenceQueue {
+	if workers <= 0 {
+		return nil
+	}
+	return &inferenceQueue{
+		workers:  make(chan struct{}, workers),
+		maxDepth: int64(maxDepth),
+		maxWait:  maxWait,
+	}
+}
+
+// acquire waits for a free worker and returns a function releasing it. A nil queue never waits.
+func (q *inferenceQueue) acquire(ctx context.Context) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	// Take a free worker directly unless others are already waiting for one
+	if atomic.LoadInt64(&q.waiting) == 0 {
+		select {
+		case q.workers <- struct{}{}:
+			inferenceQueueWaitSeconds.Observe(0)
+			return q.release, nil
+		default:
+		}
+	}
+
+	if atomic.AddInt64(&q.waiting, 1) > q.maxDepth {
+		atomic.AddInt64(&q.waiting, -1)
+		return nil, errInferenceQueueFull
+	}
+	inferenceQueueDepth.Inc()
+	defer func() {
+		atomic.AddInt64(&q.waiting, -1)
+		inferenceQueueDepth.Dec()
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+	defer func() { inferenceQueueWaitSeconds.Observe(time.Since(start).Seconds()) }()
+	select {
+	case q.workers <- struct{}{}:
+		return q.release, nil
+	case <-timer.C:
+		return nil, errInferenceQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *inferenceQueue) release() {
+	<-q.workers
+}
@@ -0,0 +1,68 @@
+// inference_read.go
+// Bounded reads of inference backend responses. A model server that stalls
+// mid-body (e.g. a chunked response that stops sending) or sends an oversized
+// body would otherwise hold the request until the model timeout or the server
+// write timeout; the body is instead read with a size cap and a stall timeout,
+// and the two failures are reported distinctly.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned when the inference backend's response body can't be read in full.
+var (
+	errUpstreamTooSlow  = errors.New("inference backend stalled while sending its response")
+	errUpstreamTooLarge = errors.New("inference backend response exceeds the size limit")
+)
+
+// stallReader resets timer whenever data arrives, so it only fires once the body has stalled.
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// readUpstreamBody reads resp's body, failing with errUpstreamTooLarge once it exceeds maxBytes
+// and with errUpstreamTooSlow when no data arrives for stallTimeout; cancel aborts the request
+// to unblock a stalled read. Non-positive limits are not enforced.
+func readUpstreamBody(resp *http.Response, maxBytes int64, stallTimeout time.Duration, cancel context.CancelFunc) ([]byte, error) {
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(nil, resp.Body, maxBytes)
+	}
+	var stalled int32
+	if stallTimeout > 0 {
+		timer := time.AfterFunc(stallTimeout, func() {
+			atomic.StoreInt32(&stalled, 1)
+			cancel()
+		})
+		defer timer.Stop()
+		body = &stallReader{r: body, timer: timer, timeout: stallTimeout}
+	}
+
+	data, err := ioutil.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return nil, errUpstreamTooLarge
+	case err != nil && atomic.LoadInt32(&stalled) == 1:
+		return nil, errUpstreamTooSlow
+	}
+	return data, err
+}
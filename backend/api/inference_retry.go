@@ -0,0 +1,41 @@
+// inference_retry.go
+// Retry-After hints on origin failures. When a cache miss's backend call
+// fails, clients that retry on a fixed schedule come back in lockstep and hit
+// the backend together again. With INFERENCE_RETRY_AFTER_BASE set, error
+// responses for failed backend calls carry a Retry-After of the base plus a
+// random jitter of up to INFERENCE_RETRY_AFTER_JITTER, spreading those retries
+// out. Retry-After is in whole seconds, so the jitter should span several.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryAfterHint returns the Retry-After value for a failed backend call answered with status,
+// or false when none should be sent: hints are disabled or the failure was the client's.
+func (s *InferenceService) retryAfterHint(status int) (string, bool) {
+	base, jitter := s.Config.RetryAfterBase, s.Config.RetryAfterJitter
+	if base <= 0 || status < http.StatusInternalServerError {
+		return "", false
+	}
+	delay := base
+	if jitter > 0 {
+		// The top-level math/rand source is safe for concurrent use
+		delay += time.Duration(rand.Int63n(int64(jitter) + 1))
+	}
+	return strconv.Itoa(int(math.Ceil(delay.Seconds()))), true
+}
+
+// setRetryAfter sets a jittered Retry-After on a response for a failed backend call.
+func (s *InferenceService) setRetryAfter(c *gin.Context, status int) {
+	if retryAfter, ok := s.retryAfterHint(status); ok {
+		c.Header("Retry-After", retryAfter)
+	}
+}
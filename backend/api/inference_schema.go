@@ -0,0 +1,115 @@
+// inference_schema.go
+// Optional per-model validation of inference backend responses. Each model may
+// have a registered schema (from INFERENCE_RESPONSE_SCHEMAS) or validator
+// function; responses failing it are rejected with 502 instead of being
+// forwarded to clients or cached.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// ResponseValidator checks a model's JSON response, returning an error describing any problem.
+type ResponseValidator func(result json.RawMessage) error
+
+// ResponseSchema is a minimal JSON schema for a model response: a JSON object containing each
+// Required field with the given JSON type ("string", "number", "boolean", "object", "array"
+// or "any").
+type ResponseSchema struct {
+	Required map[string]string `json:"required"`
+}
+
+// jsonType returns the JSON type name of an encoded value.
+func jsonType(value json.RawMessage) string {
+	if len(value) == 0 {
+		return ""
+	}
+	switch value[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// Validate checks result against the schema.
+func (s ResponseSchema) Validate(result json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(result, &fields); err != nil || fields == nil {
+		return fmt.Errorf("response is not a JSON object")
+	}
+	names := make([]string, 0, len(s.Required))
+	for name := range s.Required {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("missing required field %q", name)
+		}
+		if want := s.Required[name]; want != "any" && jsonType(value) != want {
+			return fmt.Errorf("field %q is %s, want %s", name, jsonType(value), want)
+		}
+	}
+	return nil
+}
+
+// ResponseSchemaRegistry holds the response validators of models.
+type ResponseSchemaRegistry struct {
+	validators map[string]ResponseValidator
+}
+
+// NewResponseSchemaRegistry creates a registry validating each model in schemas against its schema.
+func NewResponseSchemaRegistry(schemas map[string]ResponseSchema) *ResponseSchemaRegistry {
+	registry := &ResponseSchemaRegistry{validators: make(map[string]ResponseValidator)}
+	for model, schema := range schemas {
+		registry.Register(model, schema.Validate)
+	}
+	return registry
+}
+
+// Register sets the validator for model, replacing any schema loaded from configuration.
+func (r *ResponseSchemaRegistry) Register(model string, validator ResponseValidator) {
+	r.validators[model] = validator
+}
+
+// Validate checks result with model's validator; models without one always pass.
+func (r *ResponseSchemaRegistry) Validate(model string, result json.RawMessage) error {
+	if r == nil {
+		return nil
+	}
+	validator, ok := r.validators[model]
+	if !ok {
+		return nil
+	}
+	return validator(result)
+}
+
+// LoadResponseSchemas reads schemas from INFERENCE_RESPONSE_SCHEMAS, a JSON object mapping model
+// names to schemas, e.g. {"sentiment":{"required":{"label":"string","score":"number"}}}.
+func LoadResponseSchemas() map[string]ResponseSchema {
+	schemasEnv := os.Getenv("INFERENCE_RESPONSE_SCHEMAS")
+	if schemasEnv == "" {
+		return nil
+	}
+	var schemas map[string]ResponseSchema
+	if err := json.Unmarshal([]byte(schemasEnv), &schemas); err != nil {
+		logger.Warn("Invalid INFERENCE_RESPONSE_SCHEMAS, response validation disabled", zap.Error(err))
+		return nil
+	}
+	return schemas
+}
@@ -0,0 +1,61 @@
+// inference_shed.go
+// Global backpressure for inference. A semaphore caps the inference requests
+// being handled at once across all clients; requests over the cap are shed
+// immediately with 503. Unlike rate limiting, which is per client, this protects
+// the model server and our own memory from the total load.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// Inference load-shedding metrics.
+var (
+	inferenceShedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "inference_shed_total",
+			Help: "Total number of inference requests rejected because the concurrency cap was reached.",
+		},
+	)
+	inferenceInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "inference_in_flight",
+			Help: "Number of inference requests currently being handled.",
+		},
+	)
+)
+
+// newInferenceSemaphore returns a semaphore admitting max concurrent requests, or nil (no cap) if max is not positive.
+func newInferenceSemaphore(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// admit reserves a slot for an inference request, returning a function releasing it. When the
+// cap is reached the request is shed with 503 and admit reports false.
+func (s *InferenceService) admit(c *gin.Context) (func(), bool) {
+	if s.concurrency == nil {
+		inferenceInFlight.Inc()
+		return inferenceInFlight.Dec, true
+	}
+	select {
+	case s.concurrency <- struct{}{}:
+		inferenceInFlight.Inc()
+		return func() {
+			inferenceInFlight.Dec()
+			<-s.concurrency
+		}, true
+	default:
+		inferenceShedTotal.Inc()
+		c.JSON(http.StatusServiceUnavailable, apitypes.ErrorResponse{Error: "too many concurrent inference requests, try again later"})
+		return nil, false
+	}
+}
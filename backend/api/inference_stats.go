@@ -0,0 +1,217 @@
+// inference_stats.go
+// Aggregated inference usage. GET /api/inference/stats reports the requests
+// served, cache hit rate, average latency and per-model counts over a recent
+// window, computed from the in-process Prometheus metrics the same way the SLO
+// endpoint is, so product dashboards don't need a Prometheus query.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// Model labels for inference requests whose model isn't one of the configured models,
+// keeping the label set bounded whatever clients send.
+const (
+	defaultModelLabel = "default"
+	otherModelLabel   = "other"
+)
+
+// inferenceRequestsTotal counts served inference requests per model.
+var inferenceRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "inference_requests_total",
+		Help: "Total number of inference requests served, partitioned by model.",
+	},
+	[]string{"model"},
+)
+
+// modelLabel returns the metric label for model: the model name when it is configured
+// (routed or given its own timeout), otherwise "default" or "other".
+func (s *InferenceService) modelLabel(model string) string {
+	if model == "" {
+		return defaultModelLabel
+	}
+	if _, ok := s.Config.ModelTimeouts[model]; ok {
+		return model
+	}
+	if s.Router.Has(model) {
+		return model
+	}
+	return otherModelLabel
+}
+
+// InferenceStats is the response of GET /api/inference/stats.
+type InferenceStats struct {
+	Window                string             `json:"window"`
+	Requests              float64            `json:"requests"`
+	CacheHitRate          float64            `json:"cache_hit_rate"`
+	AverageLatencySeconds float64            `json:"average_latency_seconds"`
+	Models                map[string]float64 `json:"models"`
+}
+
+// inferenceSnapshot holds cumulative inference metrics at a point in time.
+type inferenceSnapshot struct {
+	at          time.Time
+	requests    float64
+	cached      float64
+	durationSum float64
+	models      map[string]float64
+}
+
+// takeInferenceSnapshot reads inference_duration_seconds and inference_requests_total.
+func takeInferenceSnapshot(families []*dto.MetricFamily, at time.Time) inferenceSnapshot {
+	snapshot := inferenceSnapshot{at: at, models: make(map[string]float64)}
+	for _, family := range families {
+		switch family.GetName() {
+		case "inference_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				histogram := metric.GetHistogram()
+				count := float64(histogram.GetSampleCount())
+				snapshot.requests += count
+				snapshot.durationSum += histogram.GetSampleSum()
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "cached" && label.GetValue() == "true" {
+						snapshot.cached += count
+					}
+				}
+			}
+		case "inference_requests_total":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "model" {
+						snapshot.models[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+	}
+	return snapshot
+}
+
+// inferenceStatsSlots is the number of snapshots an InferenceStatsEvaluator keeps per window.
+const inferenceStatsSlots = 60
+
+// InferenceStatsEvaluator aggregates inference metrics over a recent window.
+//
+// Like SLOEvaluator it keeps snapshots taken by previous evaluations, but in a fixed ring of
+// inferenceStatsSlots per window, at most one per Window/inferenceStatsSlots, so memory stays
+// bounded however often stats are requested. It measures against the newest snapshot taken
+// at least a window ago, or the oldest one kept if none is that old yet, so sparse requests
+// still report the change since the previous snapshot rather than totals since startup; the
+// first evaluation reports totals since startup.
+type InferenceStatsEvaluator struct {
+	Window time.Duration
+	Gather func() ([]*dto.MetricFamily, error)
+	now    func() time.Time
+
+	mu    sync.Mutex
+	ring  []inferenceSnapshot // Recorded snapshots; the oldest is at next once the ring is full
+	next  int
+	count int
+}
+
+// LoadInferenceStatsWindow returns the stats window from INFERENCE_STATS_WINDOW (default 5m).
+func LoadInferenceStatsWindow() time.Duration {
+	return getEnvDuration("INFERENCE_STATS_WINDOW", 5*time.Minute)
+}
+
+// NewInferenceStatsEvaluator creates an evaluator reading metrics from gather (e.g. prometheus.DefaultGatherer.Gather).
+func NewInferenceStatsEvaluator(window time.Duration, gather func() ([]*dto.MetricFamily, error)) *InferenceStatsEvaluator {
+	return &InferenceStatsEvaluator{
+		Window: window,
+		Gather: gather,
+		now:    time.Now,
+	}
+}
+
+// Evaluate computes the inference stats for the current window.
+func (e *InferenceStatsEvaluator) Evaluate() (InferenceStats, error) {
+	families, err := e.Gather()
+	if err != nil {
+		return InferenceStats{}, err
+	}
+	now := e.now()
+	current := takeInferenceSnapshot(families, now)
+
+	e.mu.Lock()
+	baseline := e.baseline(now.Add(-e.Window))
+	e.record(current)
+	e.mu.Unlock()
+
+	stats := InferenceStats{
+		Window:   e.Window.String(),
+		Requests: current.requests - baseline.requests,
+		Models:   make(map[string]float64, len(current.models)),
+	}
+	if stats.Requests > 0 {
+		stats.CacheHitRate = (current.cached - baseline.cached) / stats.Requests
+		stats.AverageLatencySeconds = (current.durationSum - baseline.durationSum) / stats.Requests
+	}
+	for model, count := range current.models {
+		if delta := count - baseline.models[model]; delta > 0 {
+			stats.Models[model] = delta
+		}
+	}
+	return stats, nil
+}
+
+// baseline returns the newest recorded snapshot taken at or before cutoff, else the oldest
+// recorded one, else an empty snapshot. The caller holds e.mu.
+func (e *InferenceStatsEvaluator) baseline(cutoff time.Time) inferenceSnapshot {
+	if e.count == 0 {
+		return inferenceSnapshot{models: map[string]float64{}}
+	}
+	oldest := (e.next - e.count + len(e.ring)) % len(e.ring)
+	baseline := e.ring[oldest]
+	for i := 1; i < e.count; i++ {
+		snapshot := e.ring[(oldest+i)%len(e.ring)]
+		if snapshot.at.After(cutoff) {
+			break
+		}
+		baseline = snapshot
+	}
+	return baseline
+}
+
+// record adds snapshot to the ring unless the newest recorded snapshot is less than a slot
+// old, overwriting the oldest once the ring is full. The caller holds e.mu.
+func (e *InferenceStatsEvaluator) record(snapshot inferenceSnapshot) {
+	if e.ring == nil {
+		// One slot more than a window's worth keeps a snapshot at least a window old
+		e.ring = make([]inferenceSnapshot, inferenceStatsSlots+2)
+	}
+	if e.count > 0 {
+		newest := e.ring[(e.next-1+len(e.ring))%len(e.ring)]
+		if snapshot.at.Sub(newest.at) < e.Window/inferenceStatsSlots {
+			return
+		}
+	}
+	e.ring[e.next] = snapshot
+	e.next = (e.next + 1) % len(e.ring)
+	if e.count < len(e.ring) {
+		e.count++
+	}
+}
+
+// Handler serves GET /api/inference/stats.
+func (e *InferenceStatsEvaluator) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats, err := e.Evaluate()
+		if err != nil {
+			logger.Error("Failed to gather metrics for inference stats", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, apitypes.ErrorResponse{Error: "failed to compute inference stats"})
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	}
+}
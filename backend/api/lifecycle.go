@@ -0,0 +1,174 @@
+// lifecycle.go
+// Server lifecycle helpers: in-flight request tracking and graceful shutdown.
+//
+// Shutdown runs in this order: stop accepting new connections and wait for
+// in-flight requests (forcing them closed after ShutdownTimeout), cancel
+// background work, close the cache client, then flush the logger. Its duration
+// and whether the drain had to be forced are recorded as metrics and also logged,
+// since the exiting process may not be scraped again. While the server drains,
+// responses carry Connection: close so keep-alive clients reconnect to a
+// healthy instance instead of reusing their connection to this one.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Shutdown outcomes recorded by shutdown_duration_seconds.
+const (
+	shutdownGraceful = "graceful"
+	shutdownForced   = "forced"
+)
+
+// Shutdown metrics.
+var (
+	shutdownDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "shutdown_duration_seconds",
+			Help:    "Duration of graceful shutdown in seconds, partitioned by whether the server drain was forced.",
+			Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"outcome"},
+	)
+	shutdownForcedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "shutdown_forced_total",
+			Help: "Total number of shutdowns whose server drain exceeded the shutdown timeout.",
+		},
+	)
+)
+
+// Number of requests currently being served
+var inFlightRequests int64
+
+// InFlightMiddleware tracks the number of requests currently being served.
+func InFlightMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		atomic.AddInt64(&inFlightRequests, 1)
+		defer atomic.AddInt64(&inFlightRequests, -1)
+		c.Next()
+	}
+}
+
+// InFlightRequests returns the number of requests currently being served.
+func InFlightRequests() int64 {
+	return atomic.LoadInt64(&inFlightRequests)
+}
+
+// Set to 1 once shutdown starts draining the server
+var draining int32
+
+// StartDraining marks the server as draining; it stays draining until the process exits.
+func StartDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// Draining reports whether shutdown has started draining the server.
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// DrainMiddleware sends Connection: close on responses while the server drains, so the
+// connection is not reused after the response. Outside of draining it does nothing.
+func DrainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Draining() {
+			c.Header("Connection", "close")
+		}
+		c.Next()
+	}
+}
+
+// shutdownServer drains the server within timeout, forcing remaining connections closed once it elapses.
+// It reports whether the forced-close branch was taken.
+func shutdownServer(srv *http.Server, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		return false, nil
+	}
+	if err != context.DeadlineExceeded {
+		return false, err
+	}
+
+	logger.Warn("Shutdown timeout elapsed, forcing remaining connections closed",
+		zap.Duration("timeout", timeout),
+		zap.Int64("in_flight_requests", InFlightRequests()),
+	)
+	shutdownForcedTotal.Inc()
+	return true, srv.Close()
+}
+
+// recordShutdown records a finished shutdown's duration and outcome, and logs them.
+func recordShutdown(duration time.Duration, forced bool) {
+	outcome := shutdownGraceful
+	if forced {
+		outcome = shutdownForced
+	}
+	shutdownDurationSeconds.WithLabelValues(outcome).Observe(duration.Seconds())
+	logger.Info("Shutdown finished",
+		zap.Float64("shutdown_duration_seconds", duration.Seconds()),
+		zap.Bool("forced", forced),
+	)
+}
+
+// shutdownStepGrace is how long a step may run once the shutdown context is already done,
+// so quick cleanup such as flushing logs still happens after an earlier step overran.
+const shutdownStepGrace = time.Second
+
+// ShutdownStep is one named stage of graceful shutdown.
+type ShutdownStep struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Shutdown runs steps in order, each bounded by ctx. A step that fails or is still running
+// when ctx is done is recorded and abandoned, and the remaining steps still run. It returns
+// an error listing the failed steps.
+func Shutdown(ctx context.Context, steps []ShutdownStep) error {
+	var failures []string
+	for _, step := range steps {
+		start := time.Now()
+		if err := runShutdownStep(ctx, step); err != nil {
+			logger.Error("Shutdown step failed", zap.String("step", step.Name), zap.Duration("duration", time.Since(start)), zap.Error(err))
+			failures = append(failures, fmt.Sprintf("%s: %v", step.Name, err))
+			continue
+		}
+		logger.Info("Shutdown step completed", zap.String("step", step.Name), zap.Duration("duration", time.Since(start)))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d shutdown steps failed: %s", len(failures), len(steps), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// runShutdownStep runs step, returning once it finishes or ctx is done. If ctx is already
+// done the step gets a fresh context of shutdownStepGrace instead.
+func runShutdownStep(ctx context.Context, step ShutdownStep) error {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), shutdownStepGrace)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- step.Run(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("abandoned: %w", ctx.Err())
+	}
+}
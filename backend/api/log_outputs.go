@@ -0,0 +1,145 @@
+// log_outputs.go
+// Log destinations and format. LOG_OUTPUTS lists where application logs go, e.g.
+// "stdout,file:/var/log/app.log=debug"; every output gets the same lines,
+// filtered by its own minimum level. A file that can't be opened is skipped
+// with a warning (falling back to stdout), so a bad path never keeps the
+// server from starting. Outputs are flushed by logger.Sync on shutdown.
+//
+// LOG_FORMAT=console switches to human-readable colored lines for local use.
+// Those break log ingestion, so in production (GIN_MODE=release, or ENV or
+// APP_ENV set to "production") JSON is forced with a warning unless
+// LOG_FORMAT_ALLOW_CONSOLE is set.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log formats selectable with LOG_FORMAT.
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// DefaultLogOutputs is used when LOG_OUTPUTS is not set.
+var DefaultLogOutputs = []LogOutput{{Target: "stdout", Level: zapcore.InfoLevel}}
+
+// LogOutput is one log destination: "stdout", "stderr" or "file:<path>".
+type LogOutput struct {
+	Target string
+	Level  zapcore.Level
+}
+
+// LoadLogOutputs parses LOG_OUTPUTS (comma-separated targets, each optionally suffixed with
+// "=level"). Entries that don't parse are reported to stderr and skipped, since the logger
+// doesn't exist yet.
+func LoadLogOutputs() []LogOutput {
+	value := os.Getenv("LOG_OUTPUTS")
+	if value == "" {
+		return DefaultLogOutputs
+	}
+	var outputs []LogOutput
+	for _, entry := range splitList(value) {
+		output := LogOutput{Target: entry, Level: zapcore.InfoLevel}
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			if err := output.Level.UnmarshalText([]byte(entry[i+1:])); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid level in LOG_OUTPUTS entry %q: %v\n", entry, err)
+				continue
+			}
+			output.Target = entry[:i]
+		}
+		if output.Target != "stdout" && output.Target != "stderr" && !strings.HasPrefix(output.Target, "file:") {
+			fmt.Fprintf(os.Stderr, "Invalid LOG_OUTPUTS target %q, expected stdout, stderr or file:<path>\n", output.Target)
+			continue
+		}
+		outputs = append(outputs, output)
+	}
+	if len(outputs) == 0 {
+		return DefaultLogOutputs
+	}
+	return outputs
+}
+
+// productionEnvironment reports whether the process is running in production.
+func productionEnvironment() bool {
+	return os.Getenv("GIN_MODE") == "release" ||
+		strings.EqualFold(os.Getenv("ENV"), "production") ||
+		strings.EqualFold(os.Getenv("APP_ENV"), "production")
+}
+
+// LoadLogFormat returns the log format from LOG_FORMAT (default json). It reports forced when
+// console output was requested in production and replaced by JSON; the caller should warn
+// about it once the logger exists.
+func LoadLogFormat() (format string, forced bool) {
+	format = strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT")))
+	if format != logFormatConsole {
+		return logFormatJSON, false
+	}
+	if productionEnvironment() && !getEnvBool("LOG_FORMAT_ALLOW_CONSOLE", false) {
+		return logFormatJSON, true
+	}
+	return logFormatConsole, false
+}
+
+// newLogEncoder returns the encoder shared by every output for format.
+func newLogEncoder(format string) zapcore.Encoder {
+	if format == logFormatConsole {
+		config := zap.NewDevelopmentEncoderConfig()
+		config.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		config.TimeKey = "timestamp"
+		config.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewConsoleEncoder(config)
+	}
+	config := zap.NewProductionEncoderConfig()
+	config.TimeKey = "timestamp"
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zapcore.NewJSONEncoder(config)
+}
+
+// newLogger builds a logger writing to every output in format, with stdout standing for the
+// process's standard output. It also returns the errors for outputs that could not be opened; those
+// log to stdout at their level instead, unless stdout is already an output.
+func newLogger(outputs []LogOutput, format string, stdout io.Writer) (*zap.Logger, []error) {
+	hasStdout := false
+	for _, output := range outputs {
+		hasStdout = hasStdout || output.Target == "stdout"
+	}
+
+	encoder := newLogEncoder(format)
+	var cores []zapcore.Core
+	var failed []error
+	for _, output := range outputs {
+		var sink zapcore.WriteSyncer
+		switch {
+		case output.Target == "stdout":
+			sink = zapcore.AddSync(stdout)
+		case output.Target == "stderr":
+			sink = zapcore.AddSync(os.Stderr)
+		default:
+			path := strings.TrimPrefix(output.Target, "file:")
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				failed = append(failed, fmt.Errorf("log output %s: %w", output.Target, err))
+				if hasStdout {
+					continue
+				}
+				sink = zapcore.AddSync(stdout)
+			} else {
+				sink = file
+			}
+		}
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.Lock(sink), output.Level))
+	}
+
+	// Sample repeated entries as zap's production config does
+	core := zapcore.NewSamplerWithOptions(zapcore.NewTee(cores...), time.Second, 100, 100)
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), failed
+}
@@ -1,7 +1,7 @@
 // main.go felicon
 // Main API server with middleware for logging, security, and metrics.
 // This server uses Gin for routing, Zap for logging, and Prometheus for metrics.
-// It includes basic endpoints for health checks and a placeholder for AI inference.
+// It includes basic endpoints for health checks and a proxy for AI inference.
 
 package main
 
@@ -11,15 +11,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal" 
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+	"your_project/config"   // Replace with your actual package path for the cache clients
+	ratelimit "your_project/middleware" // Replace with your actual package path for the rate limiter
 )
 
 // Metrics for Prometheus
@@ -41,38 +44,85 @@ var (
 	)
 )
 
-// Logger instance for the application
-var logger *zap.Logger
+// Logger instance for the application; a no-op until InitializeLogger replaces it
+var logger = zap.NewNop()
+
+// Shared cache backend for API responses (nil when no cache is configured)
+var appCache ResponseCache
+
+// Response cacher used by the cacheable API routes and handler-level cache-aside lookups
+var responseCacher *ResponseCacher
+
+// Inference proxy serving the /api/inference endpoint
+var inferenceService *InferenceService
+
+// SLO evaluator serving the /api/slo endpoint
+var sloEvaluator *SLOEvaluator
 
-// InitializeLogger sets up a production-ready logger using Zap.
+// Liveness watchdog consulted by the health endpoints (nil until started)
+var watchdog *Watchdog
+
+// InitializeLogger sets up a production-ready logger using Zap, writing to the outputs in LOG_OUTPUTS.
 func InitializeLogger() error {
-	config := zap.NewProductionConfig()
-	config.EncoderConfig.TimeKey = "timestamp"
-	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	var err error
-	logger, err = config.Build()
-	if err != nil {
-		return fmt.Errorf("failed to initialize logger: %v", err)
-	}
+	var failed []error
+	format, forced := LoadLogFormat()
+	logger, failed = newLogger(LoadLogOutputs(), format, os.Stdout)
 	defer logger.Sync()
+	if forced {
+		logger.Warn("LOG_FORMAT=console is not allowed in production, logging JSON instead; set LOG_FORMAT_ALLOW_CONSOLE=true to override")
+	}
+	for _, err := range failed {
+		logger.Warn("Log output unavailable, logging to stdout instead", zap.Error(err))
+	}
 	logger.Info("Logger initialized successfully")
 	return nil
 }
 
+// metricsRecordedKey marks a request whose metrics have been recorded, so a request passing
+// through MetricsMiddleware twice (e.g. also installed on a route group) is counted once.
+const metricsRecordedKey = "metrics_recorded"
+
 // MetricsMiddleware tracks request count and latency for Prometheus.
+//
+// The request is recorded once the rest of the chain has returned, with the status actually
+// written: requests aborted by later middleware (auth, rate limits) are counted with the
+// status they were aborted with, and a panicking request as the 500 the recovery middleware
+// sends. It must run outside middleware that rewrites the status after the handler, such as
+// ETagMiddleware turning a 200 into a 304. Endpoints are labelled with their route template
+// (e.g. "/api/items/:id"), so path parameters don't each create a series; requests matching
+// no route, and routes past the endpointLabels cap, are recorded as "other". The watchdog's
+// heartbeat requests are not recorded.
 func MetricsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if c.GetBool(metricsRecordedKey) || isWatchdogRequest(c.Request) {
+			c.Next()
+			return
+		}
+		c.Set(metricsRecordedKey, true)
+
 		start := time.Now()
 		method := c.Request.Method
-		endpoint := c.Request.URL.Path
+		endpoint := overflowLabelValue
+		if route := c.FullPath(); route != "" {
+			endpoint = endpointLabels.Value(route)
+		}
+		record := func(status int) {
+			httpRequestsTotal.WithLabelValues(fmt.Sprintf("%d", status), method).Inc()
+			httpRequestDuration.WithLabelValues(method, endpoint).Observe(time.Since(start).Seconds())
+		}
 
+		defer func() {
+			if p := recover(); p != nil {
+				status := c.Writer.Status()
+				if !c.Writer.Written() {
+					status = http.StatusInternalServerError
+				}
+				record(status)
+				panic(p)
+			}
+		}()
 		c.Next()
-
-		duration := time.Since(start).Seconds()
-		statusCode := fmt.Sprintf("%d", c.Writer.Status())
-
-		httpRequestsTotal.WithLabelValues(statusCode, method).Inc()
-		httpRequestDuration.WithLabelValues(method, endpoint).Observe(duration)
+		record(c.Writer.Status())
 	}
 }
 
@@ -88,9 +138,18 @@ func SecurityMiddleware() gin.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware logs incoming requests and responses using Zap.
-func LoggingMiddleware() gin.HandlerFunc {
+// LoggingMiddleware logs incoming requests and responses to log, at the level levels assigns to the status code,
+// including any fields the handler added with AddLogField. A nil log discards them. The watchdog's
+// heartbeat requests are not logged.
+func LoggingMiddleware(log *zap.Logger, levels AccessLogLevels) gin.HandlerFunc {
+	if log == nil {
+		log = zap.NewNop()
+	}
 	return func(c *gin.Context) {
+		if isWatchdogRequest(c.Request) {
+			c.Next()
+			return
+		}
 		start := time.Now()
 		path := c.Request.URL.Path
 		query := c.Request.URL.RawQuery
@@ -102,36 +161,70 @@ func LoggingMiddleware() gin.HandlerFunc {
 		statusCode := c.Writer.Status()
 		clientIP := c.ClientIP()
 
-		logger.Info("HTTP request processed",
-			zap.String("method", method),
-			zap.String("path", path),
-			zap.String("query", query),
-			zap.String("client_ip", clientIP),
-			zap.Int("status_code", statusCode),
-			zap.Duration("latency", latency),
-		)
+		if entry := log.Check(levels.Level(statusCode), "HTTP request processed"); entry != nil {
+			fields := []zap.Field{
+				zap.String("method", method),
+				zap.String("path", path),
+				zap.String("query", query),
+				zap.String("client_ip", clientIP),
+				zap.Int("status_code", statusCode),
+				zap.Duration("latency", latency),
+				zap.String("request_id", RequestID(c)),
+			}
+			entry.Write(append(fields, LogFields(c)...)...)
+		}
 	}
 }
 
 // HealthCheckHandler returns the health status of the server.
 func HealthCheckHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"message": "API server is up and running",
-		"version": "1.0.0",
+	if watchdog.Stale() {
+		c.JSON(http.StatusServiceUnavailable, apitypes.HealthResponse{
+			Status:  "unhealthy",
+			Message: "Request-serving heartbeat stale for " + watchdog.HeartbeatAge().Round(time.Second).String(),
+			Version: "1.0.0",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, apitypes.HealthResponse{
+		Status:  "healthy",
+		Message: "API server is up and running",
+		Version: "1.0.0",
 	})
 }
 
-// InferenceHandler is a placeholder for AI model inference endpoint.
-func InferenceHandler(c *gin.Context) {
-	// Placeholder for AI inference logic
-	c.JSON(http.StatusOK, gin.H{
-		"message": "AI inference endpoint - implementation pending",
-	})
+// HealthCheckHeadHandler answers HEAD liveness probes with the health status and no body.
+func HealthCheckHeadHandler(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	if watchdog.Stale() {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// routerMiddleware lists the middleware applied to every route by SetupRouter, in order (reported in the startup summary).
+func routerMiddleware(preflightBypass, apiKeys bool) []string {
+	middleware := []string{"recovery", "in_flight", "drain", "request_id", "baggage", "logging", "security", "server_timing", "metrics", "etag", "request_cache"}
+	if preflightBypass {
+		middleware = append([]string{"recovery", "cors"}, middleware[1:]...)
+	}
+	if apiKeys {
+		middleware = append(middleware, "api_key")
+	}
+	if !preflightBypass {
+		middleware = append(middleware, "cors")
+	}
+	return middleware
 }
 
 // SetupRouter configures the Gin router with middleware and endpoints.
 func SetupRouter() *gin.Engine {
+	// Without InitializeLogger (e.g. in tests), log nowhere rather than panic on the first request
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
 	// Set Gin mode to release for production
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
@@ -139,79 +232,279 @@ func SetupRouter() *gin.Engine {
 	// Add recovery middleware to handle panics
 	router.Use(gin.Recovery())
 
-	// Add custom middleware
-	router.Use(LoggingMiddleware())
-	router.Use(SecurityMiddleware())
-	router.Use(MetricsMiddleware())
-
-	// Add CORS middleware for cross-origin requests
+	// Add CORS middleware for cross-origin requests. It answers preflight requests with a 204
+	// and aborts, so registering it first keeps preflights out of request logs and metrics.
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
-	router.Use(cors.New(corsConfig))
+	corsConfig.AllowMethods = LoadCORSAllowMethods()
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", APIKeyHeader, apitypes.RequestIDHeader, apitypes.ModelHeader, MethodOverrideHeader}
+	corsHandler := cors.New(corsConfig)
+	preflightBypass := LoadCORSPreflightBypass()
+	if preflightBypass {
+		router.Use(corsHandler)
+	}
+
+	// Add custom middleware
+	router.Use(InFlightMiddleware())
+	router.Use(DrainMiddleware())
+	router.Use(RequestIDMiddleware())
+	baggage, _ := LoadBaggageConfig() // Validated by Run; an invalid strict config accepts no baggage
+	router.Use(BaggageMiddleware(baggage))
+	router.Use(LoggingMiddleware(logger, LoadAccessLogLevels()))
+	router.Use(SecurityMiddleware())
+	router.Use(ServerTimingMiddleware())
+	endpointLabels = NewLabelGuard("endpoint", LoadMetricsMaxLabelValues())
+	router.Use(MetricsMiddleware()) // Outside ETagMiddleware, so 304s are recorded as sent
+	router.Use(ETagMiddleware())
+	router.Use(RequestCacheMiddleware(appCache, LoadRequestCacheBudget()))
+	apiKeys := LoadAPIKeyConfig()
+	if apiKeys.Enabled() {
+		router.Use(APIKeyMiddleware(apiKeys))
+	}
+	if !preflightBypass {
+		router.Use(corsHandler)
+	}
 
-	// Define API routes
-	api := router.Group("/api")
+	// Configure response caching; cacheable public GET routes attach responseCacher.Middleware()
+	responseCacher = NewResponseCacher(appCache, LoadResponseCacheConfig())
+	inferenceService = NewInferenceService(responseCacher, LoadInferenceConfig())
+	inferenceService.Router = NewModelRouter(LoadModelRoutes())
+	inferenceService.Schemas = NewResponseSchemaRegistry(LoadResponseSchemas())
+	inferenceService.Hooks = NewInferenceHookRegistry(LoadInferenceFieldMappings())
+	inferenceService.Budget = LoadInferenceBudget(appCache)
+	sloEvaluator = NewSLOEvaluator(LoadSLOConfig(), prometheus.DefaultGatherer.Gather)
+	inferenceStats := NewInferenceStatsEvaluator(LoadInferenceStatsWindow(), prometheus.DefaultGatherer.Gather)
+	cacheProbe := NewCacheProbe(appCache, LoadCacheProbeConfig())
+	cacheControl := LoadCacheControlConfig()
+	cacheReady := cacheReadiness.Middleware()
+
+	// Admin and usage endpoints always require a shared (non-tenant) API key, even if their
+	// path is listed in API_KEY_EXEMPT_PATHS; without API_KEYS every request is refused
+	adminAuth := APIKeyMiddleware(APIKeyConfig{Keys: apiKeys.Keys})
+	// Admin and per-client responses are never stored by edge caches, whatever credentials the request carries
+	noStore := CacheControlMiddleware(noStoreCacheControl)
+
+	// Define API routes. Health checks stay outside rate limiting so probes are never throttled.
+	router.GET("/api/health", CacheControlMiddleware(cacheControl.Health), HealthCheckHandler)
+	router.HEAD("/api/health", CacheControlMiddleware(cacheControl.Health), HealthCheckHeadHandler)
+
+	// Every other API route is rate limited per client, reporting its budget in X-RateLimit-* headers
+	api := router.Group("/api", ratelimit.RateLimitMiddleware())
 	{
-		api.GET("/health", HealthCheckHandler)
-		api.POST("/inference", InferenceHandler)
+		api.POST("/inference", cacheReady, inferenceService.Handler())
+		api.POST("/inference/batch", cacheReady, inferenceService.BatchHandler())
+		api.GET("/inference/stats", noStore, adminAuth, inferenceStats.Handler())
+		api.GET("/slo", CacheControlMiddleware(cacheControl.Public), responseCacher.Middleware(), sloEvaluator.Handler())
+		api.GET("/cache/probe", cacheProbe.Handler())
+		api.GET("/cache/keys", noStore, adminAuth, cacheReady, CacheKeysHandler(appCache))
+		api.GET("/cache/warm/status", noStore, adminAuth, CacheWarmStatusHandler(appCache))
+		api.GET("/config/sources", noStore, adminAuth, ConfigSourcesHandler(configSources))
+		api.GET("/ratelimit/status", noStore, adminAuth, ratelimit.RateLimitStatusHandler)
 	}
 
 	// Expose Prometheus metrics endpoint
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/metrics", gin.WrapH(metricsHandler(LoadMetricsCacheTTL())))
 
 	return router
 }
 
-// main function to start the server with graceful shutdown.
-func main() {
-	// Initialize logger
-	if err := InitializeLogger(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing logger: %v\n", err)
-		os.Exit(1)
+// shutdownCleanupTimeout bounds the shutdown steps that follow the server drain.
+const shutdownCleanupTimeout = 5 * time.Second
+
+// shutdownSteps lists the graceful shutdown sequence documented in lifecycle.go. The drain
+// step sets forced if it had to force connections closed.
+func shutdownSteps(srv *http.Server, drainTimeout time.Duration, stopBackground context.CancelFunc, memcached *config.MemcachedConfig, forced *atomic.Bool) []ShutdownStep {
+	return []ShutdownStep{
+		{Name: "drain_server", Run: func(ctx context.Context) error {
+			// Stops accepting connections, then waits for in-flight requests
+			StartDraining()
+			wasForced, err := shutdownServer(srv, drainTimeout)
+			forced.Store(wasForced)
+			if wasForced {
+				logger.Warn("Server forced to shutdown", zap.Duration("timeout", drainTimeout))
+			}
+			return err
+		}},
+		{Name: "cancel_background", Run: func(ctx context.Context) error {
+			stopBackground()
+			return nil
+		}},
+		{Name: "close_cache", Run: func(ctx context.Context) error {
+			// Waits for background cache work before releasing the client
+			if memcached == nil {
+				return nil
+			}
+			return memcached.Close()
+		}},
+		{Name: "flush_logger", Run: func(ctx context.Context) error {
+			// Syncing stdout/stderr fails on some platforms; there is nowhere left to report it
+			logger.Sync()
+			return nil
+		}},
 	}
+}
 
+// Run starts the server and blocks until ctx is cancelled (normally by a shutdown
+// signal), then shuts down gracefully. It returns nil after a clean shutdown; any
+// other outcome is an *ExitError whose code and reason main reports on exit.
+func Run(ctx context.Context) error {
 	// Register Prometheus metrics
-	prometheus.MustRegister(httpRequestsTotal)
-	prometheus.MustRegister(httpRequestDuration)
+	if err := RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		return exitError(ExitConfig, ReasonConfig, err)
+	}
 	logger.Info("Prometheus metrics registered")
 
+	serverConfig := LoadServerConfig()
+	if _, err := LoadBaggageConfig(); err != nil {
+		return exitError(ExitConfig, ReasonConfig, err)
+	}
+
+	// Connect to Memcached for response caching if configured. A required cache is
+	// connected in the background once the server is listening, with cache-dependent
+	// routes answering 503 until it is ready.
+	var memcached *config.MemcachedConfig
+	if os.Getenv("MEMCACHED_SERVERS") != "" {
+		if serverConfig.CacheRequired {
+			mc, err := config.NewMemcached()
+			if err != nil {
+				return exitError(ExitConfig, ReasonConfig, fmt.Errorf("memcached: %w", err))
+			}
+			mc.Logger = logger.Named("cache")
+			memcached = mc
+			appCache = mc
+			cacheReadiness = NewCacheReadiness(defaultCacheRetryAfter)
+		} else {
+			startupCtx, cancelStartup := context.WithTimeout(ctx, serverConfig.StartupTimeout)
+			mc, err := config.InitMemcachedContext(startupCtx)
+			cancelStartup()
+			if err != nil {
+				logger.Warn("Memcached unavailable, serving API responses uncached", zap.Error(err))
+			} else {
+				mc.Logger = logger.Named("cache")
+				memcached = mc
+				appCache = mc
+			}
+		}
+	} else if serverConfig.CacheRequired {
+		return exitError(ExitConfig, ReasonConfig, fmt.Errorf("CACHE_REQUIRED is set but MEMCACHED_SERVERS is empty"))
+	}
+
 	// Setup router with middleware and endpoints
 	router := SetupRouter()
 	logger.Info("Router and middleware setup completed")
 
 	// Create HTTP server
+	logConfigSummary(configSummary(serverConfig, responseCacher.Config, memcached))
 	srv := &http.Server{
-		Addr:         ":8080",
-		Handler:      router,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         serverConfig.Addr,
+		Handler:      MethodOverride(router, LoadMethodOverrideMethods()),
+		ReadTimeout:  serverConfig.ReadTimeout,
+		WriteTimeout: serverConfig.WriteTimeout,
+		IdleTimeout:  serverConfig.IdleTimeout,
 	}
 
+	// Start the liveness watchdog, which stops with the server
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	watchdog = NewWatchdog(LoadWatchdogConfig())
+	go watchdog.Run(watchdogCtx, router)
+
 	// Start server in a goroutine for graceful shutdown
+	serveErr := make(chan error, 1)
 	go func() {
-		logger.Info("Starting API server on :8080")
+		logger.Info("Starting API server", zap.String("addr", serverConfig.Addr))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Server failed to start", zap.Error(err))
+			serveErr <- err
 		}
 	}()
 
-	// Setup graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	logger.Info("Received shutdown signal, initiating graceful shutdown...")
+	// Bring the required cache up while already serving health and metrics
+	connectErr := make(chan error, 1)
+	if cacheReadiness != nil {
+		go func() {
+			startupCtx, cancelStartup := context.WithTimeout(ctx, serverConfig.StartupTimeout)
+			defer cancelStartup()
+			if err := memcached.Connect(startupCtx); err != nil {
+				connectErr <- err
+				return
+			}
+			cacheReadiness.MarkReady()
+			logger.Info("Memcached connected, serving cache-dependent routes")
+		}()
+	}
 
-	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var runErr error
+	select {
+	case err := <-serveErr:
+		stopWatchdog()
+		if memcached != nil {
+			memcached.Close()
+		}
+		return exitError(ExitStartup, ReasonListen, err)
+	case err := <-connectErr:
+		if ctx.Err() == nil {
+			logger.Error("Required cache did not connect before the startup deadline, shutting down", zap.Error(err))
+			runErr = exitError(ExitDependency, ReasonDependency, fmt.Errorf("memcached: %w", err))
+		} else {
+			logger.Info("Received shutdown signal, initiating graceful shutdown...")
+		}
+	case <-ctx.Done():
+		logger.Info("Received shutdown signal, initiating graceful shutdown...")
+	}
+
+	// Shut down in order, leaving time for cleanup after the server drain
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout+shutdownCleanupTimeout)
 	defer cancel()
+	var forced atomic.Bool
+	shutdownStart := time.Now()
+	err := Shutdown(shutdownCtx, shutdownSteps(srv, serverConfig.ShutdownTimeout, stopWatchdog, memcached, &forced))
+	recordShutdown(time.Since(shutdownStart), forced.Load())
+	if err != nil {
+		return exitError(ExitFailure, ReasonShutdownIncomplete, err)
+	}
+	return runErr
+}
+
+// main function to start the server with graceful shutdown.
+func main() {
+	// Apply CONFIG_FILE before anything reads the environment, including the logger
+	sources, err := LoadConfigFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+	configSources = sources
 
-	// Shutdown the server
-	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatal("Server forced to shutdown", zap.Error(err))
+	// Initialize logger
+	if err := InitializeLogger(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing logger: %v\n", err)
+		os.Exit(ExitConfig)
 	}
+	configSources.Log()
 
-	logger.Info("Server shutdown completed")
+	// With --selftest, check the configuration and dependencies and exit instead of serving
+	if SelfTestRequested(os.Args[1:]) {
+		err = SelfTest(context.Background(), os.Stdout)
+		if err == nil {
+			logger.Info("Self-test passed")
+		}
+		code, _ := ExitCode(err)
+		logger.Sync()
+		os.Exit(code)
+	}
+
+	// Run until SIGINT/SIGTERM, then exit with a code describing why the server stopped
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err = Run(ctx)
+	stop()
+
+	code, reason := ExitCode(err)
+	fields := []zap.Field{zap.String("reason", reason), zap.Int("exit_code", code)}
+	if err != nil {
+		logger.Error("Server stopped", append(fields, zap.Error(err))...)
+	} else {
+		logger.Info("Server shutdown completed", fields...)
+	}
+	logger.Sync()
+	os.Exit(code)
 }
@@ -0,0 +1,58 @@
+// method_override.go
+// HTTP method override for clients behind proxies that strip PUT/DELETE. A POST
+// carrying X-HTTP-Method-Override with an allowed method is rewritten to that
+// method before routing, so it reaches the matching handler and is logged and
+// counted under the effective method. Gin matches routes before running
+// middleware, so this wraps the router as an http.Handler rather than being
+// registered with router.Use.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MethodOverrideHeader carries the method a POST request stands in for.
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// DefaultMethodOverrideMethods lists the methods a POST may be overridden to unless configured.
+var DefaultMethodOverrideMethods = []string{http.MethodPut, http.MethodDelete, http.MethodPatch}
+
+// LoadMethodOverrideMethods returns the methods a POST may be overridden to, from
+// METHOD_OVERRIDE_METHODS (comma-separated). Setting it to "none" disables overrides.
+func LoadMethodOverrideMethods() []string {
+	methodsEnv := os.Getenv("METHOD_OVERRIDE_METHODS")
+	if methodsEnv == "" {
+		return DefaultMethodOverrideMethods
+	}
+	if strings.EqualFold(methodsEnv, "none") {
+		return nil
+	}
+	methods := splitList(methodsEnv)
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	return methods
+}
+
+// MethodOverride wraps next so POST requests with an allowed X-HTTP-Method-Override are
+// handled as that method. Overrides to other methods are ignored and the request stays a POST.
+func MethodOverride(next http.Handler, methods []string) http.Handler {
+	if len(methods) == 0 {
+		return next
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if override := strings.ToUpper(strings.TrimSpace(r.Header.Get(MethodOverrideHeader))); allowed[override] {
+				r.Method = override
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
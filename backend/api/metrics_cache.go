@@ -0,0 +1,70 @@
+// metrics_cache.go
+// Snapshot caching for the /metrics endpoint. With aggressive scraping and
+// high-cardinality metrics, gathering every collector on each scrape contends
+// with request serving; when METRICS_CACHE_TTL is set, scrapes within that
+// window are served the same gathered snapshot.
+//
+// Snapshots are gathered one at a time, so a newer snapshot never replaces an
+// older one and counters scraped from the endpoint stay monotonic; they can
+// only lag by up to the TTL.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// LoadMetricsCacheTTL returns how long a gathered metrics snapshot is reused, from
+// METRICS_CACHE_TTL (e.g. "1s"). Zero, the default, gathers on every scrape.
+func LoadMetricsCacheTTL() time.Duration {
+	return getEnvDuration("METRICS_CACHE_TTL", 0)
+}
+
+// cachingGatherer reuses the families returned by Inner for TTL after each gather.
+type cachingGatherer struct {
+	Inner prometheus.Gatherer
+	TTL   time.Duration
+	now   func() time.Time
+
+	mu         sync.Mutex
+	families   []*dto.MetricFamily
+	err        error
+	gatheredAt time.Time
+}
+
+// newCachingGatherer wraps inner so gathers within ttl of the last one reuse its result.
+func newCachingGatherer(inner prometheus.Gatherer, ttl time.Duration) *cachingGatherer {
+	return &cachingGatherer{Inner: inner, TTL: ttl, now: time.Now}
+}
+
+// Gather returns the cached snapshot while it is fresh and gathers a new one otherwise.
+// Concurrent scrapes of an expired snapshot wait for a single gather.
+func (g *cachingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if !g.gatheredAt.IsZero() && now.Sub(g.gatheredAt) < g.TTL {
+		return g.families, g.err
+	}
+	g.families, g.err = g.Inner.Gather()
+	g.gatheredAt = now
+	return g.families, g.err
+}
+
+// metricsHandler serves the default registry, reusing snapshots for ttl when it is positive.
+func metricsHandler(ttl time.Duration) http.Handler {
+	if ttl <= 0 {
+		return promhttp.Handler()
+	}
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(newCachingGatherer(prometheus.DefaultGatherer, ttl), promhttp.HandlerOpts{}),
+	)
+}
@@ -0,0 +1,82 @@
+// metrics_cardinality.go
+// Label cardinality guard for request metrics. Every distinct label value
+// creates a new series that the Prometheus client keeps in memory for the
+// life of the process. Request metrics are labelled by route template rather
+// than raw path, which keeps them bounded by the routes registered; the guard
+// is a backstop that tracks at most METRICS_MAX_LABEL_VALUES distinct values
+// per label and reports any new value past the cap as "other", warning once
+// when that starts.
+
+package main
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// overflowLabelValue replaces label values past a guard's cap.
+const overflowLabelValue = "other"
+
+// defaultMetricsMaxLabelValues caps the distinct endpoints tracked by request metrics.
+const defaultMetricsMaxLabelValues = 500
+
+// endpointLabels guards the endpoint label of the request metrics; nil leaves it uncapped.
+var endpointLabels *LabelGuard
+
+// LoadMetricsMaxLabelValues returns the cap on distinct values per metric label from
+// METRICS_MAX_LABEL_VALUES. Zero disables the cap.
+func LoadMetricsMaxLabelValues() int {
+	return getEnvInt("METRICS_MAX_LABEL_VALUES", defaultMetricsMaxLabelValues)
+}
+
+// LabelGuard caps the number of distinct values a metric label takes.
+type LabelGuard struct {
+	Name string // Label name, for the overflow warning
+	Max  int    // Maximum distinct values, 0 for no cap
+
+	mu       sync.Mutex
+	seen     map[string]struct{}
+	overflow bool
+}
+
+// NewLabelGuard returns a guard admitting at most max distinct values of the label name.
+func NewLabelGuard(name string, max int) *LabelGuard {
+	return &LabelGuard{Name: name, Max: max, seen: make(map[string]struct{})}
+}
+
+// Value returns value if it is already tracked or there is room to track it, and
+// overflowLabelValue otherwise. A nil guard returns every value unchanged.
+func (g *LabelGuard) Value(value string) string {
+	if g == nil || g.Max <= 0 {
+		return value
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[value]; ok {
+		return value
+	}
+	if len(g.seen) < g.Max {
+		g.seen[value] = struct{}{}
+		return value
+	}
+	if !g.overflow {
+		g.overflow = true
+		logger.Warn("Metric label cardinality cap reached, recording new values as \""+overflowLabelValue+"\"",
+			zap.String("label", g.Name),
+			zap.Int("max", g.Max),
+			zap.String("first_overflow", value),
+		)
+	}
+	return overflowLabelValue
+}
+
+// Len returns the number of distinct values being tracked.
+func (g *LabelGuard) Len() int {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.seen)
+}
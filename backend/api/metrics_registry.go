@@ -0,0 +1,89 @@
+// metrics_registry.go
+// Idempotent Prometheus registration. Registering a collector twice (a config
+// reload, or tests building the server more than once) makes MustRegister panic;
+// here a collector that is already registered is reused instead, so the package
+// metric variables always point at the collector the registry exports.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// registerCollector registers c with reg. If an equivalent collector is already
+// registered, the existing one is returned so callers keep updating the exported series.
+func registerCollector[T prometheus.Collector](reg prometheus.Registerer, c T) (T, error) {
+	err := reg.Register(c)
+	if err == nil {
+		return c, nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// RegisterMetrics registers the server's metrics with reg. It is safe to call
+// more than once: collectors registered earlier are reused rather than panicking.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	var errs []error
+	register := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("register %s: %w", name, err))
+		}
+	}
+
+	var err error
+	httpRequestsTotal, err = registerCollector(reg, httpRequestsTotal)
+	register("http_requests_total", err)
+	httpRequestDuration, err = registerCollector(reg, httpRequestDuration)
+	register("http_request_duration_seconds", err)
+	config.CacheOperationsTotal, err = registerCollector(reg, config.CacheOperationsTotal)
+	register("cache_operations_total", err)
+	config.CacheSerializationErrorsTotal, err = registerCollector(reg, config.CacheSerializationErrorsTotal)
+	register("cache_serialization_errors_total", err)
+	config.CacheBackendErrorsTotal, err = registerCollector(reg, config.CacheBackendErrorsTotal)
+	register("cache_backend_errors_total", err)
+	config.CacheNamespaceOperationsTotal, err = registerCollector(reg, config.CacheNamespaceOperationsTotal)
+	register("cache_namespace_operations_total", err)
+	config.CacheServerLatencySeconds, err = registerCollector(reg, config.CacheServerLatencySeconds)
+	register("cache_server_latency_seconds", err)
+	config.CacheWarmLastRunTimestamp, err = registerCollector(reg, config.CacheWarmLastRunTimestamp)
+	register("cache_warm_last_run_timestamp_seconds", err)
+	config.CacheWarmRunsTotal, err = registerCollector(reg, config.CacheWarmRunsTotal)
+	register("cache_warm_runs_total", err)
+	config.CacheReplicationDroppedTotal, err = registerCollector(reg, config.CacheReplicationDroppedTotal)
+	register("cache_replication_dropped_total", err)
+	inferenceRoutedTotal, err = registerCollector(reg, inferenceRoutedTotal)
+	register("inference_routed_requests_total", err)
+	inferenceRequestSizeBytes, err = registerCollector(reg, inferenceRequestSizeBytes)
+	register("inference_request_size_bytes", err)
+	inferenceDurationSeconds, err = registerCollector(reg, inferenceDurationSeconds)
+	register("inference_duration_seconds", err)
+	inferenceRequestsTotal, err = registerCollector(reg, inferenceRequestsTotal)
+	register("inference_requests_total", err)
+	cacheFallbackResponsesTotal, err = registerCollector(reg, cacheFallbackResponsesTotal)
+	register("cache_fallback_responses_total", err)
+	inferenceQueueDepth, err = registerCollector(reg, inferenceQueueDepth)
+	register("inference_queue_depth", err)
+	inferenceQueueWaitSeconds, err = registerCollector(reg, inferenceQueueWaitSeconds)
+	register("inference_queue_wait_seconds", err)
+	inferenceShedTotal, err = registerCollector(reg, inferenceShedTotal)
+	register("inference_shed_total", err)
+	inferenceInFlight, err = registerCollector(reg, inferenceInFlight)
+	register("inference_in_flight", err)
+	shutdownDurationSeconds, err = registerCollector(reg, shutdownDurationSeconds)
+	register("shutdown_duration_seconds", err)
+	shutdownForcedTotal, err = registerCollector(reg, shutdownForcedTotal)
+	register("shutdown_forced_total", err)
+
+	return errors.Join(errs...)
+}
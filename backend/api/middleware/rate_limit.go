@@ -1,8 +1,8 @@
 package ratelimit
 
 import (
-	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"strconv" 
@@ -94,50 +94,83 @@ func (store *RateLimiterStore) cleanupRoutine() {
 	}
 }
 
+// RateLimitStatus describes a client's current rate budget
+type RateLimitStatus struct {
+	ClientID  string  `json:"client_id"`
+	Limit     int     `json:"limit"`     // Maximum burst of requests
+	Remaining int     `json:"remaining"` // Requests that can be made right now
+	Tokens    float64 `json:"tokens"`    // Exact token count, including partially refilled tokens
+	Reset     int64   `json:"reset"`     // Unix time at which the budget is fully replenished
+}
+
+// clientIDFor returns the identifier rate limits are tracked under for a request
+func clientIDFor(c *gin.Context) string {
+	// Use client IP as the identifier (can be replaced with user token if authenticated)
+	// if userID, exists := c.Get("user_id"); exists {
+	//     return fmt.Sprintf("user:%v", userID)
+	// }
+	return c.ClientIP()
+}
+
+// status reports the budget left on limiter without consuming any of it
+func (store *RateLimiterStore) status(clientID string, limiter *rate.Limiter) RateLimitStatus {
+	store.mu.RLock()
+	config := store.config
+	store.mu.RUnlock()
+
+	tokens := float64(config.BurstSize)
+	if limiter != nil {
+		tokens = limiter.Tokens()
+	}
+	if tokens < 0 {
+		tokens = 0
+	}
+	reset := time.Now()
+	if missing := float64(config.BurstSize) - tokens; missing > 0 {
+		reset = reset.Add(time.Duration(missing / config.RequestsPerSecond * float64(time.Second)))
+	}
+	return RateLimitStatus{
+		ClientID:  clientID,
+		Limit:     config.BurstSize,
+		Remaining: int(tokens),
+		Tokens:    tokens,
+		Reset:     reset.Unix(),
+	}
+}
+
+// setRateLimitHeaders reports a client's rate budget on the response
+func setRateLimitHeaders(c *gin.Context, status RateLimitStatus) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(status.Reset, 10))
+}
+
 // RateLimitMiddleware is a Gin middleware for enforcing rate limiting per client
 func RateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Use client IP as the identifier (can be replaced with user token if authenticated)
-		clientID := c.ClientIP()
-
-		// Optionally, if integrated with auth, use user ID or token from context
-		// if userID, exists := c.Get("user_id"); exists {
-		//     clientID = fmt.Sprintf("user:%v", userID)
-		// }
+		clientID := clientIDFor(c)
 
 		// Get or create rate limiter for this client
 		limiter := limiterStore.getLimiter(clientID)
 
-		// Create a context for rate limiting
-		ctx := c.Request.Context()
-		if ctx == nil {
-			ctx = context.Background()
-		}
-
 		// Check if request is allowed within rate limit
 		reservation := limiter.Reserve()
-		if !reservation.OK() {
-			// Rate limit exceeded
-			retryAfter := reservation.Delay()
-			c.Header("Retry-After", strconv.FormatInt(int64(retryAfter.Seconds()), 10))
-			c.Header("X-Rate-Limit-Limit", strconv.FormatFloat(limiterStore.config.RequestsPerSecond, 'f', 2, 64))
-			c.Header("X-Rate-Limit-Remaining", "0")
-			c.Header("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(retryAfter).Unix(), 10))
+		if delay := reservation.Delay(); !reservation.OK() || delay > 0 {
+			// Rate limit exceeded; give the token back since the request is rejected
+			reservation.Cancel()
+			setRateLimitHeaders(c, limiterStore.status(clientID, limiter))
+			c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(delay.Seconds())), 10))
 
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":   "Rate limit exceeded",
-				"message": fmt.Sprintf("Please try again after %v", retryAfter),
+				"message": fmt.Sprintf("Please try again after %v", delay),
 			})
 			c.Abort()
 			return
 		}
 
 		// Set rate limit headers for client feedback
-		tokens := limiter.Tokens()
-		resetTime := time.Now().Add(reservation.DelayFrom(time.Now()))
-		c.Header("X-Rate-Limit-Limit", strconv.FormatFloat(limiterStore.config.RequestsPerSecond, 'f', 2, 64))
-		c.Header("X-Rate-Limit-Remaining", strconv.FormatFloat(tokens, 'f', 2, 64))
-		c.Header("X-Rate-Limit-Reset", strconv.FormatInt(resetTime.Unix(), 10))
+		setRateLimitHeaders(c, limiterStore.status(clientID, limiter))
 
 		// Allow the request to proceed
 		c.Next()
@@ -149,6 +182,21 @@ func RateLimitMiddleware() gin.HandlerFunc {
 	}
 }
 
+// RateLimitStatusHandler serves GET /api/ratelimit/status, reporting the calling client's
+// rate budget without consuming any of it beyond its own request when mounted behind
+// RateLimitMiddleware. Mount it behind authentication (e.g. AuthMiddleware).
+func RateLimitStatusHandler(c *gin.Context) {
+	clientID := clientIDFor(c)
+
+	limiterStore.mu.RLock()
+	limiter := limiterStore.limiters[clientID]
+	limiterStore.mu.RUnlock()
+
+	status := limiterStore.status(clientID, limiter)
+	setRateLimitHeaders(c, status)
+	c.JSON(http.StatusOK, status)
+}
+
 // GetRateLimiterConfig returns the current rate limiter configuration
 func GetRateLimiterConfig() RateLimiterConfig {
 	return limiterStore.config
@@ -0,0 +1,191 @@
+// request_cache.go
+// Request-scoped cache memoization (an L0 in front of the response cache).
+// Several code paths serving one request may read the same key; through
+// RequestCacheFor the first read goes to the cache and later reads of the key,
+// hits or misses, are answered from memory for the rest of the request. The
+// memo lives in the gin context and is dropped when the request ends, so it
+// never serves data across requests.
+//
+// With REQUEST_CACHE_OP_BUDGET set, a request may make at most that many
+// operations on the underlying cache. Once it runs out, further reads miss and
+// writes are skipped for the rest of the request, and a warning names the
+// handler, capping the load a single pathological request can put on the cache.
+// The response cache, inference results and batch streams go through the same
+// RequestCache when it fronts their store, so the budget covers them too.
+
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// requestCacheContextKey stores the request's memoizing cache in the gin context.
+const requestCacheContextKey = "request_cache"
+
+// requestCacheEntry is a memoized read: the stored JSON and where it was read from, or a miss.
+type requestCacheEntry struct {
+	data   json.RawMessage
+	source config.CacheSource
+	found  bool
+}
+
+// RequestCache memoizes reads from a ResponseCache for the duration of one request.
+// Writes go through to the underlying cache and update the memo.
+type RequestCache struct {
+	store   ResponseCache
+	budget  int    // Operations allowed to reach store; zero means unlimited
+	handler string // Handler serving the request, reported when the budget runs out
+
+	mu      sync.Mutex
+	entries map[string]requestCacheEntry
+	ops     int  // Operations that reached store
+	warned  bool // The exhausted budget has been logged
+}
+
+// LoadRequestCacheBudget reads REQUEST_CACHE_OP_BUDGET, the cache operations allowed per request;
+// zero (the default) means unlimited.
+func LoadRequestCacheBudget() int {
+	return getEnvInt("REQUEST_CACHE_OP_BUDGET", 0)
+}
+
+// NewRequestCache creates an empty request-scoped cache in front of store.
+func NewRequestCache(store ResponseCache) *RequestCache {
+	return &RequestCache{store: store, entries: make(map[string]requestCacheEntry)}
+}
+
+// spend takes one operation from the budget, reporting false (and warning once) when it has run
+// out; the caller must hold the lock.
+func (r *RequestCache) spend() bool {
+	if r.budget <= 0 || r.ops < r.budget {
+		r.ops++
+		return true
+	}
+	if !r.warned {
+		r.warned = true
+		logger.Warn("Request exceeded its cache operation budget, bypassing the cache",
+			zap.String("handler", r.handler),
+			zap.Int("budget", r.budget),
+		)
+	}
+	return false
+}
+
+// GetCache reads key into target, going to the underlying cache only the first time the key
+// is read in this request. Errors are not memoized, so a failed read is retried next time.
+// Once the budget has run out, keys not yet read miss.
+func (r *RequestCache) GetCache(key string, target interface{}) (bool, error) {
+	_, found, err := r.GetCacheWithSource(key, target)
+	return found, err
+}
+
+// GetCacheWithSource is GetCache, also reporting where the value was first read from when the
+// underlying cache can tell.
+func (r *RequestCache) GetCacheWithSource(key string, target interface{}) (config.CacheSource, bool, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	allowed := ok || r.spend()
+	r.mu.Unlock()
+	if !allowed {
+		return "", false, nil
+	}
+	if !ok {
+		var data json.RawMessage
+		var source config.CacheSource
+		var found bool
+		var err error
+		if store, ok := r.store.(sourceReportingCache); ok {
+			source, found, err = store.GetCacheWithSource(key, &data)
+		} else {
+			found, err = r.store.GetCache(key, &data)
+		}
+		if err != nil {
+			return "", false, err
+		}
+		entry = requestCacheEntry{data: data, source: source, found: found}
+		r.mu.Lock()
+		r.entries[key] = entry
+		r.mu.Unlock()
+	}
+	if !entry.found {
+		return "", false, nil
+	}
+	return entry.source, true, json.Unmarshal(entry.data, target)
+}
+
+// SetCache stores value in the underlying cache and remembers it for the rest of the request.
+// Once the budget has run out the write is skipped and the key forgotten.
+func (r *RequestCache) SetCache(key string, value interface{}, expiration time.Duration) error {
+	r.mu.Lock()
+	if !r.spend() {
+		delete(r.entries, key)
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	err := r.store.SetCache(key, value, expiration)
+	data, marshalErr := json.Marshal(value)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil || marshalErr != nil {
+		delete(r.entries, key)
+		return err
+	}
+	r.entries[key] = requestCacheEntry{data: data, found: true}
+	return nil
+}
+
+// clear drops every memoized read.
+func (r *RequestCache) clear() {
+	r.mu.Lock()
+	r.entries = make(map[string]requestCacheEntry)
+	r.mu.Unlock()
+}
+
+// RequestCacheMiddleware gives each request its own RequestCache in front of store, allowing it
+// budget operations on store (zero means unlimited).
+func RequestCacheMiddleware(store ResponseCache, budget int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if store == nil {
+			c.Next()
+			return
+		}
+		scoped := NewRequestCache(store)
+		scoped.budget = budget
+		scoped.handler = c.HandlerName()
+		c.Set(requestCacheContextKey, scoped)
+		defer scoped.clear()
+		c.Next()
+	}
+}
+
+// requestScopedStore returns the request's RequestCache when RequestCacheMiddleware put it in
+// front of store, so reads and writes to store share its memo and count against its budget,
+// and store itself otherwise.
+func requestScopedStore(c *gin.Context, store ResponseCache) ResponseCache {
+	if value, ok := c.Get(requestCacheContextKey); ok {
+		if scoped, ok := value.(*RequestCache); ok && scoped.store == store {
+			return scoped
+		}
+	}
+	return store
+}
+
+// RequestCacheFor returns the request's memoizing cache, or the application cache (nil if there
+// is none) when RequestCacheMiddleware isn't installed.
+func RequestCacheFor(c *gin.Context) ResponseCache {
+	if value, ok := c.Get(requestCacheContextKey); ok {
+		if scoped, ok := value.(*RequestCache); ok {
+			return scoped
+		}
+	}
+	return appCache
+}
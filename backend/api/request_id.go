@@ -0,0 +1,62 @@
+// request_id.go
+// Request ID propagation. The caller's X-Request-ID is echoed back on the
+// response (or a new ID generated) so logs can be correlated across services.
+// Client-supplied values are sanitized before being written into a response
+// header: control characters such as CR/LF are stripped and the length is
+// bounded, preventing response splitting and oversized headers.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// requestIDKey is the gin context key holding the request's ID.
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds a client-supplied request ID; longer values are truncated.
+const maxRequestIDLength = 128
+
+// sanitizeHeaderValue makes a client-supplied value safe to echo in a response header by
+// keeping only printable ASCII (dropping CR, LF and other control characters) and
+// truncating it to maxLength bytes.
+func sanitizeHeaderValue(value string, maxLength int) string {
+	sanitized := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(sanitized) < maxLength; i++ {
+		if ch := value[i]; ch >= ' ' && ch <= '~' {
+			sanitized = append(sanitized, ch)
+		}
+	}
+	return string(sanitized)
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// RequestIDMiddleware echoes the sanitized X-Request-ID of each request on its response,
+// generating an ID when the client sent none (or nothing usable).
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := sanitizeHeaderValue(c.GetHeader(apitypes.RequestIDHeader), maxRequestIDLength)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(apitypes.RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// RequestID returns the ID of the request being handled, or "" outside RequestIDMiddleware.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,489 @@
+// response_cache.go
+// Response caching middleware and cache-aside helpers for API handlers.
+// Cached entries record when they were stored so responses can report their
+// cache status (X-Cache) and a standard Age header to clients and edge caches.
+//
+// StoredAt comes from the clock of whichever pod wrote the entry, so ages assume
+// pod clocks are roughly in sync (NTP). To stay sane under skew, ages are clamped
+// to zero, timestamps from the future are treated as "now", and skew beyond
+// maxClockSkew is logged so it can be fixed at the source.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// Cache status values reported in the cache status header.
+const (
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheStale  = "STALE"
+	CacheBypass = "BYPASS"
+)
+
+// CacheSourceHeader flags responses served from the in-process fallback cache during a
+// Memcached outage, which may be less fresh than usual.
+const CacheSourceHeader = "X-Cache-Source"
+
+// cacheFallbackResponsesTotal counts responses served from the fallback cache, showing how
+// often the API runs in degraded mode.
+var cacheFallbackResponsesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "cache_fallback_responses_total",
+		Help: "Total number of responses served from the in-memory fallback cache during a Memcached outage.",
+	},
+)
+
+// ResponseCache is the storage backend used for response caching (satisfied by config.MemcachedConfig).
+type ResponseCache interface {
+	GetCache(key string, target interface{}) (bool, error)
+	SetCache(key string, value interface{}, expiration time.Duration) error
+}
+
+// sourceReportingCache is implemented by stores that report where a value was read from
+// (satisfied by config.MemcachedConfig).
+type sourceReportingCache interface {
+	GetCacheWithSource(key string, target interface{}) (config.CacheSource, bool, error)
+}
+
+// ResponseCacheConfig holds the settings for response caching.
+type ResponseCacheConfig struct {
+	TTL               time.Duration // Time a cached response is served as fresh
+	StaleTTL          time.Duration // Extra time a stale response may be served when the handler fails
+	StatusHeader      string        // Response header carrying the cache status
+	ExcludePaths      []string      // Path patterns (path.Match syntax, e.g. "/api/users/*") never cached
+	CacheableStatuses []int         // Response status codes that may be cached; empty means only 200
+}
+
+// DefaultResponseCacheConfig provides default values for response caching.
+func DefaultResponseCacheConfig() ResponseCacheConfig {
+	return ResponseCacheConfig{
+		TTL:               30 * time.Second,
+		StaleTTL:          5 * time.Minute,
+		StatusHeader:      "X-Cache",
+		CacheableStatuses: []int{http.StatusOK},
+	}
+}
+
+// LoadResponseCacheConfig loads response cache configuration from environment variables or defaults.
+func LoadResponseCacheConfig() ResponseCacheConfig {
+	config := DefaultResponseCacheConfig()
+
+	if ttlEnv := os.Getenv("RESPONSE_CACHE_TTL_SECONDS"); ttlEnv != "" {
+		if val, err := strconv.Atoi(ttlEnv); err == nil && val > 0 {
+			config.TTL = time.Duration(val) * time.Second
+		}
+	}
+	if staleEnv := os.Getenv("RESPONSE_CACHE_STALE_SECONDS"); staleEnv != "" {
+		if val, err := strconv.Atoi(staleEnv); err == nil && val >= 0 {
+			config.StaleTTL = time.Duration(val) * time.Second
+		}
+	}
+	if header := os.Getenv("CACHE_STATUS_HEADER"); header != "" {
+		config.StatusHeader = header
+	}
+	if pathsEnv := os.Getenv("RESPONSE_CACHE_EXCLUDE_PATHS"); pathsEnv != "" {
+		config.ExcludePaths = splitList(pathsEnv)
+	}
+	if statusesEnv := os.Getenv("RESPONSE_CACHE_STATUSES"); statusesEnv != "" {
+		var statuses []int
+		for _, entry := range splitList(statusesEnv) {
+			status, err := strconv.Atoi(entry)
+			if err != nil || status < 100 || status > 599 {
+				logger.Warn("Invalid entry in RESPONSE_CACHE_STATUSES, ignoring", zap.String("entry", entry))
+				continue
+			}
+			statuses = append(statuses, status)
+		}
+		config.CacheableStatuses = statuses
+	}
+
+	return config
+}
+
+// excluded reports whether responses for urlPath must never be cached.
+func (c ResponseCacheConfig) excluded(urlPath string) bool {
+	for _, pattern := range c.ExcludePaths {
+		if matched, _ := path.Match(pattern, urlPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableStatus reports whether responses with status may be cached.
+func (c ResponseCacheConfig) cacheableStatus(status int) bool {
+	if len(c.CacheableStatuses) == 0 {
+		return status == http.StatusOK
+	}
+	for _, cacheable := range c.CacheableStatuses {
+		if status == cacheable {
+			return true
+		}
+	}
+	return false
+}
+
+// personalized reports whether a response depends on who made the request: it sets a
+// cookie or varies on credentials. Caching it could serve one user's response to another.
+func personalized(header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return true
+	}
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			switch http.CanonicalHeaderKey(strings.TrimSpace(field)) {
+			case "Authorization", "Cookie", "*":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cachedResponse is the stored form of a cached HTTP response.
+type cachedResponse struct {
+	Status   int                 `json:"status"`
+	Header   map[string][]string `json:"header"`
+	Body     []byte              `json:"body"`
+	StoredAt time.Time           `json:"stored_at"`
+}
+
+// cachedValue wraps a cache-aside value with the time it was stored.
+type cachedValue struct {
+	Data     json.RawMessage `json:"data"`
+	StoredAt time.Time       `json:"stored_at"`
+}
+
+// ResponseCacher serves cached responses and cache-aside values for API handlers.
+type ResponseCacher struct {
+	Store  ResponseCache
+	Config ResponseCacheConfig
+}
+
+// NewResponseCacher initializes a response cacher; a nil store disables caching.
+func NewResponseCacher(store ResponseCache, config ResponseCacheConfig) *ResponseCacher {
+	return &ResponseCacher{
+		Store:  store,
+		Config: config,
+	}
+}
+
+// maxClockSkew is the clock difference between pods tolerated before a warning is logged.
+const maxClockSkew = 5 * time.Second
+
+// entryAge returns the age of a cached entry, never negative; a future storedAt is treated as now.
+func entryAge(storedAt, now time.Time) time.Duration {
+	age := now.Sub(storedAt)
+	if age < -maxClockSkew {
+		logger.Warn("Cached entry timestamp is in the future, pod clocks may be skewed",
+			zap.Time("stored_at", storedAt),
+			zap.Duration("skew", -age),
+		)
+	}
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// cacheAge returns the age of a cached entry in whole seconds, as reported in the Age header.
+func cacheAge(storedAt, now time.Time) int64 {
+	return int64(entryAge(storedAt, now) / time.Second)
+}
+
+// store returns the cache to use for the request (see requestScopedStore).
+func (rc *ResponseCacher) store(c *gin.Context) ResponseCache {
+	return requestScopedStore(c, rc.Store)
+}
+
+// get reads key from the request's store, reporting the source when the store can tell.
+func (rc *ResponseCacher) get(c *gin.Context, key string, target interface{}) (config.CacheSource, bool, error) {
+	store := rc.store(c)
+	if store, ok := store.(sourceReportingCache); ok {
+		return store.GetCacheWithSource(key, target)
+	}
+	found, err := store.GetCache(key, target)
+	return "", found, err
+}
+
+// setSource flags a response served from the fallback cache.
+func (rc *ResponseCacher) setSource(c *gin.Context, source config.CacheSource) {
+	if source == config.SourceFallback {
+		c.Header(CacheSourceHeader, string(source))
+		cacheFallbackResponsesTotal.Inc()
+	}
+}
+
+// setStatus sets the cache status header and, for entries served from cache, the Age header.
+func (rc *ResponseCacher) setStatus(c *gin.Context, status string, storedAt time.Time) {
+	c.Header(rc.Config.StatusHeader, status)
+	if status == CacheHit || status == CacheStale {
+		c.Header("Age", strconv.FormatInt(cacheAge(storedAt, time.Now()), 10))
+	}
+}
+
+// responseCacheKey builds the cache key for a request from its path and query.
+func responseCacheKey(r *http.Request) string {
+	return "api:response:" + r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// responseRecorder captures the response written by downstream handlers.
+// When passthrough is false the response is only buffered, so a stale entry
+// can still be served if the handler fails. Hijack, Flush and CloseNotify are
+// delegated to the underlying writer so upgrades and streaming still work.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	status      int
+	passthrough bool
+	hijacked    bool // The handler took over the connection (e.g. a WebSocket upgrade)
+	streamed    bool // The handler flushed, so the response went out as it was written
+}
+
+var (
+	_ http.Hijacker      = (*responseRecorder)(nil)
+	_ http.Flusher       = (*responseRecorder)(nil)
+	_ http.CloseNotifier = (*responseRecorder)(nil)
+)
+
+// Hijack hands the connection to the handler; nothing buffered is sent afterwards.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Flush sends anything buffered so far and switches to passthrough, since a handler
+// that flushes is streaming its response.
+func (w *responseRecorder) Flush() {
+	if !w.passthrough {
+		w.passthrough = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+	w.streamed = true
+	w.ResponseWriter.Flush()
+}
+
+// detached reports whether the response left the recorder's control, in which case
+// it must not be rewritten or cached.
+func (w *responseRecorder) detached() bool {
+	return w.hijacked || w.streamed
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	w.status = code
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *responseRecorder) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return len(data), nil
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responseRecorder) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responseRecorder) Written() bool {
+	if w.passthrough {
+		return w.ResponseWriter.Written()
+	}
+	return w.status != 0 || w.body.Len() > 0
+}
+
+// writeCachedResponse replays a cached response to the client.
+func writeCachedResponse(c *gin.Context, entry *cachedResponse) {
+	for name, values := range entry.Header {
+		c.Writer.Header()[name] = values
+	}
+	c.Writer.WriteHeader(entry.Status)
+	c.Writer.Write(entry.Body)
+}
+
+// Middleware caches successful GET responses and serves them with X-Cache and Age headers.
+// A stale entry is served only when the handler fails while revalidating it. Excluded paths
+// bypass the cache, and responses with an uncacheable status or personalized headers are
+// served but not stored.
+func (rc *ResponseCacher) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rc.Store == nil || c.Request.Method != http.MethodGet || c.GetHeader("Cache-Control") == "no-cache" || rc.Config.excluded(c.Request.URL.Path) {
+			rc.setStatus(c, CacheBypass, time.Time{})
+			c.Next()
+			return
+		}
+
+		key := tenantScopedKey(c, baggageScopedKey(responseCacheKey(c.Request), Baggage(c)))
+		var entry cachedResponse
+		stop := StartTiming(c, "cache")
+		source, found, err := rc.get(c, key, &entry)
+		stop()
+		if err != nil {
+			logger.Warn("Failed to read cached response", zap.String("key", key), zap.Error(err))
+			found = false
+		}
+
+		age := entryAge(entry.StoredAt, time.Now())
+		if found && age <= rc.Config.TTL {
+			rc.setStatus(c, CacheHit, entry.StoredAt)
+			rc.setSource(c, source)
+			writeCachedResponse(c, &entry)
+			c.Abort()
+			return
+		}
+
+		// Buffer the handler's response while revalidating a stale entry
+		stale := found && age <= rc.Config.TTL+rc.Config.StaleTTL
+		recorder := &responseRecorder{ResponseWriter: c.Writer, passthrough: !stale}
+		c.Writer = recorder
+		if !stale {
+			rc.setStatus(c, CacheMiss, time.Time{})
+		}
+		// Headers set before the handler runs (request ID, rate limit budget) belong to this request
+		preset := make(map[string]bool, len(c.Writer.Header()))
+		for name := range c.Writer.Header() {
+			preset[name] = true
+		}
+
+		c.Next()
+
+		c.Writer = recorder.ResponseWriter
+		if recorder.detached() {
+			return
+		}
+		status := recorder.Status()
+		if stale {
+			if status >= http.StatusInternalServerError {
+				rc.setStatus(c, CacheStale, entry.StoredAt)
+				rc.setSource(c, source)
+				writeCachedResponse(c, &entry)
+				return
+			}
+			rc.setStatus(c, CacheMiss, time.Time{})
+			c.Writer.WriteHeader(status)
+			c.Writer.Write(recorder.body.Bytes())
+		}
+
+		if !rc.Config.cacheableStatus(status) || personalized(c.Writer.Header()) {
+			return
+		}
+
+		header := make(map[string][]string)
+		for name, values := range c.Writer.Header() {
+			// Cache-Control is per request (see CacheControlMiddleware), not per response
+			if preset[name] || name == rc.Config.StatusHeader || name == "Age" || name == "Cache-Control" {
+				continue
+			}
+			header[name] = values
+		}
+		// Store a precomputed ETag so cache hits don't rehash the body
+		if _, ok := header["Etag"]; !ok {
+			etag := computeETag(recorder.body.Bytes())
+			header["Etag"] = []string{etag}
+			c.Header("ETag", etag)
+		}
+		fresh := cachedResponse{
+			Status:   status,
+			Header:   header,
+			Body:     recorder.body.Bytes(),
+			StoredAt: time.Now(),
+		}
+		if err := rc.store(c).SetCache(key, fresh, rc.Config.TTL+rc.Config.StaleTTL); err != nil {
+			logger.Warn("Failed to cache response", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// CacheAside loads target from the cache, or from load on a miss, and reports the outcome
+// through the cache status and Age headers. load may return a config.LoadedValue to store
+// the entry with the origin's TTL (or not at all) instead of ttl.
+func (rc *ResponseCacher) CacheAside(c *gin.Context, key string, ttl time.Duration, target interface{}, load func() (interface{}, error)) error {
+	if rc.Store != nil {
+		var entry cachedValue
+		stop := StartTiming(c, "cache")
+		source, found, err := rc.get(c, key, &entry)
+		stop()
+		if err != nil {
+			logger.Warn("Failed to read cached value", zap.String("key", key), zap.Error(err))
+		}
+		if found && err == nil {
+			if err := json.Unmarshal(entry.Data, target); err == nil {
+				rc.setStatus(c, CacheHit, entry.StoredAt)
+				rc.setSource(c, source)
+				return nil
+			}
+		}
+	}
+
+	value, err := load()
+	if err != nil {
+		return err
+	}
+	if ttl == 0 {
+		ttl = rc.Config.TTL
+	}
+	value, ttl, store := config.UnwrapLoaded(value, ttl)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if rc.Store == nil {
+		rc.setStatus(c, CacheBypass, time.Time{})
+		return json.Unmarshal(data, target)
+	}
+
+	if !store {
+		rc.setStatus(c, CacheBypass, time.Time{})
+		return json.Unmarshal(data, target)
+	}
+	stop := StartTiming(c, "cache_write")
+	if err := rc.store(c).SetCache(key, cachedValue{Data: data, StoredAt: time.Now()}, ttl); err != nil {
+		logger.Warn("Failed to cache value", zap.String("key", key), zap.Error(err))
+	}
+	stop()
+	rc.setStatus(c, CacheMiss, time.Time{})
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,218 @@
+// selftest.go
+// Startup self-test. With --selftest or SELFTEST=true the server checks its
+// configuration, metrics registration, the cache (a write and read-back, as
+// the cache probe does) and the reachability of the inference backends, then
+// prints a JSON report and exits without serving: 0 when every check passed,
+// otherwise the exit code of the first failure. Deploy pipelines and container
+// smoke tests use it as a gate.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// selfTestFlag is the command-line flag selecting the self-test.
+const selfTestFlag = "--selftest"
+
+// selfTestBackendTimeout bounds each inference backend reachability check.
+const selfTestBackendTimeout = 5 * time.Second
+
+// Self-test check outcomes.
+const (
+	SelfTestPass = "pass"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip"
+)
+
+// errSelfTestSkipped is returned by a check that does not apply to this configuration.
+var errSelfTestSkipped = errors.New("not configured")
+
+// SelfTestRequested reports whether the self-test was asked for on the command line or by SELFTEST.
+func SelfTestRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == selfTestFlag {
+			return true
+		}
+	}
+	return getEnvBool("SELFTEST", false)
+}
+
+// SelfTestCheck is one check run by the self-test.
+type SelfTestCheck struct {
+	Name     string
+	ExitCode int // Exit code when the check fails
+	Run      func(ctx context.Context) error
+}
+
+// SelfTestResult is the outcome of one check.
+type SelfTestResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // "pass", "fail" or "skip"
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// SelfTestReport is the report printed by the self-test.
+type SelfTestReport struct {
+	Passed bool             `json:"passed"`
+	Checks []SelfTestResult `json:"checks"`
+}
+
+// SelfTest runs the self-test against the configured dependencies, writing its report to out.
+// It returns nil when every check passed, and otherwise an *ExitError for the first failure.
+func SelfTest(ctx context.Context, out io.Writer) error {
+	var store ResponseCache
+	var cacheErr error
+	if os.Getenv("MEMCACHED_SERVERS") != "" {
+		startupCtx, cancel := context.WithTimeout(ctx, LoadServerConfig().StartupTimeout)
+		mc, err := config.InitMemcachedContext(startupCtx)
+		cancel()
+		if err != nil {
+			cacheErr = fmt.Errorf("memcached: %w", err)
+		} else {
+			mc.Logger = logger.Named("cache")
+			defer mc.Close()
+			store = mc
+		}
+	}
+	return runSelfTest(ctx, selfTestChecks(store, cacheErr, LoadInferenceConfig(), LoadModelRoutes()), out)
+}
+
+// selfTestChecks returns the self-test's checks. cacheErr is the error connecting to the cache,
+// if any; with neither a store nor an error the cache check is skipped.
+func selfTestChecks(store ResponseCache, cacheErr error, inference InferenceConfig, routes map[string][]ModelVariant) []SelfTestCheck {
+	return []SelfTestCheck{
+		{Name: "config", ExitCode: ExitConfig, Run: func(ctx context.Context) error {
+			return validateConfig(inference, routes)
+		}},
+		{Name: "metrics", ExitCode: ExitConfig, Run: func(ctx context.Context) error {
+			// A fresh registry, so the default one is left for the server
+			registry := prometheus.NewRegistry()
+			if err := RegisterMetrics(registry); err != nil {
+				return err
+			}
+			_, err := registry.Gather()
+			return err
+		}},
+		{Name: "cache", ExitCode: ExitDependency, Run: func(ctx context.Context) error {
+			if cacheErr != nil {
+				return cacheErr
+			}
+			if store == nil {
+				return errSelfTestSkipped
+			}
+			return NewCacheProbe(store, LoadCacheProbeConfig()).check()
+		}},
+		{Name: "inference_backend", ExitCode: ExitDependency, Run: func(ctx context.Context) error {
+			for _, backendURL := range inferenceBackendURLs(inference, routes) {
+				if err := checkReachable(ctx, backendURL); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+}
+
+// runSelfTest runs checks in order, writes the report to out and returns the outcome.
+func runSelfTest(ctx context.Context, checks []SelfTestCheck, out io.Writer) error {
+	report := SelfTestReport{Passed: true, Checks: make([]SelfTestResult, 0, len(checks))}
+	var failed error
+	for _, check := range checks {
+		start := time.Now()
+		err := check.Run(ctx)
+		result := SelfTestResult{Name: check.Name, Status: SelfTestPass, DurationMs: float64(time.Since(start)) / float64(time.Millisecond)}
+		switch {
+		case errors.Is(err, errSelfTestSkipped):
+			result.Status = SelfTestSkip
+			result.Error = err.Error()
+		case err != nil:
+			result.Status = SelfTestFail
+			result.Error = err.Error()
+			report.Passed = false
+			logger.Error("Self-test check failed", zap.String("check", check.Name), zap.Error(err))
+			if failed == nil {
+				failed = exitError(check.ExitCode, ReasonSelfTest, fmt.Errorf("%s check: %w", check.Name, err))
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil && failed == nil {
+		failed = exitError(ExitFailure, ReasonSelfTest, fmt.Errorf("write self-test report: %w", err))
+	}
+	return failed
+}
+
+// validateConfig checks settings whose loaders fall back to defaults instead of failing.
+func validateConfig(inference InferenceConfig, routes map[string][]ModelVariant) error {
+	if getEnvBool("CACHE_REQUIRED", false) && os.Getenv("MEMCACHED_SERVERS") == "" {
+		return errors.New("CACHE_REQUIRED is set but MEMCACHED_SERVERS is empty")
+	}
+	if os.Getenv("INFERENCE_MODEL_ROUTES") != "" && routes == nil {
+		return errors.New("INFERENCE_MODEL_ROUTES is not valid JSON")
+	}
+	if _, err := LoadBaggageConfig(); err != nil {
+		return err
+	}
+	for _, backendURL := range inferenceBackendURLs(inference, routes) {
+		parsed, err := url.Parse(backendURL)
+		if err != nil {
+			return fmt.Errorf("invalid inference backend URL %q: %w", backendURL, err)
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid inference backend URL %q: expected an absolute http(s) URL", backendURL)
+		}
+	}
+	return nil
+}
+
+// inferenceBackendURLs returns the default backend and every routed variant's backend, sorted.
+func inferenceBackendURLs(inference InferenceConfig, routes map[string][]ModelVariant) []string {
+	seen := map[string]bool{inference.BackendURL: true}
+	for _, variants := range routes {
+		for _, variant := range variants {
+			seen[variant.BackendURL] = true
+		}
+	}
+	urls := make([]string, 0, len(seen))
+	for backendURL := range seen {
+		urls = append(urls, backendURL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// checkReachable reports whether backendURL answers HTTP requests; any response, whatever its
+// status, counts as reachable.
+func checkReachable(ctx context.Context, backendURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestBackendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, backendURL, nil)
+	if err != nil {
+		return fmt.Errorf("inference backend %s: %w", backendURL, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("inference backend %s unreachable: %w", backendURL, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,126 @@
+// server_timing.go
+// Server-Timing support. Handlers and helpers record named timing spans on the
+// request context, and ServerTimingMiddleware emits them in the Server-Timing
+// response header so backend timing shows up in browser dev tools.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverTimingKey is the gin context key holding the request's timing spans.
+const serverTimingKey = "server_timing"
+
+// timingSpan is one named duration reported in the Server-Timing header.
+type timingSpan struct {
+	name     string
+	duration time.Duration
+}
+
+// serverTimings collects the spans recorded while handling a request.
+type serverTimings struct {
+	mu    sync.Mutex
+	spans []timingSpan
+}
+
+// header formats the spans as a Server-Timing header value, e.g. "cache;dur=1.2, inference;dur=85.0".
+func (t *serverTimings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.spans))
+	for i, span := range t.spans {
+		parts[i] = fmt.Sprintf("%s;dur=%.1f", span.name, float64(span.duration)/float64(time.Millisecond))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RecordTiming records a named span on the request; it is a no-op without ServerTimingMiddleware.
+// Spans recorded after the response headers are written are not reported.
+func RecordTiming(c *gin.Context, name string, duration time.Duration) {
+	value, ok := c.Get(serverTimingKey)
+	if !ok {
+		return
+	}
+	timings := value.(*serverTimings)
+	timings.mu.Lock()
+	timings.spans = append(timings.spans, timingSpan{name: name, duration: duration})
+	timings.mu.Unlock()
+}
+
+// StartTiming starts a named span and returns a function that records it when called.
+func StartTiming(c *gin.Context, name string) func() {
+	start := time.Now()
+	return func() {
+		RecordTiming(c, name, time.Since(start))
+	}
+}
+
+// serverTimingWriter adds the Server-Timing header just before the response headers are sent.
+type serverTimingWriter struct {
+	gin.ResponseWriter
+	timings *serverTimings
+	written bool
+}
+
+func (w *serverTimingWriter) setHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	if value := w.timings.header(); value != "" {
+		w.ResponseWriter.Header().Set("Server-Timing", value)
+	}
+}
+
+func (w *serverTimingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *serverTimingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *serverTimingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush sends the header with a streamed response's first flush.
+func (w *serverTimingWriter) Flush() {
+	w.setHeader()
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hands the connection to the handler; headers can no longer be added after it.
+func (w *serverTimingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.written = true
+	return w.ResponseWriter.Hijack()
+}
+
+// ServerTimingMiddleware emits the spans recorded during a request in the Server-Timing header.
+func ServerTimingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings := &serverTimings{}
+		c.Set(serverTimingKey, timings)
+		writer := &serverTimingWriter{ResponseWriter: c.Writer, timings: timings}
+		c.Writer = writer
+
+		c.Next()
+
+		// Responses without a body (e.g. 304, 204) never called Write
+		if !writer.written && !writer.ResponseWriter.Written() {
+			writer.setHeader()
+		}
+		c.Writer = writer.ResponseWriter
+	}
+}
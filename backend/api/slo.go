@@ -0,0 +1,227 @@
+// slo.go
+// Self-reported SLO status. GET /api/slo computes the recent error rate and p99
+// latency from the in-process Prometheus metrics and compares them to the
+// configured targets, so quick checks don't need a round-trip to Prometheus.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"your_project/apitypes" // Replace with your actual package path for the shared API types
+)
+
+// SLO verdict statuses.
+const (
+	SLOStatusOK        = "ok"
+	SLOStatusBreaching = "breaching"
+)
+
+// SLOConfig holds the service level objective targets.
+type SLOConfig struct {
+	ErrorRateTarget float64       // Maximum acceptable fraction of 5xx responses
+	LatencyTarget   time.Duration // Maximum acceptable p99 request latency
+	Window          time.Duration // Period over which the SLO is evaluated
+}
+
+// DefaultSLOConfig provides default SLO targets.
+func DefaultSLOConfig() SLOConfig {
+	return SLOConfig{
+		ErrorRateTarget: 0.01,
+		LatencyTarget:   500 * time.Millisecond,
+		Window:          5 * time.Minute,
+	}
+}
+
+// LoadSLOConfig loads SLO targets from environment variables or defaults.
+func LoadSLOConfig() SLOConfig {
+	config := DefaultSLOConfig()
+	if rateEnv := os.Getenv("SLO_ERROR_RATE_TARGET"); rateEnv != "" {
+		if rate, err := strconv.ParseFloat(rateEnv, 64); err == nil && rate > 0 && rate < 1 {
+			config.ErrorRateTarget = rate
+		} else {
+			logger.Warn("Invalid SLO_ERROR_RATE_TARGET, using default", zap.String("value", rateEnv))
+		}
+	}
+	config.LatencyTarget = getEnvDuration("SLO_P99_LATENCY_TARGET", config.LatencyTarget)
+	config.Window = getEnvDuration("SLO_WINDOW", config.Window)
+	return config
+}
+
+// SLOVerdict is the response of GET /api/slo.
+type SLOVerdict struct {
+	Status                  string  `json:"status"`
+	Window                  string  `json:"window"`
+	Requests                float64 `json:"requests"`
+	ErrorRate               float64 `json:"error_rate"`
+	ErrorRateTarget         float64 `json:"error_rate_target"`
+	BurnRate                float64 `json:"burn_rate"` // Error rate relative to the error budget (1 = exactly on budget)
+	ErrorRateOK             bool    `json:"error_rate_ok"`
+	P99LatencySeconds       float64 `json:"p99_latency_seconds"`
+	P99LatencyTargetSeconds float64 `json:"p99_latency_target_seconds"`
+	LatencyOK               bool    `json:"latency_ok"`
+}
+
+// sloSnapshot holds cumulative request metrics at a point in time.
+type sloSnapshot struct {
+	at       time.Time
+	requests float64
+	errors   float64
+	count    float64             // Observations in the latency histogram
+	buckets  map[float64]float64 // Cumulative latency observations by bucket upper bound
+}
+
+// takeSLOSnapshot sums http_requests_total and http_request_duration_seconds across all label sets.
+func takeSLOSnapshot(families []*dto.MetricFamily, at time.Time) sloSnapshot {
+	snapshot := sloSnapshot{at: at, buckets: make(map[float64]float64)}
+	for _, family := range families {
+		switch family.GetName() {
+		case "http_requests_total":
+			for _, metric := range family.GetMetric() {
+				value := metric.GetCounter().GetValue()
+				snapshot.requests += value
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "code" && strings.HasPrefix(label.GetValue(), "5") {
+						snapshot.errors += value
+					}
+				}
+			}
+		case "http_request_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				histogram := metric.GetHistogram()
+				snapshot.count += float64(histogram.GetSampleCount())
+				for _, bucket := range histogram.GetBucket() {
+					snapshot.buckets[bucket.GetUpperBound()] += float64(bucket.GetCumulativeCount())
+				}
+			}
+		}
+	}
+	return snapshot
+}
+
+// histogramQuantile estimates quantile q from cumulative bucket counts, interpolating
+// linearly within the bucket as Prometheus' histogram_quantile does.
+func histogramQuantile(q float64, buckets map[float64]float64, count float64) float64 {
+	if count <= 0 {
+		return 0
+	}
+	bounds := make([]float64, 0, len(buckets))
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * count
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, bound := range bounds {
+		cumulative := buckets[bound]
+		if cumulative >= rank {
+			if math.IsInf(bound, 1) || cumulative == lowerCount {
+				return lowerBound
+			}
+			return lowerBound + (bound-lowerBound)*(rank-lowerCount)/(cumulative-lowerCount)
+		}
+		lowerBound, lowerCount = bound, cumulative
+	}
+	// The quantile falls in the implicit +Inf bucket
+	return lowerBound
+}
+
+// SLOEvaluator compares recent request metrics to SLO targets.
+//
+// Prometheus metrics are cumulative, so the evaluator keeps the snapshots taken by
+// previous evaluations and measures against the oldest one still inside the window.
+// The first evaluation therefore reports totals since startup.
+type SLOEvaluator struct {
+	Config SLOConfig
+	Gather func() ([]*dto.MetricFamily, error)
+	now    func() time.Time
+
+	mu      sync.Mutex
+	history []sloSnapshot
+}
+
+// NewSLOEvaluator creates an evaluator reading metrics from gather (e.g. prometheus.DefaultGatherer.Gather).
+func NewSLOEvaluator(config SLOConfig, gather func() ([]*dto.MetricFamily, error)) *SLOEvaluator {
+	return &SLOEvaluator{
+		Config: config,
+		Gather: gather,
+		now:    time.Now,
+	}
+}
+
+// Evaluate computes the SLO verdict for the current window.
+func (e *SLOEvaluator) Evaluate() (SLOVerdict, error) {
+	families, err := e.Gather()
+	if err != nil {
+		return SLOVerdict{}, err
+	}
+	now := e.now()
+	current := takeSLOSnapshot(families, now)
+
+	e.mu.Lock()
+	cutoff := now.Add(-e.Config.Window)
+	kept := e.history[:0]
+	for _, snapshot := range e.history {
+		if !snapshot.at.Before(cutoff) {
+			kept = append(kept, snapshot)
+		}
+	}
+	e.history = append(kept, current)
+	baseline := sloSnapshot{buckets: map[float64]float64{}}
+	if len(e.history) > 1 {
+		baseline = e.history[0]
+	}
+	e.mu.Unlock()
+
+	requests := current.requests - baseline.requests
+	errors := current.errors - baseline.errors
+	buckets := make(map[float64]float64, len(current.buckets))
+	for bound, cumulative := range current.buckets {
+		buckets[bound] = cumulative - baseline.buckets[bound]
+	}
+	p99 := histogramQuantile(0.99, buckets, current.count-baseline.count)
+
+	verdict := SLOVerdict{
+		Window:                  e.Config.Window.String(),
+		Requests:                requests,
+		ErrorRateTarget:         e.Config.ErrorRateTarget,
+		P99LatencySeconds:       p99,
+		P99LatencyTargetSeconds: e.Config.LatencyTarget.Seconds(),
+	}
+	if requests > 0 {
+		verdict.ErrorRate = errors / requests
+	}
+	verdict.BurnRate = verdict.ErrorRate / e.Config.ErrorRateTarget
+	verdict.ErrorRateOK = verdict.ErrorRate <= e.Config.ErrorRateTarget
+	verdict.LatencyOK = p99 <= e.Config.LatencyTarget.Seconds()
+	verdict.Status = SLOStatusOK
+	if !verdict.ErrorRateOK || !verdict.LatencyOK {
+		verdict.Status = SLOStatusBreaching
+	}
+	return verdict, nil
+}
+
+// Handler serves GET /api/slo.
+func (e *SLOEvaluator) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verdict, err := e.Evaluate()
+		if err != nil {
+			logger.Error("Failed to gather metrics for SLO evaluation", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, apitypes.ErrorResponse{Error: "failed to evaluate SLO"})
+			return
+		}
+		c.JSON(http.StatusOK, verdict)
+	}
+}
@@ -0,0 +1,53 @@
+// tenant.go
+// Tenant scoping for cached data. The tenant of a request comes only from its
+// authenticated credentials (a tenant-issued API key, see api_key.go), never
+// from request parameters. Cache keys of tenant requests (inference, batch stream
+// and response cache entries) are scoped to the tenant with tenantScopedKey, and
+// other handlers reach the cache through TenantCacheFor, so one tenant is never
+// served another tenant's entries.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	"your_project/config" // Replace with your actual package path for the cache clients
+)
+
+// tenantIDContextKey stores the authenticated tenant ID in the gin context.
+const tenantIDContextKey = "tenant_id"
+
+// errNoTenantCache is returned when tenant-scoped caching is requested without a Memcached backend.
+var errNoTenantCache = errors.New("tenant cache requires a Memcached backend")
+
+// validTenantID reports whether id is usable as a tenant ID.
+func validTenantID(id string) bool {
+	return config.ValidateTenantID(id) == nil
+}
+
+// TenantID returns the authenticated tenant of the request, or "" if it has none.
+func TenantID(c *gin.Context) string {
+	return c.GetString(tenantIDContextKey)
+}
+
+// TenantCacheFor returns the response cache scoped to the request's authenticated tenant.
+// Requests without a tenant get a handle whose operations fail with config.ErrEmptyTenantID.
+func TenantCacheFor(c *gin.Context) (*config.TenantCache, error) {
+	mc, ok := appCache.(*config.MemcachedConfig)
+	if !ok || mc == nil {
+		return nil, errNoTenantCache
+	}
+	return mc.TenantCache(TenantID(c)), nil
+}
+
+// tenantScopedKey scopes a cache key to the request's tenant the way TenantCache keys are built,
+// leaving it unchanged for requests without a tenant.
+func tenantScopedKey(c *gin.Context, key string) string {
+	tenant := TenantID(c)
+	if tenant == "" {
+		return key
+	}
+	return config.BuildKey("tenant", tenant, key)
+}
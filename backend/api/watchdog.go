@@ -0,0 +1,120 @@
+// watchdog.go
+// Liveness watchdog. A background loop periodically sends a synthetic request through the
+// router and records a heartbeat when it completes; if a deadlock (e.g. in the logger or a
+// cache mutex) stalls the serving path, the heartbeat goes stale and the health endpoints
+// report unhealthy so the orchestrator restarts the pod. Heartbeat requests are marked in
+// their context and left out of access logs and request metrics.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WatchdogConfig holds the liveness watchdog settings.
+type WatchdogConfig struct {
+	Interval  time.Duration // Time between synthetic heartbeat requests
+	Threshold time.Duration // Heartbeat age after which the server reports unhealthy
+}
+
+// DefaultWatchdogConfig provides default values for the liveness watchdog.
+func DefaultWatchdogConfig() WatchdogConfig {
+	return WatchdogConfig{
+		Interval:  5 * time.Second,
+		Threshold: 30 * time.Second,
+	}
+}
+
+// LoadWatchdogConfig loads watchdog configuration from WATCHDOG_INTERVAL and WATCHDOG_THRESHOLD or defaults.
+// A threshold no longer than the interval would report unhealthy between heartbeats, so the
+// defaults are used instead.
+func LoadWatchdogConfig() WatchdogConfig {
+	config := DefaultWatchdogConfig()
+	config.Interval = getEnvDuration("WATCHDOG_INTERVAL", config.Interval)
+	config.Threshold = getEnvDuration("WATCHDOG_THRESHOLD", config.Threshold)
+	if config.Threshold <= config.Interval {
+		defaults := DefaultWatchdogConfig()
+		logger.Warn("WATCHDOG_THRESHOLD must be longer than WATCHDOG_INTERVAL, using defaults",
+			zap.Duration("interval", config.Interval),
+			zap.Duration("threshold", config.Threshold),
+			zap.Duration("default_interval", defaults.Interval),
+			zap.Duration("default_threshold", defaults.Threshold),
+		)
+		return defaults
+	}
+	return config
+}
+
+// watchdogRequestKey marks the context of the watchdog's heartbeat requests.
+type watchdogRequestKey struct{}
+
+// isWatchdogRequest reports whether r is a heartbeat request sent by Watchdog.Run. The mark is
+// carried in the request context, so clients can't set it.
+func isWatchdogRequest(r *http.Request) bool {
+	marked, _ := r.Context().Value(watchdogRequestKey{}).(bool)
+	return marked
+}
+
+// Watchdog tracks the last heartbeat from the request-serving path.
+type Watchdog struct {
+	Config   WatchdogConfig
+	lastBeat int64 // Unix nanoseconds, accessed atomically
+}
+
+// NewWatchdog creates a watchdog with a fresh heartbeat.
+func NewWatchdog(config WatchdogConfig) *Watchdog {
+	w := &Watchdog{Config: config}
+	w.Beat()
+	return w
+}
+
+// Beat records a heartbeat.
+func (w *Watchdog) Beat() {
+	atomic.StoreInt64(&w.lastBeat, time.Now().UnixNano())
+}
+
+// HeartbeatAge returns the time since the last heartbeat.
+func (w *Watchdog) HeartbeatAge() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&w.lastBeat)))
+}
+
+// Stale reports whether the heartbeat is older than the threshold; a nil watchdog is never stale.
+func (w *Watchdog) Stale() bool {
+	return w != nil && w.HeartbeatAge() > w.Config.Threshold
+}
+
+// discardResponseWriter is an http.ResponseWriter that drops the synthetic request's response.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// Run sends a HEAD /api/health request through handler every interval, recording a heartbeat
+// each time one completes, until ctx is cancelled.
+func (w *Watchdog) Run(ctx context.Context, handler http.Handler) {
+	requestCtx := context.WithValue(ctx, watchdogRequestKey{}, true)
+	ticker := time.NewTicker(w.Config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			req, err := http.NewRequest(http.MethodHead, "/api/health", nil)
+			if err != nil {
+				logger.Error("Failed to build watchdog request", zap.Error(err))
+				continue
+			}
+			handler.ServeHTTP(&discardResponseWriter{header: make(http.Header)}, req.WithContext(requestCtx))
+			w.Beat()
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cache is a cache layer storing JSON-serializable values by key. MemcachedConfig
+// satisfies it, as do the in-memory and origin layers below, so they can be composed
+// into a CacheChain.
+type Cache interface {
+	GetCache(key string, target interface{}) (bool, error)
+	SetCache(key string, value interface{}, expiration time.Duration) error
+}
+
+// ChainLayer is a named layer of a CacheChain.
+type ChainLayer struct {
+	Name  string
+	Cache Cache
+}
+
+// CacheChain reads through an ordered list of cache layers, fastest first (e.g. local
+// Memcached, in-memory, secondary region, origin). A hit in a slower layer is backfilled
+// into every faster layer, so the next read is served closer to the caller. Layer errors
+// are logged and treated as misses, so one unavailable layer degrades to the next.
+type CacheChain struct {
+	Layers      []ChainLayer
+	BackfillTTL time.Duration // Expiration used when backfilling faster layers
+}
+
+// DefaultCacheChainOrder is the layer order used when CACHE_CHAIN is not set.
+const DefaultCacheChainOrder = "memcached,memory,secondary"
+
+// GetCache walks the chain in order until a layer has key, decoding it into target and
+// backfilling the layers before it. It reports a miss if no layer has key. Keys pinned in the
+// chain's memory layer (see Pin) are served from it without walking the chain.
+func (c *CacheChain) GetCache(key string, target interface{}) (bool, error) {
+	if l1 := c.memoryLayer(); l1 != nil {
+		if found, err := l1.getPinned(key, target); err == nil && found {
+			return true, nil
+		}
+	}
+	for i, layer := range c.Layers {
+		found, err := layer.Cache.GetCache(key, target)
+		if err != nil {
+			log.Printf("Cache chain layer %s failed to get key %s, trying next layer: %v", layer.Name, key, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		for _, faster := range c.Layers[:i] {
+			if err := faster.Cache.SetCache(key, target, c.BackfillTTL); err != nil {
+				log.Printf("Cache chain failed to backfill key %s into layer %s: %v", key, faster.Name, err)
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// SetCache writes value to every layer, returning the first error after trying them all.
+func (c *CacheChain) SetCache(key string, value interface{}, expiration time.Duration) error {
+	var firstErr error
+	for _, layer := range c.Layers {
+		if err := layer.Cache.SetCache(key, value, expiration); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("layer %s: %w", layer.Name, err)
+		}
+	}
+	return firstErr
+}
+
+// BuildCacheChain composes the named layers in the comma-separated order, e.g.
+// "memcached,memory,secondary". Names with no layer available (nil) are skipped, so the
+// same order works whether or not, say, a secondary region is configured. Unknown names
+// are an error.
+func BuildCacheChain(order string, layers map[string]Cache, backfillTTL time.Duration) (*CacheChain, error) {
+	chain := &CacheChain{BackfillTTL: backfillTTL}
+	for _, name := range strings.Split(order, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		layer, known := layers[name]
+		if !known {
+			return nil, fmt.Errorf("unknown cache chain layer %q", name)
+		}
+		if layer == nil {
+			continue
+		}
+		chain.Layers = append(chain.Layers, ChainLayer{Name: name, Cache: layer})
+	}
+	return chain, nil
+}
+
+// defaultPinRefreshInterval is how often LoadCacheChain refreshes pinned keys unless
+// CACHE_PIN_REFRESH_SECONDS is set.
+const defaultPinRefreshInterval = 30 * time.Second
+
+// LoadCacheChain builds the read chain for mc from CACHE_CHAIN (defaulting to
+// DefaultCacheChainOrder) using the layers "memcached" (mc), "memory" (an in-memory layer
+// sized like the fallback) and "secondary" (mc.SecondaryConfig, when configured).
+// Append an OriginCache layer to read through to the source of truth.
+//
+// The comma-separated keys in CACHE_PIN_KEYS are pinned in the memory layer and refreshed
+// every CACHE_PIN_REFRESH_SECONDS until mc is closed. Failing to pin them is logged, not an
+// error, so a cold cache doesn't stop the chain from being built.
+func LoadCacheChain(mc *MemcachedConfig) (*CacheChain, error) {
+	order := os.Getenv("CACHE_CHAIN")
+	if order == "" {
+		order = DefaultCacheChainOrder
+	}
+	layers := map[string]Cache{
+		"memcached": mc,
+		"memory":    NewMemoryLayer(mc.FallbackMaxItems, mc.FallbackTTL),
+		"secondary": nil,
+	}
+	if mc.SecondaryConfig != nil {
+		layers["secondary"] = mc.SecondaryConfig
+	}
+	chain, err := BuildCacheChain(order, layers, mc.FallbackTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	var pinned []string
+	for _, key := range strings.Split(os.Getenv("CACHE_PIN_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			pinned = append(pinned, key)
+		}
+	}
+	if len(pinned) > 0 {
+		if err := chain.Pin(pinned); err != nil {
+			log.Printf("Failed to pin cache keys from CACHE_PIN_KEYS: %v", err)
+		}
+		interval := parseSecondsEnv("CACHE_PIN_REFRESH_SECONDS", defaultPinRefreshInterval, time.Second)
+		mc.goBackground(func(ctx context.Context) {
+			chain.refreshPinnedEvery(ctx, interval)
+		})
+	}
+	return chain, nil
+}
+
+// MemoryLayer is an in-process Cache layer with least-recently-used eviction.
+type MemoryLayer struct {
+	MaxPinned int // Maximum keys kept resident by CacheChain.Pin
+
+	cache  *memoryCache
+	maxTTL time.Duration
+}
+
+// NewMemoryLayer creates an in-memory layer holding at most maxItems entries, each for at most maxTTL.
+func NewMemoryLayer(maxItems int, maxTTL time.Duration) *MemoryLayer {
+	return &MemoryLayer{MaxPinned: defaultMaxPinnedKeys, cache: newMemoryCache(maxItems), maxTTL: maxTTL}
+}
+
+// GetCache decodes the value stored for key into target.
+func (m *MemoryLayer) GetCache(key string, target interface{}) (bool, error) {
+	data, found := m.cache.Get(key)
+	if !found {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getPinned decodes the value of key into target if key is pinned and has one.
+func (m *MemoryLayer) getPinned(key string, target interface{}) (bool, error) {
+	data, found := m.cache.GetPinned(key)
+	if !found {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetCache stores value for key, capping expiration at the layer's maximum TTL.
+func (m *MemoryLayer) SetCache(key string, value interface{}, expiration time.Duration) error {
+	data, err := marshalValue(key, value)
+	if err != nil {
+		return err
+	}
+	if expiration <= 0 || expiration > m.maxTTL {
+		expiration = m.maxTTL
+	}
+	m.cache.Set(key, data, expiration)
+	return nil
+}
+
+// OriginCache is a read-only Cache layer that loads values from the source of truth. It is
+// the last layer of a chain; writes to it are ignored.
+type OriginCache struct {
+	Load func(key string) (value interface{}, found bool, err error)
+}
+
+// GetCache loads key from the origin and decodes it into target.
+func (o OriginCache) GetCache(key string, target interface{}) (bool, error) {
+	value, found, err := o.Load(key)
+	if !found || err != nil {
+		return false, err
+	}
+	data, err := marshalValue(key, value)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetCache is a no-op: the origin is not written through the cache.
+func (o OriginCache) SetCache(key string, value interface{}, expiration time.Duration) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keySeparator separates the parts of a composite cache key.
+const keySeparator = ":"
+
+// errEmptyCacheKey is returned when a Keyer produces an empty key.
+var errEmptyCacheKey = errors.New("cache key is empty")
+
+// Keyer is implemented by domain types that derive their own cache key, typically with
+// BuildKey (e.g. BuildKey("user", u.ID)), so the key format lives in one place.
+type Keyer interface {
+	CacheKey() string
+}
+
+// SetCacheFor stores value under obj's cache key. The key gets the same prefixing and
+// tracking as keys passed to SetCache.
+func (mc *MemcachedConfig) SetCacheFor(obj Keyer, value interface{}, expiration time.Duration) error {
+	key := obj.CacheKey()
+	if key == "" {
+		return fmt.Errorf("failed to set %T: %w", obj, errEmptyCacheKey)
+	}
+	return mc.SetCache(key, value, expiration)
+}
+
+// GetCacheFor retrieves the value stored under obj's cache key into target.
+func (mc *MemcachedConfig) GetCacheFor(obj Keyer, target interface{}) (bool, error) {
+	key := obj.CacheKey()
+	if key == "" {
+		return false, fmt.Errorf("failed to get %T: %w", obj, errEmptyCacheKey)
+	}
+	return mc.GetCache(key, target)
+}
+
+// BuildKey joins parts into an unambiguous composite cache key (e.g. "api:users:42").
+// Each part is percent-escaped for the separator, '%', whitespace and control characters,
+// so distinct part lists never produce the same key and the result is a valid Memcached key.
+// Parts without those characters are left untouched, keeping keys human-readable and
+// identical to the plain colon-joined form.
+func BuildKey(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = escapeKeyPart(part)
+	}
+	return strings.Join(escaped, keySeparator)
+}
+
+// escapeKeyPart percent-encodes the characters that would make a key part ambiguous or invalid.
+func escapeKeyPart(part string) string {
+	if !strings.ContainsAny(part, ":%") && !containsControlOrSpace(part) {
+		return part
+	}
+	var b strings.Builder
+	for i := 0; i < len(part); i++ {
+		ch := part[i]
+		if ch == ':' || ch == '%' || ch <= ' ' || ch == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", ch)
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// containsControlOrSpace reports whether s contains whitespace or control characters.
+func containsControlOrSpace(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// defaultMaxPinnedKeys bounds the keys pinned in a MemoryLayer unless MaxPinned is changed.
+const defaultMaxPinnedKeys = 100
+
+// ErrPinLimit is returned by Pin when pinning would exceed the memory layer's MaxPinned.
+var ErrPinLimit = errors.New("pinned key limit reached")
+
+// ErrNoMemoryLayer is returned by Pin on a chain without an in-memory layer.
+var ErrNoMemoryLayer = errors.New("cache chain has no memory layer")
+
+// Pin prefetches keys into the chain's in-memory layer (L1) from its other layers and keeps them
+// resident: pinned keys are never evicted by LRU pressure and don't expire out of L1, and
+// GetCache serves them from L1 wherever it sits in the chain. Keep them fresh with
+// RefreshPinned or StartPinRefresh (LoadCacheChain does this for CACHE_PIN_KEYS).
+//
+// At most MaxPinned keys can be pinned. Keys past the limit are not pinned and Pin returns
+// ErrPinLimit; the keys before them stay pinned.
+func (c *CacheChain) Pin(keys []string) error {
+	l1, others := c.memoryTier()
+	if l1 == nil {
+		return ErrNoMemoryLayer
+	}
+	var pinned []string
+	var err error
+	for _, key := range keys {
+		if !l1.cache.Pin(key, l1.MaxPinned) {
+			err = fmt.Errorf("%w: pinning %s would exceed %d keys", ErrPinLimit, key, l1.MaxPinned)
+			break
+		}
+		pinned = append(pinned, key)
+	}
+	c.refreshKeys(l1, others, pinned)
+	return err
+}
+
+// Unpin releases keys pinned by Pin, leaving them to normal LRU eviction and expiration.
+func (c *CacheChain) Unpin(keys []string) {
+	l1, _ := c.memoryTier()
+	if l1 == nil {
+		return
+	}
+	for _, key := range keys {
+		l1.cache.Unpin(key)
+	}
+}
+
+// RefreshPinned reloads every pinned key from the chain's other layers. A key they no longer
+// have is dropped from L1 (staying pinned, so a later refresh can load it again); a key that
+// fails to load keeps its current value.
+func (c *CacheChain) RefreshPinned() {
+	l1, others := c.memoryTier()
+	if l1 == nil {
+		return
+	}
+	c.refreshKeys(l1, others, l1.cache.PinnedKeys())
+}
+
+// StartPinRefresh refreshes pinned keys every interval until ctx is done.
+func (c *CacheChain) StartPinRefresh(ctx context.Context, interval time.Duration) {
+	go c.refreshPinnedEvery(ctx, interval)
+}
+
+// refreshPinnedEvery refreshes pinned keys every interval until ctx is done.
+func (c *CacheChain) refreshPinnedEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.RefreshPinned()
+		}
+	}
+}
+
+// memoryLayer returns the chain's first in-memory layer, or nil if it has none.
+func (c *CacheChain) memoryLayer() *MemoryLayer {
+	for _, layer := range c.Layers {
+		if l1, ok := layer.Cache.(*MemoryLayer); ok {
+			return l1
+		}
+	}
+	return nil
+}
+
+// memoryTier returns the chain's first in-memory layer and its other layers, in order.
+func (c *CacheChain) memoryTier() (*MemoryLayer, []ChainLayer) {
+	for i, layer := range c.Layers {
+		if l1, ok := layer.Cache.(*MemoryLayer); ok {
+			others := append(append([]ChainLayer(nil), c.Layers[:i]...), c.Layers[i+1:]...)
+			return l1, others
+		}
+	}
+	return nil, nil
+}
+
+// refreshKeys loads keys from others into l1.
+func (c *CacheChain) refreshKeys(l1 *MemoryLayer, others []ChainLayer, keys []string) {
+	for _, key := range keys {
+		var value json.RawMessage
+		found, err := loadPinned(others, key, &value)
+		if err != nil {
+			log.Printf("Failed to refresh pinned cache key %s, keeping current value: %v", key, err)
+			continue
+		}
+		if !found {
+			l1.cache.Delete(key)
+			continue
+		}
+		if err := l1.SetCache(key, value, l1.maxTTL); err != nil {
+			log.Printf("Failed to store pinned cache key %s: %v", key, err)
+		}
+	}
+}
+
+// loadPinned reads key from the first of others that has it. Unlike GetCache, an error from a
+// layer that may hold the key is returned rather than treated as a miss, so a failing layer
+// doesn't unload pinned keys.
+func loadPinned(others []ChainLayer, key string, target interface{}) (bool, error) {
+	var firstErr error
+	for _, layer := range others {
+		found, err := layer.Cache.GetCache(key, target)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("layer %s: %w", layer.Name, err)
+			}
+			continue
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, firstErr
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker tracks consecutive backend failures. After failureThreshold failures the
+// circuit opens for cooldown, after which a trial request is allowed through (half-open);
+// a success closes the circuit again.
+type circuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	failures         int
+	openedAt         time.Time
+	now              func() time.Time
+}
+
+// newCircuitBreaker creates a closed circuit breaker.
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a request should be sent to the backend.
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < cb.failureThreshold {
+		return true
+	}
+	// Half-open: let a trial request through once the cooldown has elapsed
+	if cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		cb.openedAt = cb.now()
+		return true
+	}
+	return false
+}
+
+// IsOpen reports whether the circuit is currently open.
+func (cb *circuitBreaker) IsOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures >= cb.failureThreshold
+}
+
+// RecordSuccess closes the circuit.
+func (cb *circuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+}
+
+// RecordFailure counts a backend failure, opening the circuit once the threshold is reached.
+func (cb *circuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.failures == cb.failureThreshold {
+		cb.openedAt = cb.now()
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Memcached keys holding the feature flag set and its version.
+const (
+	featureFlagsKey        = "flags:all"
+	featureFlagsVersionKey = "flags:version"
+)
+
+// FeatureFlagCache serves feature flags from a local copy kept in sync with Memcached.
+// Writers bump a version key with every change; a background poller checks it every
+// PollInterval and refetches the flags when it changes, so flips (e.g. kill-switches)
+// propagate within seconds. LocalTTL bounds staleness if polling stops working.
+type FeatureFlagCache struct {
+	mc           *MemcachedConfig
+	PollInterval time.Duration
+	LocalTTL     time.Duration
+
+	mu       sync.RWMutex
+	flags    map[string]bool
+	version  int64
+	loadedAt time.Time
+}
+
+// NewFeatureFlagCache creates a feature flag cache and starts its version poller,
+// which stops when the Memcached client is closed.
+func NewFeatureFlagCache(mc *MemcachedConfig, pollInterval time.Duration) *FeatureFlagCache {
+	ffc := &FeatureFlagCache{
+		mc:           mc,
+		PollInterval: pollInterval,
+		LocalTTL:     30 * time.Second,
+	}
+	mc.goBackground(ffc.poll)
+	return ffc
+}
+
+// poll refreshes the local copy whenever the stored version changes.
+func (ffc *FeatureFlagCache) poll(ctx context.Context) {
+	ticker := time.NewTicker(ffc.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var version int64
+			if _, err := ffc.mc.GetCache(featureFlagsVersionKey, &version); err != nil {
+				continue
+			}
+			ffc.mu.RLock()
+			changed := version != ffc.version || ffc.loadedAt.IsZero()
+			ffc.mu.RUnlock()
+			if changed {
+				if err := ffc.refresh(); err != nil {
+					log.Printf("Failed to refresh feature flags: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// refresh reloads the flags and their version from Memcached.
+func (ffc *FeatureFlagCache) refresh() error {
+	var version int64
+	if _, err := ffc.mc.GetCache(featureFlagsVersionKey, &version); err != nil {
+		return err
+	}
+	flags := make(map[string]bool)
+	if _, err := ffc.mc.GetCache(featureFlagsKey, &flags); err != nil {
+		return err
+	}
+
+	ffc.mu.Lock()
+	ffc.flags = flags
+	ffc.version = version
+	ffc.loadedAt = time.Now()
+	ffc.mu.Unlock()
+	return nil
+}
+
+// GetFlag reports whether the named flag is enabled; unknown flags are disabled.
+func (ffc *FeatureFlagCache) GetFlag(name string) (bool, error) {
+	ffc.mu.RLock()
+	fresh := !ffc.loadedAt.IsZero() && time.Since(ffc.loadedAt) < ffc.LocalTTL
+	enabled := ffc.flags[name]
+	ffc.mu.RUnlock()
+	if fresh {
+		return enabled, nil
+	}
+
+	if err := ffc.refresh(); err != nil {
+		return false, err
+	}
+	ffc.mu.RLock()
+	defer ffc.mu.RUnlock()
+	return ffc.flags[name], nil
+}
+
+// SetFlags stores the full flag set and bumps the version so every FeatureFlagCache picks it up.
+func (ffc *FeatureFlagCache) SetFlags(flags map[string]bool) error {
+	if err := ffc.mc.SetCache(featureFlagsKey, flags, namespaceVersionTTL); err != nil {
+		return err
+	}
+	return ffc.mc.SetCache(featureFlagsVersionKey, time.Now().UnixNano(), namespaceVersionTTL)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxDedupEntries bounds the number of distinct errors tracked before the tracker is reset.
+const maxDedupEntries = 1000
+
+// dedupEntry tracks occurrences of one distinct error within the current window.
+type dedupEntry struct {
+	windowStart time.Time
+	suppressed  int
+}
+
+// dedupLogger rate-limits repetitive error logs: the first occurrence of an error is logged,
+// and further identical errors are counted and summarized once per window.
+// Errors are considered identical when both the operation and the error message match.
+type dedupLogger struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*dedupEntry
+	logf    func(format string, args ...interface{})
+	now     func() time.Time
+}
+
+// newDedupLogger creates a deduplicating logger that summarizes repeats once per window.
+func newDedupLogger(window time.Duration) *dedupLogger {
+	return &dedupLogger{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+		logf:    log.Printf,
+		now:     time.Now,
+	}
+}
+
+// Errorf logs a failed cache operation on key, suppressing identical errors within the window.
+func (d *dedupLogger) Errorf(operation string, key string, err error) {
+	id := operation + "|" + err.Error()
+
+	d.mu.Lock()
+	now := d.now()
+	entry, exists := d.entries[id]
+	if exists && now.Sub(entry.windowStart) < d.window {
+		entry.suppressed++
+		d.mu.Unlock()
+		return
+	}
+
+	suppressed := 0
+	if exists {
+		suppressed = entry.suppressed
+	}
+	if len(d.entries) >= maxDedupEntries {
+		d.entries = make(map[string]*dedupEntry)
+	}
+	d.entries[id] = &dedupEntry{windowStart: now}
+	d.mu.Unlock()
+
+	msg := fmt.Sprintf("Failed to %s for key %s: %v", operation, key, err)
+	if suppressed > 0 {
+		d.logf("%s (%d identical errors suppressed in the last %v)", msg, suppressed, d.window)
+		return
+	}
+	d.logf("%s", msg)
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// closer is implemented by clients that hold resources to release on Close.
+type closer interface {
+	Close() error
+}
+
+// goBackground runs fn in a goroutine tracked by Close. fn receives the shared background
+// context, which is cancelled at the start of Close. It returns false once Close has begun.
+func (mc *MemcachedConfig) goBackground(fn func(ctx context.Context)) bool {
+	mc.bgMu.Lock()
+	defer mc.bgMu.Unlock()
+
+	if mc.closed {
+		return false
+	}
+	if mc.bgCtx == nil {
+		mc.bgCtx, mc.bgCancel = context.WithCancel(context.Background())
+	}
+	ctx := mc.bgCtx
+	mc.bgWG.Add(1)
+	go func() {
+		defer mc.bgWG.Done()
+		fn(ctx)
+	}()
+	return true
+}
+
+// RefreshAsync reloads key in the background (e.g. refresh-ahead before expiry) and stores the result.
+// The refresh is dropped if the client is shutting down; it returns false if it was not started.
+func (mc *MemcachedConfig) RefreshAsync(key string, expiration time.Duration, load func(ctx context.Context) (interface{}, error)) bool {
+	return mc.goBackground(func(ctx context.Context) {
+		value, err := load(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			mc.logError("refresh cache", key, err)
+			return
+		}
+		if value, expiration, store := UnwrapLoaded(value, expiration); store {
+			mc.SetCache(key, value, expiration)
+		}
+	})
+}
+
+// Close stops background cache work and releases the client. Background goroutines are
+// cancelled first and waited for, so none of them touch the client after it is closed.
+func (mc *MemcachedConfig) Close() error {
+	mc.bgMu.Lock()
+	if mc.closed {
+		mc.bgMu.Unlock()
+		return nil
+	}
+	mc.closed = true
+	if mc.bgCancel != nil {
+		mc.bgCancel()
+	}
+	mc.bgMu.Unlock()
+
+	mc.bgWG.Wait()
+
+	// Replication to the secondary has stopped, so it can be closed too
+	if mc.SecondaryConfig != nil {
+		mc.SecondaryConfig.Close()
+	}
+
+	if c, ok := mc.Client.(closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("Failed to close Memcached client: %v", err)
+			return err
+		}
+	}
+	log.Println("Memcached client closed")
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// BlockchainEncoding selects how blockchain data (blocks, receipts) is serialized in the cache.
+type BlockchainEncoding string
+
+const (
+	// EncodingJSON stores plain JSON, readable by every cache client.
+	EncodingJSON BlockchainEncoding = "json"
+	// EncodingGob stores Go gob, which is smaller and faster to decode for large structs.
+	EncodingGob BlockchainEncoding = "gob"
+	// EncodingProtobuf stores protobuf; values and targets must implement proto.Message.
+	EncodingProtobuf BlockchainEncoding = "protobuf"
+)
+
+// Format tags that prefixed binary entries before their encoding was recorded in item flags.
+// Flag-less entries starting with one are still decoded accordingly: valid JSON never starts
+// with these bytes, so plain JSON entries are unaffected.
+const (
+	formatTagGob      byte = 0x01
+	formatTagProtobuf byte = 0x02
+)
+
+// ParseBlockchainEncoding parses a MEMCACHED_BLOCKCHAIN_ENCODING value.
+func ParseBlockchainEncoding(value string) (BlockchainEncoding, error) {
+	switch encoding := BlockchainEncoding(value); encoding {
+	case EncodingJSON, EncodingGob, EncodingProtobuf:
+		return encoding, nil
+	}
+	return "", fmt.Errorf("unknown blockchain encoding %q, expected json, gob or protobuf", value)
+}
+
+// encodeBlockchainValue serializes value with encoding, returning the item flags that record it.
+func encodeBlockchainValue(encoding BlockchainEncoding, key string, value interface{}) ([]byte, uint32, error) {
+	switch encoding {
+	case EncodingGob:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+			return nil, 0, fmt.Errorf("failed to gob-encode value of type %T for key %s: %w", value, key, err)
+		}
+		return buf.Bytes(), flagGob, nil
+	case EncodingProtobuf:
+		message, ok := value.(proto.Message)
+		if !ok {
+			return nil, 0, fmt.Errorf("cannot protobuf-encode value of type %T for key %s: not a proto.Message", value, key)
+		}
+		data, err := proto.Marshal(message)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to protobuf-encode value of type %T for key %s: %w", value, key, err)
+		}
+		return data, flagProtobuf, nil
+	}
+	data, err := marshalValue(key, value)
+	return data, flagJSON, err
+}
+
+// hasLegacyFormatTag reports whether a flag-less entry starts with a binary format tag.
+func hasLegacyFormatTag(data []byte) bool {
+	return len(data) > 0 && (data[0] == formatTagGob || data[0] == formatTagProtobuf)
+}
+
+// decodeUntagged deserializes a value stored without encoding flags: JSON, or a legacy
+// entry prefixed with a format tag.
+func decodeUntagged(data []byte, target interface{}) error {
+	if !hasLegacyFormatTag(data) {
+		return json.Unmarshal(data, target)
+	}
+	if data[0] == formatTagGob {
+		return decodeValue(data[1:], flagGob, target)
+	}
+	return decodeValue(data[1:], flagProtobuf, target)
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ErrCASRetriesExhausted is returned when a compare-and-swap loop keeps losing to concurrent writers.
+var ErrCASRetriesExhausted = errors.New("compare-and-swap retries exhausted")
+
+// GetAndSet atomically replaces the value of key with newValue and reads the previous value
+// into oldTarget, reporting whether there was one. A zero ttl uses DefaultExpiry.
+//
+// The swap uses Memcached CAS (or Add when the key is absent), so two concurrent GetAndSet
+// calls never both observe the same old value; on a conflict the read is retried up to
+// CASMaxRetries times. Guarantees hold only within a single Memcached server: a key that is
+// evicted or expires between the read and the swap is treated as absent, remapping keys
+// across servers (e.g. after a server list change) loses CAS state, and plain SetCache
+// writers are not coordinated with. Not available while the fallback cache is serving. The new
+// value is packed (compressed, checksummed, encrypted) like SetCache's.
+func (mc *MemcachedConfig) GetAndSet(key string, newValue interface{}, ttl time.Duration, oldTarget interface{}) (bool, error) {
+	rawKey := key
+	key = mc.fullKey(key)
+	data, err := marshalValue(key, newValue)
+	if err != nil {
+		mc.serializeError(key, err)
+		return false, err
+	}
+	data, flags, err := mc.packValue(rawKey, data, flagJSON)
+	if err != nil {
+		mc.serializeError(key, err)
+		return false, err
+	}
+	if mc.fallbackActive() {
+		mc.recordOperation("get_and_set", key, resultError)
+		return false, ErrCircuitOpen
+	}
+
+	expirySeconds := int32(ttl.Seconds())
+	if expirySeconds == 0 {
+		expirySeconds = int32(mc.DefaultExpiry.Seconds())
+	}
+
+	for attempt := 0; attempt <= mc.CASMaxRetries; attempt++ {
+		item, err := mc.Client.Get(key)
+		if err == memcache.ErrCacheMiss {
+			// Add fails if another writer created the key first, in which case we retry
+			err = mc.Client.Add(&memcache.Item{Key: key, Value: data, Flags: flags, Expiration: expirySeconds})
+			mc.recordResult(err)
+			if err == memcache.ErrNotStored {
+				continue
+			}
+			if err != nil {
+				mc.recordOperation("get_and_set", key, resultError)
+				mc.backendError("get and set cache", key, err)
+				return false, err
+			}
+			mc.recordOperation("get_and_set", key, resultMiss)
+			mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+			return false, nil
+		}
+		mc.recordResult(err)
+		if err != nil {
+			mc.recordOperation("get_and_set", key, resultError)
+			mc.backendError("get and set cache", key, err)
+			return false, err
+		}
+
+		oldData, oldFlags := item.Value, item.Flags
+		item.Value = data
+		item.Flags = flags
+		item.Expiration = expirySeconds
+		err = mc.Client.CompareAndSwap(item)
+		mc.recordResult(err)
+		if err == memcache.ErrCASConflict || err == memcache.ErrCacheMiss || err == memcache.ErrNotStored {
+			continue
+		}
+		if err != nil {
+			mc.recordOperation("get_and_set", key, resultError)
+			mc.backendError("get and set cache", key, err)
+			return false, err
+		}
+
+		mc.recordOperation("get_and_set", key, resultHit)
+		mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+		oldData, oldFlags, err = mc.openValue(rawKey, oldData, oldFlags)
+		if err == nil {
+			err = decodeValue(oldData, oldFlags, oldTarget)
+		}
+		if err != nil {
+			mc.deserializeError(key, err)
+			return true, err
+		}
+		return true, nil
+	}
+
+	mc.recordOperation("get_and_set", key, resultError)
+	log.Printf("Gave up swapping key %s after %d conflicting writes", key, mc.CASMaxRetries+1)
+	return false, ErrCASRetriesExhausted
+}
@@ -1,82 +1,456 @@
 package config       
    
 import (  
-    "encoding/json"  
+    "context"
+    "crypto/cipher"
+    "fmt"
     "log" 
+    "net"
     "os"
+    "strconv"
     "strings" 
+    "sync"
+    "sync/atomic"
     "time" 
 
     "github.com/bradfitz/gomemcache/memcache"
+    "go.uber.org/zap"
+)
+
+// MemcacheClient is the subset of the Memcached client used by MemcachedConfig (satisfied by *memcache.Client).
+type MemcacheClient interface {
+    Get(key string) (*memcache.Item, error)
+    GetMulti(keys []string) (map[string]*memcache.Item, error)
+    Set(item *memcache.Item) error
+    Add(item *memcache.Item) error
+    CompareAndSwap(item *memcache.Item) error
+    Delete(key string) error
+    FlushAll() error
+    Ping() error
+}
 
- 
 // MemcachedConfig holds the configuration for Memcached connection.
 type MemcachedConfig struct {
-    Servers       []string // List of Memcached server addresses (e.g., "localhost:11211")
-    Timeout       time.Duration
-    DefaultExpiry time.Duration // Default TTL for cached items
-    Client        *memcache.Client
+    Servers           []string      // List of Memcached server addresses (e.g., "localhost:11211")
+    MaxServers        int           // Maximum number of servers accepted from MEMCACHED_SERVERS
+    Timeout           time.Duration
+    DefaultExpiry     time.Duration // Default TTL for cached items
+    KeyPrefix         string        // Environment prefix prepended to every key (e.g. "staging"), empty for none
+    MultiGetChunkSize int           // Maximum keys per multi-get request; larger requests are chunked
+    CASMaxRetries     int           // Retries of compare-and-swap loops after a conflicting write
+    Client            MemcacheClient
+
+    Selector memcache.ServerSelector // Maps keys to Client's servers, for per-server latency; nil records none
+
+    PingAttempts int           // Startup connection attempts before InitMemcached gives up
+    PingBackoff  time.Duration // Wait after the first failed startup ping, doubling per attempt
+
+    Logger        *zap.Logger // Receives sampled debug traces of cache operations
+    LogSampleRate int         // Trace 1 in LogSampleRate operations; 0 disables tracing
+    traceCount    uint64
+
+    BlockchainEncoding BlockchainEncoding // Serialization of blockchain helper entries (json, gob or protobuf)
+    CompressMinBytes   int                // Gzip values of at least this many bytes, 0 to never compress
+    Checksums          bool               // Store a CRC32 with each value; entries failing it are deleted and read as misses
+    ttlPolicy          atomic.Value       // *TTLPolicy for blockchain helper entries, see StartTTLPolicyLoader
+
+    OriginConcurrency int           // Maximum simultaneous origin fetches by GetOrLoad and Warm, 0 for no limit
+    OriginWait        time.Duration // Time an origin fetch waits for a free slot before failing with ErrOriginBusy
+    origin            *originBudget
+
+    ManifestMaxKeys int // Maximum keys recorded in a namespace manifest (see EnableManifest)
+    ManifestShards  int // Items a namespace manifest is split across (see EnableManifest)
+    manifestMu      sync.RWMutex
+    manifests       map[string]bool
+
+    TagChunkMaxBytes int // Maximum size of one chunk of a tag index (see SetWithTags)
+
+    encryptionMu         sync.RWMutex
+    encryptionKeys       map[byte]cipher.AEAD // AES-GCM ciphers by key version (see SetEncryptionKeys)
+    encryptionKeyVersion byte                 // Key version encrypting new entries
+    encrypted            map[string]bool      // Namespaces whose values are encrypted (see EnableEncryption)
+
+    SecondaryConfig      *MemcachedConfig // Secondary region's cluster that writes are replicated to, if any
+    ReplicateWrites      bool             // Asynchronously replicate writes and deletes to SecondaryConfig
+    ReplicationQueueSize int              // Replicated operations that may wait for a worker; more are dropped
+    ReplicationWorkers   int              // Goroutines applying replicated operations to SecondaryConfig
+    replicationOnce      sync.Once
+    replication          chan replicatedOp
+
+    MigrationDeleteOld bool // Delete the old key once GetWithMigration has copied it to the new key
+
+    ErrorLogWindow time.Duration // Window within which identical cache errors are logged once
+    errorLog       *dedupLogger
+
+    FallbackEnabled  bool          // Serve from a bounded in-memory cache while Memcached is unavailable
+    FallbackMaxItems int           // Maximum number of entries held by the in-memory fallback
+    FallbackTTL      time.Duration // Maximum time an entry is kept in the in-memory fallback
+    fallback         *memoryCache
+    breaker          *circuitBreaker
+
+    warms warmTracker // Warm-up runs, reported by WarmStatus
+
+    // Background cache work (refresh-ahead, warm-up), cancelled and awaited by Close
+    bgMu     sync.Mutex
+    bgCtx    context.Context
+    bgCancel context.CancelFunc
+    bgWG     sync.WaitGroup
+    closed   bool
 }
 
 // DefaultMemcachedConfig provides default values for Memcached configuration.
 func DefaultMemcachedConfig() *MemcachedConfig {
     return &MemcachedConfig{
-        Servers:       []string{"localhost:11211"},
-        Timeout:       1 * time.Second,
-        DefaultExpiry: 1 * time.Hour,
+        Servers:        []string{"localhost:11211"},
+        MaxServers:     32,
+        Timeout:        1 * time.Second,
+        DefaultExpiry:  1 * time.Hour,
+        ErrorLogWindow: 10 * time.Second,
+        errorLog:       newDedupLogger(10 * time.Second),
+
+        MultiGetChunkSize: 100,
+        CASMaxRetries:     10,
+
+        PingAttempts: 5,
+        PingBackoff:  500 * time.Millisecond,
+
+        Logger:        zap.NewNop(),
+        LogSampleRate: 100,
+
+        ManifestMaxKeys:  defaultManifestMaxKeys,
+        ManifestShards:   defaultManifestShards,
+        TagChunkMaxBytes: defaultTagChunkMaxBytes,
+
+        BlockchainEncoding: EncodingJSON,
+
+        OriginConcurrency: 32,
+        OriginWait:        100 * time.Millisecond,
+        origin:            newOriginBudget(32, 100*time.Millisecond),
+
+        FallbackMaxItems: 1000,
+        FallbackTTL:      5 * time.Minute,
+        breaker:          newCircuitBreaker(circuitFailureThreshold, circuitCooldown),
     }
 }
 
+// Lower bounds for configured durations. A zero Memcached timeout would disable it entirely,
+// letting a dead server hang every operation, and Memcached expiry has one-second resolution.
+const (
+    minMemcachedTimeout = 50 * time.Millisecond
+    minMemcachedExpiry  = 1 * time.Second
+)
+
+// parseSecondsEnv parses a duration in seconds (e.g. "0.5") from an environment variable. Missing,
+// invalid and non-positive values fall back with a warning; values below minimum are clamped to it.
+func parseSecondsEnv(name string, fallback time.Duration, minimum time.Duration) time.Duration {
+    value := os.Getenv(name)
+    if value == "" {
+        return fallback
+    }
+    duration, err := time.ParseDuration(value + "s")
+    if err != nil || duration <= 0 {
+        log.Printf("Invalid %s value %q, must be positive, using default %v", name, value, fallback)
+        return fallback
+    }
+    if duration < minimum {
+        log.Printf("%s value %v is below the minimum, using %v", name, duration, minimum)
+        return minimum
+    }
+    return duration
+}
+
 // InitMemcached initializes a Memcached client with configuration from environment variables or defaults.
 func InitMemcached() (*MemcachedConfig, error) {
+    return InitMemcachedContext(context.Background())
+}
+
+// InitMemcachedContext is InitMemcached with a startup deadline: while Memcached is not yet
+// reachable it keeps retrying (see PingWithRetry) until ctx is done.
+func InitMemcachedContext(ctx context.Context) (*MemcachedConfig, error) {
+    config, err := NewMemcached()
+    if err != nil {
+        return nil, err
+    }
+    if err := config.Connect(ctx); err != nil {
+        return nil, err
+    }
+    return config, nil
+}
+
+// NewMemcached creates a Memcached client from environment variables or defaults without
+// contacting the servers. Call Connect before relying on it, e.g. to accept traffic while
+// Memcached is still coming up and start caching once Connect succeeds.
+func NewMemcached() (*MemcachedConfig, error) {
+    config, err := LoadMemcachedConfig()
+    if err != nil {
+        return nil, err
+    }
+
+    // Replicate writes to a secondary region's cluster if configured
+    if err := initSecondary(config); err != nil {
+        return nil, err
+    }
+
+    // Initialize Memcached client, keeping its server selector to attribute latency to servers
+    servers := new(memcache.ServerList)
+    if err := servers.SetServers(config.Servers...); err != nil {
+        return nil, fmt.Errorf("invalid Memcached servers: %w", err)
+    }
+    client := memcache.NewFromSelector(servers)
+    client.Timeout = config.Timeout
+    config.Client = client
+    config.Selector = servers
+    return config, nil
+}
+
+// Connect waits for the Memcached servers to answer (see PingWithRetry) and then starts the
+// background work that needs them, such as the remote TTL policy loader.
+func (mc *MemcachedConfig) Connect(ctx context.Context) error {
+    // Test connection to Memcached servers, waiting briefly for them to come up
+    err := mc.PingWithRetry(ctx)
+    if err != nil {
+        log.Printf("Failed to connect to Memcached: %v", err)
+        return err
+    }
+
+    log.Println("Successfully connected to Memcached")
+
+    // Load the blockchain TTL policy from the remote config service if configured
+    if policyURL := os.Getenv("MEMCACHED_TTL_POLICY_URL"); policyURL != "" {
+        refresh := parseSecondsEnv("MEMCACHED_TTL_POLICY_REFRESH_SECONDS", time.Minute, time.Second)
+        mc.StartTTLPolicyLoader(policyURL, refresh)
+    }
+    return nil
+}
+
+// LoadMemcachedConfig loads Memcached configuration from environment variables or defaults,
+// without connecting.
+func LoadMemcachedConfig() (*MemcachedConfig, error) {
     config := DefaultMemcachedConfig()
 
+    // Override the server limit from environment variable if provided
+    if maxEnv := os.Getenv("MEMCACHED_MAX_SERVERS"); maxEnv != "" {
+        if maxServers, err := strconv.Atoi(maxEnv); err == nil && maxServers > 0 {
+            config.MaxServers = maxServers
+        } else {
+            log.Printf("Invalid MEMCACHED_MAX_SERVERS value, using default: %s", maxEnv)
+        }
+    }
+
     // Override servers from environment variable if provided
     if serversEnv := os.Getenv("MEMCACHED_SERVERS"); serversEnv != "" {
-        config.Servers = strings.Split(serversEnv, ",")
+        servers, err := ParseMemcachedServers(serversEnv, config.MaxServers)
+        if err != nil {
+            log.Printf("Invalid MEMCACHED_SERVERS: %v", err)
+            return nil, err
+        }
+        config.Servers = servers
+    }
+
+    // Override timeout and default expiry from environment variables if provided
+    config.Timeout = parseSecondsEnv("MEMCACHED_TIMEOUT_SECONDS", config.Timeout, minMemcachedTimeout)
+    config.DefaultExpiry = parseSecondsEnv("MEMCACHED_DEFAULT_EXPIRY_SECONDS", config.DefaultExpiry, minMemcachedExpiry)
+
+    // Override startup ping retries from environment variables if provided
+    if attemptsEnv := os.Getenv("MEMCACHED_PING_ATTEMPTS"); attemptsEnv != "" {
+        if attempts, err := strconv.Atoi(attemptsEnv); err == nil && attempts > 0 {
+            config.PingAttempts = attempts
+        } else {
+            log.Printf("Invalid MEMCACHED_PING_ATTEMPTS value, using default: %s", attemptsEnv)
+        }
+    }
+    config.PingBackoff = parseSecondsEnv("MEMCACHED_PING_BACKOFF_SECONDS", config.PingBackoff, minMemcachedTimeout)
+
+    // Override key prefix from environment variable if provided
+    if prefixEnv := os.Getenv("MEMCACHED_KEY_PREFIX"); prefixEnv != "" {
+        config.KeyPrefix = prefixEnv
+    }
+
+    // Override multi-get chunk size from environment variable if provided
+    if chunkEnv := os.Getenv("MEMCACHED_MULTIGET_CHUNK_SIZE"); chunkEnv != "" {
+        if chunkSize, err := strconv.Atoi(chunkEnv); err == nil && chunkSize > 0 {
+            config.MultiGetChunkSize = chunkSize
+        } else {
+            log.Printf("Invalid MEMCACHED_MULTIGET_CHUNK_SIZE value, using default: %s", chunkEnv)
+        }
     }
 
-    // Override timeout from environment variable if provided
-    if timeoutEnv := os.Getenv("MEMCACHED_TIMEOUT_SECONDS"); timeoutEnv != "" {
-        if timeout, err := time.ParseDuration(timeoutEnv + "s"); err == nil {
-            config.Timeout = timeout
+    // Override operation trace sampling from environment variable if provided
+    if sampleEnv := os.Getenv("MEMCACHED_LOG_SAMPLE_RATE"); sampleEnv != "" {
+        if sampleRate, err := strconv.Atoi(sampleEnv); err == nil && sampleRate >= 0 {
+            config.LogSampleRate = sampleRate
         } else {
-            log.Printf("Invalid MEMCACHED_TIMEOUT_SECONDS value, using default: %v", err)
+            log.Printf("Invalid MEMCACHED_LOG_SAMPLE_RATE value, using default: %s", sampleEnv)
         }
     }
 
-    // Override default expiry from environment variable if provided
-    if expiryEnv := os.Getenv("MEMCACHED_DEFAULT_EXPIRY_SECONDS"); expiryEnv != "" {
-        if expiry, err := time.ParseDuration(expiryEnv + "s"); err == nil {
-            config.DefaultExpiry = expiry
+    // Override blockchain data encoding from environment variable if provided
+    if encodingEnv := os.Getenv("MEMCACHED_BLOCKCHAIN_ENCODING"); encodingEnv != "" {
+        if encoding, err := ParseBlockchainEncoding(encodingEnv); err == nil {
+            config.BlockchainEncoding = encoding
         } else {
-            log.Printf("Invalid MEMCACHED_DEFAULT_EXPIRY_SECONDS value, using default: %v", err)
+            log.Printf("Invalid MEMCACHED_BLOCKCHAIN_ENCODING value, using default: %v", err)
         }
     }
 
-    // Initialize Memcached client
-    config.Client = memcache.New(config.Servers...)
-    config.Client.Timeout = config.Timeout
+    // Override the value compression threshold from environment variable if provided
+    if compressEnv := os.Getenv("MEMCACHED_COMPRESS_MIN_BYTES"); compressEnv != "" {
+        if minBytes, err := strconv.Atoi(compressEnv); err == nil && minBytes >= 0 {
+            config.CompressMinBytes = minBytes
+        } else {
+            log.Printf("Invalid MEMCACHED_COMPRESS_MIN_BYTES value, using default: %s", compressEnv)
+        }
+    }
 
-    // Test connection to Memcached servers
-    err := config.Client.Ping()
-    if err != nil {
-        log.Printf("Failed to connect to Memcached: %v", err)
+    // Enable value checksums from environment variable if provided
+    if checksumsEnv := os.Getenv("MEMCACHED_CHECKSUMS"); checksumsEnv != "" {
+        if checksums, err := strconv.ParseBool(checksumsEnv); err == nil {
+            config.Checksums = checksums
+        } else {
+            log.Printf("Invalid MEMCACHED_CHECKSUMS value, using default: %s", checksumsEnv)
+        }
+    }
+
+    // Configure encryption of sensitive namespaces from environment variables if provided
+    if err := loadEncryptionEnv(config); err != nil {
+        log.Printf("Invalid cache encryption settings: %v", err)
         return nil, err
     }
 
-    log.Println("Successfully connected to Memcached")
+    // Override the origin fetch budget from environment variables if provided
+    if concurrencyEnv := os.Getenv("MEMCACHED_ORIGIN_CONCURRENCY"); concurrencyEnv != "" {
+        if concurrency, err := strconv.Atoi(concurrencyEnv); err == nil && concurrency >= 0 {
+            config.OriginConcurrency = concurrency
+        } else {
+            log.Printf("Invalid MEMCACHED_ORIGIN_CONCURRENCY value, using default: %s", concurrencyEnv)
+        }
+    }
+    if waitEnv := os.Getenv("MEMCACHED_ORIGIN_WAIT_SECONDS"); waitEnv != "" {
+        if wait, err := time.ParseDuration(waitEnv + "s"); err == nil && wait >= 0 {
+            config.OriginWait = wait
+        } else {
+            log.Printf("Invalid MEMCACHED_ORIGIN_WAIT_SECONDS value, using default: %s", waitEnv)
+        }
+    }
+    config.SetOriginBudget(config.OriginConcurrency, config.OriginWait)
+
+    // Override error log dedup window from environment variable if provided
+    if windowEnv := os.Getenv("MEMCACHED_ERROR_LOG_WINDOW_SECONDS"); windowEnv != "" {
+        if window, err := time.ParseDuration(windowEnv + "s"); err == nil {
+            config.ErrorLogWindow = window
+        } else {
+            log.Printf("Invalid MEMCACHED_ERROR_LOG_WINDOW_SECONDS value, using default: %v", err)
+        }
+    }
+    config.errorLog = newDedupLogger(config.ErrorLogWindow)
+
+    // Enable the in-memory fallback cache if requested
+    if fallbackEnv := os.Getenv("MEMCACHED_FALLBACK_ENABLED"); fallbackEnv != "" {
+        if enabled, err := strconv.ParseBool(fallbackEnv); err == nil {
+            config.FallbackEnabled = enabled
+        } else {
+            log.Printf("Invalid MEMCACHED_FALLBACK_ENABLED value, fallback disabled: %v", err)
+        }
+    }
+    if maxItemsEnv := os.Getenv("MEMCACHED_FALLBACK_MAX_ITEMS"); maxItemsEnv != "" {
+        if maxItems, err := strconv.Atoi(maxItemsEnv); err == nil && maxItems > 0 {
+            config.FallbackMaxItems = maxItems
+        } else {
+            log.Printf("Invalid MEMCACHED_FALLBACK_MAX_ITEMS value, using default: %s", maxItemsEnv)
+        }
+    }
+    if config.FallbackEnabled {
+        config.EnableFallback()
+    }
     return config, nil
 }
 
+// ParseMemcachedServers parses a comma-separated server list, requiring every entry to be a
+// valid host:port and at most maxServers entries (0 for no limit).
+func ParseMemcachedServers(value string, maxServers int) ([]string, error) {
+    var servers []string
+    var invalid []string
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        host, port, err := net.SplitHostPort(entry)
+        if err != nil || host == "" {
+            invalid = append(invalid, strconv.Quote(entry))
+            continue
+        }
+        if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+            invalid = append(invalid, strconv.Quote(entry))
+            continue
+        }
+        servers = append(servers, entry)
+    }
+
+    if len(invalid) > 0 {
+        return nil, fmt.Errorf("invalid memcached server address(es), expected host:port: %s", strings.Join(invalid, ", "))
+    }
+    if len(servers) == 0 {
+        return nil, fmt.Errorf("no memcached servers configured")
+    }
+    if maxServers > 0 && len(servers) > maxServers {
+        return nil, fmt.Errorf("too many memcached servers: %d configured, maximum is %d", len(servers), maxServers)
+    }
+    return servers, nil
+}
+
+// logError logs a failed cache operation, collapsing identical repeated errors (e.g. during an outage).
+func (mc *MemcachedConfig) logError(operation string, key string, err error) {
+    if mc.errorLog == nil {
+        log.Printf("Failed to %s for key %s: %v", operation, key, err)
+        return
+    }
+    mc.errorLog.Errorf(operation, key, err)
+}
+
+// SetOriginBudget limits origin fetches by GetOrLoad and Warm to concurrency at a time, with
+// excess fetches waiting up to wait for a slot. A concurrency of 0 disables the limit.
+// It must be called before the client is shared between goroutines.
+func (mc *MemcachedConfig) SetOriginBudget(concurrency int, wait time.Duration) {
+    mc.OriginConcurrency = concurrency
+    mc.OriginWait = wait
+    mc.origin = newOriginBudget(concurrency, wait)
+}
+
+// fullKey applies the environment key prefix, if any, to key.
+func (mc *MemcachedConfig) fullKey(key string) string {
+    if mc.KeyPrefix == "" {
+        return key
+    }
+    return mc.KeyPrefix + ":" + key
+}
+
 // SetCache stores a value in Memcached with a specified key and optional expiration time.
+// A RawValue is stored as-is and other values as JSON; values of at least CompressMinBytes
+// are gzipped, with Checksums a CRC32 is appended, and values in encrypted namespaces are
+// encrypted (see EnableEncryption). The encoding is recorded in the item flags, which GetCache
+// decodes by.
+// Writes are replicated to the secondary region's cluster in the background when enabled.
 func (mc *MemcachedConfig) SetCache(key string, value interface{}, expiration time.Duration) error {
-    // Serialize the value to JSON
-    data, err := json.Marshal(value)
+    // Serialize the value
+    data, flags, err := encodeValue(mc.fullKey(key), value)
+    if err != nil {
+        mc.serializeError(mc.fullKey(key), err)
+        return err
+    }
+    data, flags, err = mc.packValue(key, data, flags)
     if err != nil {
-        log.Printf("Failed to serialize value for key %s: %v", key, err)
+        mc.serializeError(mc.fullKey(key), err)
         return err
     }
+    return mc.setData(key, data, flags, expiration)
+}
+
+// setData stores an already serialized value under key with the item flags recording its encoding.
+func (mc *MemcachedConfig) setData(key string, data []byte, flags uint32, expiration time.Duration) error {
+    mc.replicate(key, data, flags, expiration)
+    rawKey := key
+    key = mc.fullKey(key)
 
     // Set expiration in seconds (Memcached requires int32 for expiration)
     expirySeconds := int32(expiration.Seconds())
@@ -88,62 +462,168 @@ func (mc *MemcachedConfig) SetCache(key string, value interface{}, expiration ti
     item := &memcache.Item{
         Key:        key,
         Value:      data,
+        Flags:      flags,
         Expiration: expirySeconds,
     }
 
+    // Serve writes from the in-memory fallback while the Memcached circuit is open
+    if mc.fallbackActive() {
+        mc.recordOperation("set", key, resultError)
+        mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+        return nil
+    }
+
     // Store in Memcached
-    err = mc.Client.Set(item)
+    start := time.Now()
+    err := mc.Client.Set(item)
+    mc.observeServerLatency("set", key, start)
     if err != nil {
-        log.Printf("Failed to set cache for key %s: %v", key, err)
+        mc.recordOperation("set", key, resultError)
+    } else {
+        mc.recordOperation("set", key, resultOK)
+    }
+    if mc.recordResult(err) && mc.fallback != nil {
+        mc.backendError("set cache", key, err)
+        mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+        return nil
+    }
+    if err != nil {
+        mc.backendError("set cache", key, err)
         return err
     }
+    mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+    mc.trackManifest(rawKey)
 
-    log.Printf("Successfully cached data for key %s", key)
+    mc.traceOperation("set", key, resultOK, len(data), start)
     return nil
 }
 
-// GetCache retrieves a value from Memcached by key and deserializes it into the provided target.
+// GetCache retrieves a value from Memcached by key and deserializes it into the provided target
+// with the decoder its item flags select. Unrecognized flags fail with ErrUnknownEncoding.
 func (mc *MemcachedConfig) GetCache(key string, target interface{}) (bool, error) {
-    // Get item from Memcached
-    item, err := mc.Client.Get(key)
-    if err == memcache.ErrCacheMiss {
-        log.Printf("Cache miss for key %s", key)
-        return false, nil
+    data, flags, found, err := mc.getData(key)
+    if !found || err != nil {
+        return false, err
     }
-    if err != nil {
-        log.Printf("Failed to get cache for key %s: %v", key, err)
+
+    // Deserialize the value in the encoding it was stored with
+    if err := decodeValue(data, flags, target); err != nil {
+        mc.deserializeError(mc.fullKey(key), err)
         return false, err
     }
+    return true, nil
+}
+
+// getData retrieves the serialized value stored under key and its item flags.
+func (mc *MemcachedConfig) getData(key string) ([]byte, uint32, bool, error) {
+    data, flags, _, found, err := mc.getDataWithSource(key)
+    return data, flags, found, err
+}
+
+// getDataWithSource retrieves the serialized value stored under key and its item flags, and
+// reports where it was read from. Soft-deleted values are reported as misses.
+func (mc *MemcachedConfig) getDataWithSource(key string) ([]byte, uint32, CacheSource, bool, error) {
+    data, flags, source, found, err := mc.readData(key)
+    if found && softDeleted(data) {
+        return nil, 0, source, false, nil
+    }
+    return data, flags, source, found, err
+}
 
-    // Deserialize the value from JSON
-    err = json.Unmarshal(item.Value, target)
+// readData retrieves the serialized value stored under key, including soft-deleted values,
+// decrypted and decompressed and with the item flags recording its encoding.
+func (mc *MemcachedConfig) readData(key string) ([]byte, uint32, CacheSource, bool, error) {
+    data, flags, source, found, err := mc.readItem(key)
+    if !found || err != nil {
+        return nil, 0, source, found, err
+    }
+    data, flags, err = mc.openValue(key, data, flags)
+    if unreadable(err) {
+        mc.discardCorrupted(key, err)
+        return nil, 0, source, false, nil
+    }
     if err != nil {
-        log.Printf("Failed to deserialize value for key %s: %v", key, err)
-        return false, err
+        mc.deserializeError(mc.fullKey(key), err)
+        return nil, 0, source, false, err
     }
+    return data, flags, source, true, nil
+}
 
-    log.Printf("Cache hit for key %s", key)
-    return true, nil
+// readItem retrieves the value and item flags stored under key as they are held in the cache.
+func (mc *MemcachedConfig) readItem(key string) ([]byte, uint32, CacheSource, bool, error) {
+    key = mc.fullKey(key)
+
+    // Serve reads from the in-memory fallback while the Memcached circuit is open
+    if mc.fallbackActive() {
+        mc.recordOperation("get", key, resultError)
+        return mc.getFallback(key)
+    }
+
+    // Get item from Memcached
+    start := time.Now()
+    item, err := mc.Client.Get(key)
+    mc.observeServerLatency("get", key, start)
+    if mc.recordResult(err) && mc.fallback != nil {
+        mc.recordOperation("get", key, resultError)
+        mc.backendError("get cache", key, err)
+        return mc.getFallback(key)
+    }
+    if err == memcache.ErrCacheMiss {
+        mc.recordOperation("get", key, resultMiss)
+        mc.traceOperation("get", key, resultMiss, 0, start)
+        return nil, 0, SourceMemcached, false, nil
+    }
+    if err != nil {
+        mc.recordOperation("get", key, resultError)
+        mc.backendError("get cache", key, err)
+        return nil, 0, SourceMemcached, false, err
+    }
+    mc.recordOperation("get", key, resultHit)
+    mc.storeFallback(key, item.Value, item.Flags, mc.FallbackTTL)
+
+    mc.traceOperation("get", key, resultHit, len(item.Value), start)
+    return item.Value, item.Flags, SourceMemcached, true, nil
 }
 
-// DeleteCache removes a specific key from Memcached.
+// DeleteCache removes a specific key from Memcached. Deletes are replicated to the secondary
+// region's cluster in the background when enabled.
 func (mc *MemcachedConfig) DeleteCache(key string) error {
+    mc.replicateDelete(key)
+    key = mc.fullKey(key)
+    if mc.fallback != nil {
+        mc.fallback.Delete(key)
+    }
+    if mc.fallbackActive() {
+        mc.recordOperation("delete", key, resultError)
+        return ErrCircuitOpen
+    }
+
+    start := time.Now()
     err := mc.Client.Delete(key)
+    mc.observeServerLatency("delete", key, start)
+    mc.recordResult(err)
     if err == memcache.ErrCacheMiss {
-        log.Printf("Key %s not found in cache for deletion", key)
+        mc.recordOperation("delete", key, resultMiss)
+        mc.traceOperation("delete", key, resultMiss, 0, start)
         return nil
     }
     if err != nil {
-        log.Printf("Failed to delete cache for key %s: %v", key, err)
+        mc.recordOperation("delete", key, resultError)
+        mc.backendError("delete cache", key, err)
         return err
     }
+    mc.recordOperation("delete", key, resultOK)
 
-    log.Printf("Successfully deleted cache for key %s", key)
+    mc.traceOperation("delete", key, resultOK, 0, start)
     return nil
 }
 
 // FlushCache clears all data in Memcached (use with caution in production).
 func (mc *MemcachedConfig) FlushCache() error {
+    if mc.fallback != nil {
+        mc.fallback.Flush()
+    }
+
     err := mc.Client.FlushAll()
     if err != nil {
         log.Printf("Failed to flush Memcached: %v", err)
@@ -156,24 +636,69 @@ func (mc *MemcachedConfig) FlushCache() error {
 
 // SetCachedAPIResponse caches an API response with a specific key and expiration time.
 func (mc *MemcachedConfig) SetCachedAPIResponse(endpoint string, params string, response interface{}, expiration time.Duration) error {
-    cacheKey := "api:" + endpoint + ":" + params
+    cacheKey := BuildKey("api", endpoint, params)
     return mc.SetCache(cacheKey, response, expiration)
 }
 
 // GetCachedAPIResponse retrieves a cached API response by endpoint and parameters.
 func (mc *MemcachedConfig) GetCachedAPIResponse(endpoint string, params string, target interface{}) (bool, error) {
-    cacheKey := "api:" + endpoint + ":" + params
+    cacheKey := BuildKey("api", endpoint, params)
     return mc.GetCache(cacheKey, target)
 }
 
-// SetCachedBlockchainData caches blockchain data with a specific key and expiration time.
+// SetCachedBlockchainData caches blockchain data with a specific key and expiration time,
+// serialized with BlockchainEncoding. A zero expiration uses the TTL policy for dataType.
 func (mc *MemcachedConfig) SetCachedBlockchainData(dataType string, identifier string, data interface{}, expiration time.Duration) error {
-    cacheKey := "blockchain:" + dataType + ":" + identifier
-    return mc.SetCache(cacheKey, data, expiration)
+    cacheKey := BuildKey("blockchain", dataType, identifier)
+    if expiration == 0 {
+        expiration = mc.TTLPolicy().TTL(dataType)
+    }
+    encoded, flags, err := encodeBlockchainValue(mc.BlockchainEncoding, mc.fullKey(cacheKey), data)
+    if err != nil {
+        mc.serializeError(mc.fullKey(cacheKey), err)
+        return err
+    }
+    encoded, flags, err = mc.packValue(cacheKey, encoded, flags)
+    if err != nil {
+        mc.serializeError(mc.fullKey(cacheKey), err)
+        return err
+    }
+    return mc.setData(cacheKey, encoded, flags, expiration)
 }
 
-// GetCachedBlockchainData retrieves cached blockchain data by type and identifier.
+// GetCachedBlockchainData retrieves cached blockchain data by type and identifier. Entries in
+// any encoding are read by their item flags, so changing BlockchainEncoding doesn't invalidate
+// existing entries.
 func (mc *MemcachedConfig) GetCachedBlockchainData(dataType string, identifier string, target interface{}) (bool, error) {
-    cacheKey := "blockchain:" + dataType + ":" + identifier
-    return mc.GetCache(cacheKey, target)
+    return mc.GetCache(BuildKey("blockchain", dataType, identifier), target)
+}
+
+// GetManyOrMiss retrieves the cached blockchain data for several identifiers of dataType in
+// one multi-get, decoding each hit into targets[id], and returns the identifiers that were not
+// cached (in input order) so the caller can bulk-fetch just those and backfill them with
+// SetCachedBlockchainData. Entries that fail to decode are reported as missing. If some
+// multi-get chunks fail, their identifiers are reported as missing together with the error.
+func (mc *MemcachedConfig) GetManyOrMiss(dataType string, ids []string, targets map[string]interface{}) ([]string, error) {
+    keys := make([]string, len(ids))
+    for i, id := range ids {
+        if targets[id] == nil {
+            return nil, fmt.Errorf("no target for %s identifier %q", dataType, id)
+        }
+        keys[i] = BuildKey("blockchain", dataType, id)
+    }
+
+    cached, err := mc.getMultiItems(keys)
+    var missing []string
+    for i, id := range ids {
+        item, found := cached[keys[i]]
+        if !found {
+            missing = append(missing, id)
+            continue
+        }
+        if decodeErr := decodeValue(item.data, item.flags, targets[id]); decodeErr != nil {
+            mc.deserializeError(mc.fullKey(keys[i]), decodeErr)
+            missing = append(missing, id)
+        }
+    }
+    return missing, err
 }
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// incrementer is implemented by clients supporting Memcached's incr command (e.g. *memcache.Client).
+type incrementer interface {
+	Increment(key string, delta uint64) (uint64, error)
+}
+
+// IncrementCache atomically adds delta to the counter under key and returns its new value,
+// creating the counter with expiration (zero uses DefaultExpiry) when it is absent; the
+// expiration of an existing counter is left as it is. Counters are stored as plain decimal
+// JSON, never compressed, checksummed or encrypted, so Memcached can increment them and
+// GetCache reads them into an integer. Like GetAndSet it is not available while the fallback
+// cache is serving.
+func (mc *MemcachedConfig) IncrementCache(key string, delta uint64, expiration time.Duration) (uint64, error) {
+	fullKey := mc.fullKey(key)
+	if mc.fallbackActive() {
+		mc.recordOperation("increment", fullKey, resultError)
+		return 0, ErrCircuitOpen
+	}
+	expirySeconds := int32(expiration.Seconds())
+	if expirySeconds == 0 {
+		expirySeconds = int32(mc.DefaultExpiry.Seconds())
+	}
+
+	for attempt := 0; attempt <= mc.CASMaxRetries; attempt++ {
+		value, err := mc.incrementExisting(fullKey, delta)
+		if err == nil {
+			mc.recordOperation("increment", fullKey, resultOK)
+			return value, nil
+		}
+		if err == memcache.ErrCASConflict {
+			continue
+		}
+		if err != memcache.ErrCacheMiss {
+			mc.recordOperation("increment", fullKey, resultError)
+			mc.backendError("increment cache", fullKey, err)
+			return 0, err
+		}
+
+		err = mc.Client.Add(&memcache.Item{Key: fullKey, Value: []byte(strconv.FormatUint(delta, 10)), Flags: flagJSON, Expiration: expirySeconds})
+		mc.recordResult(err)
+		if err == nil {
+			mc.recordOperation("increment", fullKey, resultOK)
+			return delta, nil
+		}
+		// Created concurrently: increment it on the next attempt
+		if err != memcache.ErrNotStored {
+			mc.recordOperation("increment", fullKey, resultError)
+			mc.backendError("increment cache", fullKey, err)
+			return 0, err
+		}
+	}
+
+	mc.recordOperation("increment", fullKey, resultError)
+	log.Printf("Gave up incrementing key %s after %d conflicting writes", fullKey, mc.CASMaxRetries+1)
+	return 0, ErrCASRetriesExhausted
+}
+
+// incrementExisting adds delta to the counter under fullKey with incr when the client supports
+// it, and otherwise with a CompareAndSwap, returning memcache.ErrCASConflict if the counter
+// changed in between. An absent counter returns memcache.ErrCacheMiss.
+func (mc *MemcachedConfig) incrementExisting(fullKey string, delta uint64) (uint64, error) {
+	if client, ok := mc.Client.(incrementer); ok {
+		value, err := client.Increment(fullKey, delta)
+		mc.recordResult(err)
+		return value, err
+	}
+
+	item, err := mc.Client.Get(fullKey)
+	mc.recordResult(err)
+	if err != nil {
+		return 0, err
+	}
+	current, err := strconv.ParseUint(string(item.Value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of key %s is not a counter: %w", fullKey, err)
+	}
+	item.Value = []byte(strconv.FormatUint(current+delta, 10))
+	err = mc.Client.CompareAndSwap(item)
+	mc.recordResult(err)
+	if err == memcache.ErrNotStored {
+		err = memcache.ErrCASConflict
+	}
+	return current + delta, err
+}
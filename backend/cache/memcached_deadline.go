@@ -0,0 +1,44 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetCacheUntil stores value like SetCache, but never past deadline: the TTL is the smaller of
+// expiration (zero uses DefaultExpiry) and the time left until deadline, for entries tied to a
+// session or token that must not be served once it expires. Memcached expirations have
+// one-second resolution, so the time left is rounded down; with less than a second left
+// nothing is stored and an error wrapping context.DeadlineExceeded is returned.
+func (mc *MemcachedConfig) SetCacheUntil(key string, value interface{}, expiration time.Duration, deadline time.Time) error {
+	ttl, err := mc.capTTL(expiration, deadline)
+	if err != nil {
+		return fmt.Errorf("cache key %s: %w", key, err)
+	}
+	return mc.SetCache(key, value, ttl)
+}
+
+// SetCacheContext is SetCacheUntil with ctx's deadline; without one it is SetCache.
+func (mc *MemcachedConfig) SetCacheContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		return mc.SetCacheUntil(key, value, expiration, deadline)
+	}
+	return mc.SetCache(key, value, expiration)
+}
+
+// capTTL returns the smaller of expiration (zero meaning DefaultExpiry) and the whole seconds
+// left until deadline.
+func (mc *MemcachedConfig) capTTL(expiration time.Duration, deadline time.Time) (time.Duration, error) {
+	if expiration <= 0 {
+		expiration = mc.DefaultExpiry
+	}
+	remaining := time.Until(deadline).Truncate(time.Second)
+	if remaining < time.Second {
+		return 0, fmt.Errorf("deadline %s leaves less than a second: %w", deadline.Format(time.RFC3339), context.DeadlineExceeded)
+	}
+	if remaining < expiration {
+		return remaining, nil
+	}
+	return expiration, nil
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrNoEncryptionKey is returned by EnableEncryption before any encryption key is configured.
+var ErrNoEncryptionKey = errors.New("no cache encryption key configured")
+
+// ErrUndecryptable is returned when an encrypted cache entry can't be decrypted: its key version
+// is no longer configured, or the value was corrupted or tampered with.
+var ErrUndecryptable = errors.New("cache item could not be decrypted")
+
+// ErrUnknownKeyVersion is returned, wrapping ErrUndecryptable, for an encrypted cache entry whose
+// key version isn't configured on this instance. The entry may be valid for instances that
+// already have the key, e.g. during a rolling rotation, so it is read as a miss but kept.
+var ErrUnknownKeyVersion = fmt.Errorf("%w: key version is not configured", ErrUndecryptable)
+
+// SetEncryptionKeys installs the AES keys (16, 24 or 32 bytes) used for encrypted namespaces,
+// by version. New entries are encrypted with the current version; entries are decrypted with
+// the version stored in them, so to rotate keys add a new version, make it current and keep the
+// old one until entries written with it have expired. Keys may come from configuration (see
+// MEMCACHED_ENCRYPTION_KEYS) or be fetched from a KMS by the caller.
+func (mc *MemcachedConfig) SetEncryptionKeys(keys map[byte][]byte, current byte) error {
+	if _, ok := keys[current]; !ok {
+		return fmt.Errorf("current cache encryption key version %d is not configured", current)
+	}
+	ciphers := make(map[byte]cipher.AEAD, len(keys))
+	for version, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("cache encryption key version %d: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("cache encryption key version %d: %w", version, err)
+		}
+		ciphers[version] = aead
+	}
+
+	mc.encryptionMu.Lock()
+	defer mc.encryptionMu.Unlock()
+	mc.encryptionKeys = ciphers
+	mc.encryptionKeyVersion = current
+	return nil
+}
+
+// EnableEncryption makes SetCache encrypt values stored under keys in namespace (their first
+// key segment, e.g. "session" for "session:abc", which also covers a Namespace handle of that
+// name and the same keys under a TenantCache) with AES-GCM, binding each value to its key. Entries written before encryption was
+// enabled are still read as they are, so a namespace can be migrated without flushing it.
+// Encrypted entries that fail authentication are deleted and read as misses; entries with a key
+// version this instance doesn't have are read as misses but kept.
+func (mc *MemcachedConfig) EnableEncryption(namespace string) error {
+	mc.encryptionMu.Lock()
+	defer mc.encryptionMu.Unlock()
+	if len(mc.encryptionKeys) == 0 {
+		return ErrNoEncryptionKey
+	}
+	if mc.encrypted == nil {
+		mc.encrypted = make(map[string]bool)
+	}
+	mc.encrypted[namespace] = true
+	return nil
+}
+
+// encryptionNamespace returns the namespace a key belongs to for encryption: its first key
+// segment, or for a tenant-scoped key (see TenantCache) the first segment of the tenant's
+// logical key, so "tenant:acme:session%3Aabc" is in the "session" namespace.
+func encryptionNamespace(key string) string {
+	if strings.HasPrefix(key, "tenant:") {
+		rest := strings.TrimPrefix(key, "tenant:")
+		if i := strings.IndexByte(rest, ':'); i >= 0 {
+			key = rest[i+1:]
+			if unescaped, err := url.PathUnescape(key); err == nil {
+				key = unescaped
+			}
+		}
+	}
+	return KeyPrefix(key, "")
+}
+
+// encryptValue encrypts data for key when its namespace is encrypted, storing the key version
+// and nonce ahead of the ciphertext.
+func (mc *MemcachedConfig) encryptValue(key string, data []byte, flags uint32) ([]byte, uint32, error) {
+	mc.encryptionMu.RLock()
+	defer mc.encryptionMu.RUnlock()
+	if !mc.encrypted[encryptionNamespace(key)] {
+		return data, flags, nil
+	}
+	aead := mc.encryptionKeys[mc.encryptionKeyVersion]
+	sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	sealed[0] = mc.encryptionKeyVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, flags, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(sealed, sealed[1:], data, []byte(key)), flags | flagEncrypted, nil
+}
+
+// decryptValue decrypts a value stored with flagEncrypted under key, returning it and its flags
+// without flagEncrypted. Other values are returned as they are.
+func (mc *MemcachedConfig) decryptValue(key string, data []byte, flags uint32) ([]byte, uint32, error) {
+	if flags&flagEncrypted == 0 {
+		return data, flags, nil
+	}
+	if len(data) == 0 {
+		return nil, flags, fmt.Errorf("%w: value too short", ErrUndecryptable)
+	}
+	mc.encryptionMu.RLock()
+	aead, ok := mc.encryptionKeys[data[0]]
+	mc.encryptionMu.RUnlock()
+	if !ok {
+		return nil, flags, fmt.Errorf("%w: %d", ErrUnknownKeyVersion, data[0])
+	}
+	if len(data) < 1+aead.NonceSize() {
+		return nil, flags, fmt.Errorf("%w: value too short", ErrUndecryptable)
+	}
+	nonce, ciphertext := data[1:1+aead.NonceSize()], data[1+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, flags, fmt.Errorf("%w: %v", ErrUndecryptable, err)
+	}
+	return plaintext, flags &^ flagEncrypted, nil
+}
+
+// loadEncryptionEnv configures encryption from MEMCACHED_ENCRYPTION_KEYS, base64 AES keys by
+// version ("1:<key>,2:<key>"), MEMCACHED_ENCRYPTION_KEY_VERSION, the version new entries use
+// (default the highest), and MEMCACHED_ENCRYPTED_NAMESPACES ("session,profile"). Invalid
+// settings are errors rather than warnings, so sensitive values are never stored unencrypted
+// by mistake.
+func loadEncryptionEnv(config *MemcachedConfig) error {
+	keysEnv := os.Getenv("MEMCACHED_ENCRYPTION_KEYS")
+	namespacesEnv := os.Getenv("MEMCACHED_ENCRYPTED_NAMESPACES")
+	if keysEnv == "" && namespacesEnv == "" {
+		return nil
+	}
+
+	keys := make(map[byte][]byte)
+	var current byte
+	for _, entry := range strings.Split(keysEnv, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid MEMCACHED_ENCRYPTION_KEYS entry: expected version:base64-key")
+		}
+		version, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid MEMCACHED_ENCRYPTION_KEYS version %q: %w", parts[0], err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return fmt.Errorf("invalid MEMCACHED_ENCRYPTION_KEYS key for version %d: %w", version, err)
+		}
+		keys[byte(version)] = key
+		if byte(version) > current {
+			current = byte(version)
+		}
+	}
+	if versionEnv := os.Getenv("MEMCACHED_ENCRYPTION_KEY_VERSION"); versionEnv != "" {
+		version, err := strconv.ParseUint(versionEnv, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid MEMCACHED_ENCRYPTION_KEY_VERSION %q: %w", versionEnv, err)
+		}
+		current = byte(version)
+	}
+	if len(keys) > 0 {
+		if err := config.SetEncryptionKeys(keys, current); err != nil {
+			return err
+		}
+	}
+
+	for _, namespace := range strings.Split(namespacesEnv, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace == "" {
+			continue
+		}
+		if err := config.EnableEncryption(namespace); err != nil {
+			return fmt.Errorf("MEMCACHED_ENCRYPTED_NAMESPACES: %w", err)
+		}
+	}
+	return nil
+}
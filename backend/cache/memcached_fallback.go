@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Circuit breaker settings for detecting an unavailable Memcached.
+const (
+	circuitFailureThreshold = 5
+	circuitCooldown         = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned for operations that cannot be served while Memcached is unavailable.
+var ErrCircuitOpen = errors.New("memcached circuit is open")
+
+// EnableFallback turns on the bounded in-memory fallback cache. Successful reads and writes
+// keep it populated with recently-seen keys, and while the Memcached circuit is open all
+// reads and writes are served from it. Once Memcached recovers it simply becomes redundant.
+func (mc *MemcachedConfig) EnableFallback() {
+	mc.FallbackEnabled = true
+	mc.fallback = newMemoryCache(mc.FallbackMaxItems)
+	if mc.breaker == nil {
+		mc.breaker = newCircuitBreaker(circuitFailureThreshold, circuitCooldown)
+	}
+}
+
+// isBackendFailure reports whether err means Memcached itself is unavailable,
+// as opposed to a normal miss or a rejected operation.
+func isBackendFailure(err error) bool {
+	switch err {
+	case nil, memcache.ErrCacheMiss, memcache.ErrCASConflict, memcache.ErrNotStored, memcache.ErrMalformedKey:
+		return false
+	}
+	return true
+}
+
+// recordResult feeds the outcome of a Memcached call into the circuit breaker and reports whether it failed.
+func (mc *MemcachedConfig) recordResult(err error) bool {
+	failed := isBackendFailure(err)
+	if mc.breaker == nil {
+		return failed
+	}
+	if failed {
+		mc.breaker.RecordFailure()
+	} else {
+		mc.breaker.RecordSuccess()
+	}
+	return failed
+}
+
+// fallbackActive reports whether operations should be served from the in-memory fallback.
+func (mc *MemcachedConfig) fallbackActive() bool {
+	return mc.fallback != nil && mc.breaker != nil && !mc.breaker.Allow()
+}
+
+// storeFallback keeps a copy of a serialized value and its item flags in the in-memory fallback,
+// capped at FallbackTTL.
+func (mc *MemcachedConfig) storeFallback(key string, data []byte, flags uint32, ttl time.Duration) {
+	if mc.fallback == nil {
+		return
+	}
+	if ttl <= 0 || ttl > mc.FallbackTTL {
+		ttl = mc.FallbackTTL
+	}
+	mc.fallback.SetWithFlags(key, data, flags, ttl)
+}
+
+// CacheSource identifies where a cached value was read from.
+type CacheSource string
+
+const (
+	// SourceMemcached means the value came from Memcached.
+	SourceMemcached CacheSource = "memcached"
+	// SourceFallback means the value came from the in-memory fallback during a Memcached
+	// outage, so it may be older than what Memcached would have returned.
+	SourceFallback CacheSource = "fallback"
+)
+
+// getFallback reads a serialized value and its item flags from the in-memory fallback.
+func (mc *MemcachedConfig) getFallback(key string) ([]byte, uint32, CacheSource, bool, error) {
+	data, flags, found := mc.fallback.GetWithFlags(key)
+	if !found {
+		return nil, 0, SourceFallback, false, nil
+	}
+	mc.traceOperation("get_fallback", key, resultHit, len(data), time.Now())
+	return data, flags, SourceFallback, true, nil
+}
+
+// GetCacheWithSource is GetCache that also reports whether the value was served by Memcached
+// or by the in-memory fallback, so callers can flag degraded responses.
+func (mc *MemcachedConfig) GetCacheWithSource(key string, target interface{}) (CacheSource, bool, error) {
+	data, flags, source, found, err := mc.getDataWithSource(key)
+	if !found || err != nil {
+		return source, false, err
+	}
+	if err := decodeValue(data, flags, target); err != nil {
+		mc.deserializeError(mc.fullKey(key), err)
+		return source, false, err
+	}
+	return source, true, nil
+}
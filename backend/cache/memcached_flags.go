@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Memcached item flags recording how a value is encoded, so entries in different encodings can
+// share keys during a migration. The low bits select the encoding, flagCompressed marks a
+// gzipped value, flagChecksum one followed by its CRC32 and flagEncrypted one encrypted as a
+// whole (see EnableEncryption). Zero means uncompressed JSON, which is what entries written
+// before flags were used hold.
+const (
+	flagJSON       uint32 = 0
+	flagGob        uint32 = 1
+	flagProtobuf   uint32 = 2
+	flagRaw        uint32 = 3 // RawValue bytes stored as-is
+	flagCompressed uint32 = 1 << 4
+	flagChecksum   uint32 = 1 << 5
+	flagEncrypted  uint32 = 1 << 6
+
+	flagEncodingMask uint32 = 0x0f
+)
+
+// ErrUnknownEncoding is returned when a cached item's flags name an encoding this version can't read.
+var ErrUnknownEncoding = errors.New("unrecognized cache item encoding flags")
+
+// ErrChecksumMismatch is returned when a cached value no longer matches the checksum stored with it.
+var ErrChecksumMismatch = errors.New("cache item checksum mismatch")
+
+// checksumSize is the length of the CRC32 appended to values stored with flagChecksum.
+const checksumSize = crc32.Size
+
+// RawValue is a value SetCache and AddCache store as-is rather than as JSON, e.g. pre-rendered
+// JSON or opaque bytes. It reads back into a *[]byte, *string, *RawValue or, if it is valid
+// JSON, a *json.RawMessage, but not through JSON-only reads such as GetMultiCache. A plain
+// []byte is stored as JSON like any other value.
+type RawValue []byte
+
+// encodeValue serializes a SetCache value: a RawValue is stored raw and everything else as JSON.
+func encodeValue(key string, value interface{}) ([]byte, uint32, error) {
+	if data, ok := value.(RawValue); ok {
+		return data, flagRaw, nil
+	}
+	data, err := marshalValue(key, value)
+	return data, flagJSON, err
+}
+
+// compressValue gzips data when it is at least CompressMinBytes long and compressing saves space.
+func (mc *MemcachedConfig) compressValue(data []byte, flags uint32) ([]byte, uint32) {
+	if mc.CompressMinBytes <= 0 || len(data) < mc.CompressMinBytes {
+		return data, flags
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil || writer.Close() != nil || buf.Len() >= len(data) {
+		return data, flags
+	}
+	return buf.Bytes(), flags | flagCompressed
+}
+
+// packValue prepares an encoded value for storage under key: compressed as compressValue decides,
+// followed by its CRC32 when Checksums is set, and encrypted when key's namespace is.
+func (mc *MemcachedConfig) packValue(key string, data []byte, flags uint32) ([]byte, uint32, error) {
+	data, flags = mc.compressValue(data, flags)
+	if mc.Checksums {
+		data = binary.BigEndian.AppendUint32(data[:len(data):len(data)], crc32.ChecksumIEEE(data))
+		flags |= flagChecksum
+	}
+	return mc.encryptValue(key, data, flags)
+}
+
+// inflateValue verifies the checksum and undoes compression of a value already decrypted by
+// decryptValue, returning the encoded value and its flags without flagChecksum or
+// flagCompressed. A value failing its checksum returns ErrChecksumMismatch.
+func inflateValue(data []byte, flags uint32) ([]byte, uint32, error) {
+	if flags&^(flagEncodingMask|flagCompressed|flagChecksum) != 0 {
+		return nil, flags, fmt.Errorf("%w: %#x", ErrUnknownEncoding, flags)
+	}
+	if flags&flagChecksum != 0 {
+		if len(data) < checksumSize {
+			return nil, flags, fmt.Errorf("%w: value too short", ErrChecksumMismatch)
+		}
+		payload, sum := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+			return nil, flags, ErrChecksumMismatch
+		}
+		data, flags = payload, flags&^flagChecksum
+	}
+	if flags&flagCompressed == 0 {
+		return data, flags, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, flags, fmt.Errorf("failed to decompress value: %w", err)
+	}
+	inflated, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, flags, fmt.Errorf("failed to decompress value: %w", err)
+	}
+	return inflated, flags &^ flagCompressed, nil
+}
+
+// openValue decrypts and inflates a value as stored under key, returning the encoded value and
+// its flags.
+func (mc *MemcachedConfig) openValue(key string, data []byte, flags uint32) ([]byte, uint32, error) {
+	data, flags, err := mc.decryptValue(key, data, flags)
+	if err != nil {
+		return nil, flags, err
+	}
+	return inflateValue(data, flags)
+}
+
+// unreadable reports whether err means a stored value is corrupted beyond use and should be
+// discarded: it failed its checksum or can't be decrypted.
+func unreadable(err error) bool {
+	return errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrUndecryptable)
+}
+
+// discardCorrupted deletes the entry under key after it failed its checksum or decryption, so it
+// is read as a miss and refilled rather than decoded into garbage. Entries encrypted with a key
+// version that isn't configured here are not corrupted and are left in place.
+func (mc *MemcachedConfig) discardCorrupted(key string, err error) {
+	mc.deserializeError(mc.fullKey(key), err)
+	if errors.Is(err, ErrUnknownKeyVersion) {
+		return
+	}
+	if err := mc.DeleteCache(key); err != nil {
+		log.Printf("Failed to delete corrupted cache entry %s: %v", mc.fullKey(key), err)
+	}
+}
+
+// decodeValue deserializes data into target with the decoder its flags select.
+func decodeValue(data []byte, flags uint32, target interface{}) error {
+	data, flags, err := inflateValue(data, flags)
+	if err != nil {
+		return err
+	}
+	switch flags {
+	case flagJSON:
+		return decodeUntagged(data, target)
+	case flagGob:
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+	case flagProtobuf:
+		message, ok := target.(proto.Message)
+		if !ok {
+			return fmt.Errorf("cannot protobuf-decode into %T: not a proto.Message", target)
+		}
+		return proto.Unmarshal(data, message)
+	case flagRaw:
+		switch target := target.(type) {
+		case *[]byte:
+			*target = append([]byte(nil), data...)
+			return nil
+		case *string:
+			*target = string(data)
+			return nil
+		case *RawValue:
+			*target = append(RawValue(nil), data...)
+			return nil
+		case *json.RawMessage:
+			// Raw values are often pre-encoded JSON, e.g. for callers memoizing reads as JSON
+			if !json.Valid(data) {
+				return fmt.Errorf("cannot decode raw value into %T: not valid JSON", target)
+			}
+			*target = append(json.RawMessage(nil), data...)
+			return nil
+		}
+		return fmt.Errorf("cannot decode raw value into %T: expected *[]byte, *string, *RawValue or *json.RawMessage", target)
+	}
+	return fmt.Errorf("%w: %#x", ErrUnknownEncoding, flags)
+}
+
+// jsonValue returns data as JSON, failing for values stored in a binary encoding.
+func jsonValue(data []byte, flags uint32) (json.RawMessage, error) {
+	data, flags, err := inflateValue(data, flags)
+	if err != nil {
+		return nil, err
+	}
+	if flags != flagJSON || hasLegacyFormatTag(data) {
+		return nil, fmt.Errorf("value with encoding flags %#x is not JSON", flags)
+	}
+	return data, nil
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ErrManifestDisabled is returned by ListKeys for a namespace without a manifest (see EnableManifest).
+var ErrManifestDisabled = errors.New("manifest is not enabled for namespace")
+
+// errManifestFull is logged when a key can't be recorded because its manifest is at capacity.
+var errManifestFull = errors.New("manifest is full, key not tracked")
+
+// defaultManifestMaxKeys keeps a manifest of long keys well under Memcached's 1MB item limit.
+const defaultManifestMaxKeys = 10000
+
+// defaultManifestShards keeps each manifest item to a few hundred keys, so the read every
+// tracked write makes stays small.
+const defaultManifestShards = 16
+
+// manifestShardKey returns the key holding shard n of the manifest of a namespace.
+func manifestShardKey(namespace string, n int) string {
+	return BuildKey("manifest", namespace, strconv.Itoa(n))
+}
+
+// manifestShards returns the number of shards a manifest is split across, at least one.
+func (mc *MemcachedConfig) manifestShards() int {
+	if mc.ManifestShards < 1 {
+		return 1
+	}
+	return mc.ManifestShards
+}
+
+// manifestShardKeys returns the keys of every shard of the manifest of a namespace.
+func (mc *MemcachedConfig) manifestShardKeys(namespace string) []string {
+	keys := make([]string, mc.manifestShards())
+	for n := range keys {
+		keys[n] = manifestShardKey(namespace, n)
+	}
+	return keys
+}
+
+// manifestShardOf returns the manifest shard key is recorded in.
+func (mc *MemcachedConfig) manifestShardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(mc.manifestShards()))
+}
+
+// EnableManifest makes writes to keys in namespace (their first key segment, e.g. "api" for
+// "api:price:btc") record the key in a manifest stored in the cache, so InvalidateManifest can
+// later delete them without scanning. The manifest is split by key hash across ManifestShards
+// items. It is a best-effort alternative to namespace versioning:
+//
+//   - Every tracked write costs an extra read of its manifest shard, plus a compare-and-swap
+//     the first time the key is recorded; rewriting a recorded key writes nothing more.
+//   - The manifest holds at most ManifestMaxKeys keys, spread evenly across its shards; keys
+//     written once their shard is full (or while the shard update keeps losing CAS races) are
+//     not tracked and survive invalidation.
+//   - If a shard is evicted or expires, the keys it listed are forgotten. Changing
+//     ManifestShards forgets every key recorded before the change.
+//   - A key written while InvalidateManifest runs may be dropped from the new manifest or
+//     survive the invalidation.
+func (mc *MemcachedConfig) EnableManifest(namespace string) {
+	mc.manifestMu.Lock()
+	defer mc.manifestMu.Unlock()
+	if mc.manifests == nil {
+		mc.manifests = make(map[string]bool)
+	}
+	mc.manifests[namespace] = true
+}
+
+// manifestNamespace returns the manifest-enabled namespace of key, if any.
+func (mc *MemcachedConfig) manifestNamespace(key string) (string, bool) {
+	mc.manifestMu.RLock()
+	defer mc.manifestMu.RUnlock()
+	if len(mc.manifests) == 0 {
+		return "", false
+	}
+	namespace := KeyPrefix(key, "")
+	return namespace, mc.manifests[namespace]
+}
+
+// trackManifest records key in its namespace's manifest when manifests are enabled for it.
+func (mc *MemcachedConfig) trackManifest(key string) {
+	namespace, ok := mc.manifestNamespace(key)
+	if !ok {
+		return
+	}
+	itemKey := mc.fullKey(manifestShardKey(namespace, mc.manifestShardOf(key)))
+	shardMaxKeys := (mc.ManifestMaxKeys + mc.manifestShards() - 1) / mc.manifestShards()
+	expiration := int32(namespaceVersionTTL.Seconds())
+
+	for attempt := 0; attempt <= mc.CASMaxRetries; attempt++ {
+		item, err := mc.Client.Get(itemKey)
+		if err == memcache.ErrCacheMiss {
+			data, _ := json.Marshal([]string{key})
+			err = mc.Client.Add(&memcache.Item{Key: itemKey, Value: data, Expiration: expiration})
+			if err == memcache.ErrNotStored {
+				continue
+			}
+			if err != nil {
+				mc.backendError("update cache manifest", itemKey, err)
+			}
+			return
+		}
+		if err != nil {
+			mc.backendError("update cache manifest", itemKey, err)
+			return
+		}
+
+		var keys []string
+		if err := json.Unmarshal(item.Value, &keys); err != nil {
+			mc.deserializeError(itemKey, err)
+			return
+		}
+		for _, existing := range keys {
+			if existing == key {
+				return // Already recorded, nothing to write
+			}
+		}
+		if len(keys) >= shardMaxKeys {
+			mc.logError("update cache manifest", itemKey, errManifestFull)
+			return
+		}
+
+		item.Value, _ = json.Marshal(append(keys, key))
+		item.Expiration = expiration
+		err = mc.Client.CompareAndSwap(item)
+		if err == memcache.ErrCASConflict || err == memcache.ErrCacheMiss || err == memcache.ErrNotStored {
+			continue
+		}
+		if err != nil {
+			mc.backendError("update cache manifest", itemKey, err)
+		}
+		return
+	}
+	log.Printf("Gave up recording key %s in cache manifest %s after conflicting writes", key, itemKey)
+}
+
+// InvalidateManifest deletes every key recorded in namespace's manifest, then the manifest
+// itself, returning the number of keys deleted. See EnableManifest for its limitations.
+func (mc *MemcachedConfig) InvalidateManifest(namespace string) (int, error) {
+	shardKeys, keys, err := mc.manifestKeys(namespace)
+	if err != nil {
+		return 0, err
+	}
+	if len(shardKeys) == 0 {
+		return 0, nil
+	}
+
+	// Drop the manifest first so writes from here on start a fresh one
+	if failed, err := mc.DeleteMultiCache(shardKeys); err != nil {
+		log.Printf("Failed to delete %d shards of cache manifest %s: %v", len(failed), namespace, err)
+		return 0, err
+	}
+	failed, err := mc.DeleteMultiCache(keys)
+	if err != nil {
+		log.Printf("Failed to delete %d keys from cache manifest %s: %v", len(failed), namespace, err)
+	}
+	deleted := len(keys) - len(failed)
+	log.Printf("Invalidated %d keys from cache manifest %s", deleted, namespace)
+	return deleted, nil
+}
+
+// manifestKeys reads every shard of namespace's manifest, returning the keys of the shards
+// found and the keys recorded in them, sorted.
+func (mc *MemcachedConfig) manifestKeys(namespace string) ([]string, []string, error) {
+	shards, err := mc.GetMultiCache(mc.manifestShardKeys(namespace))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shardKeys := make([]string, 0, len(shards))
+	keys := []string{}
+	for shardKey, data := range shards {
+		var shard []string
+		if err := json.Unmarshal(data, &shard); err != nil {
+			mc.deserializeError(mc.fullKey(shardKey), err)
+			continue
+		}
+		shardKeys = append(shardKeys, shardKey)
+		keys = append(keys, shard...)
+	}
+	sort.Strings(shardKeys)
+	sort.Strings(keys)
+	return shardKeys, keys, nil
+}
+
+// ListKeys returns the keys recorded in namespace's manifest, sorted. It is best-effort, with
+// the same caveats as EnableManifest: the list can be stale in both directions. Keys that
+// have since expired, been evicted or been deleted individually are still listed, and keys
+// written while their shard was full, lost repeated CAS races or were written before their
+// shard was evicted are missing. An empty list means nothing is recorded, not that the
+// namespace is empty.
+func (mc *MemcachedConfig) ListKeys(namespace string) ([]string, error) {
+	mc.manifestMu.RLock()
+	enabled := mc.manifests[namespace]
+	mc.manifestMu.RUnlock()
+	if !enabled {
+		return nil, fmt.Errorf("%w %s", ErrManifestDisabled, namespace)
+	}
+
+	_, keys, err := mc.manifestKeys(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
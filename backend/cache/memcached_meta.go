@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// CacheMeta describes when a cached value was stored and how long it has left.
+type CacheMeta struct {
+	StoredAt     time.Time     // When the value was stored
+	TTL          time.Duration // Expiration the value was stored with, zero if unknown
+	RemainingTTL time.Duration // Time until the value expires, zero if unknown or already expired
+}
+
+// metaEnvelope wraps a value with its storage metadata. It shares the {"data", "stored_at"}
+// layout of the API's cache-aside entries, so GetCacheWithMeta can read those too.
+type metaEnvelope struct {
+	Data       json.RawMessage `json:"data"`
+	StoredAt   time.Time       `json:"stored_at"`
+	TTLSeconds int64           `json:"ttl_seconds,omitempty"`
+	Stale      bool            `json:"stale,omitempty"` // Set by SoftDelete
+}
+
+// envelopePrefix starts every serialized metaEnvelope, letting reads skip decoding plain values.
+var envelopePrefix = []byte(`{"data":`)
+
+// decodeEnvelope decodes data as a metaEnvelope, reporting false for plain values.
+func decodeEnvelope(data []byte) (metaEnvelope, bool) {
+	var envelope metaEnvelope
+	if !bytes.HasPrefix(data, envelopePrefix) || json.Unmarshal(data, &envelope) != nil || envelope.Data == nil {
+		return metaEnvelope{}, false
+	}
+	if envelope.StoredAt.IsZero() && !envelope.Stale {
+		return metaEnvelope{}, false
+	}
+	return envelope, true
+}
+
+// softDeleted reports whether data is an envelope marked stale by SoftDelete.
+func softDeleted(data []byte) bool {
+	envelope, ok := decodeEnvelope(data)
+	return ok && envelope.Stale
+}
+
+// SetCacheWithMeta stores value wrapped with its storage time and TTL for GetCacheWithMeta.
+// A zero expiration uses DefaultExpiry.
+func (mc *MemcachedConfig) SetCacheWithMeta(key string, value interface{}, expiration time.Duration) error {
+	data, err := marshalValue(mc.fullKey(key), value)
+	if err != nil {
+		mc.serializeError(mc.fullKey(key), err)
+		return err
+	}
+	if expiration == 0 {
+		expiration = mc.DefaultExpiry
+	}
+	return mc.SetCache(key, metaEnvelope{
+		Data:       data,
+		StoredAt:   time.Now(),
+		TTLSeconds: int64(expiration / time.Second),
+	}, expiration)
+}
+
+// GetCacheWithMeta retrieves a value into target along with its storage metadata, saving a
+// separate lookup for handlers that report data freshness (e.g. "dataAsOf"). Values stored
+// without an envelope (e.g. by SetCache) are returned with zero metadata.
+func (mc *MemcachedConfig) GetCacheWithMeta(key string, target interface{}) (CacheMeta, bool, error) {
+	data, flags, found, err := mc.getData(key)
+	if !found || err != nil {
+		return CacheMeta{}, false, err
+	}
+
+	envelope, ok := decodeEnvelope(data)
+	if !ok {
+		if err := decodeValue(data, flags, target); err != nil {
+			mc.deserializeError(mc.fullKey(key), err)
+			return CacheMeta{}, false, err
+		}
+		return CacheMeta{}, true, nil
+	}
+	if err := json.Unmarshal(envelope.Data, target); err != nil {
+		mc.deserializeError(mc.fullKey(key), err)
+		return CacheMeta{}, false, err
+	}
+
+	meta := CacheMeta{
+		StoredAt: envelope.StoredAt,
+		TTL:      time.Duration(envelope.TTLSeconds) * time.Second,
+	}
+	if meta.TTL > 0 {
+		if remaining := time.Until(meta.StoredAt.Add(meta.TTL)); remaining > 0 {
+			meta.RemainingTTL = remaining
+		}
+	}
+	return meta, true, nil
+}
+
+// SoftDelete invalidates key with a grace period: instead of removing the value it marks it stale
+// and shortens its TTL to graceTTL. GetAllowStale callers keep getting the value, flagged stale,
+// until it expires or is replaced, while GetCache callers see a miss. Absent keys are ignored.
+//
+// The rewrite uses CAS so a fresh value written concurrently is never marked stale. Like
+// GetAndSet it is not available while the fallback cache is serving. Values stored in a binary
+// encoding can't be wrapped in the stale envelope and are deleted instead.
+func (mc *MemcachedConfig) SoftDelete(key string, graceTTL time.Duration) error {
+	fullKey := mc.fullKey(key)
+	if mc.fallbackActive() {
+		mc.recordOperation("soft_delete", fullKey, resultError)
+		return ErrCircuitOpen
+	}
+	graceSeconds := int32(graceTTL.Seconds())
+	if graceSeconds < 1 {
+		graceSeconds = 1
+	}
+
+	for attempt := 0; attempt <= mc.CASMaxRetries; attempt++ {
+		item, err := mc.Client.Get(fullKey)
+		mc.recordResult(err)
+		if err == memcache.ErrCacheMiss {
+			mc.recordOperation("soft_delete", fullKey, resultMiss)
+			return nil
+		}
+		if err != nil {
+			mc.recordOperation("soft_delete", fullKey, resultError)
+			mc.backendError("soft delete cache", fullKey, err)
+			return err
+		}
+
+		opened, flags, err := mc.decryptValue(key, item.Value, item.Flags)
+		if err != nil {
+			return mc.DeleteCache(key)
+		}
+		value, err := jsonValue(opened, flags)
+		if err != nil {
+			return mc.DeleteCache(key)
+		}
+		envelope, ok := decodeEnvelope(value)
+		if !ok {
+			envelope = metaEnvelope{Data: value}
+		}
+		if envelope.Stale {
+			mc.recordOperation("soft_delete", fullKey, resultHit)
+			return nil
+		}
+		envelope.Stale = true
+		envelope.TTLSeconds = int64(graceSeconds)
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		data, flags, err = mc.packValue(key, data, flagJSON)
+		if err != nil {
+			return err
+		}
+
+		item.Value = data
+		item.Flags = flags
+		item.Expiration = graceSeconds
+		err = mc.Client.CompareAndSwap(item)
+		mc.recordResult(err)
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+			continue
+		}
+		if err == memcache.ErrCacheMiss {
+			mc.recordOperation("soft_delete", fullKey, resultMiss)
+			return nil
+		}
+		if err != nil {
+			mc.recordOperation("soft_delete", fullKey, resultError)
+			mc.backendError("soft delete cache", fullKey, err)
+			return err
+		}
+		mc.recordOperation("soft_delete", fullKey, resultOK)
+		mc.storeFallback(fullKey, data, flags, time.Duration(graceSeconds)*time.Second)
+		return nil
+	}
+
+	mc.recordOperation("soft_delete", fullKey, resultError)
+	log.Printf("Gave up soft-deleting key %s after %d conflicting writes", fullKey, mc.CASMaxRetries+1)
+	return ErrCASRetriesExhausted
+}
+
+// GetAllowStale retrieves a value into target like GetCache, but also returns values marked stale
+// by SoftDelete, reporting them through stale.
+func (mc *MemcachedConfig) GetAllowStale(key string, target interface{}) (found bool, stale bool, err error) {
+	data, flags, _, found, err := mc.readData(key)
+	if !found || err != nil {
+		return false, false, err
+	}
+	if envelope, ok := decodeEnvelope(data); ok {
+		data, flags, stale = envelope.Data, flagJSON, envelope.Stale
+	}
+	if err := decodeValue(data, flags, target); err != nil {
+		mc.deserializeError(mc.fullKey(key), err)
+		return false, false, err
+	}
+	return true, stale, nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cache operation results recorded in CacheOperationsTotal.
+const (
+	resultHit   = "hit"
+	resultMiss  = "miss"
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+// otherKeyPrefix labels keys without a usable top-level segment, keeping label cardinality bounded.
+const otherKeyPrefix = "other"
+
+// CacheOperationsTotal counts cache operations by operation, key prefix (data domain) and result.
+// It must be registered by the application (e.g. prometheus.MustRegister(config.CacheOperationsTotal)).
+var CacheOperationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_operations_total",
+		Help: "Total number of cache operations, partitioned by operation, key prefix and result.",
+	},
+	[]string{"operation", "prefix", "result"},
+)
+
+// CacheSerializationErrorsTotal counts values that failed to serialize or deserialize (bad data),
+// and CacheBackendErrorsTotal counts failed Memcached calls (infrastructure). Both must be
+// registered by the application like CacheOperationsTotal.
+var (
+	CacheSerializationErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_serialization_errors_total",
+			Help: "Total number of cache values that failed to serialize or deserialize, partitioned by direction and key prefix.",
+		},
+		[]string{"direction", "prefix"},
+	)
+	CacheBackendErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_backend_errors_total",
+			Help: "Total number of failed Memcached calls, partitioned by operation and key prefix.",
+		},
+		[]string{"operation", "prefix"},
+	)
+)
+
+// KeyPrefix returns the top-level prefix of key (its first colon-delimited segment, e.g. "api"
+// or "blockchain"), ignoring a leading envPrefix. Keys without a colon are labeled "other".
+func KeyPrefix(key string, envPrefix string) string {
+	if envPrefix != "" {
+		key = strings.TrimPrefix(key, envPrefix+":")
+	}
+	i := strings.IndexByte(key, ':')
+	if i <= 0 {
+		return otherKeyPrefix
+	}
+	return key[:i]
+}
+
+// recordOperation counts a cache operation against the prefix of key.
+func (mc *MemcachedConfig) recordOperation(operation string, key string, result string) {
+	CacheOperationsTotal.WithLabelValues(operation, KeyPrefix(key, mc.KeyPrefix), result).Inc()
+}
+
+// serializeError records and logs a value that could not be serialized for key.
+// err comes from marshalValue or encodeBlockchainValue and already names the key.
+func (mc *MemcachedConfig) serializeError(key string, err error) {
+	CacheSerializationErrorsTotal.WithLabelValues("serialize", KeyPrefix(key, mc.KeyPrefix)).Inc()
+	log.Printf("Cache serialization error: %v", err)
+}
+
+// deserializeError records and logs a stored value under key that could not be deserialized.
+func (mc *MemcachedConfig) deserializeError(key string, err error) {
+	CacheSerializationErrorsTotal.WithLabelValues("deserialize", KeyPrefix(key, mc.KeyPrefix)).Inc()
+	log.Printf("Cache serialization error: failed to deserialize value for key %s: %v", key, err)
+}
+
+// backendError records and logs a failed Memcached call, marking the logged error as a
+// backend failure to tell it apart from serialization errors.
+func (mc *MemcachedConfig) backendError(operation string, key string, err error) {
+	CacheBackendErrorsTotal.WithLabelValues(operation, KeyPrefix(key, mc.KeyPrefix)).Inc()
+	mc.logError(operation, key, fmt.Errorf("memcached backend error: %w", err))
+}
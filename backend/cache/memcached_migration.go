@@ -0,0 +1,44 @@
+package config
+
+import (
+	"log"
+	"time"
+)
+
+// GetWithMigration reads newKey into target, falling back to oldKey on a miss so a key format
+// change (e.g. adding a schema version) migrates hot entries lazily instead of cold-starting.
+// A value found under oldKey is copied to newKey with ttl (zero uses DefaultExpiry) and, when
+// MigrationDeleteOld is set, oldKey is then deleted. Both keys are relative to KeyPrefix.
+//
+// Failing to copy or delete is logged but doesn't fail the read; the next read retries it.
+func (mc *MemcachedConfig) GetWithMigration(oldKey, newKey string, target interface{}, ttl time.Duration) (bool, error) {
+	found, err := mc.GetCache(newKey, target)
+	if found || err != nil {
+		return found, err
+	}
+
+	data, flags, found, err := mc.getData(oldKey)
+	if !found || err != nil {
+		return false, err
+	}
+	if err := decodeValue(data, flags, target); err != nil {
+		mc.deserializeError(mc.fullKey(oldKey), err)
+		return false, err
+	}
+
+	// Re-pack for newKey: encrypted values are bound to their key
+	data, flags, err = mc.packValue(newKey, data, flags)
+	if err == nil {
+		err = mc.setData(newKey, data, flags, ttl)
+	}
+	if err != nil {
+		log.Printf("Failed to migrate cache key %s to %s: %v", mc.fullKey(oldKey), mc.fullKey(newKey), err)
+		return true, nil
+	}
+	if mc.MigrationDeleteOld {
+		if err := mc.DeleteCache(oldKey); err != nil {
+			log.Printf("Failed to delete migrated cache key %s: %v", mc.fullKey(oldKey), err)
+		}
+	}
+	return true, nil
+}
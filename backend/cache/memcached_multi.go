@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMultiGetConcurrency caps how many multi-get chunks are in flight at once.
+const maxMultiGetConcurrency = 4
+
+// maxMultiDeleteConcurrency caps how many deletes DeleteMultiCache has in flight at once.
+const maxMultiDeleteConcurrency = 8
+
+// chunkKeys splits keys into consecutive chunks of at most size keys.
+func chunkKeys(keys []string, size int) [][]string {
+	if size <= 0 {
+		size = len(keys)
+	}
+	var chunks [][]string
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunks = append(chunks, keys[start:end])
+	}
+	return chunks
+}
+
+// cachedItem is a value read by a multi-get together with the item flags recording its encoding.
+type cachedItem struct {
+	data  []byte
+	flags uint32
+}
+
+// GetMultiCache retrieves several keys at once, returning the raw JSON value of each key found.
+// Soft-deleted values are treated as misses, and so are values stored in a binary encoding.
+// Requests larger than MultiGetChunkSize are split into chunks issued concurrently and merged.
+// If some chunks fail, the values from the successful chunks are still returned together
+// with an error describing the failures.
+func (mc *MemcachedConfig) GetMultiCache(keys []string) (map[string]json.RawMessage, error) {
+	items, err := mc.getMultiItems(keys)
+	results := make(map[string]json.RawMessage, len(items))
+	for key, item := range items {
+		data, jsonErr := jsonValue(item.data, item.flags)
+		if jsonErr != nil {
+			mc.deserializeError(mc.fullKey(key), jsonErr)
+			continue
+		}
+		results[key] = data
+	}
+	return results, err
+}
+
+// getMultiItems retrieves several keys at once like GetMultiCache, returning each value found,
+// decrypted, with its item flags. Values that can't be decrypted are treated as misses.
+func (mc *MemcachedConfig) getMultiItems(keys []string) (map[string]cachedItem, error) {
+	results := make(map[string]cachedItem, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	// Map full (environment-prefixed) keys back to the caller's keys
+	fullKeys := make([]string, len(keys))
+	original := make(map[string]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = mc.fullKey(key)
+		original[fullKeys[i]] = key
+	}
+
+	// Serve reads from the in-memory fallback while the Memcached circuit is open
+	if mc.fallbackActive() {
+		for _, fullKey := range fullKeys {
+			data, flags, found := mc.fallback.GetWithFlags(fullKey)
+			if !found {
+				continue
+			}
+			if data, flags, err := mc.decryptValue(original[fullKey], data, flags); err == nil && !softDeleted(data) {
+				results[original[fullKey]] = cachedItem{data: data, flags: flags}
+			}
+		}
+		return results, nil
+	}
+
+	var mu sync.Mutex
+	var failures []string
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxMultiGetConcurrency)
+
+	chunks := chunkKeys(fullKeys, mc.MultiGetChunkSize)
+	for _, chunk := range chunks {
+		wg.Add(1)
+		go func(chunk []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			items, err := mc.Client.GetMulti(chunk)
+			mc.observeServersLatency("get_multi", chunk, start)
+			mc.recordResult(err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, fullKey := range chunk {
+					mc.recordOperation("get_multi", fullKey, resultError)
+				}
+				mc.backendError("get multi cache", chunk[0], err)
+				failures = append(failures, fmt.Sprintf("%d keys starting at %s: %v", len(chunk), chunk[0], err))
+				return
+			}
+			for _, fullKey := range chunk {
+				item, found := items[fullKey]
+				if !found {
+					mc.recordOperation("get_multi", fullKey, resultMiss)
+					continue
+				}
+				data, flags, openErr := mc.decryptValue(original[fullKey], item.Value, item.Flags)
+				if openErr != nil {
+					mc.recordOperation("get_multi", fullKey, resultMiss)
+					mc.deserializeError(fullKey, openErr)
+					continue
+				}
+				if softDeleted(data) {
+					mc.recordOperation("get_multi", fullKey, resultMiss)
+					continue
+				}
+				mc.recordOperation("get_multi", fullKey, resultHit)
+				results[original[fullKey]] = cachedItem{data: data, flags: flags}
+				mc.storeFallback(fullKey, item.Value, item.Flags, mc.FallbackTTL)
+			}
+		}(chunk)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return results, fmt.Errorf("failed to get %d of %d chunks: %s", len(failures), len(chunks), strings.Join(failures, "; "))
+	}
+	return results, nil
+}
+
+// DeleteMultiCache deletes several keys, issuing up to maxMultiDeleteConcurrency deletes at once.
+// Keys that are already absent count as deleted. It returns the keys that failed to delete, in
+// input order, together with an error summarizing the failures.
+func (mc *MemcachedConfig) DeleteMultiCache(keys []string) ([]string, error) {
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxMultiDeleteConcurrency)
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = mc.DeleteCache(key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	var failed []string
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed = append(failed, keys[i])
+	}
+	if len(failed) > 0 {
+		return failed, fmt.Errorf("failed to delete %d of %d keys, first error: %w", len(failed), len(keys), firstErr)
+	}
+	return nil, nil
+}
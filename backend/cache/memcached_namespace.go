@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheNamespaceOperationsTotal counts operations on namespace handles by namespace name,
+// operation and result. Only names passed to Namespace are used as labels, never keys, so
+// cardinality stays bounded. It must be registered by the application like CacheOperationsTotal.
+var CacheNamespaceOperationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_namespace_operations_total",
+		Help: "Total number of cache namespace operations, partitioned by namespace, operation and result.",
+	},
+	[]string{"namespace", "operation", "result"},
+)
+
+// namespaceVersionTTL keeps namespace version keys for the longest relative expiry Memcached supports.
+const namespaceVersionTTL = 30 * 24 * time.Hour
+
+// CacheNamespace is a scoped cache handle for one logical cache (e.g. user profiles, feature flags).
+// Keys are automatically prefixed with the namespace name and its current version, and
+// operations default to the namespace's TTL.
+//
+// Memcached cannot enumerate keys, so Invalidate bumps the namespace version instead of deleting
+// entries: keys written under the old version are no longer addressed and simply expire. Each
+// operation reads the version key first, costing one extra round-trip.
+type CacheNamespace struct {
+	mc         *MemcachedConfig
+	name       string
+	defaultTTL time.Duration
+}
+
+// Namespace returns a cache handle scoped to name; a zero defaultTTL falls back to the client's DefaultExpiry.
+func (mc *MemcachedConfig) Namespace(name string, defaultTTL time.Duration) *CacheNamespace {
+	return &CacheNamespace{
+		mc:         mc,
+		name:       name,
+		defaultTTL: defaultTTL,
+	}
+}
+
+// Name returns the namespace name.
+func (ns *CacheNamespace) Name() string {
+	return ns.name
+}
+
+// record counts an operation on the namespace; err non-nil records an error.
+func (ns *CacheNamespace) record(operation string, result string, err error) {
+	if err != nil {
+		result = resultError
+	}
+	CacheNamespaceOperationsTotal.WithLabelValues(ns.name, operation, result).Inc()
+}
+
+// versionKey returns the key holding the namespace's current version.
+func (ns *CacheNamespace) versionKey() string {
+	return BuildKey("ns", ns.name, "version")
+}
+
+// version returns the current namespace version, starting a new one if none is stored
+// (e.g. on first use or after the version key was evicted, which safely orphans old entries).
+// The new version is created only if absent, so concurrent callers all adopt the same one
+// rather than each writing keys under a version the last writer then replaces. While the
+// fallback cache is serving, the version is set in it directly.
+func (ns *CacheNamespace) version() (int64, error) {
+	for attempt := 0; attempt <= ns.mc.CASMaxRetries; attempt++ {
+		var version int64
+		found, err := ns.mc.GetCache(ns.versionKey(), &version)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read version of cache namespace %s: %v", ns.name, err)
+		}
+		if found {
+			return version, nil
+		}
+
+		version = time.Now().UnixNano()
+		stored, err := ns.mc.AddCache(ns.versionKey(), version, namespaceVersionTTL)
+		if errors.Is(err, ErrCircuitOpen) {
+			return ns.bumpVersion()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to store version of cache namespace %s: %v", ns.name, err)
+		}
+		if stored {
+			return version, nil
+		}
+		// Another caller created the version first: read theirs
+	}
+	return 0, fmt.Errorf("failed to read version of cache namespace %s: %w", ns.name, ErrCASRetriesExhausted)
+}
+
+// bumpVersion stores a new namespace version, invalidating every key written under the previous one.
+func (ns *CacheNamespace) bumpVersion() (int64, error) {
+	version := time.Now().UnixNano()
+	if err := ns.mc.SetCache(ns.versionKey(), version, namespaceVersionTTL); err != nil {
+		return 0, fmt.Errorf("failed to store version of cache namespace %s: %v", ns.name, err)
+	}
+	return version, nil
+}
+
+// Key returns the full Memcached key for a key within the namespace.
+func (ns *CacheNamespace) Key(key string) (string, error) {
+	version, err := ns.version()
+	if err != nil {
+		return "", err
+	}
+	return BuildKey(ns.name, strconv.FormatInt(version, 36), key), nil
+}
+
+// Set stores a value in the namespace; a zero ttl uses the namespace default.
+func (ns *CacheNamespace) Set(key string, value interface{}, ttl time.Duration) error {
+	fullKey, err := ns.Key(key)
+	if err != nil {
+		ns.record("set", resultError, err)
+		return err
+	}
+	if ttl == 0 {
+		ttl = ns.defaultTTL
+	}
+	err = ns.mc.SetCache(fullKey, value, ttl)
+	ns.record("set", resultOK, err)
+	return err
+}
+
+// Get retrieves a value from the namespace into target.
+func (ns *CacheNamespace) Get(key string, target interface{}) (bool, error) {
+	fullKey, err := ns.Key(key)
+	if err != nil {
+		ns.record("get", resultError, err)
+		return false, err
+	}
+	found, err := ns.mc.GetCache(fullKey, target)
+	result := resultMiss
+	if found {
+		result = resultHit
+	}
+	ns.record("get", result, err)
+	return found, err
+}
+
+// Delete removes a key from the namespace.
+func (ns *CacheNamespace) Delete(key string) error {
+	fullKey, err := ns.Key(key)
+	if err != nil {
+		ns.record("delete", resultError, err)
+		return err
+	}
+	err = ns.mc.DeleteCache(fullKey)
+	ns.record("delete", resultOK, err)
+	return err
+}
+
+// Invalidate drops every entry in the namespace without affecting other namespaces.
+func (ns *CacheNamespace) Invalidate() error {
+	if _, err := ns.bumpVersion(); err != nil {
+		return err
+	}
+	log.Printf("Invalidated cache namespace %s", ns.name)
+	return nil
+}
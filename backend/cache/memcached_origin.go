@@ -0,0 +1,160 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrOriginBusy is returned when an origin fetch can't get a slot in the origin budget in time.
+// HTTP handlers should answer it with 503 Service Unavailable.
+var ErrOriginBusy = errors.New("origin fetch budget exhausted")
+
+// originBudget bounds the number of concurrent origin fetches, so a cache outage that turns
+// every request into a miss doesn't stampede the systems behind the cache.
+type originBudget struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// newOriginBudget allows concurrency simultaneous fetches; excess callers wait up to wait for a slot.
+// A non-positive concurrency disables the limit.
+func newOriginBudget(concurrency int, wait time.Duration) *originBudget {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &originBudget{slots: make(chan struct{}, concurrency), wait: wait}
+}
+
+// acquire takes a slot, waiting at most the budget's wait time, and returns the function releasing it.
+func (b *originBudget) acquire(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return func() { <-b.slots }, nil
+	default:
+	}
+	if b.wait <= 0 {
+		return nil, ErrOriginBusy
+	}
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return func() { <-b.slots }, nil
+	case <-timer.C:
+		return nil, ErrOriginBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// maxOriginTTL caps origin TTLs: Memcached reads expirations over 30 days as Unix timestamps.
+const maxOriginTTL = 30 * 24 * time.Hour
+
+// LoadedValue can be returned by an origin loader to give the entry its own TTL, e.g. the
+// max-age of the origin's response (see TTLFromCacheControl). The TTL can only shorten the
+// caller's expiration; a zero TTL keeps it, and a negative TTL means the origin marked the
+// value uncacheable, so it is returned to the caller but not stored.
+type LoadedValue struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
+// UnwrapLoaded returns the loaded value and the expiration to store it with, and whether it
+// should be stored at all.
+func UnwrapLoaded(value interface{}, expiration time.Duration) (interface{}, time.Duration, bool) {
+	loaded, ok := value.(LoadedValue)
+	if !ok {
+		return value, expiration, true
+	}
+	if loaded.TTL < 0 {
+		return loaded.Value, 0, false
+	}
+	if loaded.TTL > 0 && (expiration <= 0 || loaded.TTL < expiration) {
+		expiration = loaded.TTL
+	}
+	return loaded.Value, expiration, true
+}
+
+// TTLFromCacheControl returns the TTL allowed by an origin's Cache-Control header, preferring
+// s-maxage over max-age since this is a shared cache. It returns 0 when the header sets no
+// lifetime and -1 when it forbids shared caching (no-store, no-cache or private), matching
+// LoadedValue's TTL semantics. Lifetimes are capped at 30 days.
+func TTLFromCacheControl(header string) time.Duration {
+	maxAge, sMaxAge := -1, -1
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return -1
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds < 0 {
+				continue
+			}
+			if strings.EqualFold(name, "s-maxage") {
+				sMaxAge = seconds
+			} else {
+				maxAge = seconds
+			}
+		}
+	}
+	seconds := maxAge
+	if sMaxAge >= 0 {
+		seconds = sMaxAge
+	}
+	switch {
+	case seconds == 0:
+		// A zero lifetime means the response is stale immediately
+		return -1
+	case seconds > int(maxOriginTTL/time.Second):
+		return maxOriginTTL
+	case seconds > 0:
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// loadFromOrigin calls load within the origin budget.
+func (mc *MemcachedConfig) loadFromOrigin(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	release, err := mc.origin.acquire(ctx)
+	if err != nil {
+		log.Printf("Origin fetch for key %s rejected: %v", key, err)
+		return nil, err
+	}
+	defer release()
+	return load(ctx)
+}
+
+// GetOrLoad reads key into target, loading it from the origin and caching it on a miss.
+// Cache errors are treated as misses; origin loads are bounded by the origin budget
+// (OriginConcurrency and OriginWait) and fail with ErrOriginBusy when it is exhausted.
+// A loader returning a LoadedValue sets the entry's TTL instead of expiration.
+func (mc *MemcachedConfig) GetOrLoad(ctx context.Context, key string, target interface{}, expiration time.Duration, load func(ctx context.Context) (interface{}, error)) error {
+	if found, err := mc.GetCache(key, target); found && err == nil {
+		return nil
+	}
+
+	value, err := mc.loadFromOrigin(ctx, key, load)
+	if err != nil {
+		return err
+	}
+	value, expiration, store := UnwrapLoaded(value, expiration)
+	data, err := marshalValue(key, value)
+	if err != nil {
+		return err
+	}
+	// A failed write (e.g. during an outage) still returns the loaded value
+	if store {
+		mc.SetCache(key, value, expiration)
+	}
+	return json.Unmarshal(data, target)
+}
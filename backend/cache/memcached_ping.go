@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// maxPingBackoff caps the wait between startup ping attempts.
+const maxPingBackoff = 5 * time.Second
+
+// ErrProbeMismatch is returned by Probe when the value read back isn't the one it wrote.
+var ErrProbeMismatch = errors.New("probe value not read back")
+
+// PingWithRetry pings Memcached up to PingAttempts times, waiting PingBackoff after the first
+// failure and doubling the wait (up to maxPingBackoff) after each further one, so startup
+// tolerates Memcached coming up shortly after the service. It gives up early once ctx is done.
+func (mc *MemcachedConfig) PingWithRetry(ctx context.Context) error {
+	attempts := mc.PingAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := mc.PingBackoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = mc.Client.Ping(); err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to Memcached on attempt %d/%d", attempt, attempts)
+			}
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		log.Printf("Memcached ping attempt %d/%d failed, retrying in %v: %v", attempt, attempts, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("memcached not reachable before startup deadline after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+		backoff *= 2
+		if backoff > maxPingBackoff {
+			backoff = maxPingBackoff
+		}
+	}
+	return fmt.Errorf("memcached not reachable after %d attempts: %w", attempts, err)
+}
+
+// Probe writes a unique value under key straight to Memcached and reads it back, bypassing the
+// fallback cache, so it reports whether Memcached itself is serving even while the circuit
+// breaker has the fallback serving other operations. The result doesn't feed the breaker.
+func (mc *MemcachedConfig) Probe(key string, expiration time.Duration) error {
+	fullKey := mc.fullKey(key)
+	nonce := []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+	if err := mc.Client.Set(&memcache.Item{Key: fullKey, Value: nonce, Flags: flagRaw, Expiration: int32(expiration.Seconds())}); err != nil {
+		return fmt.Errorf("probe write failed: %w", err)
+	}
+	item, err := mc.Client.Get(fullKey)
+	if err == memcache.ErrCacheMiss {
+		return ErrProbeMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("probe read failed: %w", err)
+	}
+	if !bytes.Equal(item.Value, nonce) {
+		return ErrProbeMismatch
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults for the replication queue (see ReplicationQueueSize and ReplicationWorkers).
+const (
+	defaultReplicationQueueSize = 1024
+	defaultReplicationWorkers   = 4
+)
+
+// CacheReplicationDroppedTotal counts writes and deletes not replicated to the secondary because
+// the replication queue was full. It must be registered by the application like
+// CacheOperationsTotal.
+var CacheReplicationDroppedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_replication_dropped_total",
+		Help: "Total number of cache writes and deletes dropped instead of replicated because the replication queue was full, partitioned by operation.",
+	},
+	[]string{"operation"},
+)
+
+// replicatedOp is a write (or, with remove set, a delete) waiting to be applied to the secondary.
+type replicatedOp struct {
+	key        string
+	data       []byte
+	flags      uint32
+	expiration time.Duration
+	remove     bool
+}
+
+// replicate queues a write for the secondary region's cluster when replication is enabled.
+func (mc *MemcachedConfig) replicate(key string, data []byte, flags uint32, expiration time.Duration) {
+	mc.enqueueReplication(replicatedOp{key: key, data: data, flags: flags, expiration: expiration})
+}
+
+// replicateDelete queues a delete for the secondary region's cluster when replication is enabled.
+func (mc *MemcachedConfig) replicateDelete(key string) {
+	mc.enqueueReplication(replicatedOp{key: key, remove: true})
+}
+
+// enqueueReplication hands op to the replication workers, starting them on first use.
+// Replication is best effort: when the queue is full op is dropped and counted, so a slow or
+// unreachable secondary never holds up or piles goroutines onto the primary, and failures are
+// logged by the secondary and never reported to the caller.
+func (mc *MemcachedConfig) enqueueReplication(op replicatedOp) {
+	if !mc.ReplicateWrites || mc.SecondaryConfig == nil {
+		return
+	}
+	mc.replicationOnce.Do(mc.startReplication)
+
+	select {
+	case mc.replication <- op:
+	default:
+		operation := "set"
+		if op.remove {
+			operation = "delete"
+		}
+		CacheReplicationDroppedTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// startReplication creates the replication queue and starts its workers. On Close the workers
+// apply what is already queued before they stop.
+func (mc *MemcachedConfig) startReplication() {
+	size, workers := mc.ReplicationQueueSize, mc.ReplicationWorkers
+	if size <= 0 {
+		size = defaultReplicationQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultReplicationWorkers
+	}
+	mc.replication = make(chan replicatedOp, size)
+	for i := 0; i < workers; i++ {
+		mc.goBackground(func(ctx context.Context) {
+			for {
+				select {
+				case op := <-mc.replication:
+					mc.applyReplication(op)
+				case <-ctx.Done():
+					for {
+						select {
+						case op := <-mc.replication:
+							mc.applyReplication(op)
+						default:
+							return
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// applyReplication applies op to the secondary.
+func (mc *MemcachedConfig) applyReplication(op replicatedOp) {
+	if op.remove {
+		mc.SecondaryConfig.DeleteCache(op.key)
+		return
+	}
+	mc.SecondaryConfig.setData(op.key, op.data, op.flags, op.expiration)
+}
+
+// initSecondary configures write replication to the secondary region from MEMCACHED_SECONDARY_SERVERS.
+// An unreachable secondary is logged and replication stays enabled, since it is best effort.
+func initSecondary(config *MemcachedConfig) error {
+	serversEnv := os.Getenv("MEMCACHED_SECONDARY_SERVERS")
+	if serversEnv == "" {
+		return nil
+	}
+	servers, err := ParseMemcachedServers(serversEnv, config.MaxServers)
+	if err != nil {
+		log.Printf("Invalid MEMCACHED_SECONDARY_SERVERS: %v", err)
+		return err
+	}
+
+	secondary := DefaultMemcachedConfig()
+	secondary.Servers = servers
+	secondary.Timeout = config.Timeout
+	secondary.DefaultExpiry = config.DefaultExpiry
+	secondary.KeyPrefix = config.KeyPrefix
+	client := memcache.New(servers...)
+	client.Timeout = config.Timeout
+	secondary.Client = client
+	if err := secondary.Client.Ping(); err != nil {
+		log.Printf("Failed to connect to secondary Memcached, replication will retry per write: %v", err)
+	}
+
+	config.SecondaryConfig = secondary
+	config.ReplicateWrites = true
+	log.Printf("Replicating cache writes to secondary Memcached: %v", servers)
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scheduled warm-up metrics, partitioned by warmer name. They must be registered by the
+// application like CacheOperationsTotal.
+var (
+	CacheWarmLastRunTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_warm_last_run_timestamp_seconds",
+			Help: "Unix time at which each scheduled cache warmer last finished a run.",
+		},
+		[]string{"warmer"},
+	)
+	CacheWarmRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_warm_runs_total",
+			Help: "Total number of scheduled cache warm-up runs, partitioned by warmer and result.",
+		},
+		[]string{"warmer", "result"},
+	)
+)
+
+// ScheduleWarm runs warm every interval in the background until ctx is cancelled or the
+// client is closed, e.g. to keep the top-N blockchain addresses cached. The first run
+// happens one interval after scheduling (startup warm-up is expected to use Warm).
+// Runs never overlap: a tick that arrives while warm is still running is dropped.
+// It returns false if interval is not positive or the client is shutting down.
+func (mc *MemcachedConfig) ScheduleWarm(ctx context.Context, name string, interval time.Duration, warm func(ctx context.Context) error) bool {
+	if interval <= 0 {
+		return false
+	}
+	return mc.goBackground(func(bgCtx context.Context) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(bgCtx, cancel)
+		defer stop()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := warm(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			CacheWarmLastRunTimestamp.WithLabelValues(name).Set(float64(time.Now().Unix()))
+			if err != nil {
+				CacheWarmRunsTotal.WithLabelValues(name, resultError).Inc()
+				log.Printf("Scheduled cache warm-up %s failed: %v", name, err)
+				continue
+			}
+			CacheWarmRunsTotal.WithLabelValues(name, resultOK).Inc()
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CanCache reports whether value can be stored with SetCache, returning the serialization
+// error otherwise. Values containing channels, functions or cycles fail; use it in tests
+// to check that cached types serialize.
+func CanCache(value interface{}) error {
+	_, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot cache value of type %T: %w", value, err)
+	}
+	return nil
+}
+
+// marshalValue serializes a value for key, naming the key and value type on failure.
+func marshalValue(key string, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize value of type %T for key %s: %w", value, key, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheServerLatencySeconds records cache call latency by the address of the server the key maps
+// to and operation, so one slow server in a pool stands out from the others. Labels are bounded
+// by the configured servers. It must be registered by the application like CacheOperationsTotal.
+var CacheServerLatencySeconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "cache_server_latency_seconds",
+		Help:    "Latency of Memcached calls in seconds, partitioned by server address and operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	},
+	[]string{"server", "operation"},
+)
+
+// observeServerLatency records the latency of a call for the full key since start against the
+// server Selector maps the key to. Nothing is recorded without a Selector.
+func (mc *MemcachedConfig) observeServerLatency(operation string, key string, start time.Time) {
+	if mc.Selector == nil {
+		return
+	}
+	addr, err := mc.Selector.PickServer(key)
+	if err != nil {
+		return
+	}
+	CacheServerLatencySeconds.WithLabelValues(addr.String(), operation).Observe(time.Since(start).Seconds())
+}
+
+// observeServersLatency records the latency of a multi-key call for the full keys since start
+// against each server they map to. The call waits for its slowest server, so each is charged
+// the whole latency: the slow server still stands out, while fast servers sharing its calls
+// read high too.
+func (mc *MemcachedConfig) observeServersLatency(operation string, keys []string, start time.Time) {
+	if mc.Selector == nil {
+		return
+	}
+	elapsed := time.Since(start).Seconds()
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		addr, err := mc.Selector.PickServer(key)
+		if err != nil || seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		CacheServerLatencySeconds.WithLabelValues(addr.String(), operation).Observe(elapsed)
+	}
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// defaultTagChunkMaxBytes keeps tag index chunks small, since every tagged write reads and
+// rewrites the whole last chunk.
+const defaultTagChunkMaxBytes = 16 * 1024
+
+// maxTagChunks bounds the chunks of one tag index, and so the work done by InvalidateTag.
+const maxTagChunks = 4096
+
+// errTagChunkFull is returned when a key does not fit in a tag index chunk.
+var errTagChunkFull = errors.New("tag index chunk is full")
+
+// errTagIndexFull is logged when a key can't be recorded because its tag index has maxTagChunks full chunks.
+var errTagIndexFull = errors.New("tag index is full, key not tracked")
+
+// tagHeadKey returns the key holding the number of chunks in tag's index.
+func tagHeadKey(tag string) string {
+	return BuildKey("tag", tag)
+}
+
+// tagChunkKey returns the key holding chunk n of tag's index. Chunks are numbered from zero.
+func tagChunkKey(tag string, n int) string {
+	return BuildKey("tag", tag, strconv.Itoa(n))
+}
+
+// SetWithTags stores value like SetCache and records key under each of tags, so InvalidateTag
+// can later delete every key carrying a tag.
+//
+// A tag's index is split into chunks, so a hot tag is not bounded by Memcached's item size: the
+// keys are JSON lists stored under tagChunkKey(tag, 0), tagChunkKey(tag, 1), ..., and
+// tagHeadKey(tag) holds the number of chunks. Keys are appended to the last chunk; once it would
+// grow past TagChunkMaxBytes the next chunk is started. Tagging is best-effort:
+//
+//   - Each tag costs a head read plus a compare-and-swap on the last chunk per write, and a
+//     head update when a chunk fills up.
+//   - An index holds at most maxTagChunks chunks of TagChunkMaxBytes, about 650,000 keys of
+//     100 bytes at the default size; keys tagged once it is full, or while the index update
+//     keeps losing CAS races, are not tracked and survive invalidation. Moving on to the next
+//     chunk is not a lost race and doesn't count against CASMaxRetries.
+//   - Duplicates are only detected within the last chunk, so a key tagged again after a chunk
+//     filled up is recorded twice. This costs space, not correctness.
+//   - If a chunk is evicted or expires, the keys it listed are forgotten. If the head is, new
+//     writes walk forward through the full chunks to the last one, and InvalidateTag probes
+//     chunks in order up to the first missing one.
+//   - A key tagged while InvalidateTag runs may be dropped from the index or survive the
+//     invalidation.
+func (mc *MemcachedConfig) SetWithTags(key string, value interface{}, expiration time.Duration, tags ...string) error {
+	if err := mc.SetCache(key, value, expiration); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		mc.trackTag(tag, key)
+	}
+	return nil
+}
+
+// trackTag records key in the last chunk of tag's index, starting a new chunk when it is full.
+func (mc *MemcachedConfig) trackTag(tag string, key string) {
+	headKey := mc.fullKey(tagHeadKey(tag))
+	expiration := int32(namespaceVersionTTL.Seconds())
+
+	// Advancing through full chunks is bounded by maxTagChunks per head, not by the retries
+	for attempt, advanced := 0, 0; attempt <= mc.CASMaxRetries && advanced <= maxTagChunks; {
+		chunks, _, err := mc.tagChunks(headKey)
+		if err != nil {
+			mc.backendError("update cache tag index", headKey, err)
+			return
+		}
+
+		err = mc.appendTagKey(mc.fullKey(tagChunkKey(tag, chunks-1)), key, expiration)
+		if err == errTagChunkFull {
+			if chunks >= maxTagChunks {
+				mc.logError("update cache tag index", headKey, errTagIndexFull)
+				return
+			}
+			// Start the next chunk and append to it
+			err = mc.setTagChunks(headKey, chunks+1, expiration)
+			if err == nil {
+				advanced++
+				continue
+			}
+		}
+		if err == memcache.ErrCASConflict || err == memcache.ErrCacheMiss || err == memcache.ErrNotStored {
+			attempt++
+			continue
+		}
+		if err != nil {
+			mc.backendError("update cache tag index", headKey, err)
+		}
+		return
+	}
+	log.Printf("Gave up recording key %s in cache tag index %s after conflicting writes", key, tag)
+}
+
+// tagChunks returns the number of chunks recorded in the head item at the full key headKey, and
+// whether it was recorded; an index without a head has at least one (possibly empty) chunk.
+func (mc *MemcachedConfig) tagChunks(headKey string) (int, bool, error) {
+	item, err := mc.Client.Get(headKey)
+	if err == memcache.ErrCacheMiss {
+		return 1, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	chunks, err := strconv.Atoi(string(item.Value))
+	if err != nil || chunks < 1 {
+		return 1, false, nil
+	}
+	return chunks, true, nil
+}
+
+// setTagChunks raises the chunk count in the head item at the full key headKey to chunks.
+func (mc *MemcachedConfig) setTagChunks(headKey string, chunks int, expiration int32) error {
+	value := []byte(strconv.Itoa(chunks))
+	for attempt := 0; attempt <= mc.CASMaxRetries; attempt++ {
+		item, err := mc.Client.Get(headKey)
+		if err == memcache.ErrCacheMiss {
+			err = mc.Client.Add(&memcache.Item{Key: headKey, Value: value, Expiration: expiration})
+			if err == memcache.ErrNotStored {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if current, err := strconv.Atoi(string(item.Value)); err == nil && current >= chunks {
+			return nil
+		}
+		item.Value = value
+		item.Expiration = expiration
+		err = mc.Client.CompareAndSwap(item)
+		if err == memcache.ErrCASConflict || err == memcache.ErrCacheMiss || err == memcache.ErrNotStored {
+			continue
+		}
+		return err
+	}
+	return memcache.ErrCASConflict
+}
+
+// appendTagKey appends key to the tag index chunk at the full key chunkKey, returning
+// errTagChunkFull if that would make the chunk larger than TagChunkMaxBytes. A missing chunk is
+// created holding just key.
+func (mc *MemcachedConfig) appendTagKey(chunkKey string, key string, expiration int32) error {
+	item, err := mc.Client.Get(chunkKey)
+	if err == memcache.ErrCacheMiss {
+		data, _ := json.Marshal([]string{key})
+		return mc.Client.Add(&memcache.Item{Key: chunkKey, Value: data, Expiration: expiration})
+	}
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(item.Value, &keys); err != nil {
+		mc.deserializeError(chunkKey, err)
+		return nil
+	}
+	for _, existing := range keys {
+		if existing == key {
+			return nil
+		}
+	}
+	data, _ := json.Marshal(append(keys, key))
+	if mc.TagChunkMaxBytes > 0 && len(data) > mc.TagChunkMaxBytes {
+		return errTagChunkFull
+	}
+	item.Value = data
+	item.Expiration = expiration
+	return mc.Client.CompareAndSwap(item)
+}
+
+// InvalidateTag deletes every key recorded under tag, then the tag's index, returning the number
+// of keys deleted. See SetWithTags for its limitations.
+func (mc *MemcachedConfig) InvalidateTag(tag string) (int, error) {
+	chunks, counted, err := mc.tagChunks(mc.fullKey(tagHeadKey(tag)))
+	if err != nil {
+		return 0, err
+	}
+	chunkKeys := make([]string, chunks)
+	for n := range chunkKeys {
+		chunkKeys[n] = tagChunkKey(tag, n)
+	}
+	values, err := mc.GetMultiCache(chunkKeys)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	collect := func(chunk []string) {
+		for _, key := range chunk {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, chunkKey := range chunkKeys {
+		var chunk []string
+		if data, ok := values[chunkKey]; ok && json.Unmarshal(data, &chunk) == nil {
+			collect(chunk)
+		}
+	}
+	if !counted {
+		if len(values) == 0 {
+			return 0, nil
+		}
+		// Without a head, later chunks may hold keys too: probe them in order up to the first missing one
+		for n := chunks; n < maxTagChunks; n++ {
+			var chunk []string
+			found, err := mc.GetCache(tagChunkKey(tag, n), &chunk)
+			if err != nil {
+				return 0, err
+			}
+			if !found {
+				break
+			}
+			chunkKeys = append(chunkKeys, tagChunkKey(tag, n))
+			collect(chunk)
+		}
+	}
+
+	// Drop the index first so keys tagged from here on start a fresh one
+	if _, err := mc.DeleteMultiCache(append([]string{tagHeadKey(tag)}, chunkKeys...)); err != nil {
+		return 0, err
+	}
+	failed, err := mc.DeleteMultiCache(keys)
+	if err != nil {
+		log.Printf("Failed to delete %d keys from cache tag %s: %v", len(failed), tag, err)
+	}
+	deleted := len(keys) - len(failed)
+	log.Printf("Invalidated %d keys from cache tag %s", deleted, tag)
+	return deleted, nil
+}
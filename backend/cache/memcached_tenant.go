@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxTenantIDLength bounds tenant IDs so tenant-scoped keys stay well under Memcached's key limit.
+const maxTenantIDLength = 64
+
+// Errors returned by TenantCache operations for an unusable tenant ID.
+var (
+	ErrEmptyTenantID   = errors.New("tenant ID is empty")
+	ErrInvalidTenantID = errors.New("tenant ID must be at most 64 letters, digits, '-' or '_'")
+)
+
+// ValidateTenantID checks that id is a non-empty tenant ID of letters, digits, '-' and '_'.
+func ValidateTenantID(id string) error {
+	if id == "" {
+		return ErrEmptyTenantID
+	}
+	if len(id) > maxTenantIDLength {
+		return ErrInvalidTenantID
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return ErrInvalidTenantID
+		}
+	}
+	return nil
+}
+
+// TenantCache is a cache handle scoped to one tenant. Every key is prefixed with the
+// tenant ID, so two tenants using the same logical key never share an entry even if a
+// caller builds keys incorrectly. A handle for an invalid tenant ID refuses every
+// operation rather than falling back to unscoped keys.
+type TenantCache struct {
+	mc       *MemcachedConfig
+	tenantID string
+	err      error
+}
+
+// TenantCache returns a cache handle scoped to tenantID. The ID is validated here; if it
+// is empty or invalid every operation on the handle fails with the validation error.
+func (mc *MemcachedConfig) TenantCache(tenantID string) *TenantCache {
+	tc := &TenantCache{mc: mc, tenantID: tenantID}
+	if err := ValidateTenantID(tenantID); err != nil {
+		tc.err = fmt.Errorf("tenant cache unavailable: %w", err)
+	}
+	return tc
+}
+
+// TenantID returns the tenant the handle is scoped to.
+func (tc *TenantCache) TenantID() string {
+	return tc.tenantID
+}
+
+// Key returns the key under which a tenant's logical key is stored.
+func (tc *TenantCache) Key(key string) (string, error) {
+	if tc.err != nil {
+		return "", tc.err
+	}
+	return BuildKey("tenant", tc.tenantID, key), nil
+}
+
+// SetCache stores a value under the tenant's key.
+func (tc *TenantCache) SetCache(key string, value interface{}, expiration time.Duration) error {
+	tenantKey, err := tc.Key(key)
+	if err != nil {
+		return err
+	}
+	return tc.mc.SetCache(tenantKey, value, expiration)
+}
+
+// GetCache retrieves the value stored under the tenant's key into target.
+func (tc *TenantCache) GetCache(key string, target interface{}) (bool, error) {
+	tenantKey, err := tc.Key(key)
+	if err != nil {
+		return false, err
+	}
+	return tc.mc.GetCache(tenantKey, target)
+}
+
+// DeleteCache removes the tenant's key.
+func (tc *TenantCache) DeleteCache(key string) error {
+	tenantKey, err := tc.Key(key)
+	if err != nil {
+		return err
+	}
+	return tc.mc.DeleteCache(tenantKey)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// getAndToucher is implemented by clients supporting Memcached's gat command.
+type getAndToucher interface {
+	GetAndTouch(key string, seconds int32) (*memcache.Item, error)
+}
+
+// toucher is implemented by clients supporting Memcached's touch command (e.g. *memcache.Client).
+type toucher interface {
+	Touch(key string, seconds int32) error
+}
+
+// GetAndTouch reads key into target and, if it was present, extends its expiration to ttl
+// (zero uses DefaultExpiry), for sliding-expiration entries such as sessions. A miss returns
+// false without touching anything.
+//
+// When the client supports gat this is a single atomic round trip. Otherwise it is a Get
+// followed by a Touch (or, for clients without touch, a CompareAndSwap rewriting the value),
+// which is not atomic: a value written between the two calls may be given ttl instead of its
+// own expiration, and the value read is returned even if it was deleted in between. While the
+// fallback cache is serving, the value is read from it and not touched.
+func (mc *MemcachedConfig) GetAndTouch(key string, target interface{}, ttl time.Duration) (bool, error) {
+	fullKey := mc.fullKey(key)
+	expirySeconds := int32(ttl.Seconds())
+	if expirySeconds == 0 {
+		expirySeconds = int32(mc.DefaultExpiry.Seconds())
+	}
+
+	var data []byte
+	var flags uint32
+	if mc.fallbackActive() {
+		mc.recordOperation("get_and_touch", fullKey, resultError)
+		value, valueFlags, _, found, err := mc.getFallback(fullKey)
+		if !found || err != nil {
+			return false, err
+		}
+		data, flags = value, valueFlags
+	} else {
+		start := time.Now()
+		item, err := mc.getAndTouch(fullKey, expirySeconds)
+		mc.observeServerLatency("get_and_touch", fullKey, start)
+		mc.recordResult(err)
+		if err == memcache.ErrCacheMiss {
+			mc.recordOperation("get_and_touch", fullKey, resultMiss)
+			mc.traceOperation("get_and_touch", fullKey, resultMiss, 0, start)
+			return false, nil
+		}
+		if err != nil {
+			mc.recordOperation("get_and_touch", fullKey, resultError)
+			mc.backendError("get and touch cache", fullKey, err)
+			return false, err
+		}
+		mc.recordOperation("get_and_touch", fullKey, resultHit)
+		mc.storeFallback(fullKey, item.Value, item.Flags, time.Duration(expirySeconds)*time.Second)
+		mc.traceOperation("get_and_touch", fullKey, resultHit, len(item.Value), start)
+		data, flags = item.Value, item.Flags
+	}
+
+	data, flags, err := mc.openValue(key, data, flags)
+	if unreadable(err) {
+		mc.discardCorrupted(key, err)
+		return false, nil
+	}
+	if err == nil && softDeleted(data) {
+		return false, nil
+	}
+	if err == nil {
+		err = decodeValue(data, flags, target)
+	}
+	if err != nil {
+		mc.deserializeError(fullKey, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// getAndTouch reads the item stored under the full key and sets its expiration to seconds,
+// with gat when the client supports it.
+func (mc *MemcachedConfig) getAndTouch(key string, seconds int32) (*memcache.Item, error) {
+	if client, ok := mc.Client.(getAndToucher); ok {
+		return client.GetAndTouch(key, seconds)
+	}
+
+	item, err := mc.Client.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if client, ok := mc.Client.(toucher); ok {
+		err = client.Touch(key, seconds)
+	} else {
+		item.Expiration = seconds
+		err = mc.Client.CompareAndSwap(item)
+	}
+	// Deleted or rewritten since the Get: the value read is still returned, untouched
+	if err == memcache.ErrCacheMiss || err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+		err = nil
+	}
+	return item, err
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// traceOperation logs a debug entry for one in LogSampleRate cache operations. Logging every
+// operation is far too chatty in production, while a sample still shows key prefixes, value
+// sizes, hit/miss patterns and latencies.
+func (mc *MemcachedConfig) traceOperation(operation string, key string, result string, size int, start time.Time) {
+	if mc.Logger == nil || mc.LogSampleRate <= 0 {
+		return
+	}
+	if atomic.AddUint64(&mc.traceCount, 1)%uint64(mc.LogSampleRate) != 0 {
+		return
+	}
+	mc.Logger.Debug("Cache operation",
+		zap.String("operation", operation),
+		zap.String("key_prefix", KeyPrefix(key, mc.KeyPrefix)),
+		zap.String("result", result),
+		zap.Int("size_bytes", size),
+		zap.Duration("latency", time.Since(start)),
+		zap.Int("sample_rate", mc.LogSampleRate),
+	)
+}
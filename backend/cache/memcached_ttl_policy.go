@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// maxTTLPolicySize bounds the TTL policy document read from the remote config service.
+const maxTTLPolicySize = 1 << 20
+
+// TTLPolicy sets how long blockchain data is cached, per data type (e.g. "block", "account").
+type TTLPolicy struct {
+	Default   time.Duration            // TTL for data types without an entry
+	DataTypes map[string]time.Duration // TTL per data type
+}
+
+// DefaultTTLPolicy is the built-in policy, used until (or if) a remote policy is loaded.
+func DefaultTTLPolicy() *TTLPolicy {
+	return &TTLPolicy{
+		Default: 5 * time.Minute,
+		DataTypes: map[string]time.Duration{
+			"block":       10 * time.Minute,
+			"transaction": 10 * time.Minute,
+			"receipt":     10 * time.Minute,
+			"account":     15 * time.Second,
+			"gas_price":   10 * time.Second,
+		},
+	}
+}
+
+// TTL returns the TTL for a data type.
+func (p *TTLPolicy) TTL(dataType string) time.Duration {
+	if ttl, ok := p.DataTypes[dataType]; ok {
+		return ttl
+	}
+	return p.Default
+}
+
+// ttlPolicyDocument is the JSON form of a TTLPolicy, with durations such as "30s":
+// {"default": "5m", "data_types": {"block": "10m", "account": "15s"}}
+type ttlPolicyDocument struct {
+	Default   string            `json:"default"`
+	DataTypes map[string]string `json:"data_types"`
+}
+
+// ParseTTLPolicy parses a TTL policy document, requiring every duration to be positive.
+func ParseTTLPolicy(data []byte) (*TTLPolicy, error) {
+	var doc ttlPolicyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid TTL policy: %v", err)
+	}
+	parse := func(name, value string) (time.Duration, error) {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return 0, fmt.Errorf("invalid TTL policy: %s must be a positive duration, got %q", name, value)
+		}
+		return ttl, nil
+	}
+
+	policy := &TTLPolicy{DataTypes: make(map[string]time.Duration, len(doc.DataTypes))}
+	var err error
+	if policy.Default, err = parse("default", doc.Default); err != nil {
+		return nil, err
+	}
+	for dataType, value := range doc.DataTypes {
+		if policy.DataTypes[dataType], err = parse(dataType, value); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// TTLPolicy returns the TTL policy currently in effect.
+func (mc *MemcachedConfig) TTLPolicy() *TTLPolicy {
+	if policy, ok := mc.ttlPolicy.Load().(*TTLPolicy); ok {
+		return policy
+	}
+	return DefaultTTLPolicy()
+}
+
+// SetTTLPolicy atomically replaces the TTL policy.
+func (mc *MemcachedConfig) SetTTLPolicy(policy *TTLPolicy) {
+	mc.ttlPolicy.Store(policy)
+}
+
+// fetchTTLPolicy downloads and parses the TTL policy served at url.
+func fetchTTLPolicy(ctx context.Context, client *http.Client, url string) (*TTLPolicy, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TTL policy server returned status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTTLPolicySize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseTTLPolicy(body)
+}
+
+// refreshTTLPolicy loads the remote policy and swaps it in, keeping the current policy on failure.
+func (mc *MemcachedConfig) refreshTTLPolicy(ctx context.Context, client *http.Client, url string) error {
+	policy, err := fetchTTLPolicy(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(policy, mc.TTLPolicy()) {
+		mc.SetTTLPolicy(policy)
+		log.Printf("Loaded TTL policy from %s: default %v, %d data types", url, policy.Default, len(policy.DataTypes))
+	}
+	return nil
+}
+
+// StartTTLPolicyLoader loads the TTL policy from url and refreshes it every interval until the
+// client is closed. If the remote is unreachable the current (initially built-in) policy stays
+// in effect, so the cache keeps working with the embedded defaults.
+func (mc *MemcachedConfig) StartTTLPolicyLoader(url string, interval time.Duration) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	if err := mc.refreshTTLPolicy(context.Background(), client, url); err != nil {
+		log.Printf("Failed to load TTL policy from %s, using built-in policy: %v", url, err)
+	}
+
+	mc.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := mc.refreshTTLPolicy(ctx, client, url); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to refresh TTL policy from %s: %v", url, err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrTypeMismatch is returned by Get when the cached value can't be decoded as the requested type.
+var ErrTypeMismatch = errors.New("cached value does not match the requested type")
+
+// Get reads key and returns its value decoded as a T, so callers don't have to pass a target
+// of the right type. A value that doesn't decode as T is reported as a miss together with an
+// error wrapping ErrTypeMismatch. GetCache remains available for untyped targets.
+func Get[T any](mc *MemcachedConfig, key string) (T, bool, error) {
+	var value T
+	data, flags, found, err := mc.getData(key)
+	if !found || err != nil {
+		return value, false, err
+	}
+	if err := decodeValue(data, flags, &value); err != nil {
+		mc.deserializeError(mc.fullKey(key), err)
+		var zero T
+		return zero, false, fmt.Errorf("%w: key %s as %s: %v", ErrTypeMismatch, key, reflect.TypeOf((*T)(nil)).Elem(), err)
+	}
+	return value, true, nil
+}
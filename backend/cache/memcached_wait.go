@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+// Backoff between GetWait polls.
+const (
+	getWaitInitialBackoff = 5 * time.Millisecond
+	getWaitMaxBackoff     = 100 * time.Millisecond
+)
+
+// GetWait is GetCache that, on a miss, polls again with exponential backoff for up to maxWait
+// before reporting the miss. It suits a caller that knows a write to key is in flight (e.g. an
+// asynchronous write started by another goroutine) and would otherwise race ahead of it.
+//
+// It is a convenience for that pattern, not a substitute for synchronization: there is no
+// guarantee the write lands within maxWait, and every poll is a Memcached round-trip. Errors
+// are returned immediately.
+func (mc *MemcachedConfig) GetWait(key string, target interface{}, maxWait time.Duration) (bool, error) {
+	deadline := time.Now().Add(maxWait)
+	backoff := getWaitInitialBackoff
+	for {
+		found, err := mc.GetCache(key, target)
+		if found || err != nil {
+			return found, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > getWaitMaxBackoff {
+			backoff = getWaitMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// WarmMode controls how cache warm-up treats keys that are already cached.
+type WarmMode int
+
+const (
+	// WarmOverwrite fetches and stores every key, replacing existing values.
+	WarmOverwrite WarmMode = iota
+	// WarmFillMissing only fetches keys that are not cached and stores them with Add, so when
+	// several pods warm concurrently only the first to fetch a key stores it.
+	WarmFillMissing
+)
+
+// String returns the mode name used in logs.
+func (m WarmMode) String() string {
+	switch m {
+	case WarmOverwrite:
+		return "overwrite"
+	case WarmFillMissing:
+		return "fill-missing"
+	}
+	return "unknown"
+}
+
+// WarmStats summarizes a warm-up run.
+type WarmStats struct {
+	Stored  int // Keys fetched and stored
+	Skipped int // Keys already cached (FillMissing only)
+	Failed  int // Keys whose fetch or store failed
+}
+
+// WarmStatus reports the state of cache warm-ups for operators, see MemcachedConfig.WarmStatus.
+type WarmStatus struct {
+	InProgress bool     // A warm-up is running now
+	Last       *WarmRun // Most recently finished warm-up, nil if none has finished yet
+}
+
+// WarmRun describes a finished warm-up run.
+type WarmRun struct {
+	Mode       WarmMode
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Attempted  int // Keys the run was asked to warm
+	Stats      WarmStats
+	Err        error // Why the run stopped early, nil if it covered every key
+}
+
+// warmTracker records warm-ups in progress and the last finished run.
+type warmTracker struct {
+	mu      sync.Mutex
+	running int
+	last    *WarmRun
+}
+
+// WarmStatus reports whether a warm-up is running and the results of the last one to finish.
+func (mc *MemcachedConfig) WarmStatus() WarmStatus {
+	mc.warms.mu.Lock()
+	defer mc.warms.mu.Unlock()
+	status := WarmStatus{InProgress: mc.warms.running > 0}
+	if mc.warms.last != nil {
+		last := *mc.warms.last
+		status.Last = &last
+	}
+	return status
+}
+
+// AddCache stores a value only if key is not already cached, reporting whether it was stored.
+// The value is encoded and packed (compressed, checksummed, encrypted) like SetCache's.
+func (mc *MemcachedConfig) AddCache(key string, value interface{}, expiration time.Duration) (bool, error) {
+	rawKey := key
+	key = mc.fullKey(key)
+	data, flags, err := encodeValue(key, value)
+	if err != nil {
+		mc.serializeError(key, err)
+		return false, err
+	}
+	data, flags, err = mc.packValue(rawKey, data, flags)
+	if err != nil {
+		mc.serializeError(key, err)
+		return false, err
+	}
+	if mc.fallbackActive() {
+		mc.recordOperation("add", key, resultError)
+		return false, ErrCircuitOpen
+	}
+
+	expirySeconds := int32(expiration.Seconds())
+	if expirySeconds == 0 {
+		expirySeconds = int32(mc.DefaultExpiry.Seconds())
+	}
+	err = mc.Client.Add(&memcache.Item{Key: key, Value: data, Flags: flags, Expiration: expirySeconds})
+	mc.recordResult(err)
+	if err == memcache.ErrNotStored {
+		mc.recordOperation("add", key, resultHit)
+		return false, nil
+	}
+	if err != nil {
+		mc.recordOperation("add", key, resultError)
+		mc.backendError("add cache", key, err)
+		return false, err
+	}
+	mc.recordOperation("add", key, resultOK)
+	mc.storeFallback(key, data, flags, time.Duration(expirySeconds)*time.Second)
+	return true, nil
+}
+
+// Warm populates keys using fetch, stopping early if ctx is cancelled. Fetches count
+// against the origin budget. The run is reported by WarmStatus.
+func (mc *MemcachedConfig) Warm(ctx context.Context, keys []string, expiration time.Duration, mode WarmMode, fetch func(ctx context.Context, key string) (interface{}, error)) (WarmStats, error) {
+	run := &WarmRun{Mode: mode, StartedAt: time.Now(), Attempted: len(keys)}
+	mc.warms.mu.Lock()
+	mc.warms.running++
+	mc.warms.mu.Unlock()
+
+	stats, err := mc.warm(ctx, keys, expiration, mode, fetch)
+
+	run.FinishedAt = time.Now()
+	run.Stats, run.Err = stats, err
+	mc.warms.mu.Lock()
+	mc.warms.running--
+	mc.warms.last = run
+	mc.warms.mu.Unlock()
+	return stats, err
+}
+
+// warm runs a warm-up for Warm.
+func (mc *MemcachedConfig) warm(ctx context.Context, keys []string, expiration time.Duration, mode WarmMode, fetch func(ctx context.Context, key string) (interface{}, error)) (WarmStats, error) {
+	var stats WarmStats
+
+	if mode == WarmFillMissing {
+		// Skip origin fetches for keys another pod has already warmed
+		cached, err := mc.GetMultiCache(keys)
+		if err != nil {
+			log.Printf("Failed to check existing keys before warm-up, fetching all: %v", err)
+		}
+		missing := keys[:0:0]
+		for _, key := range keys {
+			if _, found := cached[key]; found {
+				stats.Skipped++
+				continue
+			}
+			missing = append(missing, key)
+		}
+		keys = missing
+	}
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		value, err := mc.loadFromOrigin(ctx, key, func(ctx context.Context) (interface{}, error) {
+			return fetch(ctx, key)
+		})
+		if err != nil {
+			stats.Failed++
+			mc.logError("warm cache", key, err)
+			continue
+		}
+
+		if mode == WarmFillMissing {
+			stored, err := mc.AddCache(key, value, expiration)
+			switch {
+			case err != nil:
+				stats.Failed++
+			case stored:
+				stats.Stored++
+			default:
+				stats.Skipped++
+			}
+			continue
+		}
+		if err := mc.SetCache(key, value, expiration); err != nil {
+			stats.Failed++
+			continue
+		}
+		stats.Stored++
+	}
+
+	log.Printf("Cache warm-up (%s) finished: %d stored, %d skipped, %d failed", mode, stats.Stored, stats.Skipped, stats.Failed)
+	return stats, nil
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a serialized value held in the in-process cache.
+type memoryEntry struct {
+	key       string
+	value     []byte
+	flags     uint32 // Memcached item flags recording the value's encoding
+	expiresAt time.Time
+}
+
+// memoryCache is a size-bounded in-process TTL cache with least-recently-used eviction.
+// Pinned entries are held apart from the LRU list: they are never evicted, don't count
+// towards maxItems and don't expire until unpinned.
+type memoryCache struct {
+	mu       sync.Mutex
+	maxItems int
+	items    map[string]*list.Element
+	order    *list.List // Front is most recently used
+	pinned   map[string]*memoryEntry
+	now      func() time.Time
+}
+
+// newMemoryCache creates an in-process cache holding at most maxItems entries.
+func newMemoryCache(maxItems int) *memoryCache {
+	return &memoryCache{
+		maxItems: maxItems,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		pinned:   make(map[string]*memoryEntry),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value stored for key if present and not expired.
+func (m *memoryCache) Get(key string) ([]byte, bool) {
+	value, _, found := m.GetWithFlags(key)
+	return value, found
+}
+
+// GetWithFlags is Get that also returns the item flags the value was stored with.
+func (m *memoryCache) GetWithFlags(key string) ([]byte, uint32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, pinned := m.pinned[key]; pinned {
+		return entry.value, entry.flags, entry.value != nil
+	}
+	elem, exists := m.items[key]
+	if !exists {
+		return nil, 0, false
+	}
+	entry := elem.Value.(*memoryEntry)
+	if m.now().After(entry.expiresAt) {
+		m.removeElement(elem)
+		return nil, 0, false
+	}
+	m.order.MoveToFront(elem)
+	return entry.value, entry.flags, true
+}
+
+// Set stores value for key with the given TTL, evicting the least recently used entry when full.
+func (m *memoryCache) Set(key string, value []byte, ttl time.Duration) {
+	m.SetWithFlags(key, value, 0, ttl)
+}
+
+// SetWithFlags is Set that also records the item flags of value.
+func (m *memoryCache) SetWithFlags(key string, value []byte, flags uint32, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := m.now().Add(ttl)
+	if entry, pinned := m.pinned[key]; pinned {
+		entry.value = value
+		entry.flags = flags
+		entry.expiresAt = expiresAt
+		return
+	}
+	if elem, exists := m.items[key]; exists {
+		entry := elem.Value.(*memoryEntry)
+		entry.value = value
+		entry.flags = flags
+		entry.expiresAt = expiresAt
+		m.order.MoveToFront(elem)
+		return
+	}
+
+	for m.maxItems > 0 && m.order.Len() >= m.maxItems {
+		m.removeElement(m.order.Back())
+	}
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, value: value, flags: flags, expiresAt: expiresAt})
+}
+
+// Delete removes key from the cache. A pinned key stays pinned, without a value until it is set again.
+func (m *memoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, pinned := m.pinned[key]; pinned {
+		entry.value = nil
+		return
+	}
+	if elem, exists := m.items[key]; exists {
+		m.removeElement(elem)
+	}
+}
+
+// Flush removes all entries from the cache.
+func (m *memoryCache) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items = make(map[string]*list.Element)
+	m.order.Init()
+	for _, entry := range m.pinned {
+		entry.value = nil
+	}
+}
+
+// Len returns the number of unpinned entries currently held, including expired ones not yet evicted.
+func (m *memoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// Pin keeps key resident, taking over its current entry if it has one. It reports false, pinning
+// nothing, if that would make more than maxPinned keys pinned.
+func (m *memoryCache) Pin(key string, maxPinned int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, pinned := m.pinned[key]; pinned {
+		return true
+	}
+	if len(m.pinned) >= maxPinned {
+		return false
+	}
+	entry := &memoryEntry{key: key}
+	if elem, exists := m.items[key]; exists {
+		entry = elem.Value.(*memoryEntry)
+		m.removeElement(elem)
+	}
+	m.pinned[key] = entry
+	return true
+}
+
+// Unpin returns key to the LRU list with the expiration of its last write, if it has a value.
+func (m *memoryCache) Unpin(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, pinned := m.pinned[key]
+	if !pinned {
+		return
+	}
+	delete(m.pinned, key)
+	if entry.value == nil {
+		return
+	}
+	for m.maxItems > 0 && m.order.Len() >= m.maxItems {
+		m.removeElement(m.order.Back())
+	}
+	m.items[key] = m.order.PushFront(entry)
+}
+
+// GetPinned returns the value of key if it is pinned and has one. Pinned values don't expire.
+func (m *memoryCache) GetPinned(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, pinned := m.pinned[key]
+	if !pinned || entry.value == nil {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// PinnedKeys returns the pinned keys, in no particular order.
+func (m *memoryCache) PinnedKeys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.pinned))
+	for key := range m.pinned {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// removeElement unlinks an entry; the caller must hold the lock.
+func (m *memoryCache) removeElement(elem *list.Element) {
+	m.order.Remove(elem)
+	delete(m.items, elem.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,2390 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var errServerDown = errors.New("dial tcp 127.0.0.1:11211: connect: connection refused")
+
+// fakeMemcache is an in-memory MemcacheClient that can simulate an unavailable server.
+// Items returned by Get remember the key's version so CompareAndSwap can detect conflicts.
+type fakeMemcache struct {
+	mu       sync.Mutex
+	items    map[string]*memcache.Item
+	versions map[string]uint64
+	tokens   map[*memcache.Item]uint64
+	down     bool
+	failKeys map[string]bool // Keys whose operations fail as if their server were down
+	calls    map[string]int
+}
+
+func newFakeMemcache() *fakeMemcache {
+	return &fakeMemcache{
+		items:    make(map[string]*memcache.Item),
+		versions: make(map[string]uint64),
+		tokens:   make(map[*memcache.Item]uint64),
+		calls:    make(map[string]int),
+	}
+}
+
+// store saves a copy of item and bumps its version; the caller must hold the lock
+func (f *fakeMemcache) store(item *memcache.Item) {
+	copied := *item
+	f.items[item.Key] = &copied
+	f.versions[item.Key]++
+}
+
+func (f *fakeMemcache) record(op string) error {
+	f.calls[op]++
+	if f.down {
+		return errServerDown
+	}
+	return nil
+}
+
+func (f *fakeMemcache) Get(key string) (*memcache.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("get"); err != nil {
+		return nil, err
+	}
+	item, ok := f.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	copied := *item
+	f.tokens[&copied] = f.versions[key]
+	return &copied, nil
+}
+
+func (f *fakeMemcache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("getmulti"); err != nil {
+		return nil, err
+	}
+	items := make(map[string]*memcache.Item)
+	for _, key := range keys {
+		if item, ok := f.items[key]; ok {
+			copied := *item
+			items[key] = &copied
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeMemcache) Set(item *memcache.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("set"); err != nil {
+		return err
+	}
+	f.store(item)
+	return nil
+}
+
+func (f *fakeMemcache) Add(item *memcache.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("add"); err != nil {
+		return err
+	}
+	if _, ok := f.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+	f.store(item)
+	return nil
+}
+
+func (f *fakeMemcache) CompareAndSwap(item *memcache.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("cas"); err != nil {
+		return err
+	}
+	token, ok := f.tokens[item]
+	if !ok {
+		return memcache.ErrCASConflict
+	}
+	delete(f.tokens, item)
+	if _, exists := f.items[item.Key]; !exists {
+		return memcache.ErrCacheMiss
+	}
+	if f.versions[item.Key] != token {
+		return memcache.ErrCASConflict
+	}
+	f.store(item)
+	return nil
+}
+
+func (f *fakeMemcache) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("delete"); err != nil {
+		return err
+	}
+	if f.failKeys[key] {
+		return errServerDown
+	}
+	if _, ok := f.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(f.items, key)
+	f.versions[key]++
+	return nil
+}
+
+func (f *fakeMemcache) FlushAll() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("flush"); err != nil {
+		return err
+	}
+	f.items = make(map[string]*memcache.Item)
+	return nil
+}
+
+func (f *fakeMemcache) Touch(key string, seconds int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("touch"); err != nil {
+		return err
+	}
+	item, ok := f.items[key]
+	if !ok {
+		return memcache.ErrCacheMiss
+	}
+	item.Expiration = seconds
+	return nil
+}
+
+func (f *fakeMemcache) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("ping")
+}
+
+// gatMemcache adds Memcached's gat command to fakeMemcache
+type gatMemcache struct {
+	*fakeMemcache
+}
+
+func (g gatMemcache) GetAndTouch(key string, seconds int32) (*memcache.Item, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.record("gat"); err != nil {
+		return nil, err
+	}
+	item, ok := g.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	item.Expiration = seconds
+	copied := *item
+	return &copied, nil
+}
+
+func (f *fakeMemcache) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeMemcache) callCount(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// newTestMemcached returns a MemcachedConfig backed by a fake client
+func newTestMemcached() (*MemcachedConfig, *fakeMemcache) {
+	fake := newFakeMemcache()
+	mc := DefaultMemcachedConfig()
+	mc.Client = fake
+	return mc, fake
+}
+
+// newTestDedupLogger returns a dedup logger that records lines and reads time from *clock
+func newTestDedupLogger(window time.Duration, clock *time.Time, lines *[]string) *dedupLogger {
+	d := newDedupLogger(window)
+	d.now = func() time.Time { return *clock }
+	d.logf = func(format string, args ...interface{}) {
+		*lines = append(*lines, fmt.Sprintf(format, args...))
+	}
+	return d
+}
+
+func TestDedupLogger_CollapsesIdenticalErrors(t *testing.T) {
+	clock := time.Now()
+	var lines []string
+	d := newTestDedupLogger(10*time.Second, &clock, &lines)
+
+	outage := errors.New("dial tcp 127.0.0.1:11211: connect: connection refused")
+	for i := 0; i < 100; i++ {
+		d.Errorf("get cache", fmt.Sprintf("api:key%d", i), outage)
+	}
+	assert.Len(t, lines, 1)
+
+	// Once the window elapses the suppressed count is summarized
+	clock = clock.Add(11 * time.Second)
+	d.Errorf("get cache", "api:key", outage)
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], "99 identical errors suppressed")
+}
+
+func TestDedupLogger_KeepsDistinctErrors(t *testing.T) {
+	clock := time.Now()
+	var lines []string
+	d := newTestDedupLogger(10*time.Second, &clock, &lines)
+
+	d.Errorf("get cache", "api:a", errors.New("connection refused"))
+	d.Errorf("get cache", "api:a", errors.New("i/o timeout"))
+	d.Errorf("set cache", "api:a", errors.New("connection refused"))
+	assert.Len(t, lines, 3)
+}
+
+func TestBuildKey_NoCollisionsWithColons(t *testing.T) {
+	// Both part lists joined to "api:users:1:profile" under the old scheme
+	a := BuildKey("api", "users:1", "profile")
+	b := BuildKey("api", "users", "1:profile")
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, "api:users%3A1:profile", a)
+	assert.Equal(t, "api:users:1%3Aprofile", b)
+}
+
+func TestBuildKey_KeepsPlainKeysReadable(t *testing.T) {
+	assert.Equal(t, "blockchain:account:9xQeWvG816bUx9EPjHmaT23yvVM2ZWbrrpZb9PusVFin",
+		BuildKey("blockchain", "account", "9xQeWvG816bUx9EPjHmaT23yvVM2ZWbrrpZb9PusVFin"))
+	assert.Equal(t, "api:search:q=hello%20world%25", BuildKey("api", "search", "q=hello world%"))
+}
+
+func TestFallback_ServesPreviouslySetKeyDuringOutage(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.EnableFallback()
+
+	assert.NoError(t, mc.SetCache("api:hot", map[string]int{"value": 42}, time.Minute))
+	fake.setDown(true)
+
+	var result map[string]int
+	found, err := mc.GetCache("api:hot", &result)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42, result["value"])
+
+	// Once the circuit opens, Memcached is no longer contacted
+	for i := 0; i < circuitFailureThreshold; i++ {
+		mc.GetCache("api:hot", &result)
+	}
+	gets := fake.callCount("get")
+	found, err = mc.GetCache("api:hot", &result)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gets, fake.callCount("get"))
+}
+
+func TestFallback_DisabledReturnsBackendError(t *testing.T) {
+	mc, fake := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:hot", "value", time.Minute))
+	fake.setDown(true)
+
+	var result string
+	found, err := mc.GetCache("api:hot", &result)
+	assert.Error(t, err)
+	assert.False(t, found)
+}
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	m := newMemoryCache(2)
+	m.Set("a", []byte("1"), time.Minute)
+	m.Set("b", []byte("2"), time.Minute)
+	m.Get("a")
+	m.Set("c", []byte("3"), time.Minute)
+
+	_, found := m.Get("b")
+	assert.False(t, found)
+	_, found = m.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 2, m.Len())
+}
+
+func TestNamespace_IsolatesKeysAndInvalidation(t *testing.T) {
+	mc, _ := newTestMemcached()
+	profiles := mc.Namespace("profiles", time.Hour)
+	flags := mc.Namespace("flags", time.Minute)
+
+	assert.NoError(t, profiles.Set("42", "alice", 0))
+	assert.NoError(t, flags.Set("42", "enabled", 0))
+
+	var value string
+	found, err := profiles.Get("42", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "alice", value)
+
+	found, _ = flags.Get("42", &value)
+	assert.True(t, found)
+	assert.Equal(t, "enabled", value)
+
+	// Invalidating one namespace leaves the other untouched
+	assert.NoError(t, profiles.Invalidate())
+	found, err = profiles.Get("42", &value)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	found, _ = flags.Get("42", &value)
+	assert.True(t, found)
+	assert.Equal(t, "enabled", value)
+}
+
+// missBarrierMemcache holds back misses on key until waiters callers have missed it, so they
+// all act on the miss at once.
+type missBarrierMemcache struct {
+	*fakeMemcache
+	key     string
+	waiters int
+
+	barrierMu sync.Mutex
+	missed    int
+	released  chan struct{}
+}
+
+func (m *missBarrierMemcache) Get(key string) (*memcache.Item, error) {
+	item, err := m.fakeMemcache.Get(key)
+	if err != memcache.ErrCacheMiss || key != m.key {
+		return item, err
+	}
+	m.barrierMu.Lock()
+	m.missed++
+	if m.missed == m.waiters {
+		close(m.released)
+	}
+	m.barrierMu.Unlock()
+	<-m.released
+	return item, err
+}
+
+func TestNamespace_ConcurrentFirstUseSharesOneVersion(t *testing.T) {
+	const n = 20
+	mc, fake := newTestMemcached()
+	mc.Client = &missBarrierMemcache{fakeMemcache: fake, key: "ns:profiles:version", waiters: n, released: make(chan struct{})}
+	profiles := mc.Namespace("profiles", time.Hour)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			assert.NoError(t, profiles.Set(strconv.Itoa(i), i, 0))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	// Every write landed under the version that stuck, so all of them read back
+	for i := 0; i < n; i++ {
+		var value int
+		found, err := profiles.Get(strconv.Itoa(i), &value)
+		assert.NoError(t, err)
+		assert.True(t, found, "key %d written under an orphaned version", i)
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestNamespace_AppliesDefaultTTL(t *testing.T) {
+	mc, fake := newTestMemcached()
+	sessions := mc.Namespace("sessions", 15*time.Minute)
+
+	assert.NoError(t, sessions.Set("abc", "token", 0))
+	key, err := sessions.Key("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(900), fake.items[key].Expiration)
+}
+
+func TestKeyPrefix_ExtractsTopLevelSegment(t *testing.T) {
+	cases := []struct {
+		key       string
+		envPrefix string
+		want      string
+	}{
+		{"api:users:1", "", "api"},
+		{"blockchain:account:9xQe", "", "blockchain"},
+		{"session", "", "other"},
+		{":leading", "", "other"},
+		{"", "", "other"},
+		{"prod:api:users:1", "prod", "api"},
+		{"prod:session:abc", "prod", "session"},
+		{"prod:session", "prod", "other"},
+		{"api:users", "prod", "api"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, KeyPrefix(tc.key, tc.envPrefix), tc.key)
+	}
+}
+
+func TestKeyPrefix_AppliedToStoredKeys(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.KeyPrefix = "staging"
+
+	assert.NoError(t, mc.SetCache("api:users:1", "alice", time.Minute))
+	_, stored := fake.items["staging:api:users:1"]
+	assert.True(t, stored)
+
+	var value string
+	found, err := mc.GetCache("api:users:1", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "alice", value)
+}
+
+func TestParseMemcachedServers(t *testing.T) {
+	servers, err := ParseMemcachedServers("cache-1:11211, cache-2:11211,[::1]:11211", 32)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cache-1:11211", "cache-2:11211", "[::1]:11211"}, servers)
+
+	_, err = ParseMemcachedServers("cache-1:11211,cache-2,:11211,cache-3:http", 32)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"cache-2"`)
+	assert.Contains(t, err.Error(), `":11211"`)
+	assert.Contains(t, err.Error(), `"cache-3:http"`)
+	assert.NotContains(t, err.Error(), `"cache-1:11211"`)
+
+	_, err = ParseMemcachedServers("a:1,b:2,c:3", 2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "3 configured, maximum is 2")
+
+	_, err = ParseMemcachedServers(" , ", 2)
+	assert.Error(t, err)
+}
+
+func TestClose_CancelsBackgroundRefresh(t *testing.T) {
+	mc, fake := newTestMemcached()
+
+	started := make(chan struct{})
+	exited := make(chan struct{})
+	assert.True(t, mc.RefreshAsync("api:slow", time.Minute, func(ctx context.Context) (interface{}, error) {
+		defer close(exited)
+		close(started)
+		<-ctx.Done()
+		return "late", ctx.Err()
+	}))
+	<-started
+
+	closed := make(chan error)
+	go func() { closed <- mc.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after cancelling background work")
+	}
+
+	select {
+	case <-exited:
+	default:
+		t.Fatal("background refresh still running after Close")
+	}
+	assert.Equal(t, 0, fake.callCount("set"))
+
+	// No new background work is accepted once closed
+	assert.False(t, mc.RefreshAsync("api:slow", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "value", nil
+	}))
+}
+
+func TestRefreshAsync_StoresLoadedValue(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.True(t, mc.RefreshAsync("api:fresh", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "value", nil
+	}))
+
+	var value string
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if found, _ := mc.GetCache("api:fresh", &value); found {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "value", value)
+	assert.NoError(t, mc.Close())
+}
+
+func TestGetMultiCache_ChunksLargeRequests(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.MultiGetChunkSize = 100
+
+	keys := make([]string, 250)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("api:item:%d", i)
+		if i%2 == 0 {
+			assert.NoError(t, mc.SetCache(keys[i], i, time.Minute))
+		}
+	}
+
+	results, err := mc.GetMultiCache(keys)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fake.callCount("getmulti"))
+	assert.Len(t, results, 125)
+	assert.Equal(t, "248", string(results["api:item:248"]))
+	_, found := results["api:item:1"]
+	assert.False(t, found)
+}
+
+func TestChunkKeys(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, chunkKeys(keys, 2))
+	assert.Equal(t, [][]string{keys}, chunkKeys(keys, 10))
+}
+
+func TestFeatureFlagCache_RefreshesOnVersionBump(t *testing.T) {
+	mc, _ := newTestMemcached()
+	defer mc.Close()
+
+	admin := NewFeatureFlagCache(mc, time.Hour)
+	assert.NoError(t, admin.SetFlags(map[string]bool{"payments_kill_switch": false}))
+
+	flags := NewFeatureFlagCache(mc, 10*time.Millisecond)
+	flags.LocalTTL = time.Hour // Only the version poller can pick up the change
+	enabled, err := flags.GetFlag("payments_kill_switch")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, admin.SetFlags(map[string]bool{"payments_kill_switch": true}))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !enabled {
+		enabled, _ = flags.GetFlag("payments_kill_switch")
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.True(t, enabled)
+
+	enabled, err = flags.GetFlag("unknown_flag")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+}
+
+func TestGetAndSet_ReturnsPreviousValue(t *testing.T) {
+	mc, _ := newTestMemcached()
+
+	var old string
+	hadOld, err := mc.GetAndSet("leader", "pod-a", time.Minute, &old)
+	assert.NoError(t, err)
+	assert.False(t, hadOld)
+
+	hadOld, err = mc.GetAndSet("leader", "pod-b", time.Minute, &old)
+	assert.NoError(t, err)
+	assert.True(t, hadOld)
+	assert.Equal(t, "pod-a", old)
+}
+
+func TestGetAndSet_ConcurrentSwapsFormAChain(t *testing.T) {
+	mc, _ := newTestMemcached()
+	mc.CASMaxRetries = 1000
+	assert.NoError(t, mc.SetCache("token", -1, time.Minute))
+
+	const writers = 10
+	olds := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var old int
+			hadOld, err := mc.GetAndSet("token", i, time.Minute, &old)
+			assert.NoError(t, err)
+			assert.True(t, hadOld)
+			olds <- old
+		}(i)
+	}
+	wg.Wait()
+	close(olds)
+
+	// Every value, initial included, is handed over exactly once except the final one
+	seen := make(map[int]int)
+	for old := range olds {
+		seen[old]++
+	}
+	var final int
+	found, err := mc.GetCache("token", &final)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	seen[final]++
+
+	assert.Len(t, seen, writers+1)
+	for value, count := range seen {
+		assert.Equal(t, 1, count, fmt.Sprintf("value %d", value))
+	}
+}
+
+func TestIncrementCache_CountsConcurrentIncrementsExactly(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.CASMaxRetries = 1000
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mc.IncrementCache("api:budget:acme", 3, time.Minute)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	var total int
+	found, err := mc.GetCache("api:budget:acme", &total)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3*writers, total)
+	assert.Equal(t, int32(60), fake.items["api:budget:acme"].Expiration)
+}
+
+func TestWarm_FillMissingKeepsExistingKeys(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:price:btc", "from-other-pod", time.Minute))
+
+	var fetched []string
+	fetch := func(ctx context.Context, key string) (interface{}, error) {
+		fetched = append(fetched, key)
+		return "from-origin", nil
+	}
+	stats, err := mc.Warm(context.Background(), []string{"api:price:btc", "api:price:eth"}, time.Minute, WarmFillMissing, fetch)
+	assert.NoError(t, err)
+	assert.Equal(t, WarmStats{Stored: 1, Skipped: 1}, stats)
+	assert.Equal(t, []string{"api:price:eth"}, fetched)
+
+	var value string
+	mc.GetCache("api:price:btc", &value)
+	assert.Equal(t, "from-other-pod", value)
+	mc.GetCache("api:price:eth", &value)
+	assert.Equal(t, "from-origin", value)
+}
+
+func TestWarm_OverwriteReplacesExistingKeys(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:price:btc", "stale", time.Minute))
+
+	stats, err := mc.Warm(context.Background(), []string{"api:price:btc"}, time.Minute, WarmOverwrite, func(ctx context.Context, key string) (interface{}, error) {
+		return "fresh", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, WarmStats{Stored: 1}, stats)
+
+	var value string
+	mc.GetCache("api:price:btc", &value)
+	assert.Equal(t, "fresh", value)
+}
+
+func TestWarmStatus_TracksRunningAndLastRun(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.Equal(t, WarmStatus{}, mc.WarmStatus())
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mc.Warm(context.Background(), []string{"a", "b", "c"}, time.Minute, WarmOverwrite, func(ctx context.Context, key string) (interface{}, error) {
+			if key == "a" {
+				close(fetching)
+				<-release
+			}
+			if key == "c" {
+				return nil, errors.New("origin unavailable")
+			}
+			return key, nil
+		})
+	}()
+
+	<-fetching
+	assert.True(t, mc.WarmStatus().InProgress)
+	close(release)
+	<-done
+
+	status := mc.WarmStatus()
+	assert.False(t, status.InProgress)
+	if assert.NotNil(t, status.Last) {
+		assert.Equal(t, WarmOverwrite, status.Last.Mode)
+		assert.Equal(t, 3, status.Last.Attempted)
+		assert.Equal(t, WarmStats{Stored: 2, Failed: 1}, status.Last.Stats)
+		assert.NoError(t, status.Last.Err)
+		assert.False(t, status.Last.FinishedAt.Before(status.Last.StartedAt))
+	}
+}
+
+func TestEncryption_RoundTripsAcrossKeyRotation(t *testing.T) {
+	mc, fake := newTestMemcached()
+	oldKey, newKey := []byte(strings.Repeat("a", 32)), []byte(strings.Repeat("b", 32))
+	assert.ErrorIs(t, mc.EnableEncryption("session"), ErrNoEncryptionKey)
+
+	// Written before the namespace is encrypted, so stored in plain JSON
+	assert.NoError(t, mc.SetCache("session:legacy", "token-0", time.Minute))
+
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{1: oldKey}, 1))
+	assert.NoError(t, mc.EnableEncryption("session"))
+	assert.NoError(t, mc.SetCache("session:old", "token-1", time.Minute))
+	stored := fake.items[mc.fullKey("session:old")]
+	assert.Equal(t, flagEncrypted, stored.Flags&flagEncrypted)
+	assert.Equal(t, byte(1), stored.Value[0])
+	assert.NotContains(t, string(stored.Value), "token-1")
+
+	// Other namespaces are stored as before
+	assert.NoError(t, mc.SetCache("api:price", "100", time.Minute))
+	assert.Equal(t, flagJSON, fake.items[mc.fullKey("api:price")].Flags)
+
+	// After rotating, new entries use the new key and old ones still read with theirs
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{1: oldKey, 2: newKey}, 2))
+	assert.NoError(t, mc.SetCache("session:new", "token-2", time.Minute))
+	assert.Equal(t, byte(2), fake.items[mc.fullKey("session:new")].Value[0])
+	for key, want := range map[string]string{"session:legacy": "token-0", "session:old": "token-1", "session:new": "token-2"} {
+		var token string
+		found, err := mc.GetCache(key, &token)
+		assert.True(t, found, key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, token)
+	}
+	values, err := mc.GetMultiCache([]string{"session:old", "session:new"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `"token-2"`, string(values["session:new"]))
+
+	// A value moved to another key doesn't decrypt there
+	moved := *fake.items[mc.fullKey("session:new")]
+	moved.Key = mc.fullKey("session:other")
+	fake.items[moved.Key] = &moved
+	var token string
+	found, err := mc.GetCache("session:other", &token)
+	assert.False(t, found)
+	assert.NoError(t, err)
+
+	// Tampered values fail authentication and are deleted
+	assert.NotContains(t, fake.items, mc.fullKey("session:other"))
+
+	// Without the old key its entries read as misses but are kept for instances that have it
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{2: newKey}, 2))
+	found, err = mc.GetCache("session:old", &token)
+	assert.False(t, found)
+	assert.NoError(t, err)
+	assert.Contains(t, fake.items, mc.fullKey("session:old"))
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{1: oldKey, 2: newKey}, 2))
+	found, err = mc.GetCache("session:old", &token)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+}
+
+func TestEncryption_CoversEveryWritePath(t *testing.T) {
+	mc, fake := newTestMemcached()
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{1: []byte(strings.Repeat("k", 32))}, 1))
+	assert.NoError(t, mc.EnableEncryption("session"))
+	encrypted := func(key string) bool {
+		item, ok := fake.items[mc.fullKey(key)]
+		return ok && item.Flags&flagEncrypted == flagEncrypted
+	}
+
+	// AddCache, and FillMissing warm-ups that go through it
+	stored, err := mc.AddCache("session:added", "token-a", time.Minute)
+	assert.True(t, stored)
+	assert.NoError(t, err)
+	assert.True(t, encrypted("session:added"))
+	_, err = mc.Warm(context.Background(), []string{"session:warmed"}, time.Minute, WarmFillMissing, func(ctx context.Context, key string) (interface{}, error) {
+		return "token-w", nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, encrypted("session:warmed"))
+
+	// GetAndSet stores encrypted values and reads the encrypted value it replaces
+	var old string
+	found, err := mc.GetAndSet("session:added", "token-b", time.Minute, &old)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-a", old)
+	assert.True(t, encrypted("session:added"))
+
+	// GetWithMigration re-encrypts the value for its new key
+	var migrated string
+	found, err = mc.GetWithMigration("session:warmed", "session:warmed:v2", &migrated, time.Minute)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-w", migrated)
+	assert.True(t, encrypted("session:warmed:v2"))
+
+	// SoftDelete re-encrypts the stale envelope it writes
+	assert.NoError(t, mc.SoftDelete("session:warmed:v2", time.Minute))
+	assert.True(t, encrypted("session:warmed:v2"))
+	var stale string
+	found, isStale, err := mc.GetAllowStale("session:warmed:v2", &stale)
+	assert.True(t, found)
+	assert.True(t, isStale)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-w", stale)
+	multi, err := mc.GetMultiCache([]string{"session:warmed:v2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(multi))
+
+	for key, want := range map[string]string{"session:added": "token-b", "session:warmed": "token-w"} {
+		var token string
+		found, err := mc.GetCache(key, &token)
+		assert.True(t, found, key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, token)
+	}
+}
+
+func TestEncryption_AppliesToTenantScopedKeys(t *testing.T) {
+	mc, fake := newTestMemcached()
+	assert.NoError(t, mc.SetEncryptionKeys(map[byte][]byte{1: []byte(strings.Repeat("k", 32))}, 1))
+	assert.NoError(t, mc.EnableEncryption("session"))
+	tenant := mc.TenantCache("acme")
+
+	assert.NoError(t, tenant.SetCache("session:abc", "token", time.Minute))
+	assert.NoError(t, tenant.SetCache("profile:abc", "name", time.Minute))
+	sessionKey, _ := tenant.Key("session:abc")
+	profileKey, _ := tenant.Key("profile:abc")
+	assert.Equal(t, flagEncrypted, fake.items[mc.fullKey(sessionKey)].Flags&flagEncrypted)
+	assert.Equal(t, uint32(0), fake.items[mc.fullKey(profileKey)].Flags&flagEncrypted)
+
+	var token string
+	found, err := tenant.GetCache("session:abc", &token)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token)
+}
+
+func TestSetCacheUntil_CapsTTLAtDeadline(t *testing.T) {
+	mc, fake := newTestMemcached()
+
+	// A token expiring in 30s caps the default hour
+	assert.NoError(t, mc.SetCacheUntil("session:alice", "profile", 0, time.Now().Add(30*time.Second+500*time.Millisecond)))
+	assert.Equal(t, int32(30), fake.items["session:alice"].Expiration)
+
+	// A requested TTL shorter than the deadline is kept
+	assert.NoError(t, mc.SetCacheUntil("session:bob", "profile", 10*time.Second, time.Now().Add(time.Hour)))
+	assert.Equal(t, int32(10), fake.items["session:bob"].Expiration)
+
+	// A context deadline caps the TTL the same way
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+500*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, mc.SetCacheContext(ctx, "session:carol", "profile", time.Hour))
+	assert.Equal(t, int32(5), fake.items["session:carol"].Expiration)
+
+	// Nothing is stored once the deadline is (nearly) past
+	err := mc.SetCacheUntil("session:dave", "profile", time.Minute, time.Now().Add(200*time.Millisecond))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotContains(t, fake.items, "session:dave")
+}
+
+func TestGetAndTouch_ExtendsTTLOnHitOnly(t *testing.T) {
+	fake := newFakeMemcache()
+	clients := map[string]MemcacheClient{
+		"gat":   gatMemcache{fake},
+		"touch": fake,
+		"cas":   struct{ MemcacheClient }{fake}, // Hides Touch
+	}
+	for name, client := range clients {
+		mc := DefaultMemcachedConfig()
+		mc.Client = client
+		assert.NoError(t, mc.SetCache("session:alice", "token", time.Minute), name)
+
+		var token string
+		found, err := mc.GetAndTouch("session:alice", &token, 30*time.Minute)
+		assert.NoError(t, err, name)
+		assert.True(t, found, name)
+		assert.Equal(t, "token", token, name)
+		assert.Equal(t, int32(1800), fake.items["session:alice"].Expiration, name)
+
+		// A miss touches nothing and creates nothing
+		found, err = mc.GetAndTouch("session:bob", &token, 30*time.Minute)
+		assert.NoError(t, err, name)
+		assert.False(t, found, name)
+		assert.NotContains(t, fake.items, "session:bob", name)
+	}
+	assert.Equal(t, 2, fake.callCount("gat"))
+	assert.Equal(t, 1, fake.callCount("touch"))
+	assert.Equal(t, 1, fake.callCount("cas"))
+}
+
+func TestAddCache_DoesNotOverwrite(t *testing.T) {
+	mc, _ := newTestMemcached()
+	stored, err := mc.AddCache("api:lock", "first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	stored, err = mc.AddCache("api:lock", "second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+}
+
+type unserializablePayload struct {
+	Name    string
+	Updates chan int
+}
+
+func TestSetCache_UnserializableValueNamesKeyAndType(t *testing.T) {
+	mc, client := newTestMemcached()
+	value := unserializablePayload{Name: "feed", Updates: make(chan int)}
+
+	assert.Error(t, CanCache(value))
+	assert.NoError(t, CanCache(map[string]int{"ok": 1}))
+
+	err := mc.SetCache("api:feed", value, time.Minute)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "api:feed")
+		assert.Contains(t, err.Error(), "config.unserializablePayload")
+	}
+	assert.Empty(t, client.items)
+}
+
+func TestGetOrLoad_OriginBudgetBoundsConcurrencyDuringOutage(t *testing.T) {
+	mc, client := newTestMemcached()
+	client.down = true
+	mc.SetOriginBudget(3, time.Second)
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	load := func(ctx context.Context) (interface{}, error) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return "origin", nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var value string
+			errs <- mc.GetOrLoad(context.Background(), fmt.Sprintf("api:price:%d", i), &value, time.Minute, load)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, peak, 3)
+}
+
+func TestGetOrLoad_FailsFastWhenOriginBudgetExhausted(t *testing.T) {
+	mc, client := newTestMemcached()
+	client.down = true
+	mc.SetOriginBudget(1, 0)
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	go mc.GetOrLoad(context.Background(), "api:slow", new(string), time.Minute, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-unblock
+		return "slow", nil
+	})
+	<-started
+	defer close(unblock)
+
+	var value string
+	err := mc.GetOrLoad(context.Background(), "api:other", &value, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "other", nil
+	})
+	assert.Equal(t, ErrOriginBusy, err)
+}
+
+func TestGetOrLoad_UsesTTLReturnedByLoader(t *testing.T) {
+	mc, client := newTestMemcached()
+
+	var value string
+	err := mc.GetOrLoad(context.Background(), "api:price:btc", &value, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return LoadedValue{Value: "64000", TTL: 30 * time.Second}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "64000", value)
+	assert.Equal(t, int32(30), client.items["api:price:btc"].Expiration)
+
+	// An origin TTL longer than the configured expiration doesn't extend it
+	err = mc.GetOrLoad(context.Background(), "api:price:ada", &value, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return LoadedValue{Value: "1", TTL: TTLFromCacheControl("max-age=999999999999")}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(60), client.items["api:price:ada"].Expiration)
+
+	// Without an origin TTL the configured expiration applies
+	err = mc.GetOrLoad(context.Background(), "api:price:eth", &value, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return LoadedValue{Value: "3200"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(60), client.items["api:price:eth"].Expiration)
+
+	// An uncacheable origin response is returned but not stored
+	err = mc.GetOrLoad(context.Background(), "api:price:sol", &value, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return LoadedValue{Value: "150", TTL: TTLFromCacheControl("no-store")}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "150", value)
+	assert.NotContains(t, client.items, "api:price:sol")
+}
+
+func TestTTLFromCacheControl(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":                                 0,
+		"public":                           0,
+		"max-age=120":                      2 * time.Minute,
+		"public, max-age=60, s-maxage=300": 5 * time.Minute,
+		"max-age=0":                        -1,
+		"no-store":                         -1,
+		"private, max-age=60":              -1,
+		"max-age=soon":                     0,
+		"max-age=999999999999":             30 * 24 * time.Hour,
+	}
+	for header, want := range cases {
+		assert.Equal(t, want, TTLFromCacheControl(header), header)
+	}
+}
+
+func TestSetCache_ReplicatesToSecondaryAsynchronously(t *testing.T) {
+	mc, primary := newTestMemcached()
+	secondary, secondaryClient := newTestMemcached()
+	mc.SecondaryConfig = secondary
+	mc.ReplicateWrites = true
+
+	// Hold the secondary's lock so replication can't complete until SetCache has returned
+	secondaryClient.mu.Lock()
+	assert.NoError(t, mc.SetCache("api:price:eth", 3200, time.Minute))
+	assert.Contains(t, primary.items, "api:price:eth")
+	secondaryClient.mu.Unlock()
+
+	var value int
+	assert.Eventually(t, func() bool {
+		found, _ := secondary.GetCache("api:price:eth", &value)
+		return found
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3200, value)
+	assert.NoError(t, mc.Close())
+}
+
+func TestSetCache_SecondaryFailureDoesNotFailWrite(t *testing.T) {
+	mc, _ := newTestMemcached()
+	secondary, secondaryClient := newTestMemcached()
+	secondaryClient.down = true
+	mc.SecondaryConfig = secondary
+	mc.ReplicateWrites = true
+
+	assert.NoError(t, mc.SetCache("api:price:eth", 3200, time.Minute))
+	assert.NoError(t, mc.Close())
+	assert.Equal(t, 1, secondaryClient.calls["set"])
+}
+
+func TestReplication_ReplicatesDeletesAndDropsOnOverflow(t *testing.T) {
+	mc, _ := newTestMemcached()
+	secondary, secondaryClient := newTestMemcached()
+	mc.SecondaryConfig = secondary
+	mc.ReplicateWrites = true
+	mc.ReplicationQueueSize = 1
+	mc.ReplicationWorkers = 1
+
+	assert.NoError(t, mc.SetCache("api:price:eth", 3200, time.Minute))
+	assert.Eventually(t, func() bool {
+		secondaryClient.mu.Lock()
+		defer secondaryClient.mu.Unlock()
+		return secondaryClient.items["api:price:eth"] != nil
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, mc.DeleteCache("api:price:eth"))
+	assert.Eventually(t, func() bool {
+		secondaryClient.mu.Lock()
+		defer secondaryClient.mu.Unlock()
+		return secondaryClient.items["api:price:eth"] == nil
+	}, time.Second, time.Millisecond)
+
+	// With the worker stuck on the secondary, writes beyond the queue are dropped and counted
+	dropped := testutil.ToFloat64(CacheReplicationDroppedTotal.WithLabelValues("set"))
+	secondaryClient.mu.Lock()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, mc.SetCache(fmt.Sprintf("api:price:%d", i), i, time.Minute))
+	}
+	secondaryClient.mu.Unlock()
+	assert.True(t, testutil.ToFloat64(CacheReplicationDroppedTotal.WithLabelValues("set")) >= dropped+3)
+	assert.NoError(t, mc.Close())
+}
+
+type sampleTransaction struct {
+	Hash     string
+	From     string
+	To       string
+	Value    uint64
+	GasPrice uint64
+	Input    []byte
+}
+
+type sampleBlock struct {
+	Number       uint64
+	Hash         string
+	ParentHash   string
+	Timestamp    int64
+	Miner        string
+	Transactions []sampleTransaction
+}
+
+// newSampleBlock builds a block with n transactions for encoding tests and benchmarks
+func newSampleBlock(n int) sampleBlock {
+	block := sampleBlock{
+		Number:     18000000,
+		Hash:       "0x9b83c12c69edb74f6c8dd5d052765c1adf940e320bd1291696e6fa07829eee71",
+		ParentHash: "0x6cfb6b28a2b4e2c6b6ebd4d8f0e5c9e8a1f7c3b9d2e4a6c8b0d2f4e6a8c0b2d4",
+		Timestamp:  1693000000,
+		Miner:      "0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5",
+	}
+	for i := 0; i < n; i++ {
+		block.Transactions = append(block.Transactions, sampleTransaction{
+			Hash:     fmt.Sprintf("0x%064x", i),
+			From:     "0x742d35cc6634c0532925a3b844bc454e4438f44e",
+			To:       "0xdac17f958d2ee523a2206206994597c13d831ec7",
+			Value:    uint64(i) * 1e15,
+			GasPrice: 30e9,
+			Input:    []byte("a9059cbb000000000000000000000000"),
+		})
+	}
+	return block
+}
+
+func TestBlockchainEncoding_RoundTrip(t *testing.T) {
+	block := newSampleBlock(10)
+	for _, encoding := range []BlockchainEncoding{EncodingJSON, EncodingGob} {
+		mc, _ := newTestMemcached()
+		mc.BlockchainEncoding = encoding
+		assert.NoError(t, mc.SetCachedBlockchainData("block", "18000000", block, time.Minute))
+
+		var decoded sampleBlock
+		found, err := mc.GetCachedBlockchainData("block", "18000000", &decoded)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, block, decoded, string(encoding))
+	}
+}
+
+func TestBlockchainEncoding_ProtobufRoundTrip(t *testing.T) {
+	mc, _ := newTestMemcached()
+	mc.BlockchainEncoding = EncodingProtobuf
+	receipt, err := structpb.NewStruct(map[string]interface{}{"status": "success", "gasUsed": 21000.0})
+	assert.NoError(t, err)
+	assert.NoError(t, mc.SetCachedBlockchainData("receipt", "0xabc", receipt, time.Minute))
+
+	decoded := &structpb.Struct{}
+	found, err := mc.GetCachedBlockchainData("receipt", "0xabc", decoded)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, receipt.AsMap(), decoded.AsMap())
+
+	// Plain structs can't be protobuf-encoded
+	assert.Error(t, mc.SetCachedBlockchainData("block", "1", newSampleBlock(1), time.Minute))
+}
+
+func TestBlockchainEncoding_ReadsEntriesWrittenInOtherEncodings(t *testing.T) {
+	mc, _ := newTestMemcached()
+	block := newSampleBlock(2)
+	assert.NoError(t, mc.SetCachedBlockchainData("block", "legacy", block, time.Minute))
+
+	// Switching to gob keeps existing JSON entries readable
+	mc.BlockchainEncoding = EncodingGob
+	assert.NoError(t, mc.SetCachedBlockchainData("block", "new", block, time.Minute))
+	for _, id := range []string{"legacy", "new"} {
+		var decoded sampleBlock
+		found, err := mc.GetCachedBlockchainData("block", id, &decoded)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, block, decoded)
+	}
+}
+
+func TestItemFlags_RoundTripEachEncoding(t *testing.T) {
+	mc, fake := newTestMemcached()
+	block := newSampleBlock(10)
+
+	assert.NoError(t, mc.SetCache("json", block, time.Minute))
+	assert.NoError(t, mc.SetCache("raw", RawValue{0x01, 0xff, 0x00}, time.Minute))
+	for _, encoding := range []BlockchainEncoding{EncodingGob, EncodingProtobuf} {
+		mc.BlockchainEncoding = encoding
+		var value interface{} = block
+		if encoding == EncodingProtobuf {
+			receipt, err := structpb.NewStruct(map[string]interface{}{"status": "success"})
+			assert.NoError(t, err)
+			value = receipt
+		}
+		assert.NoError(t, mc.SetCachedBlockchainData("block", string(encoding), value, time.Minute))
+	}
+	mc.CompressMinBytes = 64
+	assert.NoError(t, mc.SetCache("compressed", block, time.Minute))
+
+	assert.Equal(t, flagJSON, fake.items["json"].Flags)
+	assert.Equal(t, flagRaw, fake.items["raw"].Flags)
+	assert.Equal(t, flagGob, fake.items["blockchain:block:gob"].Flags)
+	assert.Equal(t, flagProtobuf, fake.items["blockchain:block:protobuf"].Flags)
+	assert.Equal(t, flagJSON|flagCompressed, fake.items["compressed"].Flags)
+
+	for _, key := range []string{"json", "blockchain:block:gob", "compressed"} {
+		var decoded sampleBlock
+		found, err := mc.GetCache(key, &decoded)
+		assert.NoError(t, err, key)
+		assert.True(t, found, key)
+		assert.Equal(t, block, decoded, key)
+	}
+	var raw []byte
+	found, err := mc.GetCache("raw", &raw)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte{0x01, 0xff, 0x00}, raw)
+
+	receipt := &structpb.Struct{}
+	found, err = mc.GetCachedBlockchainData("block", "protobuf", receipt)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "success", receipt.AsMap()["status"])
+
+	// Compressed JSON is still returned as JSON by multi-gets, binary encodings are not
+	cached, err := mc.GetMultiCache([]string{"json", "compressed", "raw"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(cached["json"]), string(cached["compressed"]))
+	assert.NotContains(t, cached, "raw")
+}
+
+func TestItemFlags_DecodesByFlagsNotContent(t *testing.T) {
+	mc, fake := newTestMemcached()
+	block := newSampleBlock(3)
+
+	// A gob value written by another client is decoded by its flags, not sniffed
+	gobData, flags, err := encodeBlockchainValue(EncodingGob, "block", block)
+	assert.NoError(t, err)
+	fake.items["migrating"] = &memcache.Item{Key: "migrating", Value: gobData, Flags: flags}
+	var decoded sampleBlock
+	found, err := mc.GetCache("migrating", &decoded)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, block, decoded)
+
+	// Rewriting the key as JSON switches the decoder with it
+	assert.NoError(t, mc.SetCache("migrating", block, time.Minute))
+	decoded = sampleBlock{}
+	found, err = mc.GetCache("migrating", &decoded)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, block, decoded)
+
+	// Entries from before flags carried the encoding as a prefix byte and still decode
+	fake.items["legacy"] = &memcache.Item{Key: "legacy", Value: append([]byte{formatTagGob}, gobData...)}
+	decoded = sampleBlock{}
+	found, err = mc.GetCache("legacy", &decoded)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, block, decoded)
+}
+
+func TestItemFlags_ReadsRawJSONAsRawMessage(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:prerendered", RawValue(`{"price":3300}`), time.Minute))
+	assert.NoError(t, mc.SetCache("api:binary", RawValue{0x01, 0xff}, time.Minute))
+
+	// Pre-encoded JSON stored raw reads back as JSON, e.g. through a request's memo
+	var message json.RawMessage
+	found, err := mc.GetCache("api:prerendered", &message)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.JSONEq(t, `{"price":3300}`, string(message))
+
+	_, err = mc.GetCache("api:binary", &message)
+	assert.Error(t, err)
+}
+
+func TestItemFlags_StoresByteSlicesAsJSONUnlessRaw(t *testing.T) {
+	mc, fake := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:bytes", []byte{0x01, 0xff}, time.Minute))
+	stored, err := mc.AddCache("api:added", RawValue("added"), time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	// A plain []byte is JSON like any other value, so JSON-only reads still work
+	assert.Equal(t, flagJSON, fake.items["api:bytes"].Flags)
+	values, err := mc.GetMultiCache([]string{"api:bytes"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `"Af8="`, string(values["api:bytes"]))
+	var bytes []byte
+	found, err := mc.GetCache("api:bytes", &bytes)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte{0x01, 0xff}, bytes)
+
+	// AddCache stores a RawValue raw, as SetCache does
+	assert.Equal(t, flagRaw, fake.items["api:added"].Flags)
+	var added string
+	found, err = mc.GetCache("api:added", &added)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "added", added)
+}
+
+func TestItemFlags_RejectsUnknownFlags(t *testing.T) {
+	mc, fake := newTestMemcached()
+	fake.items["future"] = &memcache.Item{Key: "future", Value: []byte(`{}`), Flags: 0x07}
+	fake.items["unknown_bit"] = &memcache.Item{Key: "unknown_bit", Value: []byte(`{}`), Flags: 1 << 8}
+
+	for _, key := range []string{"future", "unknown_bit"} {
+		var value map[string]interface{}
+		found, err := mc.GetCache(key, &value)
+		assert.False(t, found, key)
+		assert.ErrorIs(t, err, ErrUnknownEncoding, key)
+	}
+}
+
+func TestChecksums_CorruptedEntryIsDeletedAndMissed(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.Checksums = true
+	mc.CompressMinBytes = 64
+	type quote struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price"`
+		Note   string  `json:"note"`
+	}
+	stored := quote{Symbol: "BTC", Price: 42000.5}
+	compressed := quote{Symbol: "ETH", Price: 2200.25, Note: strings.Repeat("spread ", 40)}
+	assert.NoError(t, mc.SetCache("price:btc", stored, time.Minute))
+	assert.NoError(t, mc.SetCache("price:eth", compressed, time.Minute))
+	assert.NoError(t, mc.SetCache("price:sol", stored, time.Minute))
+
+	// Intact entries, compressed or not, pass their checksum
+	var read quote
+	found, err := mc.GetCache("price:btc", &read)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, stored, read)
+	found, err = mc.GetCache("price:eth", &read)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, compressed, read)
+
+	// A flipped bit and a truncated value are both caught before decoding
+	fake.items["price:btc"].Value[2] ^= 0x01
+	fake.items["price:eth"].Value = fake.items["price:eth"].Value[:len(fake.items["price:eth"].Value)-3]
+	for _, key := range []string{"price:btc", "price:eth"} {
+		read = quote{}
+		found, err = mc.GetCache(key, &read)
+		assert.NoError(t, err, key)
+		assert.False(t, found, key)
+		assert.Equal(t, quote{}, read, key)
+		assert.NotContains(t, fake.items, key, "corrupted entry is deleted")
+	}
+	assert.Contains(t, fake.items, "price:sol")
+
+	// Entries written without checksums stay readable once they are enabled, and vice versa
+	mc.Checksums = false
+	assert.NoError(t, mc.SetCache("price:doge", stored, time.Minute))
+	found, err = mc.GetCache("price:sol", &read)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	mc.Checksums = true
+	found, err = mc.GetCache("price:doge", &read)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestParseBlockchainEncoding(t *testing.T) {
+	encoding, err := ParseBlockchainEncoding("gob")
+	assert.NoError(t, err)
+	assert.Equal(t, EncodingGob, encoding)
+	_, err = ParseBlockchainEncoding("msgpack")
+	assert.Error(t, err)
+}
+
+// benchmarkBlockchainEncoding measures round-trip time and reports the encoded size of a sample block
+func benchmarkBlockchainEncoding(b *testing.B, encoding BlockchainEncoding) {
+	block := newSampleBlock(200)
+	data, _, err := encodeBlockchainValue(encoding, "bench", block)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, flags, _ := encodeBlockchainValue(encoding, "bench", block)
+		var decoded sampleBlock
+		if err := decodeValue(data, flags, &decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/block")
+}
+
+func BenchmarkBlockchainEncoding_JSON(b *testing.B) { benchmarkBlockchainEncoding(b, EncodingJSON) }
+func BenchmarkBlockchainEncoding_Gob(b *testing.B)  { benchmarkBlockchainEncoding(b, EncodingGob) }
+
+func TestTraceOperation_SamplesDebugLogs(t *testing.T) {
+	mc, _ := newTestMemcached()
+	core, logs := observer.New(zapcore.DebugLevel)
+	mc.Logger = zap.New(core)
+	mc.LogSampleRate = 5
+
+	for i := 0; i < 10; i++ {
+		mc.SetCache("api:price:btc", 42000, time.Minute)
+		var value int
+		mc.GetCache("api:price:btc", &value)
+	}
+
+	// 20 operations sampled 1 in 5
+	entries := logs.All()
+	assert.Len(t, entries, 4)
+	for _, entry := range entries {
+		assert.Equal(t, zapcore.DebugLevel, entry.Level)
+		fields := entry.ContextMap()
+		assert.Equal(t, "api", fields["key_prefix"])
+		assert.Contains(t, fields, "latency")
+		assert.Contains(t, fields, "size_bytes")
+		assert.Contains(t, []interface{}{"ok", "hit"}, fields["result"])
+	}
+}
+
+func TestTraceOperation_NothingLoggedAboveDebug(t *testing.T) {
+	mc, _ := newTestMemcached()
+	core, logs := observer.New(zapcore.InfoLevel)
+	mc.Logger = zap.New(core)
+	mc.LogSampleRate = 1
+
+	mc.SetCache("api:price:btc", 42000, time.Minute)
+	assert.Equal(t, 0, logs.Len())
+}
+
+func TestLoadMemcachedConfig_RejectsNonPositiveDurations(t *testing.T) {
+	defaults := DefaultMemcachedConfig()
+	defer os.Unsetenv("MEMCACHED_TIMEOUT_SECONDS")
+	defer os.Unsetenv("MEMCACHED_DEFAULT_EXPIRY_SECONDS")
+
+	for _, value := range []string{"0", "-1", "-0.5"} {
+		os.Setenv("MEMCACHED_TIMEOUT_SECONDS", value)
+		os.Setenv("MEMCACHED_DEFAULT_EXPIRY_SECONDS", value)
+		mc, err := LoadMemcachedConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, defaults.Timeout, mc.Timeout, value)
+		assert.Equal(t, defaults.DefaultExpiry, mc.DefaultExpiry, value)
+	}
+}
+
+func TestLoadMemcachedConfig_ClampsShortDurations(t *testing.T) {
+	os.Setenv("MEMCACHED_TIMEOUT_SECONDS", "0.001")
+	os.Setenv("MEMCACHED_DEFAULT_EXPIRY_SECONDS", "0.2")
+	defer os.Unsetenv("MEMCACHED_TIMEOUT_SECONDS")
+	defer os.Unsetenv("MEMCACHED_DEFAULT_EXPIRY_SECONDS")
+
+	mc, err := LoadMemcachedConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, mc.Timeout)
+	assert.Equal(t, time.Second, mc.DefaultExpiry)
+
+	os.Setenv("MEMCACHED_TIMEOUT_SECONDS", "0.25")
+	mc, err = LoadMemcachedConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, mc.Timeout)
+}
+
+func TestInvalidateManifest_DeletesRecordedKeys(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.KeyPrefix = "prod"
+	mc.EnableManifest("session")
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		assert.NoError(t, mc.SetCache("session:"+user, "token-"+user, time.Minute))
+	}
+	assert.NoError(t, mc.SetCache("session:alice", "token-alice-2", time.Minute))
+	assert.NoError(t, mc.SetCache("api:price:btc", 42000, time.Minute))
+
+	keys, err := mc.ListKeys("session")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"session:alice", "session:bob", "session:carol"}, keys)
+
+	deleted, err := mc.InvalidateManifest("session")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	assert.NotContains(t, client.items, "prod:session:alice")
+	for key := range client.items {
+		assert.NotContains(t, key, "manifest")
+	}
+	assert.Contains(t, client.items, "prod:api:price:btc")
+}
+
+func TestManifest_ShardsKeysAndSkipsRecordedKeys(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.EnableManifest("session")
+	for i := 0; i < 64; i++ {
+		assert.NoError(t, mc.SetCache(fmt.Sprintf("session:%d", i), i, time.Minute))
+	}
+
+	shards := 0
+	for key := range client.items {
+		if strings.HasPrefix(key, "manifest:session:") {
+			shards++
+		}
+	}
+	assert.True(t, shards > 1, "manifest should be split across shards, got %d", shards)
+	keys, err := mc.ListKeys("session")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 64)
+
+	// Rewriting a recorded key reads its shard but writes only the key itself
+	sets, cas := client.callCount("set"), client.callCount("cas")
+	assert.NoError(t, mc.SetCache("session:7", 7, time.Minute))
+	assert.Equal(t, sets+1, client.callCount("set"))
+	assert.Equal(t, cas, client.callCount("cas"))
+}
+
+func TestManifest_StopsTrackingWhenFull(t *testing.T) {
+	mc, _ := newTestMemcached()
+	mc.ManifestMaxKeys = 2
+	mc.ManifestShards = 1
+	mc.EnableManifest("session")
+	for _, user := range []string{"alice", "bob", "carol"} {
+		assert.NoError(t, mc.SetCache("session:"+user, user, time.Minute))
+	}
+
+	var manifest []string
+	mc.GetCache("manifest:session:0", &manifest)
+	assert.Equal(t, []string{"session:alice", "session:bob"}, manifest)
+}
+
+func TestInvalidateTag_ClearsKeysAcrossChunks(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.KeyPrefix = "prod"
+	mc.TagChunkMaxBytes = 256 // About 15 keys per chunk
+
+	var tagged []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("api:price:token-%03d", i)
+		tagged = append(tagged, key)
+		assert.NoError(t, mc.SetWithTags(key, i, time.Minute, "prices"))
+	}
+	assert.NoError(t, mc.SetCache("api:price:untagged", 1, time.Minute))
+
+	// The index was split into chunks, each under the size limit, together holding every key
+	var chunks int
+	found, err := mc.GetCache("tag:prices", &chunks)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Greater(t, chunks, 5)
+	var indexed []string
+	for n := 0; n < chunks; n++ {
+		item := client.items[fmt.Sprintf("prod:tag:prices:%d", n)]
+		if assert.NotNil(t, item, "chunk %d", n) {
+			assert.LessOrEqual(t, len(item.Value), mc.TagChunkMaxBytes)
+			var keys []string
+			_, err := mc.GetCache(fmt.Sprintf("tag:prices:%d", n), &keys)
+			assert.NoError(t, err)
+			indexed = append(indexed, keys...)
+		}
+	}
+	assert.ElementsMatch(t, tagged, indexed)
+
+	deleted, err := mc.InvalidateTag("prices")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, deleted)
+	for _, key := range tagged {
+		assert.NotContains(t, client.items, "prod:"+key)
+	}
+	for n := 0; n < chunks; n++ {
+		assert.NotContains(t, client.items, fmt.Sprintf("prod:tag:prices:%d", n))
+	}
+	assert.NotContains(t, client.items, "prod:tag:prices")
+	assert.Contains(t, client.items, "prod:api:price:untagged")
+}
+
+func TestInvalidateTag_ProbesChunksWithoutHead(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.TagChunkMaxBytes = 128
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, mc.SetWithTags(fmt.Sprintf("session:user-%02d", i), i, time.Minute, "sessions"))
+	}
+
+	// Losing the head doesn't lose the chunks it counted
+	delete(client.items, "tag:sessions")
+	deleted, err := mc.InvalidateTag("sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, deleted)
+	assert.NotContains(t, client.items, "session:user-29")
+}
+
+func TestSetWithTags_AdvancingChunksDoesNotUseRetries(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.TagChunkMaxBytes = 128
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, mc.SetWithTags(fmt.Sprintf("session:user-%02d", i), i, time.Minute, "sessions"))
+	}
+
+	// Without a head a new key walks through every full chunk, with no retries to spare
+	delete(client.items, "tag:sessions")
+	mc.CASMaxRetries = 0
+	assert.NoError(t, mc.SetWithTags("session:user-30", 30, time.Minute, "sessions"))
+
+	deleted, err := mc.InvalidateTag("sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, 31, deleted)
+	assert.NotContains(t, client.items, "session:user-30")
+}
+
+func TestTTLPolicyLoader_LoadsAndRefreshes(t *testing.T) {
+	var mu sync.Mutex
+	policy := `{"default": "2m", "data_types": {"block": "30s"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(policy))
+	}))
+	defer server.Close()
+
+	mc, client := newTestMemcached()
+	defer mc.Close()
+	mc.StartTTLPolicyLoader(server.URL, 10*time.Millisecond)
+	assert.Equal(t, 30*time.Second, mc.TTLPolicy().TTL("block"))
+	assert.Equal(t, 2*time.Minute, mc.TTLPolicy().TTL("account"))
+
+	assert.NoError(t, mc.SetCachedBlockchainData("block", "1", "data", 0))
+	assert.Equal(t, int32(30), client.items["blockchain:block:1"].Expiration)
+
+	mu.Lock()
+	policy = `{"default": "2m", "data_types": {"block": "45s"}}`
+	mu.Unlock()
+	assert.Eventually(t, func() bool {
+		return mc.TTLPolicy().TTL("block") == 45*time.Second
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTTLPolicyLoader_FallsBackWhenUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"default": "-1s"}`))
+	}))
+	defer server.Close()
+
+	mc, _ := newTestMemcached()
+	defer mc.Close()
+	mc.StartTTLPolicyLoader("http://127.0.0.1:1/policy", time.Hour)
+	assert.Equal(t, DefaultTTLPolicy(), mc.TTLPolicy())
+
+	// Invalid policies are rejected too
+	mc.StartTTLPolicyLoader(server.URL, time.Hour)
+	assert.Equal(t, DefaultTTLPolicy(), mc.TTLPolicy())
+}
+
+func TestGetCacheWithMeta_ReportsStoredAtAndRemainingTTL(t *testing.T) {
+	mc, _ := newTestMemcached()
+	before := time.Now()
+	assert.NoError(t, mc.SetCacheWithMeta("api:price:btc", 42000, time.Minute))
+
+	var value int
+	meta, found, err := mc.GetCacheWithMeta("api:price:btc", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42000, value)
+	assert.False(t, meta.StoredAt.Before(before.Truncate(time.Second)))
+	assert.False(t, meta.StoredAt.After(time.Now()))
+	assert.Equal(t, time.Minute, meta.TTL)
+	assert.True(t, meta.RemainingTTL > 55*time.Second && meta.RemainingTTL <= time.Minute, meta.RemainingTTL)
+}
+
+func TestGetCacheWithMeta_PlainValuesHaveNoMeta(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:price:eth", map[string]int{"usd": 3200}, time.Minute))
+
+	var value map[string]int
+	meta, found, err := mc.GetCacheWithMeta("api:price:eth", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3200, value["usd"])
+	assert.Equal(t, CacheMeta{}, meta)
+
+	_, found, err = mc.GetCacheWithMeta("api:price:missing", &value)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestDeleteMultiCache_ReportsOnlyGenuineFailures(t *testing.T) {
+	mc, client := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:price:btc", 1, time.Minute))
+	assert.NoError(t, mc.SetCache("api:price:eth", 2, time.Minute))
+	assert.NoError(t, mc.SetCache("api:price:sol", 3, time.Minute))
+	client.failKeys = map[string]bool{mc.fullKey("api:price:eth"): true, mc.fullKey("api:price:ada"): true}
+
+	keys := []string{"api:price:btc", "api:price:eth", "api:price:missing", "api:price:sol", "api:price:ada"}
+	failed, err := mc.DeleteMultiCache(keys)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"api:price:eth", "api:price:ada"}, failed)
+	assert.Equal(t, len(keys), client.callCount("delete"))
+
+	var value int
+	for _, key := range []string{"api:price:btc", "api:price:sol"} {
+		found, _ := mc.GetCache(key, &value)
+		assert.False(t, found, key)
+	}
+	found, _ := mc.GetCache("api:price:eth", &value)
+	assert.True(t, found)
+
+	client.failKeys = nil
+	failed, err = mc.DeleteMultiCache(keys)
+	assert.NoError(t, err)
+	assert.Empty(t, failed)
+}
+
+func TestGetCacheWithSource_ReportsFallbackDuringOutage(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.EnableFallback()
+	assert.NoError(t, mc.SetCache("api:hot", 42, time.Minute))
+
+	var value int
+	source, found, err := mc.GetCacheWithSource("api:hot", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, SourceMemcached, source)
+
+	fake.setDown(true)
+	source, found, err = mc.GetCacheWithSource("api:hot", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, SourceFallback, source)
+	assert.Equal(t, 42, value)
+}
+
+// walletBalance derives its cache key from its chain and address
+type walletBalance struct {
+	Chain   string
+	Address string
+}
+
+func (w walletBalance) CacheKey() string {
+	return BuildKey("balance", w.Chain, w.Address)
+}
+
+func TestSetCacheFor_RoundTripsThroughKeyer(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.KeyPrefix = "prod"
+	wallet := walletBalance{Chain: "eth", Address: "0xabc"}
+
+	assert.NoError(t, mc.SetCacheFor(wallet, map[string]string{"usdc": "12.5"}, time.Minute))
+	_, stored := client.items["prod:balance:eth:0xabc"]
+	assert.True(t, stored)
+
+	var balance map[string]string
+	found, err := mc.GetCacheFor(wallet, &balance)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "12.5", balance["usdc"])
+
+	// Keys built by the Keyer and by hand address the same entry
+	found, err = mc.GetCache(wallet.CacheKey(), &balance)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = mc.GetCacheFor(walletBalance{Chain: "eth", Address: "0xdef"}, &balance)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSetCacheFor_RejectsEmptyKey(t *testing.T) {
+	mc, _ := newTestMemcached()
+	err := mc.SetCacheFor(keyerFunc(func() string { return "" }), 1, time.Minute)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, errEmptyCacheKey))
+}
+
+type keyerFunc func() string
+
+func (f keyerFunc) CacheKey() string { return f() }
+
+func TestSoftDelete_GraceServesStaleOnlyToAllowStaleCallers(t *testing.T) {
+	mc, client := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:price:btc", 42000, time.Hour))
+
+	assert.NoError(t, mc.SoftDelete("api:price:btc", 10*time.Second))
+	assert.Equal(t, int32(10), client.items[mc.fullKey("api:price:btc")].Expiration)
+
+	// Normal readers miss so they recompute the value
+	var value int
+	found, err := mc.GetCache("api:price:btc", &value)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	multi, err := mc.GetMultiCache([]string{"api:price:btc"})
+	assert.NoError(t, err)
+	assert.Empty(t, multi)
+
+	// Grace-aware readers still get the old value, flagged stale
+	found, stale, err := mc.GetAllowStale("api:price:btc", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, stale)
+	assert.Equal(t, 42000, value)
+
+	// A fresh write clears the stale flag for everyone
+	assert.NoError(t, mc.SetCache("api:price:btc", 43000, time.Hour))
+	found, stale, err = mc.GetAllowStale("api:price:btc", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, stale)
+	assert.Equal(t, 43000, value)
+	found, err = mc.GetCache("api:price:btc", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestSoftDelete_KeepsMetadataEnvelope(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCacheWithMeta("api:price:eth", 3200, time.Hour))
+	assert.NoError(t, mc.SoftDelete("api:price:eth", 5*time.Second))
+	assert.NoError(t, mc.SoftDelete("api:price:missing", 5*time.Second))
+
+	var value int
+	_, found, err := mc.GetCacheWithMeta("api:price:eth", &value)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	found, stale, err := mc.GetAllowStale("api:price:eth", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, stale)
+	assert.Equal(t, 3200, value)
+}
+
+func TestGetWithMigration_RewritesOldKeyUnderNewKey(t *testing.T) {
+	mc, client := newTestMemcached()
+	mc.MigrationDeleteOld = true
+	assert.NoError(t, mc.SetCache("price:btc", 42000, time.Hour))
+
+	var value int
+	found, err := mc.GetWithMigration("price:btc", "v2:price:btc", &value, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42000, value)
+
+	migrated, ok := client.items["v2:price:btc"]
+	assert.True(t, ok)
+	assert.Equal(t, int32(60), migrated.Expiration)
+	_, ok = client.items["price:btc"]
+	assert.False(t, ok)
+
+	// Later reads are served from the new key alone
+	gets := client.callCount("get")
+	value = 0
+	found, err = mc.GetWithMigration("price:btc", "v2:price:btc", &value, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42000, value)
+	assert.Equal(t, gets+1, client.callCount("get"))
+
+	found, err = mc.GetWithMigration("price:eth", "v2:price:eth", &value, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestGetWait_ObservesDelayedWrite(t *testing.T) {
+	mc, _ := newTestMemcached()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		mc.SetCache("api:order:7", "filled", time.Minute)
+	}()
+
+	var status string
+	found, err := mc.GetWait("api:order:7", &status, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "filled", status)
+}
+
+func TestGetWait_GivesUpAfterMaxWait(t *testing.T) {
+	mc, client := newTestMemcached()
+
+	start := time.Now()
+	var status string
+	found, err := mc.GetWait("api:order:8", &status, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.True(t, client.callCount("get") > 1)
+}
+
+func TestSerializationErrors_CountedSeparatelyFromBackendErrors(t *testing.T) {
+	mc, client := newTestMemcached()
+	serialize := CacheSerializationErrorsTotal.WithLabelValues("serialize", "errclass")
+	deserialize := CacheSerializationErrorsTotal.WithLabelValues("deserialize", "errclass")
+	backendSet := CacheBackendErrorsTotal.WithLabelValues("set cache", "errclass")
+	before := [3]float64{testutil.ToFloat64(serialize), testutil.ToFloat64(deserialize), testutil.ToFloat64(backendSet)}
+
+	assert.Error(t, mc.SetCache("errclass:feed", unserializablePayload{Updates: make(chan int)}, time.Minute))
+	assert.Equal(t, before[0]+1, testutil.ToFloat64(serialize))
+	assert.Equal(t, before[2], testutil.ToFloat64(backendSet))
+
+	client.store(&memcache.Item{Key: mc.fullKey("errclass:count"), Value: []byte("not json")})
+	var count int
+	_, err := mc.GetCache("errclass:count", &count)
+	assert.Error(t, err)
+	assert.Equal(t, before[1]+1, testutil.ToFloat64(deserialize))
+
+	client.setDown(true)
+	assert.Error(t, mc.SetCache("errclass:price", 42, time.Minute))
+	assert.Equal(t, before[2]+1, testutil.ToFloat64(backendSet))
+	assert.Equal(t, before[0]+1, testutil.ToFloat64(serialize))
+	assert.Equal(t, before[1]+1, testutil.ToFloat64(deserialize))
+}
+
+func TestScheduleWarm_RunsRepeatedlyUntilCancelled(t *testing.T) {
+	mc, _ := newTestMemcached()
+	ctx, cancel := context.WithCancel(context.Background())
+	successes := CacheWarmRunsTotal.WithLabelValues("top-addresses", resultOK)
+	before := testutil.ToFloat64(successes)
+
+	var mu sync.Mutex
+	runs := 0
+	assert.True(t, mc.ScheduleWarm(ctx, "top-addresses", 5*time.Millisecond, func(ctx context.Context) error {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		return mc.SetCache("blockchain:address:top", []string{"0xabc"}, time.Minute)
+	}))
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs
+	}
+
+	assert.Eventually(t, func() bool { return count() >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	stopped := count()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, count())
+	// A run interrupted by the cancellation is not counted
+	assert.InDelta(t, before+float64(stopped), testutil.ToFloat64(successes), 1)
+	assert.False(t, mc.ScheduleWarm(context.Background(), "disabled", 0, nil))
+}
+
+func TestGetManyOrMiss_ReturnsHitsAndMissingIDs(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCachedBlockchainData("balance", "0xaaa", 10, time.Minute))
+	assert.NoError(t, mc.SetCachedBlockchainData("balance", "0xccc", 30, time.Minute))
+
+	ids := []string{"0xaaa", "0xbbb", "0xccc", "0xddd"}
+	balances := make([]int, len(ids))
+	targets := make(map[string]interface{}, len(ids))
+	for i, id := range ids {
+		targets[id] = &balances[i]
+	}
+
+	missing, err := mc.GetManyOrMiss("balance", ids, targets)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0xbbb", "0xddd"}, missing)
+	assert.Equal(t, []int{10, 0, 30, 0}, balances)
+
+	_, err = mc.GetManyOrMiss("balance", []string{"0xeee"}, targets)
+	assert.Error(t, err)
+}
+
+// recordingLayer is a Cache layer that logs every call into a shared trace.
+type recordingLayer struct {
+	name   string
+	values map[string]string
+	trace  *[]string
+	err    error
+}
+
+func (l *recordingLayer) GetCache(key string, target interface{}) (bool, error) {
+	*l.trace = append(*l.trace, "get:"+l.name)
+	if l.err != nil {
+		return false, l.err
+	}
+	value, found := l.values[key]
+	if found {
+		*target.(*string) = value
+	}
+	return found, nil
+}
+
+func (l *recordingLayer) SetCache(key string, value interface{}, expiration time.Duration) error {
+	*l.trace = append(*l.trace, "set:"+l.name)
+	l.values[key] = *value.(*string)
+	return nil
+}
+
+func TestCacheChain_WalksInOrderAndBackfillsFasterLayers(t *testing.T) {
+	var trace []string
+	local := &recordingLayer{name: "memcached", values: map[string]string{}, trace: &trace}
+	memory := &recordingLayer{name: "memory", values: map[string]string{}, trace: &trace}
+	secondary := &recordingLayer{name: "secondary", values: map[string]string{"api:price": "42"}, trace: &trace}
+	chain, err := BuildCacheChain("memcached, memory, secondary, origin", map[string]Cache{
+		"memcached": local, "memory": memory, "secondary": secondary, "origin": nil,
+	}, time.Minute)
+	assert.NoError(t, err)
+
+	var price string
+	found, err := chain.GetCache("api:price", &price)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "42", price)
+	assert.Equal(t, []string{"get:memcached", "get:memory", "get:secondary", "set:memcached", "set:memory"}, trace)
+
+	// The backfilled local layer now serves the read on its own
+	trace = nil
+	found, _ = chain.GetCache("api:price", &price)
+	assert.True(t, found)
+	assert.Equal(t, []string{"get:memcached"}, trace)
+
+	// A failing layer is skipped rather than failing the read
+	trace = nil
+	local.err = errServerDown
+	found, _ = chain.GetCache("api:price", &price)
+	assert.True(t, found)
+	assert.Equal(t, []string{"get:memcached", "get:memory", "set:memcached"}, trace)
+
+	_, err = BuildCacheChain("memcached,disk", map[string]Cache{"memcached": local}, time.Minute)
+	assert.Error(t, err)
+}
+
+func TestCacheChain_PinnedKeySurvivesEviction(t *testing.T) {
+	mc, _ := newTestMemcached()
+	memory := NewMemoryLayer(3, time.Minute)
+	memory.MaxPinned = 1
+	chain, err := BuildCacheChain("memory,memcached", map[string]Cache{"memory": memory, "memcached": mc}, time.Minute)
+	assert.NoError(t, err)
+
+	// Pinning prefetches the key from L2
+	assert.NoError(t, mc.SetCache("api:price:btc", 42000, time.Minute))
+	assert.NoError(t, chain.Pin([]string{"api:price:btc"}))
+	assert.ErrorIs(t, chain.Pin([]string{"api:price:eth"}), ErrPinLimit)
+
+	// Far more writes than L1 holds evict every other key, but not the pinned one
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, chain.SetCache(fmt.Sprintf("api:price:alt-%d", i), i, time.Minute))
+	}
+	var price int
+	found, err := memory.GetCache("api:price:btc", &price)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42000, price)
+	found, _ = memory.GetCache("api:price:alt-0", &price)
+	assert.False(t, found)
+
+	// Refreshing picks up changes in L2
+	assert.NoError(t, mc.SetCache("api:price:btc", 43000, time.Minute))
+	chain.RefreshPinned()
+	memory.GetCache("api:price:btc", &price)
+	assert.Equal(t, 43000, price)
+
+	// Once unpinned the key is evicted like any other
+	chain.Unpin([]string{"api:price:btc"})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, chain.SetCache(fmt.Sprintf("api:price:new-%d", i), i, time.Minute))
+	}
+	found, _ = memory.GetCache("api:price:btc", &price)
+	assert.False(t, found)
+	assert.NoError(t, chain.Pin([]string{"api:price:eth"}))
+}
+
+func TestLoadCacheChain_PinsConfiguredKeysAndServesThemFromMemory(t *testing.T) {
+	mc, client := newTestMemcached()
+	defer mc.Close()
+	assert.NoError(t, mc.SetCache("api:price:btc", 42000, time.Minute))
+	os.Setenv("CACHE_PIN_KEYS", "api:price:btc, api:price:eth")
+	defer os.Unsetenv("CACHE_PIN_KEYS")
+
+	// The default order puts Memcached before the memory layer
+	chain, err := LoadCacheChain(mc)
+	assert.NoError(t, err)
+
+	gets := client.callCount("get")
+	var price int
+	found, err := chain.GetCache("api:price:btc", &price)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42000, price)
+	assert.Equal(t, gets, client.callCount("get"))
+
+	// A pinned key without a value still walks the chain
+	found, err = chain.GetCache("api:price:eth", &price)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, gets+1, client.callCount("get"))
+}
+
+func TestTenantCache_IsolatesTenants(t *testing.T) {
+	mc, _ := newTestMemcached()
+	acme, globex := mc.TenantCache("acme"), mc.TenantCache("globex")
+
+	assert.NoError(t, acme.SetCache("portfolio:1", "acme-portfolio", time.Minute))
+	assert.NoError(t, globex.SetCache("portfolio:1", "globex-portfolio", time.Minute))
+
+	var value string
+	found, err := acme.GetCache("portfolio:1", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "acme-portfolio", value)
+
+	found, err = globex.GetCache("portfolio:1", &value)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "globex-portfolio", value)
+
+	// A logical key can't reach into another tenant's keyspace
+	found, _ = acme.GetCache("tenant:globex:portfolio:1", &value)
+	assert.False(t, found)
+	acmeKey, _ := acme.Key("x:y")
+	globexKey, _ := globex.Key("x:y")
+	assert.NotEqual(t, acmeKey, globexKey)
+}
+
+func TestTenantCache_RejectsEmptyOrInvalidTenant(t *testing.T) {
+	mc, client := newTestMemcached()
+
+	err := mc.TenantCache("").SetCache("portfolio:1", "data", time.Minute)
+	assert.True(t, errors.Is(err, ErrEmptyTenantID))
+	_, err = mc.TenantCache("").GetCache("portfolio:1", new(string))
+	assert.True(t, errors.Is(err, ErrEmptyTenantID))
+	assert.True(t, errors.Is(mc.TenantCache("acme:globex").DeleteCache("portfolio:1"), ErrInvalidTenantID))
+	assert.Empty(t, client.items)
+}
+
+// flakyPingClient fails the first failures pings, as if Memcached were still starting.
+type flakyPingClient struct {
+	*fakeMemcache
+	failures int
+}
+
+func (f *flakyPingClient) Ping() error {
+	f.fakeMemcache.Ping()
+	if f.callCount("ping") <= f.failures {
+		return errServerDown
+	}
+	return nil
+}
+
+func TestPingWithRetry_SucceedsOnceMemcachedIsUp(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.Client = &flakyPingClient{fakeMemcache: fake, failures: 3}
+	mc.PingAttempts = 5
+	mc.PingBackoff = time.Millisecond
+
+	assert.NoError(t, mc.PingWithRetry(context.Background()))
+	assert.Equal(t, 4, fake.callCount("ping"))
+}
+
+func TestPingWithRetry_GivesUpAfterAttemptsOrDeadline(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.Client = &flakyPingClient{fakeMemcache: fake, failures: 100}
+	mc.PingAttempts = 3
+	mc.PingBackoff = time.Millisecond
+
+	err := mc.PingWithRetry(context.Background())
+	assert.True(t, errors.Is(err, errServerDown))
+	assert.Equal(t, 3, fake.callCount("ping"))
+
+	// The startup deadline ends the wait between attempts
+	mc.PingAttempts = 100
+	mc.PingBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, mc.PingWithRetry(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestProbe_BypassesFallbackDuringOutage(t *testing.T) {
+	mc, fake := newTestMemcached()
+	mc.EnableFallback()
+	assert.NoError(t, mc.Probe("monitoring:probe", time.Minute))
+
+	// With the circuit open the fallback keeps serving, but the probe still fails
+	fake.setDown(true)
+	for i := 0; i < circuitFailureThreshold; i++ {
+		mc.SetCache("api:hot", "value", time.Minute)
+	}
+	assert.NoError(t, mc.SetCache("api:hot", "value", time.Minute))
+	assert.True(t, errors.Is(mc.Probe("monitoring:probe", time.Minute), errServerDown))
+}
+
+func TestListKeys_ReturnsManifestKeys(t *testing.T) {
+	mc, _ := newTestMemcached()
+	mc.EnableManifest("session")
+
+	keys, err := mc.ListKeys("session")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		assert.NoError(t, mc.SetCache("session:"+user, "token-"+user, time.Minute))
+	}
+	assert.NoError(t, mc.SetCache("api:price:btc", 42000, time.Minute))
+
+	keys, err = mc.ListKeys("session")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"session:alice", "session:bob"}, keys)
+
+	_, err = mc.ListKeys("api")
+	assert.True(t, errors.Is(err, ErrManifestDisabled))
+}
+
+func TestNamespace_RecordsMetricsPerNamespace(t *testing.T) {
+	mc, client := newTestMemcached()
+	sessions := mc.Namespace("metrics-sessions", time.Minute)
+	profiles := mc.Namespace("metrics-profiles", time.Minute)
+	counter := func(namespace, operation, result string) float64 {
+		return testutil.ToFloat64(CacheNamespaceOperationsTotal.WithLabelValues(namespace, operation, result))
+	}
+
+	var value string
+	assert.NoError(t, sessions.Set("alice", "token", 0))
+	sessions.Get("alice", &value)
+	sessions.Get("bob", &value)
+	profiles.Get("alice", &value)
+
+	assert.Equal(t, float64(1), counter("metrics-sessions", "set", resultOK))
+	assert.Equal(t, float64(1), counter("metrics-sessions", "get", resultHit))
+	assert.Equal(t, float64(1), counter("metrics-sessions", "get", resultMiss))
+	assert.Equal(t, float64(0), counter("metrics-profiles", "get", resultHit))
+	assert.Equal(t, float64(1), counter("metrics-profiles", "get", resultMiss))
+
+	client.setDown(true)
+	profiles.Get("alice", &value)
+	assert.Equal(t, float64(1), counter("metrics-profiles", "get", resultError))
+	assert.Equal(t, float64(0), counter("metrics-sessions", "get", resultError))
+}
+
+func TestGet_RoundTripsTypedValues(t *testing.T) {
+	mc, _ := newTestMemcached()
+
+	type account struct {
+		Address string   `json:"address"`
+		Balance uint64   `json:"balance"`
+		Tokens  []string `json:"tokens"`
+	}
+	want := account{Address: "0xabc", Balance: 42, Tokens: []string{"ETH", "USDC"}}
+	assert.NoError(t, mc.SetCache("api:account:0xabc", want, time.Minute))
+	got, found, err := Get[account](mc, "api:account:0xabc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, want, got)
+
+	assert.NoError(t, mc.SetCache("api:blocks", []int{1, 2, 3}, time.Minute))
+	blocks, found, err := Get[[]int](mc, "api:blocks")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []int{1, 2, 3}, blocks)
+
+	_, found, err = Get[account](mc, "api:account:missing")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestGet_ReportsTypeMismatch(t *testing.T) {
+	mc, _ := newTestMemcached()
+	assert.NoError(t, mc.SetCache("api:blocks", []int{1, 2, 3}, time.Minute))
+
+	value, found, err := Get[map[string]int](mc, "api:blocks")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	assert.Contains(t, err.Error(), "map[string]int")
+	assert.False(t, found)
+	assert.Nil(t, value)
+}
+
+// serverSelector maps keys containing "slow" to one server and all other keys to another
+type serverSelector struct {
+	slow, fast net.Addr
+}
+
+func (s serverSelector) PickServer(key string) (net.Addr, error) {
+	if strings.Contains(key, "slow") {
+		return s.slow, nil
+	}
+	return s.fast, nil
+}
+
+func (s serverSelector) Each(f func(net.Addr) error) error {
+	if err := f(s.slow); err != nil {
+		return err
+	}
+	return f(s.fast)
+}
+
+// slowServerMemcache delays reads of keys its selector maps to the slow server
+type slowServerMemcache struct {
+	*fakeMemcache
+	selector serverSelector
+	delay    time.Duration
+}
+
+func (s slowServerMemcache) Get(key string) (*memcache.Item, error) {
+	if addr, _ := s.selector.PickServer(key); addr == s.selector.slow {
+		time.Sleep(s.delay)
+	}
+	return s.fakeMemcache.Get(key)
+}
+
+// serverLatency returns the sample count and sum of a server's latency histogram
+func serverLatency(t *testing.T, server, operation string) (uint64, float64) {
+	var metric dto.Metric
+	err := CacheServerLatencySeconds.WithLabelValues(server, operation).(prometheus.Metric).Write(&metric)
+	assert.NoError(t, err)
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestServerLatency_TracksSlowServerSeparately(t *testing.T) {
+	selector := serverSelector{
+		slow: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 11211},
+		fast: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 11211},
+	}
+	delay := 20 * time.Millisecond
+	mc, fake := newTestMemcached()
+	mc.Client = slowServerMemcache{fakeMemcache: fake, selector: selector, delay: delay}
+	mc.Selector = selector
+
+	assert.NoError(t, mc.SetCache("slow-key", "value", time.Minute))
+	assert.NoError(t, mc.SetCache("fast-key", "value", time.Minute))
+	slowCount, slowSum := serverLatency(t, selector.slow.String(), "get")
+	fastCount, fastSum := serverLatency(t, selector.fast.String(), "get")
+
+	var value string
+	for i := 0; i < 3; i++ {
+		found, err := mc.GetCache("slow-key", &value)
+		assert.True(t, found)
+		assert.NoError(t, err)
+		found, err = mc.GetCache("fast-key", &value)
+		assert.True(t, found)
+		assert.NoError(t, err)
+	}
+
+	// Each server's reads land in its own series, and only the slow server's are slow
+	count, sum := serverLatency(t, selector.slow.String(), "get")
+	assert.Equal(t, slowCount+3, count)
+	assert.GreaterOrEqual(t, sum-slowSum, 3*delay.Seconds())
+	count, sum = serverLatency(t, selector.fast.String(), "get")
+	assert.Equal(t, fastCount+3, count)
+	assert.Less(t, sum-fastSum, delay.Seconds())
+}
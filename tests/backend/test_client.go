@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"your_project/apitypes"
+	"your_project/client"
+)
+
+// newClientTestServer serves the real router, with inference forwarded to a fake model server.
+// Incoming API requests are recorded into *received when it is non-nil.
+func newClientTestServer(t *testing.T, model http.HandlerFunc, received **http.Request) (*client.Client, func()) {
+	backend := httptest.NewServer(model)
+	os.Setenv("INFERENCE_BACKEND_URL", backend.URL)
+	router := SetupRouter()
+	os.Unsetenv("INFERENCE_BACKEND_URL")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if received != nil {
+			*received = r
+		}
+		router.ServeHTTP(w, r)
+	}))
+
+	return client.New(server.URL), func() {
+		server.Close()
+		backend.Close()
+	}
+}
+
+func TestClient_Health(t *testing.T) {
+	api, cleanup := newClientTestServer(t, func(w http.ResponseWriter, r *http.Request) {}, nil)
+	defer cleanup()
+
+	health, err := api.Health(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "healthy", health.Status)
+	assert.Equal(t, "1.0.0", health.Version)
+}
+
+func TestClient_InferSendsRequestID(t *testing.T) {
+	var received *http.Request
+	var gotRequest apitypes.InferenceRequest
+	api, cleanup := newClientTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotRequest)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}, &received)
+	defer cleanup()
+
+	ctx := client.WithRequestID(context.Background(), "req-123")
+	resp, err := api.Infer(ctx, apitypes.InferenceRequest{Model: "sentiment", Input: json.RawMessage(`"great product"`)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"output":"hello"}`, string(resp.Result))
+	assert.Equal(t, "req-123", received.Header.Get(apitypes.RequestIDHeader))
+	assert.Equal(t, "sentiment", gotRequest.Model)
+}
+
+func TestClient_DecodesErrorEnvelope(t *testing.T) {
+	api, cleanup := newClientTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>down</html>"))
+	}, nil)
+	defer cleanup()
+
+	_, err := api.Infer(context.Background(), apitypes.InferenceRequest{Input: json.RawMessage(`"hi"`)})
+	apiErr, ok := err.(*client.APIError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, "inference backend returned an invalid response", apiErr.Message)
+	assert.NotEmpty(t, apiErr.RequestID)
+}
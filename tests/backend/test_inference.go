@@ -0,0 +1,1205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// newInferenceRouter serves POST /inference through an InferenceService pointed at backendURL
+func newInferenceRouter(store ResponseCache, backendURL string) *gin.Engine {
+	cacher := NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"})
+	config := DefaultInferenceConfig()
+	config.BackendURL = backendURL
+	service := NewInferenceService(cacher, config)
+
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	return router
+}
+
+func TestInference_NonJSONBackendReturnsBadGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("<html><body>502 Bad Gateway</body></html>"))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	router := newInferenceRouter(store, backend.URL)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"prompt":"hi"}`)))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid response")
+	assert.Empty(t, store.entries)
+}
+
+func TestInference_OriginFailureSendsJitteredRetryAfter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	// Without a base no hint is sent
+	rr := postInference(newInferenceRouter(newMemoryResponseCache(), backend.URL), `{"prompt":"hi"}`)
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.RetryAfterBase = time.Second
+	config.RetryAfterJitter = time.Minute
+	service := NewInferenceService(NewResponseCacher(newMemoryResponseCache(), ResponseCacheConfig{TTL: time.Minute}), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		rr := postInference(router, `{"prompt":"hi"}`)
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+		seconds, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, seconds, 1)
+		assert.LessOrEqual(t, seconds, 61)
+		seen[rr.Header().Get("Retry-After")] = true
+	}
+	assert.Greater(t, len(seen), 1, "Retry-After should vary across responses")
+}
+
+func TestBaggage_PropagatesToBackendAndScopesCache(t *testing.T) {
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Baggage-Tenant-Id"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(newMemoryResponseCache(), ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	router := gin.New()
+	router.Use(BaggageMiddleware(BaggageConfig{Allowed: map[string][]string{"tenant-id": nil}}))
+	router.POST("/inference", service.Handler())
+
+	post := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/inference", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set("X-Baggage-Tenant-Id", tenant)
+		req.Header.Set("X-Baggage-Unlisted", "dropped")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Each tenant's baggage reaches the backend, and one tenant's result isn't served to another
+	assert.Equal(t, http.StatusOK, post("acme").Code)
+	assert.Equal(t, http.StatusOK, post("globex").Code)
+	rr := post("acme")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	assert.Equal(t, []string{"acme", "globex"}, received)
+}
+
+func TestInference_TenantsDoNotShareCachedResults(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	store := newMemoryResponseCache()
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	router := gin.New()
+	router.Use(APIKeyMiddleware(APIKeyConfig{TenantKeys: map[string]string{"acme-key": "acme", "globex-key": "globex"}}))
+	router.POST("/inference", service.Handler())
+
+	post := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/inference", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set(APIKeyHeader, apiKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The same body from another tenant misses, and each tenant's entry is served only to it
+	assert.Equal(t, CacheMiss, post("acme-key").Header().Get("X-Cache"))
+	assert.Equal(t, CacheMiss, post("globex-key").Header().Get("X-Cache"))
+	assert.Equal(t, CacheHit, post("acme-key").Header().Get("X-Cache"))
+	assert.Equal(t, 2, calls)
+}
+
+func TestInference_CachesValidJSON(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	router := newInferenceRouter(store, backend.URL)
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"prompt":"hi"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"output":"hello"}`, rr.Body.String())
+	}
+	assert.Equal(t, 1, calls)
+	assert.Len(t, store.entries, 1)
+}
+
+func TestInference_CachesWithBackendMaxAge(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+r.URL.Query().Get("max-age"))
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+
+	// A shorter max-age than the configured 5 minutes applies; a longer one doesn't extend it
+	for maxAge, want := range map[string]time.Duration{"60": time.Minute, "86400": 5 * time.Minute} {
+		store := newMemoryResponseCache()
+		router := newInferenceRouter(store, backend.URL+"?max-age="+maxAge)
+		assert.Equal(t, http.StatusOK, postInference(router, `{"prompt":"hi"}`).Code)
+
+		assert.Len(t, store.ttls, 1)
+		for _, ttl := range store.ttls {
+			assert.Equal(t, want, ttl, maxAge)
+		}
+	}
+}
+
+func TestInference_DoesNotCacheNoStoreResponses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	router := newInferenceRouter(store, backend.URL)
+	rr := postInference(router, `{"prompt":"hi"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"output":"hello"}`, rr.Body.String())
+	assert.Empty(t, store.entries)
+}
+
+func TestBatchInference_ReplaysCachedStream(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	router := gin.New()
+	router.POST("/inference/batch", service.BatchHandler())
+
+	stream := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/inference/batch?stream=ndjson", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := stream(`[{"prompt":"a"},{"prompt":"b"}]`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, CacheMiss, first.Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// The same batch, formatted differently, is replayed from the cache as a stream
+	second := stream(`[ {"prompt": "a"}, {"prompt": "b"} ]`)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, CacheHit, second.Header().Get("X-Cache"))
+	assert.Equal(t, "application/x-ndjson", second.Header().Get("Content-Type"))
+	assert.True(t, second.Flushed)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestBatchInference_DoesNotCacheOversizedStreams(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"` + strings.Repeat("x", 512) + `"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.StreamCacheMaxBytes = 256
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	router := gin.New()
+	router.POST("/inference/batch", service.BatchHandler())
+
+	req := httptest.NewRequest("POST", "/inference/batch?stream=ndjson", strings.NewReader(`[{"prompt":"a"}]`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, store.entries)
+}
+
+func TestInference_RunsModelHooks(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"label":"POSITIVE"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	service.Hooks = NewInferenceHookRegistry(nil)
+	service.Hooks.RegisterPreprocess("legacy-sentiment", func(body json.RawMessage) (json.RawMessage, error) {
+		var request struct{ Input json.RawMessage }
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		var text string
+		if err := json.Unmarshal(request.Input, &text); err != nil {
+			return nil, fmt.Errorf("input must be a string")
+		}
+		return json.Marshal(map[string]string{"text": strings.ToLower(strings.TrimSpace(text))})
+	})
+	service.Hooks.RegisterPostprocess("legacy-sentiment", func(result json.RawMessage) (json.RawMessage, error) {
+		var response struct{ Label string }
+		if err := json.Unmarshal(result, &response); err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"sentiment": strings.ToLower(response.Label)})
+	})
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	rr := postInference(router, `{"model":"legacy-sentiment","input":"  Great Product "}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"text":"great product"}`, received)
+	assert.JSONEq(t, `{"sentiment":"positive"}`, rr.Body.String())
+
+	// Models without hooks are forwarded unchanged
+	rr = postInference(router, `{"model":"other","input":"hi"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"model":"other","input":"hi"}`, received)
+
+	// Input the hook can't transform is the client's error
+	rr = postInference(router, `{"model":"legacy-sentiment","input":42}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "input must be a string")
+}
+
+func TestRenameFields_MapsConfiguredFields(t *testing.T) {
+	hooks := NewInferenceHookRegistry(map[string]map[string]string{"legacy": {"input": "inputs"}})
+	payload, err := hooks.Preprocess("legacy", json.RawMessage(`{"model":"legacy","input":"hi"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"model":"legacy","inputs":"hi"}`, string(payload))
+}
+
+// newBoundedInferenceRouter is newInferenceRouter with limits on the backend response body
+func newBoundedInferenceRouter(backendURL string, maxBytes int64, stallTimeout time.Duration) *gin.Engine {
+	cacher := NewResponseCacher(newMemoryResponseCache(), ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"})
+	config := DefaultInferenceConfig()
+	config.BackendURL = backendURL
+	config.MaxResponseBytes = maxBytes
+	config.ResponseStallTimeout = stallTimeout
+	service := NewInferenceService(cacher, config)
+
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	return router
+}
+
+func TestInference_StalledBackendBodyTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hel`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(unblock)
+
+	router := newBoundedInferenceRouter(backend.URL, 1<<20, 100*time.Millisecond)
+	start := time.Now()
+	rr := postInference(router, `{"prompt":"hi"}`)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(t, rr.Body.String(), "stalled")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestInference_OversizedBackendBodyIsRejected(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"` + strings.Repeat("x", 1024) + `"}`))
+	}))
+	defer backend.Close()
+
+	router := newBoundedInferenceRouter(backend.URL, 256, time.Second)
+	rr := postInference(router, `{"prompt":"hi"}`)
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "too large")
+}
+
+func TestBatchInference_StreamsNDJSON(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "bad") {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>oops</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference/batch", service.BatchHandler())
+
+	req := httptest.NewRequest("POST", "/inference/batch", bytes.NewBufferString(`[{"prompt":"a"},{"prompt":"bad"},{"prompt":"c"}]`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.True(t, rr.Flushed)
+
+	seen := make(map[int]BatchInferenceResult)
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var result BatchInferenceResult
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+		seen[result.Index] = result
+	}
+	assert.Len(t, seen, 3)
+	assert.JSONEq(t, `{"output":"ok"}`, string(seen[0].Result))
+	assert.NotEmpty(t, seen[1].Error)
+	assert.Empty(t, seen[1].Result)
+	assert.JSONEq(t, `{"output":"ok"}`, string(seen[2].Result))
+}
+
+func TestBatchInference_DefaultsToJSONArray(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference/batch", service.BatchHandler())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference/batch", bytes.NewBufferString(`[{"prompt":"a"},{"prompt":"b"}]`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var results []BatchInferenceResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Len(t, results, 2)
+	assert.Equal(t, 1, results[1].Index)
+}
+
+func newABRouter() *ModelRouter {
+	return NewModelRouter(map[string][]ModelVariant{
+		"sentiment": {
+			{Name: "v1", BackendURL: "http://v1", Weight: 80},
+			{Name: "v2", BackendURL: "http://v2", Weight: 20},
+		},
+	})
+}
+
+func TestModelRouter_WeightDistribution(t *testing.T) {
+	router := newABRouter()
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		variant, ok := router.Route("sentiment", "")
+		assert.True(t, ok)
+		counts[variant.Name]++
+	}
+	assert.InDelta(t, 8000, counts["v1"], 300)
+	assert.InDelta(t, 2000, counts["v2"], 300)
+
+	_, ok := router.Route("unknown", "")
+	assert.False(t, ok)
+}
+
+func TestModelRouter_StickyKeyIsConsistent(t *testing.T) {
+	router := newABRouter()
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		first, _ := router.Route("sentiment", key)
+		for i := 0; i < 50; i++ {
+			variant, _ := router.Route("sentiment", key)
+			assert.Equal(t, first.Name, variant.Name)
+		}
+	}
+
+	// Sticky keys still follow the configured weights across many callers
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		variant, _ := router.Route("sentiment", fmt.Sprintf("user-%d", i))
+		counts[variant.Name]++
+	}
+	assert.InDelta(t, 8000, counts["v1"], 300)
+}
+
+func TestInference_ReportsRoutedVariant(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"v2"}`))
+	}))
+	defer backend.Close()
+
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), DefaultInferenceConfig())
+	service.Router = NewModelRouter(map[string][]ModelVariant{
+		"sentiment": {{Name: "v2", BackendURL: backend.URL, Weight: 1}},
+	})
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"model":"sentiment","input":"hi"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "v2", rr.Header().Get("X-Model-Variant"))
+}
+
+func TestInference_CoalescesConcurrentIdenticalRequests(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"trending"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute}), config)
+	service.Budget = NewInferenceBudget(100, time.Hour, store)
+	service.Budget.RegisterEstimator("m", func(json.RawMessage) int { return 4 })
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	const n = 10
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Same inputs with different key order and whitespace
+			body := `{"model":"m","input":"hot topic"}`
+			if i%2 == 1 {
+				body = `{ "input": "hot topic", "model": "m" }`
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(body)))
+			codes[i], bodies[i] = rr.Code, rr.Body.String()
+		}(i)
+	}
+
+	// Release the backend only once every other request is waiting on the first one's call
+	assert.Eventually(t, func() bool { return service.inflight.waiting() == n-1 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for i := 0; i < n; i++ {
+		assert.Equal(t, http.StatusOK, codes[i])
+		assert.JSONEq(t, `{"output":"trending"}`, bodies[i])
+	}
+
+	// Only the request that ran the backend call is charged
+	charged := 0
+	for key := range store.entries {
+		if strings.HasPrefix(key, "api:inference:budget:") {
+			var units int
+			store.GetCache(key, &units)
+			charged += units
+		}
+	}
+	assert.Equal(t, 4, charged)
+}
+
+func TestCallGroup_ReleasesWaitersWhenCallPanics(t *testing.T) {
+	var group callGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	leader := make(chan interface{})
+	go func() {
+		defer func() { leader <- recover() }()
+		group.Do(context.Background(), "key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("hook failed")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error)
+	go func() {
+		_, err, shared := group.Do(context.Background(), "key", func() (interface{}, error) { return "unused", nil })
+		assert.True(t, shared)
+		waiter <- err
+	}()
+	assert.Eventually(t, func() bool { return group.waiting() == 1 }, time.Second, time.Millisecond)
+	close(release)
+
+	// The caller running the call still panics; the waiter gets an error instead of hanging
+	assert.Equal(t, "hook failed", <-leader)
+	assert.ErrorIs(t, <-waiter, errCoalescedPanic)
+
+	// The key is forgotten, so later calls run again
+	value, err, shared := group.Do(context.Background(), "key", func() (interface{}, error) { return "fresh", nil })
+	assert.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, "fresh", value)
+}
+
+func TestCallGroup_WaiterGivesUpWhenItsContextIsDone(t *testing.T) {
+	var group callGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := group.Do(context.Background(), "key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return "slow", nil
+		})
+		done <- value
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err, shared := group.Do(ctx, "key", func() (interface{}, error) { return "unused", nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, shared)
+	assert.Equal(t, 0, group.waiting())
+
+	// The call itself carries on for its own caller
+	close(release)
+	assert.Equal(t, "slow", <-done)
+}
+
+func TestInferenceCacheKey_KeepsLargeNumbersDistinct(t *testing.T) {
+	target := inferenceTarget{Model: "m"}
+	// Equal as float64, but different requests
+	assert.NotEqual(t,
+		inferenceCacheKey(target, []byte(`{"model":"m","seed":9007199254740993}`)),
+		inferenceCacheKey(target, []byte(`{"model":"m","seed":9007199254740992}`)))
+	assert.Equal(t,
+		inferenceCacheKey(target, []byte(`{"seed":9007199254740993,"model":"m"}`)),
+		inferenceCacheKey(target, []byte(`{ "model": "m", "seed": 9007199254740993 }`)))
+}
+
+// histogramCount returns the observation count of a histogram series with the given label value
+func histogramCount(t *testing.T, registry *prometheus.Registry, name, label, value string) uint64 {
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label && pair.GetValue() == value {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestInference_LatencyLabeledByCacheUse(t *testing.T) {
+	inferenceDurationSeconds.Reset()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(inferenceDurationSeconds)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+	router := newInferenceRouter(newMemoryResponseCache(), backend.URL)
+
+	// First request misses, second is served from cache
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"prompt":"hi"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.Equal(t, uint64(1), histogramCount(t, registry, "inference_duration_seconds", "cached", "false"))
+	assert.Equal(t, uint64(1), histogramCount(t, registry, "inference_duration_seconds", "cached", "true"))
+}
+
+// newTimedModelServer returns a model server taking delay to respond
+func newTimedModelServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"done"}`))
+	}))
+}
+
+func TestInference_PerModelTimeouts(t *testing.T) {
+	backend := newTimedModelServer(200 * time.Millisecond)
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.ModelTimeouts = parseModelTimeouts("classifier=50ms,generator=5s")
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	// The classifier's budget is shorter than the backend latency
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"model":"classifier","input":"x"}`)))
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+
+	// The generator is allowed to take longer
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"model":"generator","input":"x"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestInference_RequestDeadlineStricterThanModelTimeout(t *testing.T) {
+	backend := newTimedModelServer(200 * time.Millisecond)
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.ModelTimeouts = map[string]time.Duration{"generator": 5 * time.Second}
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"model":"generator","input":"x"}`)).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+}
+
+func TestParseModelTimeouts(t *testing.T) {
+	timeouts := parseModelTimeouts("classifier=50ms, generator=30s,broken,bad=-1s")
+	assert.Equal(t, map[string]time.Duration{"classifier": 50 * time.Millisecond, "generator": 30 * time.Second}, timeouts)
+}
+
+func TestInference_ServerTimingReportsSpans(t *testing.T) {
+	backend := newTimedModelServer(30 * time.Millisecond)
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(newMemoryResponseCache(), DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.Use(ServerTimingMiddleware())
+	router.POST("/inference", service.Handler())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", bytes.NewBufferString(`{"model":"m","input":"x"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	header := rr.Header().Get("Server-Timing")
+	assert.Contains(t, header, "cache;dur=")
+	assert.Contains(t, header, "cache_write;dur=")
+	match := regexp.MustCompile(`inference;dur=([0-9.]+)`).FindStringSubmatch(header)
+	if assert.Len(t, match, 2, header) {
+		ms, err := strconv.ParseFloat(match[1], 64)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, ms, 30.0)
+	}
+}
+
+// newQueuedInferenceRouter serves POST /inference with a single worker and the given queue limits,
+// against a backend that holds each call until release is closed
+func newQueuedInferenceRouter(depth int, wait time.Duration) (*gin.Engine, chan struct{}, chan struct{}, func()) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"done"}`))
+	}))
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.Workers = 1
+	config.QueueDepth = depth
+	config.QueueWait = wait
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	return router, started, release, backend.Close
+}
+
+func postInference(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/inference", strings.NewReader(body)))
+	return rr
+}
+
+func TestInferenceQueue_WaitsThenServes(t *testing.T) {
+	router, started, release, closeBackend := newQueuedInferenceRouter(1, 5*time.Second)
+	defer closeBackend()
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postInference(router, `{"prompt":"first"}`) }()
+	<-started
+
+	// The second request queues behind the busy worker and is served once it frees up
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- postInference(router, `{"prompt":"second"}`) }()
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(inferenceQueueDepth) == 1 }, time.Second, 5*time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, http.StatusOK, (<-second).Code)
+	assert.Equal(t, float64(0), testutil.ToFloat64(inferenceQueueDepth))
+}
+
+func TestInferenceQueue_FullQueueRejects(t *testing.T) {
+	router, started, release, closeBackend := newQueuedInferenceRouter(0, 5*time.Second)
+	defer closeBackend()
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postInference(router, `{"prompt":"first"}`) }()
+	<-started
+
+	rr := postInference(router, `{"prompt":"second"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "at capacity")
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+}
+
+func TestInferenceQueue_WaitTimeoutRejects(t *testing.T) {
+	router, started, release, closeBackend := newQueuedInferenceRouter(1, 20*time.Millisecond)
+	defer closeBackend()
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postInference(router, `{"prompt":"first"}`) }()
+	<-started
+
+	assert.Equal(t, http.StatusServiceUnavailable, postInference(router, `{"prompt":"second"}`).Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+}
+
+func TestInference_ShedsRequestsOverConcurrencyCap(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"done"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.MaxConcurrent = 1
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	router.POST("/inference/batch", service.BatchHandler())
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postInference(router, `{"prompt":"first"}`) }()
+	<-started
+	assert.Equal(t, float64(1), testutil.ToFloat64(inferenceInFlight))
+
+	// With the only slot taken, further requests are shed without reaching the backend
+	shedBefore := testutil.ToFloat64(inferenceShedTotal)
+	rr := postInference(router, `{"prompt":"second"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "too many concurrent inference requests")
+	batch := httptest.NewRecorder()
+	router.ServeHTTP(batch, httptest.NewRequest("POST", "/inference/batch", strings.NewReader(`[{"prompt":"third"}]`)))
+	assert.Equal(t, http.StatusServiceUnavailable, batch.Code)
+	assert.Equal(t, shedBefore+2, testutil.ToFloat64(inferenceShedTotal))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, float64(0), testutil.ToFloat64(inferenceInFlight))
+	assert.Equal(t, http.StatusOK, postInference(router, `{"prompt":"fourth"}`).Code)
+}
+
+// newSchemaValidatedRouter serves POST /inference against a backend returning response, with a
+// schema registered for the "sentiment" model
+func newSchemaValidatedRouter(store ResponseCache, response string) (*gin.Engine, func()) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	service.Schemas = NewResponseSchemaRegistry(map[string]ResponseSchema{
+		"sentiment": {Required: map[string]string{"label": "string", "score": "number"}},
+	})
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	return router, backend.Close
+}
+
+func TestInference_ValidResponsePassesSchema(t *testing.T) {
+	store := newMemoryResponseCache()
+	router, closeBackend := newSchemaValidatedRouter(store, `{"label":"positive","score":0.97}`)
+	defer closeBackend()
+
+	rr := postInference(router, `{"model":"sentiment","input":"great"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"label":"positive","score":0.97}`, rr.Body.String())
+	assert.Len(t, store.entries, 1)
+}
+
+func TestInference_InvalidResponseFailsSchema(t *testing.T) {
+	store := newMemoryResponseCache()
+	router, closeBackend := newSchemaValidatedRouter(store, `{"label":"positive","score":"high"}`)
+	defer closeBackend()
+
+	rr := postInference(router, `{"model":"sentiment","input":"great"}`)
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid response")
+	assert.Empty(t, store.entries)
+
+	// Models without a schema are forwarded as-is
+	rr = postInference(router, `{"model":"summarizer","input":"great"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestResponseSchema_Validate(t *testing.T) {
+	schema := ResponseSchema{Required: map[string]string{"label": "string", "tags": "array", "meta": "any"}}
+	assert.NoError(t, schema.Validate(json.RawMessage(`{"label":"a","tags":[],"meta":null}`)))
+	assert.EqualError(t, schema.Validate(json.RawMessage(`{"label":"a","meta":1}`)), `missing required field "tags"`)
+	assert.EqualError(t, schema.Validate(json.RawMessage(`{"label":1,"tags":[],"meta":1}`)), `field "label" is number, want string`)
+	assert.Error(t, schema.Validate(json.RawMessage(`[1,2]`)))
+}
+
+func TestInference_RejectsDeeplyNestedBody(t *testing.T) {
+	router := newInferenceRouter(newMemoryResponseCache(), "http://127.0.0.1:0")
+
+	nested := `{"input":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`
+	rr := postInference(router, nested)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "nested too deeply")
+
+	assert.Equal(t, errJSONTooManyTokens, guardJSON([]byte(`[1,2,3,4,5]`), 0, 4))
+	assert.NoError(t, guardJSON([]byte(`{"input":[[1]]}`), 3, 0))
+	assert.Error(t, guardJSON([]byte(`{"input":1} {}`), 0, 0))
+}
+
+func TestInference_UnknownFieldsRejectedWhenConfigured(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.DisallowUnknownFields = true
+	service := NewInferenceService(NewResponseCacher(nil, DefaultResponseCacheConfig()), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	rr := postInference(router, `{"model":"classifier","input":"hi","promtp":"typo"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `unknown field \"promtp\"`)
+
+	rr = postInference(router, `{"model":"classifier","input":"hi"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+// syntheticInferenceMetrics builds inference metrics with the given cached and uncached
+// request counts, total latency, and per-model counts.
+func syntheticInferenceMetrics(cached, uncached uint64, latencySum float64, models map[string]float64) []*dto.MetricFamily {
+	perModel := make([]*dto.Metric, 0, len(models))
+	for model, count := range models {
+		perModel = append(perModel, &dto.Metric{
+			Label:   []*dto.LabelPair{{Name: str("model"), Value: str(model)}},
+			Counter: &dto.Counter{Value: f64(count)},
+		})
+	}
+	return []*dto.MetricFamily{
+		{
+			Name: str("inference_duration_seconds"),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{{Name: str("cached"), Value: str("true")}}, Histogram: &dto.Histogram{SampleCount: u64(cached), SampleSum: f64(0)}},
+				{Label: []*dto.LabelPair{{Name: str("cached"), Value: str("false")}}, Histogram: &dto.Histogram{SampleCount: u64(uncached), SampleSum: f64(latencySum)}},
+			},
+		},
+		{Name: str("inference_requests_total"), Metric: perModel},
+	}
+}
+
+func TestInferenceStats_AggregatesRecentWindow(t *testing.T) {
+	families := syntheticInferenceMetrics(10, 10, 4, map[string]float64{"sentiment": 15, "other": 5})
+	evaluator := NewInferenceStatsEvaluator(time.Minute, func() ([]*dto.MetricFamily, error) { return families, nil })
+	now := time.Now()
+	evaluator.now = func() time.Time { return now }
+
+	stats, err := evaluator.Evaluate()
+	assert.NoError(t, err)
+	assert.Equal(t, float64(20), stats.Requests)
+	assert.InDelta(t, 0.5, stats.CacheHitRate, 1e-9)
+	assert.InDelta(t, 0.2, stats.AverageLatencySeconds, 1e-9)
+
+	// 40 more requests, 30 of them cache hits, since the previous snapshot
+	families = syntheticInferenceMetrics(40, 20, 6, map[string]float64{"sentiment": 50, "other": 10})
+	now = now.Add(30 * time.Second)
+	stats, err = evaluator.Evaluate()
+	assert.NoError(t, err)
+	assert.Equal(t, "1m0s", stats.Window)
+	assert.Equal(t, float64(40), stats.Requests)
+	assert.InDelta(t, 0.75, stats.CacheHitRate, 1e-9)
+	assert.InDelta(t, 0.05, stats.AverageLatencySeconds, 1e-9)
+	assert.Equal(t, map[string]float64{"sentiment": 35, "other": 5}, stats.Models)
+}
+
+func TestInferenceStats_KeepsBoundedSnapshotsAndBaselineAcrossSparseRequests(t *testing.T) {
+	families := syntheticInferenceMetrics(0, 10, 1, map[string]float64{"sentiment": 10})
+	evaluator := NewInferenceStatsEvaluator(time.Minute, func() ([]*dto.MetricFamily, error) { return families, nil })
+	now := time.Now()
+	evaluator.now = func() time.Time { return now }
+
+	// Frequent requests record at most one snapshot per slot
+	for i := 0; i < 1000; i++ {
+		_, err := evaluator.Evaluate()
+		assert.NoError(t, err)
+		now = now.Add(10 * time.Millisecond)
+	}
+	assert.Equal(t, 10, evaluator.count)
+	for i := 0; i < 1000; i++ {
+		evaluator.Evaluate()
+		now = now.Add(time.Second)
+	}
+	assert.Equal(t, len(evaluator.ring), evaluator.count)
+
+	// A request long after the last one measures from that snapshot, not from startup
+	families = syntheticInferenceMetrics(0, 25, 1, map[string]float64{"sentiment": 25})
+	now = now.Add(10 * time.Minute)
+	stats, err := evaluator.Evaluate()
+	assert.NoError(t, err)
+	assert.Equal(t, float64(15), stats.Requests)
+	assert.Equal(t, map[string]float64{"sentiment": 15}, stats.Models)
+}
+
+func TestInferenceStats_RequiresAPIKey(t *testing.T) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/inference/stats", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	os.Setenv("API_KEYS", "stats-key")
+	defer os.Unsetenv("API_KEYS")
+	router = SetupRouter()
+	req := httptest.NewRequest("GET", "/api/inference/stats", nil)
+	req.Header.Set(APIKeyHeader, "stats-key")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestInferenceBudget_RejectsOverBudgetBeforeBackend(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute}), config)
+	service.Budget = NewInferenceBudget(10, time.Hour, store)
+	service.Budget.RegisterEstimator("small", func(json.RawMessage) int { return 4 })
+	service.Budget.RegisterEstimator("huge", func(json.RawMessage) int { return 11 })
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+
+	// A request costing more than the whole budget can never be served
+	rr := postInference(router, `{"model":"huge","input":"a"}`)
+	assert.Equal(t, http.StatusPaymentRequired, rr.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, http.StatusOK, postInference(router, `{"model":"small","input":"a"}`).Code)
+	assert.Equal(t, http.StatusOK, postInference(router, `{"model":"small","input":"b"}`).Code)
+	// Cached results are not charged
+	assert.Equal(t, http.StatusOK, postInference(router, `{"model":"small","input":"a"}`).Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	rr = postInference(router, `{"model":"small","input":"c"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestInferenceBudget_DoesNotChargeReplayedBatchStreams(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer backend.Close()
+
+	store := newMemoryResponseCache()
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	service := NewInferenceService(NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"}), config)
+	service.Budget = NewInferenceBudget(10, time.Hour, store)
+	service.Budget.RegisterEstimator("small", func(json.RawMessage) int { return 4 })
+	router := gin.New()
+	router.POST("/inference/batch", service.BatchHandler())
+
+	stream := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/inference/batch?stream=ndjson", strings.NewReader(`[{"model":"small","input":"a"},{"model":"small","input":"b"}]`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	charged := func() int {
+		total := 0
+		for key := range store.entries {
+			if strings.HasPrefix(key, "api:inference:budget:") {
+				var units int
+				store.GetCache(key, &units)
+				total += units
+			}
+		}
+		return total
+	}
+
+	assert.Equal(t, CacheMiss, stream().Header().Get("X-Cache"))
+	assert.Equal(t, 8, charged())
+	// A replayed stream costs nothing, even though the budget couldn't cover another run
+	for i := 0; i < 3; i++ {
+		rr := stream()
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, 8, charged())
+}
+
+func newContentTypeRouter(t *testing.T) (*gin.Engine, *[]string) {
+	var received []string
+	var mu sync.Mutex
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r.Header.Get("Content-Type")+" "+string(body))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"label":"cat"}`))
+	}))
+	t.Cleanup(backend.Close)
+
+	config := DefaultInferenceConfig()
+	config.BackendURL = backend.URL
+	config.ContentTypes = map[string][]string{"vision": {"image/*"}}
+	config.MaxBinaryBytes = 64
+	service := NewInferenceService(NewResponseCacher(newMemoryResponseCache(), ResponseCacheConfig{TTL: time.Minute}), config)
+	router := gin.New()
+	router.POST("/inference", service.Handler())
+	return router, &received
+}
+
+func postImage(router *gin.Engine, model string, image []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/inference", bytes.NewReader(image))
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("X-Model", model)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestInferenceContentTypes_JSONModelRejectsImage(t *testing.T) {
+	router, received := newContentTypeRouter(t)
+
+	rr := postImage(router, "sentiment", []byte("\x89PNG\r\n"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.Empty(t, *received)
+
+	// JSON models keep working as before
+	assert.Equal(t, http.StatusOK, postInference(router, `{"model":"sentiment","input":"hi"}`).Code)
+	assert.Len(t, *received, 1)
+}
+
+func TestInferenceContentTypes_BinaryModelAcceptsImage(t *testing.T) {
+	router, received := newContentTypeRouter(t)
+
+	rr := postImage(router, "vision", []byte("\x89PNG\r\n"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"label":"cat"}`, rr.Body.String())
+	assert.Equal(t, []string{"image/png \x89PNG\r\n"}, *received)
+
+	// The binary model doesn't accept JSON, and binary bodies are still size-limited
+	assert.Equal(t, http.StatusUnsupportedMediaType, postInference(router, `{"model":"vision","input":"hi"}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postImage(router, "vision", bytes.Repeat([]byte{0xff}, 65)).Code)
+	assert.Equal(t, http.StatusBadRequest, postImage(router, "", []byte("\x89PNG\r\n")).Code)
+	assert.Len(t, *received, 1)
+}
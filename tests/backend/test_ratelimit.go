@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitedRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimitMiddleware())
+	router.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	router.GET("/api/ratelimit/status", RateLimitStatusHandler)
+	return router
+}
+
+func rateLimitedRequest(router *gin.Engine, path, clientIP string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.RemoteAddr = clientIP + ":40000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimitMiddleware_HeadersDecrement(t *testing.T) {
+	router := newRateLimitedRouter()
+	limit := strconv.Itoa(GetRateLimiterConfig().BurstSize)
+
+	previous := -1
+	for i := 0; i < 3; i++ {
+		rr := rateLimitedRequest(router, "/ping", "203.0.113.10")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, limit, rr.Header().Get("X-RateLimit-Limit"))
+		assert.NotEmpty(t, rr.Header().Get("X-RateLimit-Reset"))
+
+		remaining, err := strconv.Atoi(rr.Header().Get("X-RateLimit-Remaining"))
+		assert.NoError(t, err)
+		if previous >= 0 {
+			assert.Less(t, remaining, previous)
+		}
+		previous = remaining
+	}
+}
+
+func TestRateLimitStatusHandler_ReportsClientBudget(t *testing.T) {
+	router := newRateLimitedRouter()
+	rateLimitedRequest(router, "/ping", "203.0.113.20")
+
+	var status RateLimitStatus
+	rr := rateLimitedRequest(router, "/api/ratelimit/status", "203.0.113.20")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, "203.0.113.20", status.ClientID)
+	assert.Equal(t, GetRateLimiterConfig().BurstSize, status.Limit)
+	assert.Less(t, status.Remaining, status.Limit)
+	assert.Equal(t, strconv.Itoa(status.Remaining), rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, strconv.FormatInt(status.Reset, 10), rr.Header().Get("X-RateLimit-Reset"))
+}
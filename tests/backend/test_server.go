@@ -0,0 +1,1882 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"your_project/config"
+)
+
+// memoryResponseCache is an in-memory ResponseCache for exercising the caching middleware
+type memoryResponseCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	ttls    map[string]time.Duration
+}
+
+func newMemoryResponseCache() *memoryResponseCache {
+	return &memoryResponseCache{entries: make(map[string][]byte), ttls: make(map[string]time.Duration)}
+}
+
+func (m *memoryResponseCache) GetCache(key string, target interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.entries[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, target)
+}
+
+func (m *memoryResponseCache) SetCache(key string, value interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = data
+	m.ttls[key] = expiration
+	return nil
+}
+
+func (m *memoryResponseCache) IncrementCache(key string, delta uint64, expiration time.Duration) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var value uint64
+	if data, ok := m.entries[key]; ok {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return 0, err
+		}
+	} else {
+		m.ttls[key] = expiration
+	}
+	value += delta
+	m.entries[key] = []byte(strconv.FormatUint(value, 10))
+	return value, nil
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	logger = zap.NewNop()
+}
+
+// newCachedRouter builds a router serving GET /items through the response cache
+func newCachedRouter(store ResponseCache, status *int, calls *int) *gin.Engine {
+	cacher := NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StaleTTL: time.Minute, StatusHeader: "X-Cache"})
+	router := gin.New()
+	router.Use(cacher.Middleware())
+	handler := func(c *gin.Context) {
+		*calls++
+		c.JSON(*status, gin.H{"items": []string{"a", "b"}})
+	}
+	router.GET("/items", handler)
+	router.POST("/items", handler)
+	return router
+}
+
+func TestResponseCache_MissThenHit(t *testing.T) {
+	store := newMemoryResponseCache()
+	status, calls := http.StatusOK, 0
+	router := newCachedRouter(store, &status, &calls)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, CacheMiss, rr.Header().Get("X-Cache"))
+	assert.Empty(t, rr.Header().Get("Age"))
+
+	rr2 := httptest.NewRecorder()
+	router.ServeHTTP(rr2, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, http.StatusOK, rr2.Code)
+	assert.Equal(t, CacheHit, rr2.Header().Get("X-Cache"))
+	assert.Equal(t, "0", rr2.Header().Get("Age"))
+	assert.Equal(t, rr.Body.String(), rr2.Body.String())
+	assert.Equal(t, 1, calls)
+}
+
+func TestResponseCache_Bypass(t *testing.T) {
+	status, calls := http.StatusOK, 0
+
+	// Non-GET requests are never cached
+	router := newCachedRouter(newMemoryResponseCache(), &status, &calls)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", nil))
+	assert.Equal(t, CacheBypass, rr.Header().Get("X-Cache"))
+
+	// Without a store every request bypasses the cache
+	router = newCachedRouter(nil, &status, &calls)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, CacheBypass, rr.Header().Get("X-Cache"))
+	assert.Equal(t, 2, calls)
+}
+
+func TestResponseCache_StaleOnHandlerFailure(t *testing.T) {
+	store := newMemoryResponseCache()
+	req := httptest.NewRequest("GET", "/items", nil)
+	store.SetCache(responseCacheKey(req), cachedResponse{
+		Status:   http.StatusOK,
+		Header:   map[string][]string{"Content-Type": {"application/json"}},
+		Body:     []byte(`{"items":["cached"]}`),
+		StoredAt: time.Now().Add(-90 * time.Second),
+	}, 0)
+
+	status, calls := http.StatusInternalServerError, 0
+	router := newCachedRouter(store, &status, &calls)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, CacheStale, rr.Header().Get("X-Cache"))
+	assert.Equal(t, "90", rr.Header().Get("Age"))
+	assert.JSONEq(t, `{"items":["cached"]}`, rr.Body.String())
+	assert.Equal(t, 1, calls)
+}
+
+func TestCacheAge(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, int64(0), cacheAge(now, now))
+	assert.Equal(t, int64(42), cacheAge(now.Add(-42500*time.Millisecond), now))
+}
+
+func TestCacheAside_MissThenHit(t *testing.T) {
+	cacher := NewResponseCacher(newMemoryResponseCache(), DefaultResponseCacheConfig())
+	loads := 0
+	router := gin.New()
+	router.GET("/value", func(c *gin.Context) {
+		var result map[string]int
+		err := cacher.CacheAside(c, "api:value", time.Minute, &result, func() (interface{}, error) {
+			loads++
+			return map[string]int{"value": 7}, nil
+		})
+		assert.NoError(t, err)
+		c.JSON(http.StatusOK, result)
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/value", nil))
+	assert.Equal(t, CacheMiss, rr.Header().Get("X-Cache"))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/value", nil))
+	assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	assert.Equal(t, "0", rr.Header().Get("Age"))
+	assert.JSONEq(t, `{"value":7}`, rr.Body.String())
+	assert.Equal(t, 1, loads)
+}
+
+func TestShutdownServer_ForcedCloseWithSlowHandler(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	router := gin.New()
+	router.Use(InFlightMiddleware())
+	router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &http.Server{Handler: router}
+	go srv.Serve(listener)
+	go http.Get("http://" + listener.Addr().String() + "/slow")
+	<-started
+
+	assert.Equal(t, int64(1), InFlightRequests())
+	forced, err := shutdownServer(srv, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, forced)
+}
+
+func TestShutdownServer_CleanDrain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &http.Server{Handler: gin.New()}
+	go srv.Serve(listener)
+
+	forced, err := shutdownServer(srv, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, forced)
+}
+
+func TestDrainMiddleware_ClosesConnectionsOnlyWhileDraining(t *testing.T) {
+	defer atomic.StoreInt32(&draining, 0)
+	router := gin.New()
+	router.Use(DrainMiddleware())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Connection"))
+
+	StartDraining()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "close", rr.Header().Get("Connection"))
+}
+
+func TestBaggageMiddleware_StrictRejectsUnknownKey(t *testing.T) {
+	config := BaggageConfig{Allowed: map[string][]string{"flags": {"beta"}}, Strict: true}
+	router := gin.New()
+	router.Use(BaggageMiddleware(config))
+	router.GET("/baggage", func(c *gin.Context) { c.JSON(http.StatusOK, Baggage(c)) })
+
+	get := func(name, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/baggage", nil)
+		req.Header.Set(name, value)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("X-Baggage-Flags", "beta")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"flags":"beta"}`, rr.Body.String())
+
+	rr = get("X-Baggage-Tenant-Id", "acme")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `unknown baggage key \"tenant-id\"`)
+
+	// Values outside the allowed list are rejected too
+	assert.Equal(t, http.StatusBadRequest, get("X-Baggage-Flags", "canary").Code)
+}
+
+func TestLoadBaggageConfig_StrictFailsOnInvalidAllowed(t *testing.T) {
+	os.Setenv("BAGGAGE_ALLOWED", "{not json")
+	defer os.Unsetenv("BAGGAGE_ALLOWED")
+	defer os.Unsetenv("BAGGAGE_STRICT")
+
+	config, err := LoadBaggageConfig()
+	assert.NoError(t, err)
+	assert.Empty(t, config.Allowed)
+
+	os.Setenv("BAGGAGE_STRICT", "true")
+	_, err = LoadBaggageConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "BAGGAGE_ALLOWED")
+
+	os.Setenv("BAGGAGE_ALLOWED", `{"Tenant-Id":[]}`)
+	config, err = LoadBaggageConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"tenant-id": {}}, config.Allowed)
+}
+
+func TestHealthCheck_HeadReturnsNoBody(t *testing.T) {
+	router := SetupRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("HEAD", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+}
+
+func TestCacheReadiness_RejectsCacheRoutesUntilConnected(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"hello"}`))
+	}))
+	defer backend.Close()
+	os.Setenv("INFERENCE_BACKEND_URL", backend.URL)
+	defer os.Unsetenv("INFERENCE_BACKEND_URL")
+
+	cacheReadiness = NewCacheReadiness(5 * time.Second)
+	defer func() { cacheReadiness = nil }()
+	router := SetupRouter()
+
+	// While the cache connects, cache-dependent routes are rejected but health stays up
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/inference", strings.NewReader(`{"prompt":"hi"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("Retry-After"))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Once connected, the routes go live
+	cacheReadiness.MarkReady()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/inference", strings.NewReader(`{"prompt":"hi"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"output":"hello"}`, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+}
+
+func TestNewLogger_WritesToStdoutAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	var stdout bytes.Buffer
+	log, failed := newLogger([]LogOutput{
+		{Target: "stdout", Level: zapcore.InfoLevel},
+		{Target: "file:" + path, Level: zapcore.DebugLevel},
+	}, logFormatJSON, &stdout)
+	assert.Empty(t, failed)
+
+	log.Info("order settled", zap.String("order_id", "42"))
+	log.Debug("debug detail")
+	assert.NoError(t, log.Sync())
+
+	assert.Contains(t, stdout.String(), `"order settled"`)
+	assert.NotContains(t, stdout.String(), "debug detail")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"order settled"`)
+	assert.Contains(t, string(data), `"order_id":"42"`)
+	assert.Contains(t, string(data), "debug detail")
+}
+
+func TestNewLogger_FallsBackToStdoutWhenFileCannotBeOpened(t *testing.T) {
+	var stdout bytes.Buffer
+	log, failed := newLogger([]LogOutput{{Target: "file:/nonexistent/dir/app.log", Level: zapcore.InfoLevel}}, logFormatJSON, &stdout)
+	assert.Len(t, failed, 1)
+
+	log.Info("still logged")
+	assert.Contains(t, stdout.String(), "still logged")
+}
+
+func TestLoadLogOutputs_ParsesTargetsAndLevels(t *testing.T) {
+	os.Setenv("LOG_OUTPUTS", "stdout, file:/var/log/app.log=debug, syslog")
+	defer os.Unsetenv("LOG_OUTPUTS")
+	assert.Equal(t, []LogOutput{
+		{Target: "stdout", Level: zapcore.InfoLevel},
+		{Target: "file:/var/log/app.log", Level: zapcore.DebugLevel},
+	}, LoadLogOutputs())
+}
+
+func TestLoadLogFormat_ForcesJSONInProduction(t *testing.T) {
+	os.Setenv("LOG_FORMAT", "console")
+	defer os.Unsetenv("LOG_FORMAT")
+
+	format, forced := LoadLogFormat()
+	assert.Equal(t, logFormatConsole, format)
+	assert.False(t, forced)
+
+	os.Setenv("GIN_MODE", "release")
+	defer os.Unsetenv("GIN_MODE")
+	format, forced = LoadLogFormat()
+	assert.Equal(t, logFormatJSON, format)
+	assert.True(t, forced)
+
+	// The override keeps console output even in production
+	os.Setenv("LOG_FORMAT_ALLOW_CONSOLE", "true")
+	defer os.Unsetenv("LOG_FORMAT_ALLOW_CONSOLE")
+	format, forced = LoadLogFormat()
+	assert.Equal(t, logFormatConsole, format)
+	assert.False(t, forced)
+}
+
+func TestCORS_AllowsPatchPreflight(t *testing.T) {
+	router := SetupRouter()
+
+	req := httptest.NewRequest("OPTIONS", "/api/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PATCH")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "PATCH")
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "HEAD")
+}
+
+func TestCORS_PreflightBypassesMetrics(t *testing.T) {
+	router := SetupRouter()
+	preflights := httpRequestsTotal.WithLabelValues("204", "OPTIONS")
+	before := testutil.ToFloat64(preflights)
+
+	req := httptest.NewRequest("OPTIONS", "/api/inference", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, before, testutil.ToFloat64(preflights))
+
+	// With the bypass disabled preflights are counted like any other request
+	os.Setenv("CORS_PREFLIGHT_BYPASS", "false")
+	defer os.Unsetenv("CORS_PREFLIGHT_BYPASS")
+	router = SetupRouter()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(preflights))
+}
+
+func TestConfigSummary_IncludesSettingsAndRedactsSecrets(t *testing.T) {
+	os.Setenv("JWT_SECRET", "supersecret-signing-key")
+	defer os.Unsetenv("JWT_SECRET")
+
+	memcached := config.DefaultMemcachedConfig()
+	memcached.Servers = []string{"cacheuser:hunter2@cache-1:11211", "cache-2:11211"}
+
+	summary := configSummary(DefaultServerConfig(), DefaultResponseCacheConfig(), memcached)
+	data, err := json.Marshal(summary)
+	assert.NoError(t, err)
+	output := string(data)
+
+	for _, field := range []string{"addr", "shutdown_timeout", "cache_backend", "cache_servers", "response_cache_ttl", "middleware", "metrics_path"} {
+		assert.Contains(t, summary, field)
+	}
+	assert.Equal(t, "memcached", summary["cache_backend"])
+	assert.Contains(t, output, "cache-2:11211")
+	assert.Contains(t, output, "[REDACTED]@cache-1:11211")
+	assert.NotContains(t, output, "hunter2")
+	assert.NotContains(t, output, "supersecret-signing-key")
+}
+
+func newETagRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(ETagMiddleware())
+	router.GET("/items", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"items": []string{"a", "b"}})
+	})
+	return router
+}
+
+func TestETag_FreshRequestReturnsETag(t *testing.T) {
+	router := newETagRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+	assert.JSONEq(t, `{"items":["a","b"]}`, rr.Body.String())
+}
+
+func TestETag_ConditionalRequestReturnsNotModified(t *testing.T) {
+	router := newETagRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	etag := rr.Header().Get("ETag")
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.Equal(t, etag, rr.Header().Get("ETag"))
+}
+
+func TestCacheAge_ClampsClockSkew(t *testing.T) {
+	now := time.Now()
+	// Written by a pod whose clock runs ahead
+	assert.Equal(t, int64(0), cacheAge(now.Add(2*time.Second), now))
+	assert.Equal(t, int64(0), cacheAge(now.Add(time.Hour), now))
+	assert.Equal(t, time.Duration(0), entryAge(now.Add(time.Hour), now))
+	// Written by a pod whose clock runs behind
+	assert.Equal(t, int64(90), cacheAge(now.Add(-90*time.Second), now))
+}
+
+func TestResponseCache_FutureTimestampServedAsFresh(t *testing.T) {
+	store := newMemoryResponseCache()
+	store.SetCache(responseCacheKey(httptest.NewRequest("GET", "/items", nil)), cachedResponse{
+		Status:   http.StatusOK,
+		Header:   map[string][]string{"Content-Type": {"application/json"}},
+		Body:     []byte(`{"items":["cached"]}`),
+		StoredAt: time.Now().Add(time.Hour),
+	}, time.Minute)
+	status, calls := http.StatusOK, 0
+	router := newCachedRouter(store, &status, &calls)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	assert.Equal(t, "0", rr.Header().Get("Age"))
+	assert.Equal(t, 0, calls)
+}
+
+func f64(v float64) *float64 { return &v }
+func u64(v uint64) *uint64   { return &v }
+func str(v string) *string   { return &v }
+
+// syntheticMetrics builds request metrics with the given status counts and all latencies in one bucket
+func syntheticMetrics(ok, failed uint64, latencyBound float64) []*dto.MetricFamily {
+	total := ok + failed
+	return []*dto.MetricFamily{
+		{
+			Name: str("http_requests_total"),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{{Name: str("code"), Value: str("200")}}, Counter: &dto.Counter{Value: f64(float64(ok))}},
+				{Label: []*dto.LabelPair{{Name: str("code"), Value: str("503")}}, Counter: &dto.Counter{Value: f64(float64(failed))}},
+			},
+		},
+		{
+			Name: str("http_request_duration_seconds"),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount: u64(total),
+				Bucket: []*dto.Bucket{
+					{UpperBound: f64(latencyBound / 2), CumulativeCount: u64(0)},
+					{UpperBound: f64(latencyBound), CumulativeCount: u64(total)},
+				},
+			}}},
+		},
+	}
+}
+
+func TestSLO_HealthyVerdict(t *testing.T) {
+	evaluator := NewSLOEvaluator(DefaultSLOConfig(), func() ([]*dto.MetricFamily, error) {
+		return syntheticMetrics(999, 1, 0.1), nil
+	})
+	verdict, err := evaluator.Evaluate()
+	assert.NoError(t, err)
+	assert.Equal(t, SLOStatusOK, verdict.Status)
+	assert.InDelta(t, 0.001, verdict.ErrorRate, 1e-9)
+	assert.InDelta(t, 0.1, verdict.BurnRate, 1e-9)
+	assert.True(t, verdict.LatencyOK)
+	assert.True(t, verdict.P99LatencySeconds > 0.05 && verdict.P99LatencySeconds <= 0.1)
+}
+
+func TestSLO_BreachingVerdictUsesRecentWindow(t *testing.T) {
+	ok, failed := uint64(1000), uint64(0)
+	evaluator := NewSLOEvaluator(DefaultSLOConfig(), func() ([]*dto.MetricFamily, error) {
+		return syntheticMetrics(ok, failed, 2), nil
+	})
+	now := time.Now()
+	evaluator.now = func() time.Time { return now }
+	evaluator.Evaluate()
+
+	// 100 new requests, 10 of them failing, since the previous snapshot
+	ok, failed = 1090, 10
+	now = now.Add(time.Minute)
+	verdict, err := evaluator.Evaluate()
+	assert.NoError(t, err)
+	assert.Equal(t, SLOStatusBreaching, verdict.Status)
+	assert.Equal(t, float64(100), verdict.Requests)
+	assert.InDelta(t, 0.1, verdict.ErrorRate, 1e-9)
+	assert.False(t, verdict.ErrorRateOK)
+	assert.False(t, verdict.LatencyOK)
+}
+
+// failingResponseCache is a ResponseCache whose backend is unavailable
+type failingResponseCache struct{}
+
+func (failingResponseCache) GetCache(key string, target interface{}) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingResponseCache) SetCache(key string, value interface{}, expiration time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func probeRequest(store ResponseCache) (*httptest.ResponseRecorder, CacheProbeResult) {
+	router := gin.New()
+	router.GET("/cache/probe", NewCacheProbe(store, DefaultCacheProbeConfig()).Handler())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/cache/probe", nil))
+	var result CacheProbeResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	return rr, result
+}
+
+func TestCacheProbe_WorkingCache(t *testing.T) {
+	rr, result := probeRequest(newMemoryResponseCache())
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", result.Status)
+	assert.True(t, result.Matched)
+	assert.True(t, strings.HasPrefix(result.Key, "monitoring:cache_probe"))
+	assert.Contains(t, rr.Body.String(), `"latency_ms"`)
+	assert.True(t, result.LatencyMs >= 0)
+}
+
+func TestCacheProbe_FailingCache(t *testing.T) {
+	rr, result := probeRequest(failingResponseCache{})
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "failed", result.Status)
+	assert.False(t, result.Matched)
+	assert.Contains(t, rr.Body.String(), `"latency_ms"`)
+	// The cause is logged, not returned to the caller
+	assert.Equal(t, "cache write or read-back failed", result.Error)
+	assert.NotContains(t, rr.Body.String(), "connection refused")
+}
+
+func TestCacheProbe_RateLimited(t *testing.T) {
+	router := gin.New()
+	router.GET("/cache/probe", NewCacheProbe(newMemoryResponseCache(), CacheProbeConfig{Key: "probe", MinInterval: time.Hour, Burst: 1}).Handler())
+
+	codes := make([]int, 2)
+	for i := range codes {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/cache/probe", nil))
+		codes[i] = rr.Code
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestHealthCheck_UnhealthyWhenHeartbeatStalls(t *testing.T) {
+	router := SetupRouter()
+	watchdog = NewWatchdog(WatchdogConfig{Interval: time.Second, Threshold: 50 * time.Millisecond})
+	defer func() { watchdog = nil }()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Simulate a serving path that stopped beating
+	atomic.StoreInt64(&watchdog.lastBeat, time.Now().Add(-time.Minute).UnixNano())
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unhealthy")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("HEAD", "/api/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// A completed heartbeat request restores health
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	watchdog.Config.Interval = 10 * time.Millisecond
+	go func() {
+		watchdog.Run(ctx, router)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return !watchdog.Stale() }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestWatchdog_HeartbeatsAreNotLoggedOrMeasured(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	httpRequestDuration.Reset()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(httpRequestDuration)
+	router := gin.New()
+	router.Use(LoggingMiddleware(zap.New(core), DefaultAccessLogLevels()))
+	router.Use(MetricsMiddleware())
+	router.HEAD("/api/health", HealthCheckHeadHandler)
+
+	heartbeat := NewWatchdog(WatchdogConfig{Interval: 5 * time.Millisecond, Threshold: time.Minute})
+	atomic.StoreInt64(&heartbeat.lastBeat, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		heartbeat.Run(ctx, router)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return !heartbeat.Stale() }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Empty(t, logs.FilterMessage("HTTP request processed").All())
+	assert.Equal(t, uint64(0), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "/api/health"))
+
+	// The same request from a client is logged and measured
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/api/health", nil))
+	assert.Len(t, logs.FilterMessage("HTTP request processed").All(), 1)
+	assert.Equal(t, uint64(1), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "/api/health"))
+}
+
+func TestLoadWatchdogConfig_RequiresThresholdAboveInterval(t *testing.T) {
+	os.Setenv("WATCHDOG_INTERVAL", "10s")
+	os.Setenv("WATCHDOG_THRESHOLD", "20s")
+	defer os.Unsetenv("WATCHDOG_INTERVAL")
+	defer os.Unsetenv("WATCHDOG_THRESHOLD")
+	assert.Equal(t, WatchdogConfig{Interval: 10 * time.Second, Threshold: 20 * time.Second}, LoadWatchdogConfig())
+
+	os.Setenv("WATCHDOG_THRESHOLD", "10s")
+	assert.Equal(t, DefaultWatchdogConfig(), LoadWatchdogConfig())
+	os.Setenv("WATCHDOG_THRESHOLD", "5s")
+	assert.Equal(t, DefaultWatchdogConfig(), LoadWatchdogConfig())
+}
+
+func TestSetupRouter_ServesRateLimitStatus(t *testing.T) {
+	os.Setenv("API_KEYS", "status-key")
+	defer os.Unsetenv("API_KEYS")
+	router := SetupRouter()
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/ratelimit/status", nil)
+		req.RemoteAddr = "203.0.113.30:40000"
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("").Code)
+
+	rr := get("status-key")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var status struct {
+		ClientID  string `json:"client_id"`
+		Limit     int    `json:"limit"`
+		Remaining int    `json:"remaining"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, "203.0.113.30", status.ClientID)
+	assert.Equal(t, strconv.Itoa(status.Limit), rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, strconv.Itoa(status.Remaining), rr.Header().Get("X-RateLimit-Remaining"))
+
+	// Other API routes are rate limited and report the budget they consume
+	req := httptest.NewRequest("GET", "/api/config/sources", nil)
+	req.RemoteAddr = "203.0.113.31:40000"
+	req.Header.Set(APIKeyHeader, "status-key")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, strconv.Itoa(status.Limit), rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, strconv.Itoa(status.Limit-1), rr.Header().Get("X-RateLimit-Remaining"))
+
+	// Health checks are not
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestSetupRouter_ServesWithoutInitializedLogger(t *testing.T) {
+	previous := logger
+	logger = nil
+	defer func() { logger = previous }()
+
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	assert.NotPanics(t, func() { router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil)) })
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The middleware also tolerates being given no logger
+	router = gin.New()
+	router.Use(LoggingMiddleware(nil, DefaultAccessLogLevels()))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	rr = httptest.NewRecorder()
+	assert.NotPanics(t, func() { router.ServeHTTP(rr, httptest.NewRequest("GET", "/ok", nil)) })
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLoggingMiddleware_LevelFollowsStatusCode(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	router := gin.New()
+	router.Use(LoggingMiddleware(zap.New(core), DefaultAccessLogLevels()))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/missing", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	router.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	for _, path := range []string{"/ok", "/missing", "/fail"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	entries := logs.FilterMessage("HTTP request processed").All()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+		assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+		assert.Equal(t, zapcore.ErrorLevel, entries[2].Level)
+	}
+}
+
+func TestLoggingMiddleware_IncludesHandlerFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	router := gin.New()
+	router.Use(LoggingMiddleware(zap.New(core), DefaultAccessLogLevels()))
+	router.GET("/api/users/:id", func(c *gin.Context) {
+		AddLogField(c, "user_id", c.Param("id"))
+		AddLogField(c, "address", "0xabc")
+		AddLogField(c, "address", "0xdef")
+		c.Status(http.StatusOK)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users/42", nil))
+	entries := logs.FilterMessage("HTTP request processed").All()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "42", fields["user_id"])
+		assert.Equal(t, "0xdef", fields["address"])
+		assert.Equal(t, int64(http.StatusOK), fields["status_code"])
+	}
+}
+
+func TestAccessLogLevels_EnvOverrides(t *testing.T) {
+	os.Setenv("ACCESS_LOG_LEVELS", "2xx=debug, 4xx=info, bogus, 9xx=error")
+	defer os.Unsetenv("ACCESS_LOG_LEVELS")
+
+	levels := LoadAccessLogLevels()
+	assert.Equal(t, zapcore.DebugLevel, levels.Level(http.StatusOK))
+	assert.Equal(t, zapcore.InfoLevel, levels.Level(http.StatusTooManyRequests))
+	assert.Equal(t, zapcore.ErrorLevel, levels.Level(http.StatusBadGateway))
+}
+
+func TestAccessLogLevels_RejectsLevelsThatWouldCrash(t *testing.T) {
+	os.Setenv("ACCESS_LOG_LEVELS", "2xx=fatal, 4xx=panic, 5xx=dpanic, 3xx=warn")
+	defer os.Unsetenv("ACCESS_LOG_LEVELS")
+
+	levels := LoadAccessLogLevels()
+	assert.Equal(t, zapcore.InfoLevel, levels.Level(http.StatusOK))
+	assert.Equal(t, zapcore.WarnLevel, levels.Level(http.StatusNotFound))
+	assert.Equal(t, zapcore.ErrorLevel, levels.Level(http.StatusBadGateway))
+	assert.Equal(t, zapcore.WarnLevel, levels.Level(http.StatusFound))
+}
+
+func newAPIKeyRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(APIKeyMiddleware(APIKeyConfig{
+		Keys:        []string{"old-key", "new-key"},
+		ExemptPaths: DefaultAPIKeyExemptPaths,
+	}))
+	router.GET("/api/health", HealthCheckHandler)
+	router.POST("/api/inference", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"result": "ok"})
+	})
+	return router
+}
+
+func TestAPIKey_ValidKeyAccepted(t *testing.T) {
+	router := newAPIKeyRouter()
+
+	// Both keys are accepted while a rotation is in progress
+	for _, key := range []string{"old-key", "new-key"} {
+		req := httptest.NewRequest("POST", "/api/inference", strings.NewReader(`{}`))
+		req.Header.Set(APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, key)
+	}
+}
+
+func TestAPIKey_MissingOrInvalidKeyRejected(t *testing.T) {
+	router := newAPIKeyRouter()
+
+	for _, key := range []string{"", "wrong-key", "new-key-but-longer"} {
+		req := httptest.NewRequest("POST", "/api/inference", strings.NewReader(`{}`))
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, key)
+		assert.Contains(t, rr.Body.String(), "missing or invalid API key")
+	}
+}
+
+func TestAPIKey_ExemptPathSkipsCheck(t *testing.T) {
+	router := newAPIKeyRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestMiddlewareChain_HijackSucceeds(t *testing.T) {
+	router := SetupRouter()
+	cacher := NewResponseCacher(newMemoryResponseCache(), DefaultResponseCacheConfig())
+	router.GET("/ws/echo", cacher.Middleware(), func(c *gin.Context) {
+		assert.Implements(t, (*http.Flusher)(nil), c.Writer)
+		assert.Implements(t, (*http.CloseNotifier)(nil), c.Writer)
+		conn, rw, err := c.Writer.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo: " + line)
+		rw.Flush()
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /ws/echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	conn.Write([]byte("ping\n"))
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: ping\n", line)
+}
+
+func TestResponseCache_ExcludedPathNeverCached(t *testing.T) {
+	store := newMemoryResponseCache()
+	config := DefaultResponseCacheConfig()
+	config.ExcludePaths = []string{"/api/users/*"}
+	cacher := NewResponseCacher(store, config)
+	router := gin.New()
+	router.Use(cacher.Middleware())
+	calls := 0
+	router.GET("/api/users/:id", func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/users/42", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, CacheBypass, rr.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, store.entries)
+}
+
+func TestResponseCache_SetCookieResponseNeverCached(t *testing.T) {
+	store := newMemoryResponseCache()
+	cacher := NewResponseCacher(store, DefaultResponseCacheConfig())
+	router := gin.New()
+	router.Use(cacher.Middleware())
+	calls := 0
+	router.GET("/api/profile", func(c *gin.Context) {
+		calls++
+		c.SetCookie("session", "user-"+strconv.Itoa(calls), 3600, "/", "", true, true)
+		c.JSON(http.StatusOK, gin.H{"user": calls})
+	})
+
+	for i := 1; i <= 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/profile", nil))
+		assert.Equal(t, CacheMiss, rr.Header().Get("X-Cache"))
+		assert.Contains(t, rr.Header().Get("Set-Cookie"), "session=user-"+strconv.Itoa(i))
+	}
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, store.entries)
+}
+
+func TestResponseCache_PersonalizedHeaders(t *testing.T) {
+	assert.False(t, personalized(http.Header{"Vary": {"Accept-Encoding"}}))
+	assert.True(t, personalized(http.Header{"Vary": {"Accept-Encoding, authorization"}}))
+	assert.True(t, personalized(http.Header{"Set-Cookie": {"session=abc"}}))
+}
+
+// outageMemcache is an in-memory Memcached client whose server can be taken down
+type outageMemcache struct {
+	mu    sync.Mutex
+	items map[string]*memcache.Item
+	down  bool
+}
+
+func (m *outageMemcache) fail() error {
+	if m.down {
+		return errors.New("dial tcp 127.0.0.1:11211: connect: connection refused")
+	}
+	return nil
+}
+
+func (m *outageMemcache) Get(key string) (*memcache.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail(); err != nil {
+		return nil, err
+	}
+	item, ok := m.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return item, nil
+}
+
+func (m *outageMemcache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *outageMemcache) Set(item *memcache.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	m.items[item.Key] = item
+	return nil
+}
+
+func (m *outageMemcache) Add(item *memcache.Item) error            { return m.Set(item) }
+func (m *outageMemcache) CompareAndSwap(item *memcache.Item) error { return m.Set(item) }
+func (m *outageMemcache) Delete(key string) error                  { return nil }
+func (m *outageMemcache) FlushAll() error                          { return nil }
+func (m *outageMemcache) Ping() error                              { return m.fail() }
+
+func TestResponseCache_FallbackServedResponseFlagged(t *testing.T) {
+	client := &outageMemcache{items: make(map[string]*memcache.Item)}
+	mc := config.DefaultMemcachedConfig()
+	mc.Client = client
+	mc.EnableFallback()
+	cacher := NewResponseCacher(mc, DefaultResponseCacheConfig())
+	router := gin.New()
+	router.Use(cacher.Middleware())
+	router.GET("/api/prices", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"btc": 42000})
+	})
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/prices", nil))
+		return rr
+	}
+
+	assert.Equal(t, CacheMiss, get().Header().Get("X-Cache"))
+	rr := get()
+	assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	assert.Empty(t, rr.Header().Get(CacheSourceHeader))
+
+	// During the outage the hit comes from the in-process fallback
+	before := testutil.ToFloat64(cacheFallbackResponsesTotal)
+	client.mu.Lock()
+	client.down = true
+	client.mu.Unlock()
+	rr = get()
+	assert.Equal(t, CacheHit, rr.Header().Get("X-Cache"))
+	assert.Equal(t, "fallback", rr.Header().Get(CacheSourceHeader))
+	assert.Equal(t, before+1, testutil.ToFloat64(cacheFallbackResponsesTotal))
+}
+
+func TestShutdown_RunsStepsInOrder(t *testing.T) {
+	var order []string
+	step := func(name string) ShutdownStep {
+		return ShutdownStep{Name: name, Run: func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+
+	err := Shutdown(context.Background(), []ShutdownStep{step("drain_server"), step("cancel_background"), step("close_cache"), step("flush_logger")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drain_server", "cancel_background", "close_cache", "flush_logger"}, order)
+}
+
+func TestShutdown_MainSequenceOrder(t *testing.T) {
+	srv := &http.Server{Handler: gin.New()}
+	var names []string
+	for _, step := range shutdownSteps(srv, time.Second, func() {}, nil, new(atomic.Bool)) {
+		names = append(names, step.Name)
+	}
+	assert.Equal(t, []string{"drain_server", "cancel_background", "close_cache", "flush_logger"}, names)
+}
+
+func TestShutdown_HangingStepBoundedAndLaterStepsRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hang := make(chan struct{})
+	defer close(hang)
+
+	var ran []string
+	start := time.Now()
+	err := Shutdown(ctx, []ShutdownStep{
+		{Name: "hang", Run: func(ctx context.Context) error {
+			<-hang
+			return nil
+		}},
+		{Name: "fail", Run: func(ctx context.Context) error {
+			ran = append(ran, "fail")
+			return errors.New("close failed")
+		}},
+		{Name: "flush", Run: func(ctx context.Context) error {
+			ran = append(ran, "flush")
+			return nil
+		}},
+	})
+	assert.Less(t, time.Since(start), shutdownStepGrace)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "hang: abandoned")
+	assert.Contains(t, err.Error(), "fail: close failed")
+	assert.NotContains(t, err.Error(), "flush")
+	assert.Equal(t, []string{"fail", "flush"}, ran)
+}
+
+func TestRequestID_EchoedHeaderIsSanitized(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, RequestID(c))
+	})
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header["X-Request-Id"] = []string{"abc123\r\nSet-Cookie: session=evil"}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, "abc123Set-Cookie: session=evil", rr.Header().Get("X-Request-ID"))
+	assert.Empty(t, rr.Header().Get("Set-Cookie"))
+	assert.Equal(t, rr.Header().Get("X-Request-ID"), rr.Body.String())
+
+	// Oversized IDs are truncated and missing ones generated
+	req = httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("X-Request-ID", strings.Repeat("a", 10000))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Len(t, rr.Header().Get("X-Request-ID"), maxRequestIDLength)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/ping", nil))
+	assert.Len(t, rr.Header().Get("X-Request-ID"), 32)
+}
+
+func TestSanitizeHeaderValue(t *testing.T) {
+	assert.Equal(t, "ab", sanitizeHeaderValue("a\r\n\x00b", 10))
+	assert.Equal(t, "abc", sanitizeHeaderValue("abcdef", 3))
+	assert.Equal(t, "", sanitizeHeaderValue("\r\n", 10))
+}
+
+func TestCacheControl_HealthAndAuthenticatedRoutes(t *testing.T) {
+	os.Setenv("API_KEYS", "internal-key")
+	defer os.Unsetenv("API_KEYS")
+	router := SetupRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+
+	req := httptest.NewRequest("GET", "/api/slo", nil)
+	req.Header.Set(APIKeyHeader, "internal-key")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, "private, no-store", rr.Header().Get("Cache-Control"))
+}
+
+func TestSetupRouter_CachesSLOResponses(t *testing.T) {
+	previous := appCache
+	appCache = newMemoryResponseCache()
+	defer func() { appCache = previous }()
+	router := SetupRouter()
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/slo", nil)
+		req.RemoteAddr = "203.0.113.31:40000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	first := get()
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, CacheMiss, first.Header().Get("X-Cache"))
+
+	second := get()
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, CacheHit, second.Header().Get("X-Cache"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	// A hit reports this request's rate limit budget, not the one stored with the response
+	remaining, _ := strconv.Atoi(first.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, strconv.Itoa(remaining-1), second.Header().Get("X-RateLimit-Remaining"))
+
+	// Admin routes are served without the response cache
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/config/sources", nil))
+	assert.Empty(t, rr.Header().Get("X-Cache"))
+}
+
+func TestCacheControl_AdminRoutesAreNeverStored(t *testing.T) {
+	os.Setenv("API_KEYS", "admin-key")
+	defer os.Unsetenv("API_KEYS")
+	router := SetupRouter()
+
+	for _, path := range []string{"/api/inference/stats", "/api/cache/warm/status", "/api/config/sources", "/api/ratelimit/status"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(APIKeyHeader, "admin-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, "private, no-store", rr.Header().Get("Cache-Control"), path)
+	}
+
+	// Admin routes refused for want of API_KEYS aren't stored either
+	os.Unsetenv("API_KEYS")
+	router = SetupRouter()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/inference/stats", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "private, no-store", rr.Header().Get("Cache-Control"))
+}
+
+func TestCacheControl_PublicRouteForAnonymousRequests(t *testing.T) {
+	router := gin.New()
+	router.GET("/public", CacheControlMiddleware(DefaultCacheControlConfig().Public), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/public", nil))
+	assert.Equal(t, "public, max-age=10, s-maxage=30", rr.Header().Get("Cache-Control"))
+
+	req := httptest.NewRequest("GET", "/public", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, "private, no-store", rr.Header().Get("Cache-Control"))
+}
+
+func TestRegisterMetrics_IsIdempotent(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	assert.NotPanics(t, func() {
+		assert.NoError(t, RegisterMetrics(reg))
+		assert.NoError(t, RegisterMetrics(reg))
+	})
+
+	registered := httpRequestsTotal
+	duplicate := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "http_requests_total", Help: "Total number of HTTP requests processed, partitioned by status code and method."},
+		[]string{"code", "method"},
+	)
+	reused, err := registerCollector(reg, duplicate)
+	assert.NoError(t, err)
+	assert.Same(t, registered, reused)
+}
+
+func TestRun_ExitCodes(t *testing.T) {
+	os.Setenv("SERVER_ADDR", "127.0.0.1:0")
+	defer os.Unsetenv("SERVER_ADDR")
+
+	// A signal (cancelled context) is a clean shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code, reason := ExitCode(Run(ctx))
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, ReasonSignal, reason)
+
+	// An address already in use fails at startup
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	os.Setenv("SERVER_ADDR", ln.Addr().String())
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, reason = ExitCode(Run(ctx))
+	assert.Equal(t, ExitStartup, code)
+	assert.Equal(t, ReasonListen, reason)
+
+	// Requiring a cache without configuring one is a config error
+	os.Setenv("CACHE_REQUIRED", "true")
+	defer os.Unsetenv("CACHE_REQUIRED")
+	code, reason = ExitCode(Run(ctx))
+	assert.Equal(t, ExitConfig, code)
+	assert.Equal(t, ReasonConfig, reason)
+}
+
+func TestRun_RecordsForcedShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"output":"late"}`))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	os.Setenv("SERVER_ADDR", addr)
+	defer os.Unsetenv("SERVER_ADDR")
+	os.Setenv("SHUTDOWN_TIMEOUT", "100ms")
+	defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+	os.Setenv("INFERENCE_BACKEND_URL", backend.URL)
+	defer os.Unsetenv("INFERENCE_BACKEND_URL")
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	previous := logger
+	logger = zap.New(core)
+	defer func() { logger = previous }()
+
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, RegisterMetrics(registry))
+	forcedBefore := testutil.ToFloat64(shutdownForcedTotal)
+	durationsBefore := histogramCount(t, registry, "shutdown_duration_seconds", "outcome", "forced")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx) }()
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/api/health")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A request still waiting on the backend outlives the shutdown timeout
+	go http.Post("http://"+addr+"/api/inference", "application/json", strings.NewReader(`{"input":"slow"}`))
+	<-started
+	cancel()
+	code, _ := ExitCode(<-done)
+	assert.Equal(t, ExitOK, code)
+
+	assert.Equal(t, forcedBefore+1, testutil.ToFloat64(shutdownForcedTotal))
+	assert.Equal(t, durationsBefore+1, histogramCount(t, registry, "shutdown_duration_seconds", "outcome", "forced"))
+	entries := logs.FilterMessage("Shutdown finished").All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, true, entries[0].ContextMap()["forced"])
+		assert.Greater(t, entries[0].ContextMap()["shutdown_duration_seconds"], 0.1)
+	}
+}
+
+func TestExitCode_MapsErrors(t *testing.T) {
+	wrapped := fmt.Errorf("startup: %w", exitError(ExitDependency, ReasonDependency, errors.New("connection refused")))
+	code, reason := ExitCode(wrapped)
+	assert.Equal(t, ExitDependency, code)
+	assert.Equal(t, ReasonDependency, reason)
+
+	code, reason = ExitCode(errors.New("boom"))
+	assert.Equal(t, ExitFailure, code)
+	assert.Equal(t, ReasonUnknown, reason)
+}
+
+// countingGatherer counts gathers and reports the count as a counter value.
+type countingGatherer struct{ calls int }
+
+func (g *countingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.calls++
+	name, value := "scrapes_total", float64(g.calls)
+	return []*dto.MetricFamily{{
+		Name:   &name,
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: &value}}},
+	}}, nil
+}
+
+func TestCachingGatherer_ReusesSnapshotWithinTTL(t *testing.T) {
+	inner := &countingGatherer{}
+	gatherer := newCachingGatherer(inner, time.Second)
+	now := time.Now()
+	gatherer.now = func() time.Time { return now }
+
+	first, err := gatherer.Gather()
+	assert.NoError(t, err)
+	now = now.Add(500 * time.Millisecond)
+	second, err := gatherer.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inner.calls)
+	assert.Equal(t, first, second)
+
+	// Once the snapshot expires the next scrape gathers fresh, non-decreasing values
+	now = now.Add(time.Second)
+	third, err := gatherer.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.calls)
+	assert.True(t, third[0].Metric[0].Counter.GetValue() >= first[0].Metric[0].Counter.GetValue())
+}
+
+func TestAPIKey_TenantKeysScopeTheCache(t *testing.T) {
+	previous := appCache
+	mc := &config.MemcachedConfig{}
+	appCache = mc
+	defer func() { appCache = previous }()
+
+	router := gin.New()
+	router.Use(APIKeyMiddleware(APIKeyConfig{
+		Keys:       []string{"shared-key"},
+		TenantKeys: map[string]string{"acme-key": "acme", "globex-key": "globex"},
+	}))
+	router.GET("/tenant", func(c *gin.Context) {
+		tc, err := TenantCacheFor(c)
+		if !assert.NoError(t, err) {
+			return
+		}
+		key, err := tc.Key("portfolio")
+		if err != nil {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+		c.String(http.StatusOK, key)
+	})
+
+	get := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/tenant", nil)
+		req.Header.Set(APIKeyHeader, apiKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, "tenant:acme:portfolio", get("acme-key").Body.String())
+	assert.Equal(t, "tenant:globex:portfolio", get("globex-key").Body.String())
+
+	// A shared key authenticates but carries no tenant, so tenant-scoped caching is refused
+	rr := get("shared-key")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), config.ErrEmptyTenantID.Error())
+}
+
+func TestCacheKeysHandler_ListsNamespaceKeys(t *testing.T) {
+	mc := config.DefaultMemcachedConfig()
+	mc.Client = &outageMemcache{items: make(map[string]*memcache.Item)}
+	mc.EnableManifest("session")
+	for _, user := range []string{"alice", "bob"} {
+		assert.NoError(t, mc.SetCache("session:"+user, "token-"+user, time.Minute))
+	}
+
+	router := gin.New()
+	router.GET("/api/cache/keys", CacheKeysHandler(mc))
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/cache/keys"+query, nil))
+		return rr
+	}
+
+	rr := get("?namespace=session")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response CacheKeysResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, CacheKeysResponse{Namespace: "session", Keys: []string{"session:alice", "session:bob"}, Count: 2}, response)
+
+	assert.Equal(t, http.StatusNotFound, get("?namespace=api").Code)
+	assert.Equal(t, http.StatusBadRequest, get("").Code)
+}
+
+func TestCacheWarmStatusHandler_ReportsLastWarm(t *testing.T) {
+	mc := config.DefaultMemcachedConfig()
+	mc.Client = &outageMemcache{items: make(map[string]*memcache.Item)}
+	router := gin.New()
+	router.GET("/api/cache/warm/status", CacheWarmStatusHandler(mc))
+	get := func() CacheWarmStatusResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/cache/warm/status", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response CacheWarmStatusResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	assert.Equal(t, CacheWarmStatusResponse{}, get())
+
+	keys := []string{"api:price:btc", "api:price:eth", "api:price:sol"}
+	_, err := mc.Warm(context.Background(), keys, time.Minute, config.WarmOverwrite, func(ctx context.Context, key string) (interface{}, error) {
+		if key == "api:price:sol" {
+			return nil, errors.New("origin unavailable")
+		}
+		return 42000, nil
+	})
+	assert.NoError(t, err)
+
+	response := get()
+	assert.False(t, response.InProgress)
+	if assert.NotNil(t, response.LastWarm) {
+		assert.Equal(t, "overwrite", response.LastWarm.Mode)
+		assert.Equal(t, 3, response.LastWarm.Attempted)
+		assert.Equal(t, 2, response.LastWarm.Succeeded)
+		assert.Equal(t, 1, response.LastWarm.Failed)
+		assert.Empty(t, response.LastWarm.Error)
+		assert.False(t, response.LastWarm.StartedAt.IsZero())
+		assert.GreaterOrEqual(t, response.LastWarm.DurationSeconds, 0.0)
+	}
+}
+
+func TestCacheWarmStatus_RequiresAPIKey(t *testing.T) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/cache/warm/status", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestMethodOverride_PostReachesDeleteHandler(t *testing.T) {
+	router := SetupRouter()
+	router.DELETE("/api/items/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "deleted "+c.Param("id"))
+	})
+	handler := MethodOverride(router, DefaultMethodOverrideMethods)
+	deletes := httpRequestsTotal.WithLabelValues("200", "DELETE")
+	before := testutil.ToFloat64(deletes)
+
+	req := httptest.NewRequest("POST", "/api/items/7", nil)
+	req.Header.Set(MethodOverrideHeader, "delete")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "deleted 7", rr.Body.String())
+	assert.Equal(t, before+1, testutil.ToFloat64(deletes))
+
+	// Methods outside the allowlist are ignored, leaving an unrouted POST
+	req = httptest.NewRequest("POST", "/api/items/7", nil)
+	req.Header.Set(MethodOverrideHeader, "CONNECT")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEqual(t, http.StatusOK, rr.Code)
+
+	// Only POST can be overridden
+	req = httptest.NewRequest("GET", "/api/items/7", nil)
+	req.Header.Set(MethodOverrideHeader, "DELETE")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEqual(t, http.StatusOK, rr.Code)
+}
+
+func TestMetricsMiddleware_RecordsAbortedRequestsOnce(t *testing.T) {
+	rateLimited := func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+	}
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	router.PATCH("/limited", rateLimited, func(c *gin.Context) { c.Status(http.StatusOK) })
+	secured := router.Group("/secured", MetricsMiddleware(), APIKeyMiddleware(APIKeyConfig{Keys: []string{"secret"}}))
+	secured.PATCH("/data", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// PATCH keeps these series apart from other tests' requests
+	limited := httpRequestsTotal.WithLabelValues("429", "PATCH")
+	unauthorized := httpRequestsTotal.WithLabelValues("401", "PATCH")
+	ok := httpRequestsTotal.WithLabelValues("200", "PATCH")
+	beforeLimited, beforeUnauthorized, beforeOK := testutil.ToFloat64(limited), testutil.ToFloat64(unauthorized), testutil.ToFloat64(ok)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PATCH", "/limited", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, beforeLimited+1, testutil.ToFloat64(limited))
+
+	// The group installs the middleware a second time; the request is still counted once
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PATCH", "/secured/data", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, beforeUnauthorized+1, testutil.ToFloat64(unauthorized))
+
+	assert.Equal(t, beforeOK, testutil.ToFloat64(ok))
+}
+
+func TestMetricsMiddleware_RecordsNotModifiedFromETag(t *testing.T) {
+	router := SetupRouter()
+	notModified := httpRequestsTotal.WithLabelValues("304", "GET")
+	before := testutil.ToFloat64(notModified)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/health", nil))
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	req := httptest.NewRequest("GET", "/api/health", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(notModified))
+}
+
+// countingResponseCache counts reads reaching the wrapped cache
+type countingResponseCache struct {
+	*memoryResponseCache
+	gets int32
+}
+
+func (c *countingResponseCache) GetCache(key string, target interface{}) (bool, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return c.memoryResponseCache.GetCache(key, target)
+}
+
+func TestRequestCache_MemoizesReadsWithinRequest(t *testing.T) {
+	store := &countingResponseCache{memoryResponseCache: newMemoryResponseCache()}
+	assert.NoError(t, store.SetCache("api:price:eth", 3200, time.Minute))
+
+	router := gin.New()
+	router.Use(RequestCacheMiddleware(store, 0))
+	router.GET("/price", func(c *gin.Context) {
+		cache := RequestCacheFor(c)
+		var first, second int
+		found, err := cache.GetCache("api:price:eth", &first)
+		assert.True(t, found)
+		assert.NoError(t, err)
+		found, err = cache.GetCache("api:price:eth", &second)
+		assert.True(t, found)
+		assert.NoError(t, err)
+
+		// Misses are memoized too
+		var missing int
+		cache.GetCache("api:price:btc", &missing)
+		found, _ = cache.GetCache("api:price:btc", &missing)
+		assert.False(t, found)
+		c.JSON(http.StatusOK, gin.H{"first": first, "second": second})
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/price", nil))
+	assert.JSONEq(t, `{"first":3200,"second":3200}`, rr.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.gets))
+
+	// The memo doesn't outlive the request
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/price", nil))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&store.gets))
+}
+
+func TestRequestCache_BudgetBypassesCacheOnceExhausted(t *testing.T) {
+	store := &countingResponseCache{memoryResponseCache: newMemoryResponseCache()}
+	for _, coin := range []string{"eth", "btc", "sol", "ada"} {
+		assert.NoError(t, store.SetCache("api:price:"+coin, 100, time.Minute))
+	}
+
+	router := gin.New()
+	router.Use(RequestCacheMiddleware(store, 2))
+	router.GET("/prices", func(c *gin.Context) {
+		cache := RequestCacheFor(c)
+		found := make(map[string]bool)
+		for _, coin := range []string{"eth", "btc", "sol", "eth"} {
+			var price int
+			found[coin], _ = cache.GetCache("api:price:"+coin, &price)
+		}
+		// Writes past the budget are skipped too
+		assert.NoError(t, cache.SetCache("api:price:dot", 5, time.Minute))
+		c.JSON(http.StatusOK, found)
+	})
+
+	// The first two keys are read; the third misses without reaching the cache, while the
+	// already-read key is still served from the request's memo
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/prices", nil))
+	assert.JSONEq(t, `{"eth":true,"btc":true,"sol":false}`, rr.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.gets))
+	assert.NotContains(t, store.entries, "api:price:dot")
+
+	// Each request gets its own budget
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/prices", nil))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&store.gets))
+}
+
+func TestRequestCache_BudgetCoversResponseCache(t *testing.T) {
+	store := &countingResponseCache{memoryResponseCache: newMemoryResponseCache()}
+	assert.NoError(t, store.SetCache("api:price:eth", 3300, time.Minute))
+	cacher := NewResponseCacher(store, ResponseCacheConfig{TTL: time.Minute, StatusHeader: "X-Cache"})
+
+	router := gin.New()
+	router.Use(RequestCacheMiddleware(store, 1))
+	router.GET("/price", cacher.Middleware(), func(c *gin.Context) {
+		var price int
+		found, _ := RequestCacheFor(c).GetCache("api:price:eth", &price)
+		c.JSON(http.StatusOK, gin.H{"found": found})
+	})
+
+	// The response cache lookup uses the whole budget: the handler's read misses without
+	// reaching the cache and the response isn't stored
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/price", nil))
+	assert.Equal(t, CacheMiss, rr.Header().Get("X-Cache"))
+	assert.JSONEq(t, `{"found":false}`, rr.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.gets))
+	assert.Len(t, store.entries, 1)
+}
+
+func TestRequestCache_WritesThroughAndRemembers(t *testing.T) {
+	store := &countingResponseCache{memoryResponseCache: newMemoryResponseCache()}
+	cache := NewRequestCache(store)
+
+	assert.NoError(t, cache.SetCache("api:price:eth", 3300, time.Minute))
+	var value int
+	found, err := cache.GetCache("api:price:eth", &value)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, 3300, value)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&store.gets))
+	assert.Contains(t, store.entries, "api:price:eth")
+}
+
+func TestLabelGuard_CollapsesOverflowIntoOther(t *testing.T) {
+	guard := NewLabelGuard("endpoint", 5)
+	for i := 0; i < 50; i++ {
+		value := fmt.Sprintf("/api/items/%d", i)
+		if i < 5 {
+			assert.Equal(t, value, guard.Value(value))
+		} else {
+			assert.Equal(t, "other", guard.Value(value))
+		}
+	}
+	assert.Equal(t, 5, guard.Len())
+
+	// Values tracked before the cap was hit keep their own series
+	assert.Equal(t, "/api/items/3", guard.Value("/api/items/3"))
+
+	var uncapped *LabelGuard
+	assert.Equal(t, "/api/items/99", uncapped.Value("/api/items/99"))
+	assert.Equal(t, "/api/items/99", NewLabelGuard("endpoint", 0).Value("/api/items/99"))
+}
+
+func TestMetricsMiddleware_LabelsEndpointsByRoute(t *testing.T) {
+	httpRequestDuration.Reset()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(httpRequestDuration)
+
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	router.GET("/probe/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	for i := 0; i < 50; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/probe/%d", i), nil))
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/random/%d", i), nil))
+	}
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	series := 0
+	for _, family := range families {
+		if family.GetName() == "http_request_duration_seconds" {
+			series += len(family.GetMetric())
+		}
+	}
+	assert.Equal(t, 2, series)
+	assert.Equal(t, uint64(50), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "/probe/:id"))
+	assert.Equal(t, uint64(50), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "other"))
+}
+
+func TestMetricsMiddleware_CapsEndpointCardinality(t *testing.T) {
+	previous := endpointLabels
+	endpointLabels = NewLabelGuard("endpoint", 3)
+	defer func() { endpointLabels = previous }()
+	httpRequestDuration.Reset()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(httpRequestDuration)
+
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	for i := 0; i < 10; i++ {
+		router.GET(fmt.Sprintf("/probe%d", i), func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	for i := 0; i < 10; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/probe%d", i), nil))
+	}
+
+	assert.Equal(t, uint64(7), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "other"))
+	assert.Equal(t, uint64(1), histogramCount(t, registry, "http_request_duration_seconds", "endpoint", "/probe0"))
+}
+
+func TestConfigSources_EnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.conf")
+	assert.NoError(t, os.WriteFile(path, []byte("# Server settings\nSERVER_ADDR=:9000\nSHUTDOWN_TIMEOUT=\"7s\"\nAPI_KEYS=file-key\n"), 0644))
+	os.Setenv("SERVER_ADDR", ":9100")
+	defer os.Unsetenv("SERVER_ADDR")
+	defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+	defer os.Unsetenv("API_KEYS")
+
+	sources, err := loadConfigFile(path)
+	assert.NoError(t, err)
+	server := LoadServerConfig()
+	assert.Equal(t, ":9100", server.Addr)
+	assert.Equal(t, 7*time.Second, server.ShutdownTimeout)
+	assert.Equal(t, SourceEnv, sources.Source("SERVER_ADDR"))
+	assert.Equal(t, SourceFile, sources.Source("SHUTDOWN_TIMEOUT"))
+	assert.Equal(t, SourceDefault, sources.Source("STARTUP_TIMEOUT"))
+
+	router := gin.New()
+	router.GET("/api/config/sources", ConfigSourcesHandler(sources))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/config/sources", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ConfigSourcesResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, path, response.File)
+	settings := make(map[string]ConfigSetting)
+	for _, setting := range response.Settings {
+		settings[setting.Name] = setting
+	}
+	assert.Equal(t, ConfigSetting{Name: "SERVER_ADDR", Value: ":9100", Source: SourceEnv}, settings["SERVER_ADDR"])
+	assert.Equal(t, ConfigSetting{Name: "SHUTDOWN_TIMEOUT", Value: "7s", Source: SourceFile}, settings["SHUTDOWN_TIMEOUT"])
+	assert.Equal(t, ConfigSetting{Name: "API_KEYS", Value: "[REDACTED]", Source: SourceFile}, settings["API_KEYS"])
+	assert.Equal(t, ConfigSetting{Name: "STARTUP_TIMEOUT", Source: SourceDefault}, settings["STARTUP_TIMEOUT"])
+	assert.NotContains(t, rr.Body.String(), "file-key")
+}
+
+func TestConfigSources_RedactsEncryptionKeys(t *testing.T) {
+	os.Setenv("MEMCACHED_ENCRYPTION_KEYS", "1:c2VjcmV0LWtleS1tYXRlcmlhbC0xMjM0NTY3ODkwMTI=")
+	defer os.Unsetenv("MEMCACHED_ENCRYPTION_KEYS")
+	sources, err := loadConfigFile("")
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/api/config/sources", ConfigSourcesHandler(sources))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/config/sources", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "c2VjcmV0")
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	previous := logger
+	logger = zap.New(core)
+	defer func() { logger = previous }()
+	sources.Log()
+	for _, entry := range logs.FilterMessage("Config setting resolved").All() {
+		if entry.ContextMap()["name"] == "MEMCACHED_ENCRYPTION_KEYS" {
+			assert.Equal(t, "[REDACTED]", entry.ContextMap()["value"])
+		}
+	}
+	assert.Len(t, logs.FilterField(zap.String("name", "MEMCACHED_ENCRYPTION_KEYS")).All(), 1)
+}
+
+func TestConfigSources_RejectsMalformedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.conf")
+	assert.NoError(t, os.WriteFile(path, []byte("SERVER_ADDR=:9000\nnot a setting\n"), 0644))
+	_, err := loadConfigFile(path)
+	assert.ErrorContains(t, err, ":2:")
+	_, err = loadConfigFile(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.Error(t, err)
+}
+
+func TestSelfTest_ExitOutcomeFollowsDependencies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed) // Any answer counts as reachable
+	}))
+	defer backend.Close()
+	inference := DefaultInferenceConfig()
+	inference.BackendURL = backend.URL
+
+	client := &outageMemcache{items: make(map[string]*memcache.Item)}
+	mc := config.DefaultMemcachedConfig()
+	mc.Client = client
+
+	var out bytes.Buffer
+	err := runSelfTest(context.Background(), selfTestChecks(mc, nil, inference, nil), &out)
+	assert.NoError(t, err)
+	var report SelfTestReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.True(t, report.Passed)
+	for _, check := range report.Checks {
+		assert.Equal(t, SelfTestPass, check.Status, check.Name)
+	}
+
+	// With the cache down the self-test fails with the dependency exit code and reports why
+	client.down = true
+	out.Reset()
+	err = runSelfTest(context.Background(), selfTestChecks(mc, nil, inference, nil), &out)
+	code, reason := ExitCode(err)
+	assert.Equal(t, ExitDependency, code)
+	assert.Equal(t, ReasonSelfTest, reason)
+	report = SelfTestReport{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.False(t, report.Passed)
+	statuses := make(map[string]string)
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(t, map[string]string{"config": SelfTestPass, "metrics": SelfTestPass, "cache": SelfTestFail, "inference_backend": SelfTestPass}, statuses)
+
+	// An unreachable backend fails too; without a cache its check is skipped
+	backend.Close()
+	out.Reset()
+	code, _ = ExitCode(runSelfTest(context.Background(), selfTestChecks(nil, nil, inference, nil), &out))
+	assert.Equal(t, ExitDependency, code)
+	assert.Contains(t, out.String(), `"status": "skip"`)
+}